@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"fm-cli/internal/accounts"
+
+	"github.com/99designs/keyring"
+	"golang.org/x/term"
+)
+
+// keyringSecrets keeps account secrets in the system keyring
+type keyringSecrets struct {
+	ring keyring.Keyring
+}
+
+func openKeyring() (accounts.Secrets, error) {
+	ring, err := keyring.Open(keyring.Config{ServiceName: "fm-cli"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open keyring: %w", err)
+	}
+	return keyringSecrets{ring: ring}, nil
+}
+
+func (k keyringSecrets) Get(key string) (string, error) {
+	item, err := k.ring.Get(key)
+	if err != nil {
+		return "", err
+	}
+	return string(item.Data), nil
+}
+
+func (k keyringSecrets) Set(key, value string) error {
+	return k.ring.Set(keyring.Item{Key: key, Data: []byte(value), Label: "fm-cli " + key})
+}
+
+func (k keyringSecrets) Remove(key string) error {
+	return k.ring.Remove(key)
+}
+
+// login adds an account, or replaces the credentials of an existing one.
+// Settings only found in accounts.json, such as session_url and username,
+// are kept.
+func login(args []string) error {
+	cfg, err := accounts.Load()
+	if err != nil {
+		return err
+	}
+	name := cfg.Default
+	if len(args) > 0 {
+		name = args[0]
+	}
+	if name == "" {
+		name = "default"
+	}
+	acct, ok := cfg.Get(name)
+	if !ok {
+		acct = accounts.Account{Name: name}
+	}
+
+	in := bufio.NewReader(os.Stdin)
+	fmt.Printf("Logging in to account %q\n", name)
+	acct.Email, err = prompt(in, "Email", acct.Email)
+	if err != nil {
+		return err
+	}
+	tokenLabel := "API token"
+	if acct.Username != "" {
+		tokenLabel = "Password for " + acct.Username
+	}
+	token, err := promptSecret(tokenLabel)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return fmt.Errorf("an API token is required")
+	}
+	appPassword, err := promptSecret("App password for calendars and contacts (optional)")
+	if err != nil {
+		return err
+	}
+
+	secrets, err := openKeyring()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Add(secrets, acct, token, appPassword); err != nil {
+		return err
+	}
+	fmt.Printf("Saved account %q\n", name)
+	return nil
+}
+
+// logout removes an account, the default one unless another is named
+func logout(args []string) error {
+	cfg, err := accounts.Load()
+	if err != nil {
+		return err
+	}
+	name := cfg.Default
+	if len(args) > 0 {
+		name = args[0]
+	}
+	secrets, err := openKeyring()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Remove(secrets, name); err != nil {
+		return err
+	}
+	fmt.Printf("Removed account %q\n", name)
+	return nil
+}
+
+func listAccounts() error {
+	cfg, err := accounts.Load()
+	if err != nil {
+		return err
+	}
+	if len(cfg.Accounts) == 0 {
+		fmt.Println("No accounts yet; run fm-cli login")
+		return nil
+	}
+	for _, a := range cfg.Ordered() {
+		line := a.Name
+		if a.Email != "" {
+			line += "  " + a.Email
+		}
+		if a.Name == cfg.Default {
+			line += "  (default)"
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// prompt reads a line, keeping current when the answer is empty
+func prompt(in *bufio.Reader, label, current string) (string, error) {
+	if current != "" {
+		fmt.Printf("%s [%s]: ", label, current)
+	} else {
+		fmt.Printf("%s: ", label)
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return current, nil
+	}
+	return line, nil
+}
+
+// promptSecret reads a line without echoing it
+func promptSecret(label string) (string, error) {
+	fmt.Printf("%s: ", label)
+	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
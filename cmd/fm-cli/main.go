@@ -0,0 +1,321 @@
+// Command fm-cli is a terminal client for Fastmail and other JMAP,
+// CalDAV and CardDAV servers.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"fm-cli/internal/accounts"
+	"fm-cli/internal/api"
+	"fm-cli/internal/mailsync"
+	"fm-cli/internal/storage"
+	"fm-cli/internal/tui"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+func main() {
+	args := os.Args[1:]
+	cmd := ""
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+
+	var err error
+	switch cmd {
+	case "":
+		err = runTUI()
+	case "login":
+		err = login(args)
+	case "logout":
+		err = logout(args)
+	case "accounts":
+		err = listAccounts()
+	case "settings":
+		err = settings(args)
+	case "sync":
+		err = syncPending()
+	case "remind":
+		err = remindAlarms()
+	case "debug":
+		err = debug()
+	case "version", "--version":
+		fmt.Println("fm-cli", version)
+	case "help", "-h", "--help":
+		usage(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "fm-cli: unknown command %q\n\n", cmd)
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fm-cli:", err)
+		os.Exit(1)
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, `Usage: fm-cli [command]
+
+Commands:
+  (none)                  Start the TUI
+  login [account]         Add an account, or log in to it again, storing its credentials in the keyring
+  logout [account]        Remove an account and its credentials (the default account if none is named)
+  accounts                List the accounts
+  settings                View current settings
+  settings offline on|off Enable or disable offline mode
+  sync                    Sync pending offline changes
+  remind                  Run in the background and show event alarms as desktop notifications
+  debug                   Show debug info (JMAP session, CalDAV/CardDAV status)
+  help                    Show this help
+
+FM_API_TOKEN, FM_EMAIL and FM_APP_PASSWORD set a single account instead of
+the account list.
+`)
+}
+
+// session is a configured account with its credentials and database
+type session struct {
+	name  string // Empty for the account set by environment variables
+	creds api.Credentials
+	db    *storage.DB
+}
+
+// label names a session in command output
+func (s session) label() string {
+	if s.name == "" {
+		return s.creds.Email
+	}
+	return s.name
+}
+
+// accountNames returns the names of the accounts, the default first. The
+// account set by environment variables has the empty name.
+func accountNames() ([]string, error) {
+	if os.Getenv("FM_API_TOKEN") != "" {
+		return []string{""}, nil
+	}
+	cfg, err := accounts.Load()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, a := range cfg.Ordered() {
+		names = append(names, a.Name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no account set up yet; run fm-cli login")
+	}
+	return names, nil
+}
+
+// openSessions reads every account's credentials and opens its database
+func openSessions() ([]session, error) {
+	if token := os.Getenv("FM_API_TOKEN"); token != "" {
+		db, err := storage.Open()
+		if err != nil {
+			return nil, err
+		}
+		return []session{{
+			creds: api.Credentials{
+				Token:       token,
+				Email:       os.Getenv("FM_EMAIL"),
+				AppPassword: os.Getenv("FM_APP_PASSWORD"),
+			},
+			db: db,
+		}}, nil
+	}
+
+	names, err := accountNames()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := accounts.Load()
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := openKeyring()
+	if err != nil {
+		return nil, err
+	}
+	var sessions []session
+	for _, name := range names {
+		creds, err := cfg.Credentials(secrets, name)
+		if err != nil {
+			closeSessions(sessions)
+			return nil, err
+		}
+		db, err := storage.OpenAccount(name)
+		if err != nil {
+			closeSessions(sessions)
+			return nil, err
+		}
+		sessions = append(sessions, session{name: name, creds: creds, db: db})
+	}
+	return sessions, nil
+}
+
+func closeSessions(sessions []session) {
+	for _, s := range sessions {
+		s.db.Close()
+	}
+}
+
+// connect builds an account's clients if its server can be reached
+func connect(creds api.Credentials) (*api.Client, *api.DAVClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if !api.Reachable(ctx, api.SessionURL(creds.AppConfig())) {
+		return nil, nil, fmt.Errorf("server not reachable")
+	}
+	return api.Connect(creds)
+}
+
+func runTUI() error {
+	sessions, err := openSessions()
+	if err != nil {
+		return err
+	}
+	defer closeSessions(sessions)
+
+	// Offline mode is chosen in Settings and stored with the first account
+	offline := false
+	if v, _ := sessions[0].db.GetConfig("offline_mode"); v == "true" {
+		offline = true
+	}
+
+	accts := make([]tui.Account, len(sessions))
+	for i, s := range sessions {
+		creds := s.creds
+		accts[i] = tui.Account{Name: s.name, DB: s.db, Creds: &creds}
+		// Accounts that can't connect now start offline; the TUI keeps
+		// trying and builds their clients once they can
+		if !offline {
+			accts[i].Client, accts[i].DAVClient, _ = connect(creds)
+		}
+	}
+
+	_, err = tea.NewProgram(tui.NewModelWithAccounts(accts, offline), tea.WithAltScreen()).Run()
+	return err
+}
+
+func settings(args []string) error {
+	names, err := accountNames()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		db, err := storage.OpenAccount(names[0])
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		offline, _ := db.GetConfig("offline_mode")
+		fmt.Println("Offline mode:", onOff(offline == "true"))
+		return nil
+	}
+
+	if len(args) != 2 || args[0] != "offline" || (args[1] != "on" && args[1] != "off") {
+		return fmt.Errorf("usage: fm-cli settings offline on|off")
+	}
+	value := "false"
+	if args[1] == "on" {
+		value = "true"
+	}
+	db, err := storage.OpenAccount(names[0])
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.SetConfig("offline_mode", value); err != nil {
+		return err
+	}
+	fmt.Println("Offline mode:", args[1])
+	return nil
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+func syncPending() error {
+	sessions, err := openSessions()
+	if err != nil {
+		return err
+	}
+	defer closeSessions(sessions)
+
+	failed := false
+	for _, s := range sessions {
+		client, _, err := connect(s.creds)
+		if err != nil {
+			fmt.Printf("%s: %v\n", s.label(), err)
+			failed = true
+			continue
+		}
+		res, err := mailsync.New(client, s.db).ReplayPending()
+		fmt.Printf("%s: %d applied, %d failed, %d conflicts, %d unconfirmed, %d still queued\n",
+			s.label(), res.Done, res.Failed, res.Conflicts, res.Unconfirmed, res.Remaining)
+		if err != nil {
+			fmt.Printf("%s: %v\n", s.label(), err)
+			failed = true
+		}
+	}
+	if failed {
+		return fmt.Errorf("not everything could be synced")
+	}
+	return nil
+}
+
+func debug() error {
+	sessions, err := openSessions()
+	if err != nil {
+		return err
+	}
+	defer closeSessions(sessions)
+
+	for _, s := range sessions {
+		fmt.Printf("Account %s\n", s.label())
+		fmt.Printf("  JMAP session: %s\n", api.SessionURL(s.creds.AppConfig()))
+		client, dav, err := connect(s.creds)
+		if err != nil {
+			fmt.Printf("  JMAP: %v\n", err)
+			continue
+		}
+		fmt.Printf("  JMAP: connected as %s, account %s\n", client.Session.Username, client.AccountID())
+		if client.Session.EventSourceURL != "" {
+			fmt.Printf("  Push: %s\n", client.Session.EventSourceURL)
+		} else {
+			fmt.Println("  Push: not offered")
+		}
+
+		if dav == nil {
+			fmt.Println("  CalDAV/CardDAV: no app password, or the servers didn't answer")
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if calendars, err := dav.FetchCalendars(ctx); err != nil {
+			fmt.Printf("  CalDAV: %v\n", err)
+		} else {
+			fmt.Printf("  CalDAV: %d calendars\n", len(calendars))
+		}
+		if books, err := dav.FetchAddressBooks(ctx); err != nil {
+			fmt.Printf("  CardDAV: %v\n", err)
+		} else {
+			fmt.Printf("  CardDAV: %d address books\n", len(books))
+		}
+		cancel()
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/remind"
+)
+
+// remindAlarms shows the event alarms of every account with an app password
+// until interrupted. The server doesn't have to be reachable at the start,
+// so it can be launched with the desktop session.
+func remindAlarms() error {
+	sessions, err := openSessions()
+	if err != nil {
+		return err
+	}
+	defer closeSessions(sessions)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	notify := remind.DesktopNotifier(os.Stdout)
+	var wg sync.WaitGroup
+	for _, s := range sessions {
+		if s.creds.Email == "" || s.creds.AppPassword == "" {
+			fmt.Fprintf(os.Stderr, "remind: %s has no app password, skipping its calendars\n", s.label())
+			continue
+		}
+		dav, err := api.NewDAVClientWithConfig(s.creds.DAVConfig())
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			remind.New(dav, notify, os.Stderr).Run(ctx)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() == nil {
+		return errors.New("no account has calendars; log in with an app password")
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+// Package accounts keeps the list of named accounts and their keyring
+// entries. fm-cli opens every account in it at startup.
+package accounts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/storage"
+)
+
+// Account is a named account, on Fastmail unless SessionURL, CalDAVURL and
+// CardDAVURL point at other servers. Its token (or password, with Username) and app
+// password are kept in the keyring under TokenKey and AppPasswordKey, never
+// in the file.
+type Account struct {
+	Name       string `json:"name"`
+	Email      string `json:"email,omitempty"`       // Login for calendars and contacts
+	SessionURL string `json:"session_url,omitempty"` // JMAP session resource; Fastmail's if empty
+	Username   string `json:"username,omitempty"`    // Basic auth login for JMAP servers without tokens
+	CalDAVURL  string `json:"caldav_url,omitempty"`  // Fastmail's if both DAV URLs are empty
+	CardDAVURL string `json:"carddav_url,omitempty"`
+}
+
+// Secrets is where tokens and app passwords are stored; the system keyring
+// in the app
+type Secrets interface {
+	Get(key string) (string, error)
+	Set(key, value string) error
+	Remove(key string) error
+}
+
+// Config is the list of accounts, stored in ~/.config/fm-cli/accounts.json
+type Config struct {
+	Default  string    `json:"default"`
+	Accounts []Account `json:"accounts"`
+}
+
+// TokenKey is the keyring key of an account's API token, or its password
+// when it logs in with a username
+func TokenKey(name string) string {
+	return "account:" + name + ":token"
+}
+
+// AppPasswordKey is the keyring key of an account's app password
+func AppPasswordKey(name string) string {
+	return "account:" + name + ":app_password"
+}
+
+func configPath() (string, error) {
+	dir, err := storage.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "accounts.json"), nil
+}
+
+// Load reads the account list; no file means no accounts yet
+func Load() (*Config, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read accounts: %w", err)
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse accounts: %w", err)
+	}
+	return &c, nil
+}
+
+// Save writes the account list
+func (c *Config) Save() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// Get looks up an account by name
+func (c *Config) Get(name string) (Account, bool) {
+	for _, a := range c.Accounts {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Account{}, false
+}
+
+// Ordered returns the accounts with the default one first
+func (c *Config) Ordered() []Account {
+	ordered := make([]Account, 0, len(c.Accounts))
+	for _, a := range c.Accounts {
+		if a.Name == c.Default {
+			ordered = append(ordered, a)
+		}
+	}
+	for _, a := range c.Accounts {
+		if a.Name != c.Default {
+			ordered = append(ordered, a)
+		}
+	}
+	return ordered
+}
+
+// Add stores an account's secrets and adds it to the list, replacing an
+// account of the same name. The first account becomes the default. token
+// is the password when acct.Username is set. An empty appPassword leaves
+// calendars and contacts unavailable.
+//
+// Without a SessionURL the JMAP server is looked up from the email domain,
+// and likewise the CalDAV and CardDAV servers without their URLs; what
+// isn't found is assumed to be on Fastmail.
+func (c *Config) Add(secrets Secrets, acct Account, token, appPassword string) error {
+	if acct.Name == "" {
+		return fmt.Errorf("account name is required")
+	}
+	if acct.SessionURL == "" && acct.Email != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		acct.SessionURL, _ = api.DiscoverSessionURL(ctx, acct.Email)
+		cancel()
+	}
+	if acct.CalDAVURL == "" && acct.CardDAVURL == "" && acct.Email != "" && appPassword != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		acct.CalDAVURL, acct.CardDAVURL, _ = api.DiscoverDAVURLs(ctx, acct.Email)
+		cancel()
+	}
+	if err := secrets.Set(TokenKey(acct.Name), token); err != nil {
+		return fmt.Errorf("failed to store token: %w", err)
+	}
+	if appPassword != "" {
+		if err := secrets.Set(AppPasswordKey(acct.Name), appPassword); err != nil {
+			return fmt.Errorf("failed to store app password: %w", err)
+		}
+	}
+
+	replaced := false
+	for i, a := range c.Accounts {
+		if a.Name == acct.Name {
+			c.Accounts[i] = acct
+			replaced = true
+		}
+	}
+	if !replaced {
+		c.Accounts = append(c.Accounts, acct)
+	}
+	if c.Default == "" {
+		c.Default = acct.Name
+	}
+	return c.Save()
+}
+
+// Remove drops an account and its secrets. Its local database is kept.
+func (c *Config) Remove(secrets Secrets, name string) error {
+	if _, ok := c.Get(name); !ok {
+		return fmt.Errorf("no account named %q", name)
+	}
+	secrets.Remove(TokenKey(name))
+	secrets.Remove(AppPasswordKey(name))
+
+	kept := c.Accounts[:0]
+	for _, a := range c.Accounts {
+		if a.Name != name {
+			kept = append(kept, a)
+		}
+	}
+	c.Accounts = kept
+	if c.Default == name {
+		c.Default = ""
+		if len(c.Accounts) > 0 {
+			c.Default = c.Accounts[0].Name
+		}
+	}
+	return c.Save()
+}
+
+// Credentials reads an account's secrets from the keyring
+func (c *Config) Credentials(secrets Secrets, name string) (api.Credentials, error) {
+	acct, ok := c.Get(name)
+	if !ok {
+		return api.Credentials{}, fmt.Errorf("no account named %q", name)
+	}
+	token, err := secrets.Get(TokenKey(name))
+	if err != nil {
+		return api.Credentials{}, fmt.Errorf("no token for account %q: %w", name, err)
+	}
+	// The app password is optional
+	appPassword, _ := secrets.Get(AppPasswordKey(name))
+	creds := api.Credentials{
+		Email:       acct.Email,
+		AppPassword: appPassword,
+		SessionURL:  acct.SessionURL,
+		CalDAVURL:   acct.CalDAVURL,
+		CardDAVURL:  acct.CardDAVURL,
+	}
+	if acct.Username != "" {
+		creds.Username = acct.Username
+		creds.Password = token
+	} else {
+		creds.Token = token
+	}
+	return creds, nil
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"fm-cli/internal/model"
+
+	"github.com/emersion/go-ical"
+)
+
+// parseAlarms reads the VALARMs of a VEVENT
+func parseAlarms(comp *ical.Component) []model.EventAlert {
+	var alerts []model.EventAlert
+	for _, child := range comp.Children {
+		if child.Name != ical.CompAlarm {
+			continue
+		}
+		var alert model.EventAlert
+		if prop := child.Props.Get(ical.PropUID); prop != nil {
+			alert.ID = prop.Value
+		}
+		if prop := child.Props.Get(ical.PropAction); prop != nil {
+			alert.Action = strings.ToLower(prop.Value)
+		}
+		prop := child.Props.Get(ical.PropTrigger)
+		if prop == nil {
+			continue
+		}
+		if prop.ValueType() == ical.ValueDateTime {
+			t, err := prop.DateTime(time.Local)
+			if err != nil {
+				continue
+			}
+			alert.At = t
+		} else {
+			alert.Trigger = prop.Value
+			alert.FromEnd = strings.EqualFold(prop.Params.Get(ical.ParamRelated), "END")
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+// alarmComponents builds the VALARMs of an event
+func alarmComponents(event model.CalendarEvent) []*ical.Component {
+	var alarms []*ical.Component
+	for _, alert := range event.Alerts {
+		valarm := ical.NewComponent(ical.CompAlarm)
+		if alert.ID != "" {
+			valarm.Props.SetText(ical.PropUID, alert.ID)
+		}
+		action := strings.ToUpper(alert.Action)
+		if action == "" {
+			action = "DISPLAY"
+		}
+		valarm.Props.SetText(ical.PropAction, action)
+
+		trigger := ical.NewProp(ical.PropTrigger)
+		if !alert.At.IsZero() {
+			trigger.SetDateTime(alert.At.UTC())
+		} else {
+			trigger.Value = alert.Trigger
+			if alert.FromEnd {
+				trigger.Params.Set(ical.ParamRelated, "END")
+			}
+		}
+		valarm.Props.Set(trigger)
+
+		// DISPLAY alarms need a DESCRIPTION, EMAIL ones a SUMMARY too
+		valarm.Props.SetText(ical.PropDescription, event.Title)
+		if action == "EMAIL" {
+			valarm.Props.SetText(ical.PropSummary, event.Title)
+		}
+		alarms = append(alarms, valarm)
+	}
+	return alarms
+}
+
+// patchAlarms brings the VALARMs of an existing VEVENT in line with
+// event.Alerts. An alarm that is still wanted is kept whole, with whatever
+// else another client put in it.
+func patchAlarms(comp *ical.Component, event model.CalendarEvent) {
+	var children, existing []*ical.Component
+	for _, child := range comp.Children {
+		if child.Name == ical.CompAlarm {
+			existing = append(existing, child)
+		} else {
+			children = append(children, child)
+		}
+	}
+
+	built := alarmComponents(event)
+	for i, alert := range event.Alerts {
+		valarm := built[i]
+		for j, old := range existing {
+			if old != nil && sameAlarm(old, alert) {
+				valarm = old
+				if alert.Action != "" {
+					valarm.Props.SetText(ical.PropAction, strings.ToUpper(alert.Action))
+				}
+				existing[j] = nil
+				break
+			}
+		}
+		children = append(children, valarm)
+	}
+	comp.Children = children
+}
+
+// sameAlarm reports whether a VALARM is the one alert was read from
+func sameAlarm(valarm *ical.Component, alert model.EventAlert) bool {
+	if prop := valarm.Props.Get(ical.PropUID); prop != nil && alert.ID != "" {
+		return prop.Value == alert.ID
+	}
+	prop := valarm.Props.Get(ical.PropTrigger)
+	if prop == nil {
+		return false
+	}
+	if !alert.At.IsZero() {
+		t, err := prop.DateTime(time.Local)
+		return err == nil && t.Equal(alert.At)
+	}
+	fromEnd := strings.EqualFold(prop.Params.Get(ical.ParamRelated), "END")
+	return prop.Value == alert.Trigger && fromEnd == alert.FromEnd
+}
+
+// AlertTime returns when an alert goes off for an event (or for an
+// occurrence of one)
+func AlertTime(event model.CalendarEvent, alert model.EventAlert) (time.Time, error) {
+	if !alert.At.IsZero() {
+		return alert.At, nil
+	}
+	offset, err := alertOffset(alert)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if alert.FromEnd {
+		return event.End.Add(offset), nil
+	}
+	return event.Start.Add(offset), nil
+}
+
+func alertOffset(alert model.EventAlert) (time.Duration, error) {
+	prop := ical.NewProp(ical.PropTrigger)
+	prop.Value = alert.Trigger
+	offset, err := prop.Duration()
+	if err != nil {
+		return 0, fmt.Errorf("invalid alarm trigger %q: %w", alert.Trigger, err)
+	}
+	return offset, nil
+}
+
+// DescribeAlert puts an alert in words, e.g. "15 minutes before"
+func DescribeAlert(alert model.EventAlert) string {
+	if !alert.At.IsZero() {
+		return "At " + alert.At.Format("Jan 2 15:04")
+	}
+	offset, err := alertOffset(alert)
+	if err != nil {
+		return alert.Trigger
+	}
+
+	anchor := "start"
+	if alert.FromEnd {
+		anchor = "end"
+	}
+	if offset == 0 {
+		return "At " + anchor
+	}
+	when := "before"
+	if offset > 0 {
+		when = "after"
+	} else {
+		offset = -offset
+	}
+	if alert.FromEnd {
+		when += " end"
+	}
+	return formatOffset(offset) + " " + when
+}
+
+func formatOffset(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s", unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case d%(7*24*time.Hour) == 0:
+		return plural(int(d/(7*24*time.Hour)), "week")
+	case d%(24*time.Hour) == 0:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d%time.Hour == 0:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d/time.Minute), "minute")
+	}
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"fm-cli/internal/model"
+
+	"git.sr.ht/~rockorager/go-jmap"
+	"git.sr.ht/~rockorager/go-jmap/mail/email"
+)
+
+// FetchAttachments lists the attachments of an email.
+func (c *Client) FetchAttachments(emailID string) ([]model.Attachment, error) {
+	req := &jmap.Request{}
+	req.Invoke(&email.Get{
+		Account:    c.getMailAccountID(),
+		IDs:        []jmap.ID{jmap.ID(emailID)},
+		Properties: []string{"attachments"},
+	})
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Email/get failed: %w", err)
+	}
+
+	for _, inv := range resp.Responses {
+		if errArgs, ok := inv.Args.(*jmap.MethodError); ok {
+			return nil, fmt.Errorf("JMAP method error: %s (type: %s)", inv.Name, errArgs.Type)
+		}
+		if res, ok := inv.Args.(*email.GetResponse); ok {
+			if len(res.List) == 0 {
+				return nil, fmt.Errorf("email not found")
+			}
+			var atts []model.Attachment
+			for _, part := range res.List[0].Attachments {
+				name := part.Name
+				if name == "" {
+					name = "attachment-" + part.PartID
+				}
+				atts = append(atts, model.Attachment{
+					BlobID:   string(part.BlobID),
+					Name:     name,
+					Type:     part.Type,
+					Size:     int64(part.Size),
+					IsInline: part.Disposition == "inline" && part.CID != "",
+				})
+			}
+			return atts, nil
+		}
+	}
+	return nil, fmt.Errorf("email not found")
+}
+
+// DownloadAttachment fetches an attachment's contents from the session's
+// downloadUrl.
+func (c *Client) DownloadAttachment(att model.Attachment) ([]byte, error) {
+	if c.Session == nil || c.Session.DownloadURL == "" {
+		return nil, fmt.Errorf("server does not provide a download URL")
+	}
+
+	mimeType := att.Type
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	target := strings.NewReplacer(
+		"{accountId}", url.PathEscape(c.AccountID()),
+		"{blobId}", url.PathEscape(att.BlobID),
+		"{name}", url.PathEscape(att.Name),
+		"{type}", url.QueryEscape(mimeType),
+	).Replace(c.Session.DownloadURL)
+
+	resp, err := c.Client.HttpClient.Get(target)
+	if err != nil {
+		return nil, fmt.Errorf("download failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// UploadAttachment uploads a local file to the session's uploadUrl and
+// returns it as an attachment referencing the new blob.
+func (c *Client) UploadAttachment(path string) (model.Attachment, error) {
+	if c.Session == nil || c.Session.UploadURL == "" {
+		return model.Attachment{}, fmt.Errorf("server does not provide an upload URL")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return model.Attachment{}, err
+	}
+	defer f.Close()
+
+	name := filepath.Base(path)
+	mimeType := mime.TypeByExtension(filepath.Ext(name))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	target := strings.ReplaceAll(c.Session.UploadURL, "{accountId}", url.PathEscape(c.AccountID()))
+	req, err := http.NewRequest(http.MethodPost, target, f)
+	if err != nil {
+		return model.Attachment{}, err
+	}
+	req.Header.Set("Content-Type", mimeType)
+
+	resp, err := c.Client.HttpClient.Do(req)
+	if err != nil {
+		return model.Attachment{}, fmt.Errorf("upload of %s failed: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return model.Attachment{}, fmt.Errorf("upload of %s failed: %s", name, resp.Status)
+	}
+
+	var info jmap.UploadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return model.Attachment{}, fmt.Errorf("upload of %s failed: %w", name, err)
+	}
+	// Body parts carry the bare media type; parameters such as charset
+	// belong in their own property
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+		mimeType = mediaType
+	}
+	return model.Attachment{
+		BlobID: string(info.ID),
+		Name:   name,
+		Type:   mimeType,
+		Size:   int64(info.Size),
+	}, nil
+}
+
+// uploadAttachments uploads every attachment that is still a local file,
+// leaving ones that already reference a blob untouched
+func (c *Client) uploadAttachments(atts []model.Attachment) ([]model.Attachment, error) {
+	uploaded := make([]model.Attachment, 0, len(atts))
+	for _, att := range atts {
+		if att.BlobID == "" {
+			up, err := c.UploadAttachment(att.Path)
+			if err != nil {
+				return nil, err
+			}
+			att = up
+		}
+		uploaded = append(uploaded, att)
+	}
+	return uploaded, nil
+}
+
+// setEmailBody fills in the body of an email being created. Plain messages
+// get a single text/plain part. An HTML version makes the body a
+// multipart/alternative of text and HTML, and attachments wrap that in a
+// multipart/mixed followed by the uploaded blobs.
+func setEmailBody(obj *email.Email, body, htmlBody string, atts []model.Attachment) {
+	text := &email.BodyPart{
+		PartID: "text",
+		Type:   "text/plain",
+	}
+	obj.BodyValues = map[string]*email.BodyValue{
+		"text": {Value: body},
+	}
+
+	if htmlBody == "" && len(atts) == 0 {
+		obj.TextBody = []*email.BodyPart{text}
+		return
+	}
+
+	content := text
+	if htmlBody != "" {
+		obj.BodyValues["html"] = &email.BodyValue{Value: htmlBody}
+		content = &email.BodyPart{
+			Type: "multipart/alternative",
+			SubParts: []*email.BodyPart{
+				text,
+				{PartID: "html", Type: "text/html"},
+			},
+		}
+	}
+	if len(atts) == 0 {
+		obj.BodyStructure = content
+		return
+	}
+
+	parts := []*email.BodyPart{content}
+	for _, att := range atts {
+		mimeType := att.Type
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		parts = append(parts, &email.BodyPart{
+			BlobID:      jmap.ID(att.BlobID),
+			Name:        att.Name,
+			Type:        mimeType,
+			Disposition: "attachment",
+		})
+	}
+	obj.BodyStructure = &email.BodyPart{
+		Type:     "multipart/mixed",
+		SubParts: parts,
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+
+	"fm-cli/internal/model"
+)
+
+// Credentials hold what is needed to connect, so clients can be built
+// later when the app starts without a network. Email and AppPassword are
+// only needed for calendars and contacts.
+type Credentials struct {
+	Token       string
+	Email       string
+	AppPassword string
+	SessionURL  string // JMAP session resource; Fastmail's if empty
+	Username    string // Basic auth login for JMAP, used when there is no Token
+	Password    string
+	CalDAVURL   string // Fastmail's if both DAV URLs are empty
+	CardDAVURL  string
+}
+
+// AppConfig returns the JMAP part of the credentials
+func (c Credentials) AppConfig() model.AppConfig {
+	return model.AppConfig{
+		APIToken: c.Token,
+		JMAPCore: c.SessionURL,
+		Username: c.Username,
+		Password: c.Password,
+	}
+}
+
+// DAVConfig returns the calendar and contacts part of the credentials
+func (c Credentials) DAVConfig() DAVConfig {
+	return DAVConfig{
+		CalDAVURL:  c.CalDAVURL,
+		CardDAVURL: c.CardDAVURL,
+		Username:   c.Email,
+		Password:   c.AppPassword,
+	}
+}
+
+// Connect authenticates the JMAP client and, given an app password, sets up
+// the DAV client too. Mail works without DAV, so a DAV setup failure only
+// leaves the DAV client nil.
+func Connect(creds Credentials) (*Client, *DAVClient, error) {
+	client, err := NewClientWithConfig(creds.AppConfig())
+	if err != nil {
+		return nil, nil, err
+	}
+	if creds.Email == "" || creds.AppPassword == "" {
+		return client, nil, nil
+	}
+	davClient, err := NewDAVClientWithConfig(creds.DAVConfig())
+	if err != nil {
+		return client, nil, nil
+	}
+	return client, davClient, nil
+}
+
+// Reachable reports whether the JMAP session endpoint answers at all. Any
+// HTTP response counts, even an authentication error; only failing to get
+// one means we're offline.
+func Reachable(ctx context.Context, sessionURL string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, sessionURL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// IsNetworkError reports whether err means the server couldn't be reached,
+// as opposed to the server rejecting the request. A DAV conflict comes back
+// from the transport, so net/http wraps it like a network failure.
+func IsNetworkError(err error) bool {
+	if errors.Is(err, ErrConflict) {
+		return false
+	}
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr)
+}
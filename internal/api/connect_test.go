@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsNetworkErrorConflict(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &basicAuthTransport{base: http.DefaultTransport}}
+	req, err := http.NewRequestWithContext(withIfMatch(context.Background(), "v1"), http.MethodPut, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Do(req)
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("err = %v, want ErrConflict", err)
+	}
+	if IsNetworkError(err) {
+		t.Error("IsNetworkError reports a 412 conflict as a network failure")
+	}
+}
+
+func TestIsNetworkErrorUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	_, err := http.Get(url)
+	if err == nil {
+		t.Fatal("request to a closed server succeeded")
+	}
+	if !IsNetworkError(err) {
+		t.Errorf("IsNetworkError(%v) = false, want true", err)
+	}
+}
@@ -2,9 +2,13 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"path"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,13 +29,57 @@ type DAVClient struct {
 	email        string
 }
 
-// NewDAVClient creates CalDAV/CardDAV clients with app password auth
+// Fastmail's CalDAV and CardDAV servers, used when no endpoint is configured
+const (
+	FastmailCalDAVURL  = "https://caldav.fastmail.com/"
+	FastmailCardDAVURL = "https://carddav.fastmail.com/"
+)
+
+// DAVConfig says where the calendars and contacts are and how to log in.
+// The URLs may be any point from which the user's principal can be found,
+// such as a server root; empty ones mean Fastmail.
+type DAVConfig struct {
+	CalDAVURL  string
+	CardDAVURL string
+	Username   string
+	Password   string
+}
+
+// NewDAVClient creates CalDAV/CardDAV clients for Fastmail with app password auth
 func NewDAVClient(email, appPassword string) (*DAVClient, error) {
-	httpClient := webdav.HTTPClientWithBasicAuth(nil, email, appPassword)
+	return NewDAVClientWithConfig(DAVConfig{Username: email, Password: appPassword})
+}
 
-	// Fastmail CalDAV/CardDAV endpoints with principal path
-	calURL := "https://caldav.fastmail.com/dav/principals/user/" + email + "/"
-	cardURL := "https://carddav.fastmail.com/dav/principals/user/" + email + "/"
+// NewDAVClientWithConfig creates CalDAV/CardDAV clients for any server
+func NewDAVClientWithConfig(cfg DAVConfig) (*DAVClient, error) {
+	calURL := cfg.CalDAVURL
+	cardURL := cfg.CardDAVURL
+	if calURL == "" && cardURL == "" {
+		// Fastmail's principal, which skips a round of discovery
+		calURL = FastmailCalDAVURL + "dav/principals/user/" + cfg.Username + "/"
+		cardURL = FastmailCardDAVURL + "dav/principals/user/" + cfg.Username + "/"
+	} else if calURL == "" {
+		calURL = cardURL
+	} else if cardURL == "" {
+		cardURL = calURL
+	}
+
+	// Credentials go on every request to the configured servers, including
+	// ones redirected within them, which servers such as Radicale and Baikal
+	// behind a proxy rely on. A redirect elsewhere doesn't get them.
+	transport := &basicAuthTransport{
+		username: cfg.Username,
+		password: cfg.Password,
+		base:     http.DefaultTransport,
+	}
+	for _, endpoint := range []string{calURL, cardURL} {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DAV URL %q: %w", endpoint, err)
+		}
+		transport.hosts = append(transport.hosts, hostKey(u))
+	}
+	httpClient := &http.Client{Transport: transport}
 
 	calClient, err := caldav.NewClient(httpClient, calURL)
 	if err != nil {
@@ -47,19 +95,68 @@ func NewDAVClient(email, appPassword string) (*DAVClient, error) {
 		CalDAV:     calClient,
 		CardDAV:    cardClient,
 		httpClient: httpClient,
-		email:      email,
+		email:      cfg.Username,
 	}, nil
 }
 
 type basicAuthTransport struct {
 	username string
 	password string
+	hosts    []string // Where the credentials may be sent, as hostKey gives them
 	base     http.RoundTripper
 }
 
 func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.SetBasicAuth(t.username, t.password)
-	return t.base.RoundTrip(req)
+	req = req.Clone(req.Context())
+	for _, host := range t.hosts {
+		if hostKey(req.URL) == host {
+			req.SetBasicAuth(t.username, t.password)
+			break
+		}
+	}
+	etag, _ := req.Context().Value(ifMatchKey{}).(string)
+	if etag != "" {
+		req.Header.Set("If-Match", strconv.Quote(etag))
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && etag != "" && resp.StatusCode == http.StatusPreconditionFailed {
+		resp.Body.Close()
+		return nil, ErrConflict
+	}
+	return resp, err
+}
+
+// hostKey identifies the server a URL points at, so that
+// "https://Dav.example.org/" and "https://dav.example.org:443/x" match
+func hostKey(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return strings.ToLower(u.Hostname()) + ":" + port
+}
+
+// ErrConflict is returned when a calendar object or contact was changed
+// elsewhere, such as on a phone, since it was read
+var ErrConflict = errors.New("changed elsewhere since it was loaded, refresh and try again")
+
+type ifMatchKey struct{}
+
+// withIfMatch makes the requests sent with ctx apply only while the
+// resource still has the given ETag. go-webdav can't set If-Match itself.
+func withIfMatch(ctx context.Context, etag string) context.Context {
+	return context.WithValue(ctx, ifMatchKey{}, etag)
+}
+
+// davError describes a failed request, a conflict without the URL
+func davError(what string, err error) error {
+	if errors.Is(err, ErrConflict) {
+		return fmt.Errorf("%s: %w", what, ErrConflict)
+	}
+	return fmt.Errorf("%s: %w", what, err)
 }
 
 // FetchCalendars retrieves all calendars via CalDAV
@@ -113,7 +210,12 @@ func (d *DAVClient) FetchEvents(ctx context.Context, calendarPaths []string, sta
 						"SUMMARY", "DTSTART", "DTEND", "DURATION",
 						"LOCATION", "DESCRIPTION", "UID", "STATUS",
 						"ORGANIZER", "ATTENDEE",
+						"RRULE", "RDATE", "EXDATE", "RECURRENCE-ID",
 					},
+					Comps: []caldav.CalendarCompRequest{{
+						Name:     "VALARM",
+						AllProps: true,
+					}},
 				}},
 			},
 			CompFilter: caldav.CompFilter{
@@ -132,9 +234,9 @@ func (d *DAVClient) FetchEvents(ctx context.Context, calendarPaths []string, sta
 		}
 
 		for _, obj := range objects {
-			event := parseCalendarObject(obj, calPath)
-			if event != nil {
-				allEvents = append(allEvents, *event)
+			for _, event := range expandCalendarObject(obj, calPath, start, end) {
+				event.ETag = obj.ETag
+				allEvents = append(allEvents, event)
 			}
 		}
 	}
@@ -147,79 +249,81 @@ func (d *DAVClient) FetchEvents(ctx context.Context, calendarPaths []string, sta
 	return allEvents, nil
 }
 
-func parseCalendarObject(obj caldav.CalendarObject, calPath string) *model.CalendarEvent {
-	if obj.Data == nil {
-		return nil
+// parseEvent reads one VEVENT: a plain event, the master of a recurring
+// one or an override of one of its occurrences
+func parseEvent(comp *ical.Component, path, calPath string) *model.CalendarEvent {
+	event := &model.CalendarEvent{
+		ID:         path,
+		CalendarID: calPath,
 	}
 
-	for _, comp := range obj.Data.Children {
-		if comp.Name != ical.CompEvent {
-			continue
-		}
+	// Parse properties
+	if prop := comp.Props.Get(ical.PropSummary); prop != nil {
+		event.Title = prop.Value
+	}
+	if prop := comp.Props.Get(ical.PropDescription); prop != nil {
+		event.Description = prop.Value
+	}
+	if prop := comp.Props.Get(ical.PropLocation); prop != nil {
+		event.Location = prop.Value
+	}
+	if prop := comp.Props.Get(ical.PropStatus); prop != nil {
+		event.Status = strings.ToLower(prop.Value)
+	}
 
-		event := &model.CalendarEvent{
-			ID:         obj.Path,
-			CalendarID: calPath,
+	// Parse start time
+	if prop := comp.Props.Get(ical.PropDateTimeStart); prop != nil {
+		if t, err := prop.DateTime(time.Local); err == nil {
+			event.Start = t
+		}
+		// Check if all-day event
+		if val := prop.Params.Get(ical.ParamValue); val == "DATE" {
+			event.IsAllDay = true
 		}
+	}
 
-		// Parse properties
-		if prop := comp.Props.Get(ical.PropSummary); prop != nil {
-			event.Title = prop.Value
+	// Parse end time or duration
+	if prop := comp.Props.Get(ical.PropDateTimeEnd); prop != nil {
+		if t, err := prop.DateTime(time.Local); err == nil {
+			event.End = t
 		}
-		if prop := comp.Props.Get(ical.PropDescription); prop != nil {
-			event.Description = prop.Value
+	} else if prop := comp.Props.Get(ical.PropDuration); prop != nil {
+		event.Duration = prop.Value
+		if dur, err := prop.Duration(); err == nil {
+			event.End = event.Start.Add(dur)
 		}
-		if prop := comp.Props.Get(ical.PropLocation); prop != nil {
-			event.Location = prop.Value
+	}
+
+	// Parse participants
+	for _, prop := range comp.Props.Values(ical.PropAttendee) {
+		participant := model.EventParticipant{
+			Email: strings.TrimPrefix(prop.Value, "mailto:"),
 		}
-		if prop := comp.Props.Get(ical.PropStatus); prop != nil {
-			event.Status = strings.ToLower(prop.Value)
+		if name := prop.Params.Get(ical.ParamCommonName); name != "" {
+			participant.Name = name
 		}
-
-		// Parse start time
-		if prop := comp.Props.Get(ical.PropDateTimeStart); prop != nil {
-			if t, err := prop.DateTime(time.Local); err == nil {
-				event.Start = t
-			}
-			// Check if all-day event
-			if val := prop.Params.Get(ical.ParamValue); val == "DATE" {
-				event.IsAllDay = true
-			}
+		if status := prop.Params.Get(ical.ParamParticipationStatus); status != "" {
+			participant.Status = strings.ToLower(status)
 		}
-
-		// Parse end time or duration
-		if prop := comp.Props.Get(ical.PropDateTimeEnd); prop != nil {
-			if t, err := prop.DateTime(time.Local); err == nil {
-				event.End = t
-			}
-		} else if prop := comp.Props.Get(ical.PropDuration); prop != nil {
-			event.Duration = prop.Value
-			if dur, err := prop.Duration(); err == nil {
-				event.End = event.Start.Add(dur)
-			}
+		if role := prop.Params.Get(ical.ParamRole); role != "" {
+			participant.Role = strings.ToLower(role)
 		}
+		event.Participants = append(event.Participants, participant)
+	}
 
-		// Parse participants
-		for _, prop := range comp.Props.Values(ical.PropAttendee) {
-			participant := model.EventParticipant{
-				Email: strings.TrimPrefix(prop.Value, "mailto:"),
-			}
-			if name := prop.Params.Get(ical.ParamCommonName); name != "" {
-				participant.Name = name
-			}
-			if status := prop.Params.Get(ical.ParamParticipationStatus); status != "" {
-				participant.Status = strings.ToLower(status)
-			}
-			if role := prop.Params.Get(ical.ParamRole); role != "" {
-				participant.Role = strings.ToLower(role)
-			}
-			event.Participants = append(event.Participants, participant)
-		}
+	event.Alerts = parseAlarms(comp)
 
-		return event
+	// Parse recurrence
+	if prop := comp.Props.Get(ical.PropRecurrenceRule); prop != nil {
+		event.Recurrence = prop.Value
+	}
+	if prop := comp.Props.Get(ical.PropRecurrenceID); prop != nil {
+		if t, err := prop.DateTime(time.Local); err == nil {
+			event.RecurrenceID = t
+		}
 	}
 
-	return nil
+	return event
 }
 
 // CreateEvent creates a new calendar event via CalDAV
@@ -229,8 +333,31 @@ func (d *DAVClient) CreateEvent(ctx context.Context, event model.CalendarEvent)
 	cal.Props.SetText(ical.PropVersion, "2.0")
 	cal.Props.SetText(ical.PropProductID, "-//FM-CLI//EN")
 
-	vevent := ical.NewComponent(ical.CompEvent)
 	uid := fmt.Sprintf("%d@fm-cli", time.Now().UnixNano())
+	cal.Children = append(cal.Children, eventComponent(uid, event))
+
+	// Put to server
+	path := event.CalendarID + uid + ".ics"
+	_, err := d.CalDAV.PutCalendarObject(ctx, path, cal)
+	if err != nil {
+		return "", fmt.Errorf("failed to create event: %w", err)
+	}
+
+	return path, nil
+}
+
+// eventComponent builds a VEVENT from an event
+func eventComponent(uid string, event model.CalendarEvent) *ical.Component {
+	// time.Local has no zone name to write as TZID, so such times go out
+	// in UTC. Times read from the server keep their own zone.
+	if event.Start.Location() == time.Local && !event.IsAllDay {
+		event.Start = event.Start.UTC()
+		if !event.End.IsZero() {
+			event.End = event.End.UTC()
+		}
+	}
+
+	vevent := ical.NewComponent(ical.CompEvent)
 	vevent.Props.SetText(ical.PropUID, uid)
 	vevent.Props.SetText(ical.PropSummary, event.Title)
 
@@ -268,96 +395,93 @@ func (d *DAVClient) CreateEvent(ctx context.Context, event model.CalendarEvent)
 		vevent.Props.Set(dtend)
 	}
 
-	vevent.Props.SetDateTime(ical.PropDateTimeStamp, time.Now())
-	cal.Children = append(cal.Children, vevent)
-
-	// Put to server
-	path := event.CalendarID + uid + ".ics"
-	_, err := d.CalDAV.PutCalendarObject(ctx, path, cal)
-	if err != nil {
-		return "", fmt.Errorf("failed to create event: %w", err)
+	if event.Recurrence != "" {
+		rrule := ical.NewProp(ical.PropRecurrenceRule)
+		rrule.SetValueType(ical.ValueRecurrence)
+		rrule.Value = event.Recurrence
+		vevent.Props.Set(rrule)
 	}
 
-	return path, nil
+	vevent.Props.SetDateTime(ical.PropDateTimeStamp, time.Now().UTC())
+	vevent.Children = alarmComponents(event)
+	return vevent
 }
 
-// UpdateEvent updates an existing calendar event via CalDAV
+// UpdateEvent updates an existing calendar event via CalDAV. The fields
+// of event are written into the stored VEVENT, so attendees, categories,
+// X- properties and the like set by other clients stay as they were. For a
+// recurring event it changes all occurrences: given one of them, the series
+// moves by as much as that occurrence did.
 func (d *DAVClient) UpdateEvent(ctx context.Context, event model.CalendarEvent) error {
-	// First get the existing event to preserve UID
-	objects, err := d.CalDAV.MultiGetCalendar(ctx, event.CalendarID, &caldav.CalendarMultiGet{
-		Paths: []string{event.ID},
-		CompRequest: caldav.CalendarCompRequest{
-			Name: "VCALENDAR",
-			Comps: []caldav.CalendarCompRequest{{
-				Name: "VEVENT",
-			}},
-		},
-	})
-	if err != nil || len(objects) == 0 {
-		return fmt.Errorf("failed to get existing event: %w", err)
+	obj, err := d.getEventObject(ctx, event)
+	if err != nil {
+		return err
 	}
-
-	// Get existing UID
-	existingCal := objects[0].Data
-	var uid string
-	for _, comp := range existingCal.Children {
-		if comp.Name == ical.CompEvent {
-			if prop := comp.Props.Get(ical.PropUID); prop != nil {
-				uid = prop.Value
-			}
-		}
+	cal := obj.Data
+	master := masterComponent(cal)
+	if master == nil {
+		return fmt.Errorf("failed to get existing event: no VEVENT in %s", event.ID)
 	}
 
-	// Create updated iCal event
-	cal := ical.NewCalendar()
-	cal.Props.SetText(ical.PropVersion, "2.0")
-	cal.Props.SetText(ical.PropProductID, "-//FM-CLI//EN")
-
-	vevent := ical.NewComponent(ical.CompEvent)
-	vevent.Props.SetText(ical.PropUID, uid)
-	vevent.Props.SetText(ical.PropSummary, event.Title)
-
-	if event.Description != "" {
-		vevent.Props.SetText(ical.PropDescription, event.Description)
-	}
-	if event.Location != "" {
-		vevent.Props.SetText(ical.PropLocation, event.Location)
+	if !event.RecurrenceID.IsZero() {
+		if start, err := master.Props.DateTime(ical.PropDateTimeStart, time.Local); err == nil {
+			length := event.End.Sub(event.Start)
+			event.Start = start.Add(event.Start.Sub(event.RecurrenceID))
+			event.End = event.Start.Add(length)
+		}
 	}
 
-	dtstart := ical.NewProp(ical.PropDateTimeStart)
-	if event.IsAllDay {
-		dtstart.SetDate(event.Start)
-	} else {
-		dtstart.SetDateTime(event.Start)
+	patchEvent(master, event)
+	if event.Recurrence == "" {
+		// No longer recurring, so overrides have nothing to override
+		master.Props.Del(ical.PropExceptionDates)
+		master.Props.Del(ical.PropRecurrenceDates)
+		children := cal.Children[:0]
+		for _, comp := range cal.Children {
+			if comp == master || comp.Name != ical.CompEvent {
+				children = append(children, comp)
+			}
+		}
+		cal.Children = children
 	}
-	vevent.Props.Set(dtstart)
 
-	if !event.End.IsZero() {
-		dtend := ical.NewProp(ical.PropDateTimeEnd)
-		if event.IsAllDay {
-			dtend.SetDate(event.End)
-		} else {
-			dtend.SetDateTime(event.End)
-		}
-		vevent.Props.Set(dtend)
+	_, err = d.CalDAV.PutCalendarObject(withIfMatch(ctx, obj.ETag), event.ID, cal)
+	if err != nil {
+		return davError("failed to update event", err)
 	}
 
-	vevent.Props.SetDateTime(ical.PropDateTimeStamp, time.Now())
-	cal.Children = append(cal.Children, vevent)
+	return nil
+}
 
-	_, err = d.CalDAV.PutCalendarObject(ctx, event.ID, cal)
+// getEventObject fetches the calendar object an event was read from,
+// failing if it has changed on the server since then
+func (d *DAVClient) getEventObject(ctx context.Context, event model.CalendarEvent) (*caldav.CalendarObject, error) {
+	obj, err := d.CalDAV.GetCalendarObject(ctx, event.ID)
 	if err != nil {
-		return fmt.Errorf("failed to update event: %w", err)
+		return nil, fmt.Errorf("failed to get existing event: %w", err)
+	}
+	if event.ETag != "" && obj.ETag != "" && event.ETag != obj.ETag {
+		return nil, fmt.Errorf("event %w", ErrConflict)
 	}
+	return obj, nil
+}
 
-	return nil
+// MoveEvent moves an event to another calendar and returns its new path
+func (d *DAVClient) MoveEvent(ctx context.Context, event model.CalendarEvent) (string, error) {
+	dest := event.CalendarID + path.Base(event.ID)
+	err := d.CalDAV.Move(withIfMatch(ctx, event.ETag), event.ID, dest, &webdav.MoveOptions{NoOverwrite: true})
+	if err != nil {
+		return "", davError("failed to move event", err)
+	}
+	return dest, nil
 }
 
-// DeleteEvent deletes a calendar event via CalDAV
-func (d *DAVClient) DeleteEvent(ctx context.Context, eventPath string) error {
-	err := d.CalDAV.RemoveAll(ctx, eventPath)
+// DeleteEvent deletes a calendar event via CalDAV, failing with
+// ErrConflict if it has changed on the server since it was read
+func (d *DAVClient) DeleteEvent(ctx context.Context, event model.CalendarEvent) error {
+	err := d.CalDAV.RemoveAll(withIfMatch(ctx, event.ETag), event.ID)
 	if err != nil {
-		return fmt.Errorf("failed to delete event: %w", err)
+		return davError("failed to delete event", err)
 	}
 	return nil
 }
@@ -449,6 +573,7 @@ func parseAddressObject(obj carddav.AddressObject, abPath string) *model.Contact
 	contact := &model.Contact{
 		ID:            obj.Path,
 		AddressBookID: abPath,
+		ETag:          obj.ETag,
 	}
 
 	// Full name
@@ -469,9 +594,9 @@ func parseAddressObject(obj carddav.AddressObject, abPath string) *model.Contact
 		contact.Nickname = nick.Value
 	}
 
-	// Organization
+	// Organization, without the departments that may follow it
 	if org := obj.Card.Get(vcard.FieldOrganization); org != nil {
-		contact.Company = org.Value
+		contact.Company = strings.Split(org.Value, ";")[0]
 	}
 
 	// Title
@@ -481,17 +606,8 @@ func parseAddressObject(obj carddav.AddressObject, abPath string) *model.Contact
 
 	// Emails
 	for _, email := range obj.Card[vcard.FieldEmail] {
-		emailType := "other"
-		if types := email.Params.Types(); len(types) > 0 {
-			switch strings.ToLower(types[0]) {
-			case "work":
-				emailType = "work"
-			case "home":
-				emailType = "home"
-			}
-		}
 		contact.Emails = append(contact.Emails, model.ContactEmail{
-			Type:      emailType,
+			Type:      workHomeType(email),
 			Email:     email.Value,
 			IsDefault: email.Params.Get("PREF") != "",
 		})
@@ -499,22 +615,8 @@ func parseAddressObject(obj carddav.AddressObject, abPath string) *model.Contact
 
 	// Phones
 	for _, phone := range obj.Card[vcard.FieldTelephone] {
-		phoneType := "other"
-		if types := phone.Params.Types(); len(types) > 0 {
-			t := strings.ToLower(types[0])
-			switch {
-			case strings.Contains(t, "cell") || strings.Contains(t, "mobile"):
-				phoneType = "mobile"
-			case strings.Contains(t, "work"):
-				phoneType = "work"
-			case strings.Contains(t, "home"):
-				phoneType = "home"
-			case strings.Contains(t, "fax"):
-				phoneType = "fax"
-			}
-		}
 		contact.Phones = append(contact.Phones, model.ContactPhone{
-			Type:      phoneType,
+			Type:      phoneType(phone),
 			Number:    phone.Value,
 			IsDefault: phone.Params.Get("PREF") != "",
 		})
@@ -522,42 +624,7 @@ func parseAddressObject(obj carddav.AddressObject, abPath string) *model.Contact
 
 	// Addresses
 	for _, addr := range obj.Card[vcard.FieldAddress] {
-		addrType := "other"
-		if types := addr.Params.Types(); len(types) > 0 {
-			switch strings.ToLower(types[0]) {
-			case "work":
-				addrType = "work"
-			case "home":
-				addrType = "home"
-			}
-		}
-		// Parse ADR field - semicolon-separated components
-		// Format: PO Box;Extended Address;Street;City;Region;Postal Code;Country
-		parts := strings.Split(addr.Value, ";")
-		var street, city, state, postalCode, country string
-		if len(parts) > 2 {
-			street = parts[2]
-		}
-		if len(parts) > 3 {
-			city = parts[3]
-		}
-		if len(parts) > 4 {
-			state = parts[4]
-		}
-		if len(parts) > 5 {
-			postalCode = parts[5]
-		}
-		if len(parts) > 6 {
-			country = parts[6]
-		}
-		contact.Addresses = append(contact.Addresses, model.ContactAddress{
-			Type:       addrType,
-			Street:     street,
-			City:       city,
-			State:      state,
-			PostalCode: postalCode,
-			Country:    country,
-		})
+		contact.Addresses = append(contact.Addresses, parseAddress(addr))
 	}
 
 	// Notes
@@ -578,6 +645,38 @@ func parseAddressObject(obj carddav.AddressObject, abPath string) *model.Contact
 	return contact
 }
 
+// parseAddress reads an ADR field
+func parseAddress(addr *vcard.Field) model.ContactAddress {
+	addrType := workHomeType(addr)
+	// Parse ADR field - semicolon-separated components
+	// Format: PO Box;Extended Address;Street;City;Region;Postal Code;Country
+	parts := strings.Split(addr.Value, ";")
+	var street, city, state, postalCode, country string
+	if len(parts) > 2 {
+		street = parts[2]
+	}
+	if len(parts) > 3 {
+		city = parts[3]
+	}
+	if len(parts) > 4 {
+		state = parts[4]
+	}
+	if len(parts) > 5 {
+		postalCode = parts[5]
+	}
+	if len(parts) > 6 {
+		country = parts[6]
+	}
+	return model.ContactAddress{
+		Type:       addrType,
+		Street:     street,
+		City:       city,
+		State:      state,
+		PostalCode: postalCode,
+		Country:    country,
+	}
+}
+
 // CreateContact creates a new contact via CardDAV
 func (d *DAVClient) CreateContact(ctx context.Context, contact model.Contact) (string, error) {
 	card := make(vcard.Card)
@@ -669,92 +768,39 @@ func (d *DAVClient) CreateContact(ctx context.Context, contact model.Contact) (s
 	return path, nil
 }
 
-// UpdateContact updates an existing contact via CardDAV
+// UpdateContact updates an existing contact via CardDAV. The fields of
+// contact are merged into the stored card, so photos, URLs, categories and
+// X- fields stay as they were. If the card changed on the server since it
+// was read, ErrConflict is returned and nothing is written.
 func (d *DAVClient) UpdateContact(ctx context.Context, contact model.Contact) error {
-	// Get existing card to preserve UID
-	objects, err := d.CardDAV.MultiGetAddressBook(ctx, contact.AddressBookID, &carddav.AddressBookMultiGet{
-		Paths: []string{contact.ID},
-		DataRequest: carddav.AddressDataRequest{
-			Props: []string{vcard.FieldUID},
-		},
-	})
-	if err != nil || len(objects) == 0 {
+	obj, err := d.CardDAV.GetAddressObject(ctx, contact.ID)
+	if err != nil {
 		return fmt.Errorf("failed to get existing contact: %w", err)
 	}
-
-	uid := ""
-	if uidField := objects[0].Card.Get(vcard.FieldUID); uidField != nil {
-		uid = uidField.Value
-	}
-	if uid == "" {
-		uid = fmt.Sprintf("%d@fm-cli", time.Now().UnixNano())
-	}
-
-	card := make(vcard.Card)
-	card.SetValue(vcard.FieldUID, uid)
-	card.SetValue(vcard.FieldFormattedName, contact.FullName)
-
-	if contact.FirstName != "" || contact.LastName != "" {
-		name := &vcard.Name{
-			FamilyName:      contact.LastName,
-			GivenName:       contact.FirstName,
-			HonorificPrefix: contact.Prefix,
-			HonorificSuffix: contact.Suffix,
-		}
-		card.AddName(name)
-	}
-
-	if contact.Nickname != "" {
-		card.SetValue(vcard.FieldNickname, contact.Nickname)
-	}
-	if contact.Company != "" {
-		card.SetValue(vcard.FieldOrganization, contact.Company)
-	}
-
-	for _, email := range contact.Emails {
-		field := &vcard.Field{
-			Value:  email.Email,
-			Params: make(vcard.Params),
-		}
-		if email.Type != "" && email.Type != "other" {
-			field.Params.Add(vcard.ParamType, strings.ToUpper(email.Type))
-		}
-		card.Add(vcard.FieldEmail, field)
-	}
-
-	for _, phone := range contact.Phones {
-		field := &vcard.Field{
-			Value:  phone.Number,
-			Params: make(vcard.Params),
-		}
-		switch phone.Type {
-		case "mobile":
-			field.Params.Add(vcard.ParamType, "CELL")
-		case "work", "home", "fax":
-			field.Params.Add(vcard.ParamType, strings.ToUpper(phone.Type))
-		}
-		card.Add(vcard.FieldTelephone, field)
+	if contact.ETag != "" && obj.ETag != "" && contact.ETag != obj.ETag {
+		return fmt.Errorf("contact %w", ErrConflict)
 	}
 
-	if contact.Notes != "" {
-		card.SetValue(vcard.FieldNote, contact.Notes)
+	card := obj.Card
+	if card.Value(vcard.FieldUID) == "" {
+		card.SetValue(vcard.FieldUID, fmt.Sprintf("%d@fm-cli", time.Now().UnixNano()))
 	}
+	patchCard(card, contact)
 
-	card.SetValue(vcard.FieldVersion, "3.0")
-
-	_, err = d.CardDAV.PutAddressObject(ctx, contact.ID, card)
+	_, err = d.CardDAV.PutAddressObject(withIfMatch(ctx, obj.ETag), contact.ID, card)
 	if err != nil {
-		return fmt.Errorf("failed to update contact: %w", err)
+		return davError("failed to update contact", err)
 	}
 
 	return nil
 }
 
-// DeleteContact deletes a contact via CardDAV
-func (d *DAVClient) DeleteContact(ctx context.Context, contactPath string) error {
-	err := d.CardDAV.RemoveAll(ctx, contactPath)
+// DeleteContact deletes a contact via CardDAV, failing with ErrConflict if
+// the card has changed on the server since it was read
+func (d *DAVClient) DeleteContact(ctx context.Context, contact model.Contact) error {
+	err := d.CardDAV.RemoveAll(withIfMatch(ctx, contact.ETag), contact.ID)
 	if err != nil {
-		return fmt.Errorf("failed to delete contact: %w", err)
+		return davError("failed to delete contact", err)
 	}
 	return nil
 }
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"fm-cli/internal/model"
+)
+
+func TestDAVCredentialsStayWithConfiguredHost(t *testing.T) {
+	var elsewhereAuth string
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		elsewhereAuth = r.Header.Get("Authorization")
+	}))
+	defer elsewhere.Close()
+
+	var authed []string
+	dav := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); ok {
+			authed = append(authed, r.URL.Path)
+		}
+		switch r.URL.Path {
+		case "/.well-known/caldav":
+			http.Redirect(w, r, "/dav/", http.StatusMovedPermanently)
+		case "/dav/":
+			http.Redirect(w, r, elsewhere.URL+"/dav/", http.StatusFound)
+		}
+	}))
+	defer dav.Close()
+
+	d, err := NewDAVClientWithConfig(DAVConfig{CalDAVURL: dav.URL + "/", Username: "me", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, dav.URL+"/.well-known/caldav", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(authed) != 2 {
+		t.Errorf("configured server got credentials on %v, want the request and its redirect", authed)
+	}
+	if elsewhereAuth != "" {
+		t.Errorf("redirect to another host got Authorization %q", elsewhereAuth)
+	}
+}
+
+// newVersionedDAV serves DELETE for resources whose ETag is "v2" and
+// records the If-Match headers it was sent
+func newVersionedDAV(t *testing.T, ifMatch *[]string) *DAVClient {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "unexpected "+r.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		*ifMatch = append(*ifMatch, r.Header.Get("If-Match"))
+		if m := r.Header.Get("If-Match"); m != "" && m != `"v2"` {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+
+	d, err := NewDAVClientWithConfig(DAVConfig{CalDAVURL: srv.URL + "/", CardDAVURL: srv.URL + "/", Username: "me", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestDeleteEventChangedElsewhere(t *testing.T) {
+	var ifMatch []string
+	d := newVersionedDAV(t, &ifMatch)
+
+	err := d.DeleteEvent(context.Background(), model.CalendarEvent{ID: "/cal/a.ics", ETag: "v1"})
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("err = %v, want ErrConflict", err)
+	}
+	if err := d.DeleteEvent(context.Background(), model.CalendarEvent{ID: "/cal/a.ics", ETag: "v2"}); err != nil {
+		t.Errorf("deleting the current version: %v", err)
+	}
+	if want := []string{`"v1"`, `"v2"`}; len(ifMatch) != 2 || ifMatch[0] != want[0] || ifMatch[1] != want[1] {
+		t.Errorf("If-Match = %q, want %q", ifMatch, want)
+	}
+}
+
+func TestDeleteContactChangedElsewhere(t *testing.T) {
+	var ifMatch []string
+	d := newVersionedDAV(t, &ifMatch)
+
+	err := d.DeleteContact(context.Background(), model.Contact{ID: "/ab/a.vcf", ETag: "v1"})
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("err = %v, want ErrConflict", err)
+	}
+	if err := d.DeleteContact(context.Background(), model.Contact{ID: "/ab/a.vcf", ETag: "v2"}); err != nil {
+		t.Errorf("deleting the current version: %v", err)
+	}
+	if want := []string{`"v1"`, `"v2"`}; len(ifMatch) != 2 || ifMatch[0] != want[0] || ifMatch[1] != want[1] {
+		t.Errorf("If-Match = %q, want %q", ifMatch, want)
+	}
+}
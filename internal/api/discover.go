@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/emersion/go-webdav/caldav"
+	"github.com/emersion/go-webdav/carddav"
+)
+
+// What discovery looks things up with; tests point these at local servers
+var (
+	lookupSRV       = net.DefaultResolver.LookupSRV
+	discoveryClient = http.DefaultClient
+)
+
+// emailDomain returns the part of an email address after the @
+func emailDomain(email string) (string, error) {
+	at := strings.LastIndex(email, "@")
+	if at < 0 || at == len(email)-1 {
+		return "", fmt.Errorf("not an email address: %q", email)
+	}
+	return email[at+1:], nil
+}
+
+// DiscoverSessionURL finds the JMAP session resource for an email address
+// as RFC 8620 section 2.2 describes: a _jmap._tcp SRV record on the
+// address's domain, falling back to https://<domain>/.well-known/jmap.
+func DiscoverSessionURL(ctx context.Context, email string) (string, error) {
+	domain, err := emailDomain(email)
+	if err != nil {
+		return "", err
+	}
+
+	var candidates []string
+	if _, srvs, err := lookupSRV(ctx, "jmap", "tcp", domain); err == nil {
+		for _, srv := range srvs {
+			// A target of "." means the service is decidedly not available
+			host := strings.TrimSuffix(srv.Target, ".")
+			if host == "" {
+				continue
+			}
+			candidates = append(candidates, fmt.Sprintf("https://%s:%d/.well-known/jmap", host, srv.Port))
+		}
+	}
+	candidates = append(candidates, "https://"+domain+"/.well-known/jmap")
+
+	for _, u := range candidates {
+		if isSessionEndpoint(ctx, u) {
+			return u, nil
+		}
+	}
+	return "", fmt.Errorf("no JMAP server found for %s", domain)
+}
+
+// isSessionEndpoint reports whether a well-known URL leads to a JMAP
+// session resource. Without credentials servers answer 401, which still
+// shows one is there; redirects are followed as the client will.
+func isSessionEndpoint(ctx context.Context, u string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := discoveryClient.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusUnauthorized
+}
+
+// DiscoverDAVURLs finds the CalDAV and CardDAV servers for an email address
+// as RFC 6764 describes: _caldavs._tcp and _carddavs._tcp SRV records with
+// an optional TXT path, falling back to https://<domain>/.well-known/caldav
+// and carddav. Either URL is empty when its service wasn't found.
+func DiscoverDAVURLs(ctx context.Context, email string) (calURL, cardURL string, err error) {
+	domain, err := emailDomain(email)
+	if err != nil {
+		return "", "", err
+	}
+	calURL = discoverDAVURL(ctx, "caldav", domain, caldav.DiscoverContextURL)
+	cardURL = discoverDAVURL(ctx, "carddav", domain, carddav.DiscoverContextURL)
+	if calURL == "" && cardURL == "" {
+		return "", "", fmt.Errorf("no CalDAV or CardDAV server found for %s", domain)
+	}
+	return calURL, cardURL, nil
+}
+
+func discoverDAVURL(ctx context.Context, service, domain string, srv func(context.Context, string) (string, error)) string {
+	var candidates []string
+	if u, err := srv(ctx, domain); err == nil {
+		candidates = append(candidates, u)
+	}
+	candidates = append(candidates, "https://"+domain+"/.well-known/"+service)
+
+	for _, u := range candidates {
+		if resolved, ok := resolveDAVURL(ctx, u); ok {
+			return resolved
+		}
+	}
+	return ""
+}
+
+// resolveDAVURL checks that a DAV server answers at u and follows the
+// redirect well-known URLs usually are. The WebDAV client can't do that
+// itself: Go turns a redirected PROPFIND into a GET.
+func resolveDAVURL(ctx context.Context, u string) (string, bool) {
+	client := &http.Client{
+		Transport: discoveryClient.Transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	for hops := 0; hops < 5; hops++ {
+		req, err := http.NewRequestWithContext(ctx, "PROPFIND", u, nil)
+		if err != nil {
+			return "", false
+		}
+		req.Header.Set("Depth", "0")
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", false
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode >= 300 && resp.StatusCode < 400:
+			loc, err := resp.Location()
+			if err != nil {
+				return "", false
+			}
+			u = loc.String()
+		case resp.StatusCode == http.StatusMultiStatus || resp.StatusCode == http.StatusUnauthorized:
+			// Without credentials a server answers 401, which still shows
+			// one is there
+			return u, true
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// stubDiscovery points discovery at test servers: SRV lookups answer with
+// srvs, and HTTPS requests trust httptest's certificate
+func stubDiscovery(t *testing.T, client *http.Client, srvs []*net.SRV) {
+	t.Helper()
+	oldLookup, oldClient := lookupSRV, discoveryClient
+	t.Cleanup(func() { lookupSRV, discoveryClient = oldLookup, oldClient })
+
+	lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		if srvs == nil {
+			return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+		}
+		return "", srvs, nil
+	}
+	discoveryClient = client
+}
+
+// hostPort splits a test server's address for use as a domain or SRV target
+func hostPort(t *testing.T, srv *httptest.Server) (string, uint16) {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Hostname(), uint16(port)
+}
+
+func TestDiscoverSessionURLFromSRV(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/jmap" {
+			http.NotFound(w, r)
+			return
+		}
+		// No credentials, so a real server asks for them
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	host, port := hostPort(t, srv)
+	stubDiscovery(t, srv.Client(), []*net.SRV{{Target: host + ".", Port: port}})
+
+	got, err := DiscoverSessionURL(context.Background(), "me@example.invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := srv.URL + "/.well-known/jmap"; got != want {
+		t.Errorf("DiscoverSessionURL = %q, want %q", got, want)
+	}
+}
+
+func TestDiscoverSessionURLFallsBackToWellKnown(t *testing.T) {
+	// The SRV target has no JMAP server
+	dead := httptest.NewTLSServer(http.NotFoundHandler())
+	defer dead.Close()
+
+	// The domain redirects its well-known URL to the session resource
+	var sessionHit bool
+	domain := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/jmap":
+			http.Redirect(w, r, "/jmap/session", http.StatusMovedPermanently)
+		case "/jmap/session":
+			sessionHit = true
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"capabilities":{}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer domain.Close()
+
+	deadHost, deadPort := hostPort(t, dead)
+	stubDiscovery(t, domain.Client(), []*net.SRV{
+		{Target: ".", Port: 443}, // Explicitly no service; skipped
+		{Target: deadHost + ".", Port: deadPort},
+	})
+
+	host, port := hostPort(t, domain)
+	got, err := DiscoverSessionURL(context.Background(), "me@"+net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := domain.URL + "/.well-known/jmap"; got != want {
+		t.Errorf("DiscoverSessionURL = %q, want %q", got, want)
+	}
+	if !sessionHit {
+		t.Error("redirect to the session resource wasn't followed")
+	}
+}
+
+func TestDiscoverSessionURLNotFound(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	stubDiscovery(t, srv.Client(), nil)
+
+	host, port := hostPort(t, srv)
+	_, err := DiscoverSessionURL(context.Background(), "me@"+net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err == nil {
+		t.Fatal("DiscoverSessionURL succeeded with only 404s")
+	}
+}
+
+func TestDiscoverSessionURLBadAddress(t *testing.T) {
+	for _, email := range []string{"", "nobody", "me@"} {
+		if _, err := DiscoverSessionURL(context.Background(), email); err == nil {
+			t.Errorf("DiscoverSessionURL(%q) succeeded", email)
+		}
+	}
+}
+
+func TestResolveDAVURLFollowsRedirect(t *testing.T) {
+	var methods []string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		switch r.URL.Path {
+		case "/.well-known/caldav":
+			http.Redirect(w, r, "/dav/principals/user/me/", http.StatusMovedPermanently)
+		case "/dav/principals/user/me/":
+			w.WriteHeader(http.StatusMultiStatus)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	stubDiscovery(t, srv.Client(), nil)
+
+	got, ok := resolveDAVURL(context.Background(), srv.URL+"/.well-known/caldav")
+	if !ok {
+		t.Fatal("resolveDAVURL found no server")
+	}
+	if want := srv.URL + "/dav/principals/user/me/"; got != want {
+		t.Errorf("resolveDAVURL = %q, want %q", got, want)
+	}
+	// Go's own redirect handling would have turned the PROPFIND into a GET
+	for _, m := range methods {
+		if m != "PROPFIND" {
+			t.Errorf("server got a %s, want only PROPFIND", m)
+		}
+	}
+}
+
+func TestResolveDAVURLRedirectLoop(t *testing.T) {
+	hits := 0
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path == "/a" {
+			http.Redirect(w, r, "/b", http.StatusFound)
+		} else {
+			http.Redirect(w, r, "/a", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	stubDiscovery(t, srv.Client(), nil)
+
+	if got, ok := resolveDAVURL(context.Background(), srv.URL+"/a"); ok {
+		t.Errorf("resolveDAVURL = %q through a redirect loop", got)
+	}
+	if hits > 5 {
+		t.Errorf("followed %d redirects, want at most 5", hits)
+	}
+}
+
+func TestResolveDAVURLTooManyRedirects(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		if n == 10 {
+			w.WriteHeader(http.StatusMultiStatus)
+			return
+		}
+		http.Redirect(w, r, "/?n="+strconv.Itoa(n+1), http.StatusMovedPermanently)
+	}))
+	defer srv.Close()
+	stubDiscovery(t, srv.Client(), nil)
+
+	if got, ok := resolveDAVURL(context.Background(), srv.URL+"/?n=0"); ok {
+		t.Errorf("resolveDAVURL = %q after 10 redirects", got)
+	}
+}
+
+func TestDiscoverDAVURLFallsBackToWellKnown(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/carddav":
+			http.Redirect(w, r, "/dav/addressbooks/", http.StatusMovedPermanently)
+		case "/dav/addressbooks/":
+			// No credentials, so a real server asks for them
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	stubDiscovery(t, srv.Client(), nil)
+
+	host, port := hostPort(t, srv)
+	domain := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	noSRV := func(context.Context, string) (string, error) {
+		return "", &net.DNSError{Err: "no such host", IsNotFound: true}
+	}
+	// The SRV record points nowhere useful, so the well-known URL is next
+	badSRV := func(context.Context, string) (string, error) {
+		return srv.URL + "/missing/", nil
+	}
+
+	if got, want := discoverDAVURL(context.Background(), "carddav", domain, badSRV), srv.URL+"/dav/addressbooks/"; got != want {
+		t.Errorf("discoverDAVURL = %q, want %q", got, want)
+	}
+	if got := discoverDAVURL(context.Background(), "caldav", domain, noSRV); got != "" {
+		t.Errorf("discoverDAVURL = %q for a service the server doesn't have", got)
+	}
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"fm-cli/internal/model"
+
+	"git.sr.ht/~rockorager/go-jmap"
+	"git.sr.ht/~rockorager/go-jmap/mail/email"
+	"git.sr.ht/~rockorager/go-jmap/mail/mailbox"
+)
+
+// ErrMailboxHasEmail is returned when a folder can't be deleted because it
+// still holds emails and they weren't to be deleted with it
+var ErrMailboxHasEmail = errors.New("folder still has emails")
+
+// ErrMailboxHasChild is returned when a folder can't be deleted because it
+// has subfolders
+var ErrMailboxHasChild = errors.New("folder has subfolders, move or delete them first")
+
+// CreateMailbox creates a folder inside parentID, or at the top level when
+// parentID is empty, and returns it
+func (c *Client) CreateMailbox(name, parentID string) (model.Mailbox, error) {
+	req := &jmap.Request{}
+	req.Invoke(&mailbox.Set{
+		Account: c.getMailAccountID(),
+		Create: map[jmap.ID]*mailbox.Mailbox{
+			"new": {Name: name, ParentID: jmap.ID(parentID), IsSubscribed: true},
+		},
+	})
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return model.Mailbox{}, fmt.Errorf("Mailbox/set failed: %w", err)
+	}
+	res, err := mailboxSetResponse(resp)
+	if err != nil {
+		return model.Mailbox{}, err
+	}
+
+	created, ok := res.Created["new"]
+	if !ok || created == nil {
+		return model.Mailbox{}, fmt.Errorf("failed to create folder: no ID returned")
+	}
+	return model.Mailbox{
+		ID:        string(created.ID),
+		Name:      name,
+		ParentID:  parentID,
+		SortOrder: int(created.SortOrder),
+	}, nil
+}
+
+// RenameMailbox gives a folder a new name
+func (c *Client) RenameMailbox(id, name string) error {
+	return c.updateMailbox(id, jmap.Patch{"name": name})
+}
+
+// MoveMailbox puts a folder inside parentID, or at the top level when
+// parentID is empty
+func (c *Client) MoveMailbox(id, parentID string) error {
+	var parent interface{}
+	if parentID != "" {
+		parent = parentID
+	}
+	return c.updateMailbox(id, jmap.Patch{"parentId": parent})
+}
+
+func (c *Client) updateMailbox(id string, patch jmap.Patch) error {
+	req := &jmap.Request{}
+	req.Invoke(&mailbox.Set{
+		Account: c.getMailAccountID(),
+		Update:  map[jmap.ID]jmap.Patch{jmap.ID(id): patch},
+	})
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Mailbox/set failed: %w", err)
+	}
+	_, err = mailboxSetResponse(resp)
+	return err
+}
+
+// DeleteMailbox destroys a folder. With removeEmails the emails only in
+// this folder are destroyed too; without it the server refuses while the
+// folder holds any, and ErrMailboxHasEmail is returned.
+func (c *Client) DeleteMailbox(id string, removeEmails bool) error {
+	req := &jmap.Request{}
+	req.Invoke(&mailbox.Set{
+		Account:               c.getMailAccountID(),
+		Destroy:               []jmap.ID{jmap.ID(id)},
+		OnDestroyRemoveEmails: removeEmails,
+	})
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Mailbox/set failed: %w", err)
+	}
+	_, err = mailboxSetResponse(resp)
+	return err
+}
+
+// EmptyMailbox permanently deletes every email in a mailbox, such as the
+// Trash, and returns the IDs of those it deleted. It stops at the first
+// batch the server won't fully destroy.
+func (c *Client) EmptyMailbox(mailboxID string) ([]string, error) {
+	var destroyed []string
+	for {
+		req := &jmap.Request{}
+		req.Invoke(&email.Query{
+			Account: c.getMailAccountID(),
+			Filter:  &email.FilterCondition{InMailbox: jmap.ID(mailboxID)},
+			Limit:   uint64(c.maxObjectsInSet()),
+		})
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			return destroyed, fmt.Errorf("Email/query failed: %w", err)
+		}
+		var ids []string
+		for _, inv := range resp.Responses {
+			if methodErr, ok := inv.Args.(*jmap.MethodError); ok {
+				return destroyed, fmt.Errorf("JMAP method error: %s (type: %s)", inv.Name, methodErr.Type)
+			}
+			if res, ok := inv.Args.(*email.QueryResponse); ok {
+				ids = fromJMAPIDs(res.IDs)
+			}
+		}
+		if len(ids) == 0 {
+			return destroyed, nil
+		}
+
+		err = c.DestroyEmails(ids)
+		var setErr *EmailSetError
+		if errors.As(err, &setErr) {
+			for _, id := range ids {
+				if _, failed := setErr.Failed[id]; !failed {
+					destroyed = append(destroyed, id)
+				}
+			}
+			return destroyed, err
+		}
+		if err != nil {
+			return destroyed, err
+		}
+		destroyed = append(destroyed, ids...)
+	}
+}
+
+// mailboxSetResponse returns the Mailbox/set result, or what it couldn't do
+func mailboxSetResponse(resp *jmap.Response) (*mailbox.SetResponse, error) {
+	for _, inv := range resp.Responses {
+		if methodErr, ok := inv.Args.(*jmap.MethodError); ok {
+			return nil, fmt.Errorf("JMAP method error: %s (type: %s)", inv.Name, methodErr.Type)
+		}
+		res, ok := inv.Args.(*mailbox.SetResponse)
+		if !ok {
+			continue
+		}
+
+		failed := make(map[jmap.ID]*jmap.SetError)
+		for id, setErr := range res.NotCreated {
+			failed[id] = setErr
+		}
+		for id, setErr := range res.NotUpdated {
+			failed[id] = setErr
+		}
+		for id, setErr := range res.NotDestroyed {
+			failed[id] = setErr
+		}
+		var errs []string
+		for _, setErr := range failed {
+			switch setErr.Type {
+			case "mailboxHasEmail":
+				return nil, ErrMailboxHasEmail
+			case "mailboxHasChild":
+				return nil, ErrMailboxHasChild
+			}
+			desc := ""
+			if setErr.Description != nil {
+				desc = " (" + *setErr.Description + ")"
+			}
+			errs = append(errs, setErr.Type+desc)
+		}
+		if len(errs) > 0 {
+			sort.Strings(errs)
+			return nil, fmt.Errorf("failed to change folder: %s", strings.Join(errs, "; "))
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("no Mailbox/set response")
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	netmail "net/mail"
@@ -11,6 +12,7 @@ import (
 
 	md "github.com/JohannesKaufmann/html-to-markdown"
 	"git.sr.ht/~rockorager/go-jmap"
+	"git.sr.ht/~rockorager/go-jmap/core"
 	"git.sr.ht/~rockorager/go-jmap/mail"
 	"git.sr.ht/~rockorager/go-jmap/mail/email"
 	"git.sr.ht/~rockorager/go-jmap/mail/emailsubmission"
@@ -25,14 +27,29 @@ type Client struct {
 
 const FastmailSessionURL = "https://api.fastmail.com/.well-known/jmap"
 
-// NewClient initializes a JMAP client with the given token.
+// NewClient initializes a JMAP client for Fastmail with the given token.
 func NewClient(token string) (*Client, error) {
+	return NewClientWithConfig(model.AppConfig{APIToken: token})
+}
+
+// NewClientWithConfig initializes a JMAP client for any server. It uses
+// the session resource in cfg.JMAPCore, Fastmail's if empty, and logs in
+// with the API token as a bearer token, or with Basic auth if there is no
+// token.
+func NewClientWithConfig(cfg model.AppConfig) (*Client, error) {
 	// Initialize the JMAP client
 	c := &jmap.Client{
-		SessionEndpoint: FastmailSessionURL,
+		SessionEndpoint: SessionURL(cfg),
 		HttpClient:      &http.Client{},
 	}
-	c.WithAccessToken(token)
+	switch {
+	case cfg.APIToken != "":
+		c.WithAccessToken(cfg.APIToken)
+	case cfg.Username != "":
+		c.WithBasicAuth(cfg.Username, cfg.Password)
+	default:
+		return nil, fmt.Errorf("no API token or username configured")
+	}
 
 	// Phase 1: Authentication & Session Discovery
 	// We fetch the session object to discover capabilities and URLs.
@@ -46,6 +63,14 @@ func NewClient(token string) (*Client, error) {
 	}, nil
 }
 
+// SessionURL returns the JMAP session resource to use for cfg
+func SessionURL(cfg model.AppConfig) string {
+	if cfg.JMAPCore != "" {
+		return cfg.JMAPCore
+	}
+	return FastmailSessionURL
+}
+
 // DebugSession prints session info for debugging
 func (c *Client) DebugSession() string {
 	if c.Session == nil {
@@ -165,7 +190,7 @@ func (c *Client) FetchEmails(mailboxID string, position int) ([]model.Email, err
 	g := &email.Get{
 		Account:    c.getMailAccountID(),
 		IDs:        ids,
-		Properties: []string{"id", "subject", "from", "to", "cc", "bcc", "replyTo", "preview", "receivedAt", "mailboxIds", "threadId", "keywords"},
+		Properties: emailListProperties,
 	}
 	reqGet.Invoke(g)
 
@@ -177,54 +202,7 @@ func (c *Client) FetchEmails(mailboxID string, position int) ([]model.Email, err
 	for _, inv := range resp2.Responses {
 		if res, ok := inv.Args.(*email.GetResponse); ok {
 			for _, e := range res.List {
-				// Convert to model.Email
-				sender := formatAddresses(e.From)
-				to := formatAddresses(e.To)
-				cc := formatAddresses(e.CC)
-				bcc := formatAddresses(e.BCC)
-				replyTo := formatAddresses(e.ReplyTo)
-
-				isUnread := true
-				if _, ok := e.Keywords["$seen"]; ok {
-					isUnread = false
-				}
-				
-				isFlagged := false
-				if _, ok := e.Keywords["$flagged"]; ok {
-					isFlagged = true
-				}
-
-				isDraft := false
-				if _, ok := e.Keywords["$draft"]; ok {
-					isDraft = true
-				}
-
-				var boxIDs []string
-				for k := range e.MailboxIDs {
-					boxIDs = append(boxIDs, string(k))
-				}
-
-				dateStr := ""
-				if e.ReceivedAt != nil {
-					dateStr = e.ReceivedAt.Format("2006-01-02 15:04")
-				}
-
-				emails = append(emails, model.Email{
-					ID:         string(e.ID),
-					Subject:    e.Subject,
-					From:       sender,
-					To:         to,
-					Cc:         cc,
-					Bcc:        bcc,
-					ReplyTo:    replyTo,
-					Preview:    e.Preview,
-					Date:       dateStr,
-					IsUnread:   isUnread,
-					IsFlagged:  isFlagged,
-					IsDraft:    isDraft,
-					ThreadID:   string(e.ThreadID),
-					MailboxIDs: boxIDs,
-				})
+				emails = append(emails, toModelEmail(e))
 			}
 		}
 	}
@@ -326,78 +304,325 @@ return mb.ID, nil
 return "", fmt.Errorf("mailbox with role %s not found", role)
 }
 
-// DeleteEmail moves an email to Trash (or deletes it).
-func (c *Client) DeleteEmail(emailID string) error {
-	req := &jmap.Request{}
-	req.Invoke(&email.Set{
-		Account: c.getMailAccountID(),
-		Destroy: []jmap.ID{jmap.ID(emailID)},
+// TrashEmails moves emails to the Trash mailbox, trashID, taking them out
+// of every other mailbox as deleting does in other clients.
+func (c *Client) TrashEmails(emailIDs []string, trashID string) error {
+	mailboxes := make(map[string][]string, len(emailIDs))
+	for _, id := range emailIDs {
+		mailboxes[id] = []string{trashID}
+	}
+	return c.SetMailboxes(mailboxes)
+}
+
+// SetMailboxes puts each email in exactly the mailboxes it maps to, in a
+// single Email/set call.
+func (c *Client) SetMailboxes(mailboxes map[string][]string) error {
+	update := make(map[jmap.ID]jmap.Patch, len(mailboxes))
+	for id, mbIDs := range mailboxes {
+		set := make(map[string]bool, len(mbIDs))
+		for _, mbID := range mbIDs {
+			set[mbID] = true
+		}
+		update[jmap.ID(id)] = jmap.Patch{"mailboxIds": set}
+	}
+	return c.setEmails(update)
+}
+
+// DestroyEmails permanently deletes several emails, in as few Email/set
+// calls as the server allows. Only the Trash and Junk mailboxes offer this.
+func (c *Client) DestroyEmails(emailIDs []string) error {
+	return c.inBatches(emailIDs, func(batch []string) error {
+		req := &jmap.Request{}
+		req.Invoke(&email.Set{
+			Account: c.getMailAccountID(),
+			Destroy: toJMAPIDs(batch),
+		})
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		return emailSetError(resp)
 	})
-	_, err := c.Client.Do(req)
-	return err
 }
 
 // MoveEmail moves an email from one mailbox to another.
 func (c *Client) MoveEmail(emailID, fromMailboxID, toMailboxID string) error {
-	req := &jmap.Request{}
-	
-	patch := map[string]interface{}{
-		"mailboxIds/" + toMailboxID: true,
+	return c.MoveEmails([]string{emailID}, fromMailboxID, toMailboxID)
+}
+
+// MoveEmails moves several emails from one mailbox to another in a single
+// Email/set call.
+func (c *Client) MoveEmails(emailIDs []string, fromMailboxID, toMailboxID string) error {
+	from := make(map[string]string, len(emailIDs))
+	for _, id := range emailIDs {
+		from[id] = fromMailboxID
+	}
+	return c.MoveEmailsFrom(from, toMailboxID)
+}
+
+// MoveEmailsFrom moves emails that may be in different mailboxes in a
+// single Email/set call. from maps each email ID to the mailbox it leaves;
+// an empty one just adds toMailboxID, copying the email there.
+func (c *Client) MoveEmailsFrom(from map[string]string, toMailboxID string) error {
+	update := make(map[jmap.ID]jmap.Patch, len(from))
+	for id, fromMailboxID := range from {
+		patch := jmap.Patch{
+			"mailboxIds/" + toMailboxID: true,
+		}
+		if fromMailboxID != "" && fromMailboxID != toMailboxID {
+			patch["mailboxIds/"+fromMailboxID] = nil
+		}
+		update[jmap.ID(id)] = patch
 	}
-	if fromMailboxID != "" && fromMailboxID != toMailboxID {
-		patch["mailboxIds/" + fromMailboxID] = nil
+	return c.setEmails(update)
+}
+
+// SetKeyword adds or removes a keyword, such as $important or a label of
+// the user's own, on several emails at once.
+func (c *Client) SetKeyword(emailIDs []string, keyword string, set bool) error {
+	path := keywordPath(keyword)
+	patch := jmap.Patch{path: nil}
+	if set {
+		patch[path] = true
 	}
+	return c.updateEmails(emailIDs, patch)
+}
 
-	req.Invoke(&email.Set{
-		Account: c.getMailAccountID(),
-		Update: map[jmap.ID]jmap.Patch{
-			jmap.ID(emailID): patch,
-		},
-	})
-	_, err := c.Client.Do(req)
-	return err
+// keywordPath is the patch path of a keyword. It's a JSON pointer, so "~"
+// and "/" need escaping.
+func keywordPath(keyword string) string {
+	return "keywords/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(keyword)
+}
+
+// EmailState is what RestoreEmails puts back on an email. Nil Mailboxes
+// are left alone, as are keywords not in the map.
+type EmailState struct {
+	Mailboxes []string
+	Keywords  map[string]bool // Whether the email had each keyword
+}
+
+// RestoreEmails puts emails back the way they were, e.g. to undo a move
+// or a flag, in a single Email/set call.
+func (c *Client) RestoreEmails(states map[string]EmailState) error {
+	update := make(map[jmap.ID]jmap.Patch, len(states))
+	for id, state := range states {
+		patch := jmap.Patch{}
+		if state.Mailboxes != nil {
+			set := make(map[string]bool, len(state.Mailboxes))
+			for _, mbID := range state.Mailboxes {
+				set[mbID] = true
+			}
+			patch["mailboxIds"] = set
+		}
+		for keyword, had := range state.Keywords {
+			patch[keywordPath(keyword)] = nil
+			if had {
+				patch[keywordPath(keyword)] = true
+			}
+		}
+		if len(patch) > 0 {
+			update[jmap.ID(id)] = patch
+		}
+	}
+	if len(update) == 0 {
+		return nil
+	}
+	return c.setEmails(update)
 }
 
 // SetUnread toggles the $seen keyword.
 func (c *Client) SetUnread(emailID string, isUnread bool) error {
-	req := &jmap.Request{}
-	
+	return c.SetUnreadMany([]string{emailID}, isUnread)
+}
+
+// SetUnreadMany sets or clears the $seen keyword on several emails at once.
+func (c *Client) SetUnreadMany(emailIDs []string, isUnread bool) error {
 	patch := map[string]interface{}{}
 	if isUnread {
 		patch["keywords/$seen"] = nil // Remove $seen to mark unread
 	} else {
 		patch["keywords/$seen"] = true // Add $seen to mark read
 	}
-
-	req.Invoke(&email.Set{
-		Account: c.getMailAccountID(),
-		Update: map[jmap.ID]jmap.Patch{
-			jmap.ID(emailID): patch,
-		},
-	})
-	_, err := c.Client.Do(req)
-	return err
+	return c.updateEmails(emailIDs, patch)
 }
 
 // SetFlagged toggles the $flagged keyword.
 func (c *Client) SetFlagged(emailID string, isFlagged bool) error {
-	req := &jmap.Request{}
-	
+	return c.SetFlaggedMany([]string{emailID}, isFlagged)
+}
+
+// SetFlaggedMany sets or clears the $flagged keyword on several emails at once.
+func (c *Client) SetFlaggedMany(emailIDs []string, isFlagged bool) error {
 	patch := map[string]interface{}{}
 	if isFlagged {
 		patch["keywords/$flagged"] = true
 	} else {
 		patch["keywords/$flagged"] = nil
 	}
+	return c.updateEmails(emailIDs, patch)
+}
 
-	req.Invoke(&email.Set{
-		Account: c.getMailAccountID(),
-		Update: map[jmap.ID]jmap.Patch{
-			jmap.ID(emailID): patch,
-		},
+// updateEmails applies the same patch to every email in one Email/set call.
+func (c *Client) updateEmails(emailIDs []string, patch jmap.Patch) error {
+	update := make(map[jmap.ID]jmap.Patch, len(emailIDs))
+	for _, id := range emailIDs {
+		update[jmap.ID(id)] = patch
+	}
+	return c.setEmails(update)
+}
+
+// setEmails applies a patch per email, in as few Email/set calls as the
+// server allows
+func (c *Client) setEmails(update map[jmap.ID]jmap.Patch) error {
+	ids := make([]string, 0, len(update))
+	for id := range update {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+	return c.inBatches(ids, func(batch []string) error {
+		req := &jmap.Request{}
+		patches := make(map[jmap.ID]jmap.Patch, len(batch))
+		for _, id := range batch {
+			patches[jmap.ID(id)] = update[jmap.ID(id)]
+		}
+		req.Invoke(&email.Set{
+			Account: c.getMailAccountID(),
+			Update:  patches,
+		})
+		resp, err := c.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		return emailSetError(resp)
 	})
-	_, err := c.Client.Do(req)
-	return err
+}
+
+// defaultSetBatch is how many objects a /set call changes when the server
+// doesn't give its maxObjectsInSet
+const defaultSetBatch = 250
+
+// maxObjectsInSet is the most objects the server takes in one /set call
+func (c *Client) maxObjectsInSet() int {
+	if c.Session != nil {
+		if caps, ok := c.Session.Capabilities[jmap.CoreURI].(*core.Core); ok && caps.MaxObjectsInSet > 0 {
+			return int(caps.MaxObjectsInSet)
+		}
+	}
+	return defaultSetBatch
+}
+
+// inBatches runs set over the IDs in batches of at most maxObjectsInSet,
+// gathering what each batch couldn't change into one *EmailSetError. A
+// batch failing outright counts against it and the batches after it,
+// unless nothing has changed yet, when its error is returned as it is.
+func (c *Client) inBatches(ids []string, set func(batch []string) error) error {
+	size := c.maxObjectsInSet()
+	failed := make(map[string]string)
+	for start := 0; start < len(ids); start += size {
+		batch := ids[start:min(start+size, len(ids))]
+		err := set(batch)
+		var setErr *EmailSetError
+		switch {
+		case errors.As(err, &setErr):
+			for id, reason := range setErr.Failed {
+				failed[id] = reason
+			}
+		case err != nil:
+			if start == 0 {
+				return err
+			}
+			for _, id := range ids[start:] {
+				failed[id] = err.Error()
+			}
+			return &EmailSetError{Failed: failed}
+		}
+	}
+	if len(failed) > 0 {
+		return &EmailSetError{Failed: failed}
+	}
+	return nil
+}
+
+// ErrNotFound is returned when an email an operation targets no longer
+// exists on the server.
+var ErrNotFound = errors.New("email no longer exists on the server")
+
+// EmailSetError lists the emails an Email/set call couldn't update or
+// destroy. The changes to the other emails in the call went through.
+// When every failure is notFound it wraps ErrNotFound.
+type EmailSetError struct {
+	Failed map[string]string // Email ID to the reason the server gave
+}
+
+func (e *EmailSetError) Error() string {
+	errs := make([]string, 0, len(e.Failed))
+	for id, reason := range e.Failed {
+		errs = append(errs, id+": "+reason)
+	}
+	sort.Strings(errs)
+	if e.allNotFound() {
+		return fmt.Sprintf("%v: %s", ErrNotFound, strings.Join(errs, "; "))
+	}
+	return "failed to update emails: " + strings.Join(errs, "; ")
+}
+
+func (e *EmailSetError) Unwrap() error {
+	if e.allNotFound() {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Reasons returns the distinct reasons emails failed, sorted
+func (e *EmailSetError) Reasons() []string {
+	seen := make(map[string]bool)
+	var reasons []string
+	for _, reason := range e.Failed {
+		if !seen[reason] {
+			seen[reason] = true
+			reasons = append(reasons, reason)
+		}
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+func (e *EmailSetError) allNotFound() bool {
+	for _, reason := range e.Failed {
+		if !strings.HasPrefix(reason, "notFound") {
+			return false
+		}
+	}
+	return len(e.Failed) > 0
+}
+
+// emailSetError reports the emails an Email/set call couldn't update or
+// destroy as an *EmailSetError.
+func emailSetError(resp *jmap.Response) error {
+	for _, inv := range resp.Responses {
+		if methodErr, ok := inv.Args.(*jmap.MethodError); ok {
+			return fmt.Errorf("JMAP method error: %s (type: %s)", inv.Name, methodErr.Type)
+		}
+		res, ok := inv.Args.(*email.SetResponse)
+		if !ok {
+			continue
+		}
+
+		failed := make(map[string]string)
+		for _, notDone := range []map[jmap.ID]*jmap.SetError{res.NotUpdated, res.NotDestroyed} {
+			for id, setErr := range notDone {
+				reason := setErr.Type
+				if setErr.Description != nil {
+					reason += " (" + *setErr.Description + ")"
+				}
+				failed[string(id)] = reason
+			}
+		}
+		if len(failed) > 0 {
+			return &EmailSetError{Failed: failed}
+		}
+	}
+	return nil
 }
 
 // GetDefaultIdentity retrieves the first available identity.
@@ -438,11 +663,12 @@ func (c *Client) GetIdentities() ([]*identity.Identity, error) {
 }
 
 // SaveDraft creates or updates a draft without submitting it.
-func (c *Client) SaveDraft(existingDraftID, from, to, subject, body string) error {
+func (c *Client) SaveDraft(existingDraftID string, draft model.Draft) error {
 	// identityID unused for pure draft save unless we want to attach it to the Email object?
 	// The Email object structure doesn't seem to hold identityID directly, mostly used for Submission.
 	// So we can ignore it here.
 
+	from := draft.From
 	if from == "" {
 		ident, err := c.GetDefaultIdentity()
 		if err == nil {
@@ -458,35 +684,28 @@ func (c *Client) SaveDraft(existingDraftID, from, to, subject, body string) erro
 	}
 
 	// 1. Prepare Email Object
-	// Parse the "to" address - might be in "Name <email>" format
-	to = strings.TrimSpace(to)
-	toEmail := to
-	toName := ""
-	if parsedTo, err := netmail.ParseAddress(to); err == nil {
-		toEmail = parsedTo.Address
-		toName = parsedTo.Name
-	}
-	
 	// Always use a new creation ID
 	creationID := jmap.ID("draft-0")
 	
 	emailObj := &email.Email{
 		From:    []*mail.Address{{Email: from}},
-		To:      []*mail.Address{{Name: toName, Email: toEmail}},
-		Subject: subject,
-		TextBody: []*email.BodyPart{
-			{
-				PartID: "text",
-				Type:   "text/plain",
-			},
-		},
-		BodyValues: map[string]*email.BodyValue{
-			"text": {Value: body},
-		},
+		To:      toMailAddresses(ParseRecipients(draft.To)),
+		CC:      toMailAddresses(ParseRecipients(draft.Cc)),
+		BCC:     toMailAddresses(ParseRecipients(draft.Bcc)),
+		Subject: draft.Subject,
+		// Kept on the draft so it still threads when sent later
+		InReplyTo:  draft.Reply.InReplyTo(),
+		References: draft.Reply.ThreadReferences(),
 		MailboxIDs: map[jmap.ID]bool{jmap.ID(draftsID): true},
 		Keywords:   map[string]bool{"$draft": true},
 	}
 
+	attachments, err := c.uploadAttachments(draft.Attachments)
+	if err != nil {
+		return err
+	}
+	setEmailBody(emailObj, draft.Body, draft.HTMLBody, attachments)
+
 	req := &jmap.Request{}
 
 	emailSet := &email.Set{
@@ -538,34 +757,30 @@ func (c *Client) SaveDraft(existingDraftID, from, to, subject, body string) erro
 }
 
 // SendEmail creates or updates a draft and submits it.
-func (c *Client) SendEmail(existingDraftID, from, to, subject, body string) error {
+func (c *Client) SendEmail(existingDraftID string, draft model.Draft) error {
 	var identityID jmap.ID
 
-	// Parse the "to" address(es) - might be in "Name <email>" format or comma-separated
-	to = strings.TrimSpace(to)
-	var toAddresses []*netmail.Address
-	var rcptTo []*emailsubmission.Address
-	
-	// Try parsing as address list first
-	if parsed, err := netmail.ParseAddressList(to); err == nil {
-		toAddresses = parsed
-	} else if parsed, err := netmail.ParseAddress(to); err == nil {
-		// Single address
-		toAddresses = []*netmail.Address{parsed}
-	} else {
-		// Fallback: treat as plain email
-		toAddresses = []*netmail.Address{{Address: to}}
-	}
-	
-	// Build recipient list for submission envelope
-	for _, addr := range toAddresses {
-		rcptTo = append(rcptTo, &emailsubmission.Address{Email: addr.Address})
+	from := draft.From
+	toAddresses := ParseRecipients(draft.To)
+	ccAddresses := ParseRecipients(draft.Cc)
+	bccAddresses := ParseRecipients(draft.Bcc)
+	if len(toAddresses)+len(ccAddresses)+len(bccAddresses) == 0 {
+		return fmt.Errorf("no recipients")
 	}
-	
-	// Convert to mail.Address for Email object
-	var mailToAddrs []*mail.Address
-	for _, addr := range toAddresses {
-		mailToAddrs = append(mailToAddrs, &mail.Address{Name: addr.Name, Email: addr.Address})
+
+	// Build recipient list for submission envelope; Bcc recipients are
+	// only ever named here
+	var rcptTo []*emailsubmission.Address
+	seen := make(map[string]bool)
+	for _, list := range [][]*netmail.Address{toAddresses, ccAddresses, bccAddresses} {
+		for _, addr := range list {
+			key := strings.ToLower(addr.Address)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			rcptTo = append(rcptTo, &emailsubmission.Address{Email: addr.Address})
+		}
 	}
 
 	// Always fetch identities to get the correct identityID
@@ -611,22 +826,23 @@ func (c *Client) SendEmail(existingDraftID, from, to, subject, body string) erro
 	
 	// Create in Drafts first - only move to Sent on successful submission
 	emailObj := &email.Email{
-		From:    []*mail.Address{{Email: from}},
-		To:      mailToAddrs,
-		Subject: subject,
-		TextBody: []*email.BodyPart{
-			{
-				PartID: "text",
-				Type:   "text/plain",
-			},
-		},
-		BodyValues: map[string]*email.BodyValue{
-			"text": {Value: body},
-		},
+		From:       []*mail.Address{{Email: from}},
+		To:         toMailAddresses(toAddresses),
+		CC:         toMailAddresses(ccAddresses),
+		BCC:        toMailAddresses(bccAddresses),
+		Subject:    draft.Subject,
+		InReplyTo:  draft.Reply.InReplyTo(),
+		References: draft.Reply.ThreadReferences(),
 		MailboxIDs: map[jmap.ID]bool{jmap.ID(draftsID): true},
 		Keywords:   map[string]bool{"$draft": true},
 	}
 
+	attachments, err := c.uploadAttachments(draft.Attachments)
+	if err != nil {
+		return err
+	}
+	setEmailBody(emailObj, draft.Body, draft.HTMLBody, attachments)
+
 	// 2. Prepare Submission Object
 	submitID := jmap.ID("submit-0")
 	
@@ -738,17 +954,122 @@ return fmt.Errorf("failed to submit email (from: %s, to: %v): %s", from, toList,
 }
 }
 
+	// The message is on its way at this point, so failing to mark the
+	// original answered/forwarded isn't worth reporting as a send error
+	if draft.Reply != nil && draft.Reply.EmailID != "" {
+		c.updateEmails([]string{draft.Reply.EmailID}, jmap.Patch{
+			"keywords/" + draft.Reply.Keyword(): true,
+		})
+	}
+
 return nil
 }
 
+// emailListProperties are the Email properties needed to render a list entry.
+var emailListProperties = []string{"id", "subject", "from", "to", "cc", "bcc", "replyTo", "preview", "receivedAt", "mailboxIds", "threadId", "keywords", "hasAttachment"}
+
+// toModelEmail converts a JMAP Email into the TUI representation.
+func toModelEmail(e *email.Email) model.Email {
+	isUnread := true
+	if _, ok := e.Keywords["$seen"]; ok {
+		isUnread = false
+	}
+
+	isFlagged := false
+	if _, ok := e.Keywords["$flagged"]; ok {
+		isFlagged = true
+	}
+
+	isDraft := false
+	if _, ok := e.Keywords["$draft"]; ok {
+		isDraft = true
+	}
+
+	// Sorted so the order doesn't change with map iteration
+	var boxIDs []string
+	for k := range e.MailboxIDs {
+		boxIDs = append(boxIDs, string(k))
+	}
+	sort.Strings(boxIDs)
+
+	dateStr := ""
+	if e.ReceivedAt != nil {
+		dateStr = e.ReceivedAt.Format("2006-01-02 15:04")
+	}
+
+	return model.Email{
+		ID:         string(e.ID),
+		Subject:    e.Subject,
+		From:       formatAddresses(e.From),
+		To:         formatAddresses(e.To),
+		Cc:         formatAddresses(e.CC),
+		Bcc:        formatAddresses(e.BCC),
+		ReplyTo:    formatAddresses(e.ReplyTo),
+		Preview:    e.Preview,
+		Date:       dateStr,
+		IsUnread:   isUnread,
+		IsFlagged:  isFlagged,
+		IsDraft:    isDraft,
+		ThreadID:   string(e.ThreadID),
+		MailboxIDs: boxIDs,
+
+		HasAttachment: e.HasAttachment,
+	}
+}
+
+// ParseRecipients splits a comma-separated recipient field, accepting both
+// "Name <email>" and bare addresses, names quoted if they hold commas. An
+// empty field yields no addresses.
+func ParseRecipients(list string) []*netmail.Address {
+	list = strings.TrimSpace(strings.Trim(strings.TrimSpace(list), ","))
+	if list == "" {
+		return nil
+	}
+	if parsed, err := netmail.ParseAddressList(list); err == nil {
+		return parsed
+	}
+
+	// Fall back to splitting by hand so one odd entry doesn't reject the rest
+	var addrs []*netmail.Address
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if parsed, err := netmail.ParseAddress(part); err == nil {
+			addrs = append(addrs, parsed)
+		} else {
+			addrs = append(addrs, &netmail.Address{Address: part})
+		}
+	}
+	return addrs
+}
+
+// FormatRecipient writes an address the way ParseRecipients reads it back,
+// quoting a name that would otherwise split the list, e.g. "Doe, Jane"
+func FormatRecipient(name, email string) string {
+	if name == "" {
+		return email
+	}
+	if strings.ContainsAny(name, `,;:<>@"()[]\`) {
+		name = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+	return name + " <" + email + ">"
+}
+
+// toMailAddresses converts parsed addresses for an Email object
+func toMailAddresses(addrs []*netmail.Address) []*mail.Address {
+	var out []*mail.Address
+	for _, addr := range addrs {
+		out = append(out, &mail.Address{Name: addr.Name, Email: addr.Address})
+	}
+	return out
+}
+
 func formatAddresses(addrs []*mail.Address) string {
 var parts []string
 for _, a := range addrs {
-if a.Name != "" {
-parts = append(parts, fmt.Sprintf("%s <%s>", a.Name, a.Email))
-} else {
-parts = append(parts, a.Email)
-}
+parts = append(parts, FormatRecipient(a.Name, a.Email))
 }
 return strings.Join(parts, ", ")
 }
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"fm-cli/internal/model"
+
+	"git.sr.ht/~rockorager/go-jmap"
+	"git.sr.ht/~rockorager/go-jmap/mail/email"
+)
+
+// newJMAPServer serves a session allowing maxSet objects per /set call and
+// answers each method call with what handle returns for it
+func newJMAPServer(t *testing.T, maxSet int, handle func(method string, args json.RawMessage) (string, interface{})) *Client {
+	t.Helper()
+	srv := httptest.NewServer(nil)
+	t.Cleanup(srv.Close)
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"capabilities": map[string]interface{}{
+					"urn:ietf:params:jmap:core": map[string]interface{}{"maxObjectsInSet": maxSet},
+					"urn:ietf:params:jmap:mail": map[string]interface{}{},
+				},
+				"accounts":        map[string]interface{}{"a1": map[string]interface{}{"name": "me"}},
+				"primaryAccounts": map[string]string{"urn:ietf:params:jmap:mail": "a1"},
+				"username":        "me",
+				"apiUrl":          srv.URL + "/api",
+				"state":           "s0",
+			})
+			return
+		}
+
+		var req struct {
+			MethodCalls [][]json.RawMessage `json:"methodCalls"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("bad request: %v", err)
+			return
+		}
+		var responses []interface{}
+		for _, call := range req.MethodCalls {
+			var method, callID string
+			json.Unmarshal(call[0], &method)
+			json.Unmarshal(call[2], &callID)
+			name, args := handle(method, call[1])
+			responses = append(responses, []interface{}{name, args, callID})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"methodResponses": responses,
+			"sessionState":    "s0",
+		})
+	})
+
+	client, err := NewClientWithConfig(model.AppConfig{APIToken: "t", JMAPCore: srv.URL + "/session"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// jmapServer answers Email/set, refusing to update the emails in reject.
+// It records the IDs each call asked for.
+func jmapServer(t *testing.T, maxSet int, reject map[string]bool) (*Client, func() [][]string) {
+	t.Helper()
+	var mu sync.Mutex
+	var calls [][]string
+
+	client := newJMAPServer(t, maxSet, func(method string, raw json.RawMessage) (string, interface{}) {
+		var args struct {
+			Update  map[string]json.RawMessage `json:"update"`
+			Destroy []string                   `json:"destroy"`
+		}
+		json.Unmarshal(raw, &args)
+
+		ids := args.Destroy
+		for id := range args.Update {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		mu.Lock()
+		calls = append(calls, ids)
+		mu.Unlock()
+
+		updated := map[string]interface{}{}
+		notUpdated := map[string]interface{}{}
+		for id := range args.Update {
+			if reject[id] {
+				notUpdated[id] = map[string]string{"type": "forbidden"}
+			} else {
+				updated[id] = nil
+			}
+		}
+		return "Email/set", map[string]interface{}{
+			"accountId":  "a1",
+			"newState":   "s1",
+			"updated":    updated,
+			"notUpdated": notUpdated,
+			"destroyed":  args.Destroy,
+		}
+	})
+	return client, func() [][]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+}
+
+func TestSetEmailsBatchesByMaxObjectsInSet(t *testing.T) {
+	client, calls := jmapServer(t, 2, map[string]bool{"e3": true})
+
+	err := client.SetFlaggedMany([]string{"e1", "e2", "e3", "e4", "e5"}, true)
+	var setErr *EmailSetError
+	if !errors.As(err, &setErr) {
+		t.Fatalf("err = %v, want an *EmailSetError", err)
+	}
+	if len(setErr.Failed) != 1 || setErr.Failed["e3"] != "forbidden" {
+		t.Errorf("Failed = %v, want only e3 forbidden", setErr.Failed)
+	}
+
+	got := calls()
+	if len(got) != 3 {
+		t.Fatalf("made %d Email/set calls, want 3: %v", len(got), got)
+	}
+	seen := 0
+	for _, ids := range got {
+		if len(ids) > 2 {
+			t.Errorf("Email/set call with %d emails, server allows 2", len(ids))
+		}
+		seen += len(ids)
+	}
+	if seen != 5 {
+		t.Errorf("Email/set calls covered %d emails, want 5", seen)
+	}
+}
+
+func TestDestroyEmailsBatches(t *testing.T) {
+	client, calls := jmapServer(t, 3, nil)
+
+	if err := client.DestroyEmails([]string{"e1", "e2", "e3", "e4"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := calls(); len(got) != 2 || len(got[0]) != 3 || len(got[1]) != 1 {
+		t.Errorf("Email/set calls = %v, want batches of 3 and 1", got)
+	}
+}
+
+func TestToModelEmailSortsMailboxIDs(t *testing.T) {
+	e := &email.Email{
+		ID:         "e1",
+		MailboxIDs: map[jmap.ID]bool{"m3": true, "m1": true, "m2": true},
+	}
+	for i := 0; i < 10; i++ {
+		got := toModelEmail(e).MailboxIDs
+		if len(got) != 3 || got[0] != "m1" || got[1] != "m2" || got[2] != "m3" {
+			t.Fatalf("MailboxIDs = %v, want [m1 m2 m3]", got)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// StateChange reports the data types that changed on the mail account, mapped
+// to their new state strings (e.g. "Email" -> "s123").
+type StateChange struct {
+	Types map[string]string
+}
+
+// PushListener streams JMAP StateChange events from an EventSource endpoint
+// (RFC 8620 section 7.3) and reconnects with exponential backoff when the
+// connection drops.
+type PushListener struct {
+	// URL is the session's eventSourceUrl. It may be a URI template
+	// containing {types}, {closeafter} and {ping}.
+	URL        string
+	HTTPClient *http.Client
+	AccountID  string
+
+	// Types limits the events to the given data types; empty means all
+	Types []string
+	// Ping asks the server to send keepalives at this interval in seconds
+	Ping int
+
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// StableAfter is how long a connection must stay up, unless it
+	// delivers an event first, before the backoff resets. A server that
+	// accepts the stream and drops it at once is still backed off from.
+	StableAfter time.Duration
+
+	// OnError, if set, is told about each failed or dropped connection
+	// before the listener backs off and retries
+	OnError func(error)
+}
+
+// NewPushListener creates a listener for the given endpoint. The HTTP client
+// is expected to handle authentication.
+func NewPushListener(eventSourceURL string, httpClient *http.Client, accountID string) *PushListener {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	return &PushListener{
+		URL:         eventSourceURL,
+		HTTPClient:  httpClient,
+		AccountID:   accountID,
+		Types:       []string{"Email", "Mailbox"},
+		Ping:        60,
+		MinBackoff:  time.Second,
+		MaxBackoff:  5 * time.Minute,
+		StableAfter: 30 * time.Second,
+	}
+}
+
+// PushListener returns a listener for this session's eventSourceUrl, or nil
+// if the server does not offer push.
+func (c *Client) PushListener() *PushListener {
+	if c.Session == nil || c.Session.EventSourceURL == "" {
+		return nil
+	}
+	return NewPushListener(c.Session.EventSourceURL, c.Client.HttpClient, c.AccountID())
+}
+
+// Run connects and delivers state changes to handler until ctx is cancelled.
+// Dropped connections are retried with exponential backoff, which resets
+// once a connection has delivered an event or stayed up for StableAfter.
+func (p *PushListener) Run(ctx context.Context, handler func(StateChange)) error {
+	backoff := p.MinBackoff
+	for {
+		stable, err := p.listen(ctx, handler)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if stable {
+			backoff = p.MinBackoff
+		}
+		if err != nil && p.OnError != nil {
+			p.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// listen holds a single EventSource connection open. It reports whether the
+// connection proved stable, by delivering an event or staying up for
+// StableAfter, so Run can reset its backoff.
+func (p *PushListener) listen(ctx context.Context, handler func(StateChange)) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.endpoint(), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("event source connect failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("event source returned %s", resp.Status)
+	}
+
+	connectedAt := time.Now()
+	delivered := false
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	// Dispatch on blank lines per the SSE spec; data lines accumulate
+	var event string
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(data) > 0 && (event == "" || event == "state") {
+				if change, ok := p.parseStateChange(strings.Join(data, "\n")); ok {
+					handler(change)
+					delivered = true
+				}
+			}
+			event = ""
+			data = nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue // comment / keepalive
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+
+	return delivered || time.Since(connectedAt) >= p.StableAfter, scanner.Err()
+}
+
+// endpoint expands the eventSourceUrl template or appends query parameters
+// when the server gave a plain URL
+func (p *PushListener) endpoint() string {
+	types := "*"
+	if len(p.Types) > 0 {
+		types = strings.Join(p.Types, ",")
+	}
+	ping := fmt.Sprintf("%d", p.Ping)
+
+	if strings.Contains(p.URL, "{types}") {
+		r := strings.NewReplacer(
+			"{types}", url.QueryEscape(types),
+			"{closeafter}", "no",
+			"{ping}", ping,
+		)
+		return r.Replace(p.URL)
+	}
+
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return p.URL
+	}
+	q := u.Query()
+	q.Set("types", types)
+	q.Set("closeafter", "no")
+	q.Set("ping", ping)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// parseStateChange decodes a StateChange payload and keeps only the entries
+// for our account
+func (p *PushListener) parseStateChange(payload string) (StateChange, bool) {
+	var raw struct {
+		Type    string                       `json:"@type"`
+		Changed map[string]map[string]string `json:"changed"`
+	}
+	if err := json.Unmarshal([]byte(payload), &raw); err != nil {
+		return StateChange{}, false
+	}
+	if raw.Type != "" && raw.Type != "StateChange" {
+		return StateChange{}, false
+	}
+
+	types, ok := raw.Changed[p.AccountID]
+	if !ok && p.AccountID == "" {
+		// No account filter: merge everything
+		types = make(map[string]string)
+		for _, t := range raw.Changed {
+			for k, v := range t {
+				types[k] = v
+			}
+		}
+		ok = len(types) > 0
+	}
+	if !ok {
+		return StateChange{}, false
+	}
+	return StateChange{Types: types}, true
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sseServer serves one scripted stream per connection, then closes it.
+// Connections past the last script are held open until the client leaves.
+func sseServer(t *testing.T, streams ...string) (*httptest.Server, func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	conns := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Accept"); got != "text/event-stream" {
+			t.Errorf("Accept = %q, want text/event-stream", got)
+		}
+		mu.Lock()
+		n := conns
+		conns++
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		if n < len(streams) {
+			fmt.Fprint(w, streams[n])
+			w.(http.Flusher).Flush()
+			return
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return conns
+	}
+}
+
+// collect runs the listener until want changes have arrived
+func collect(t *testing.T, p *PushListener, want int) []StateChange {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var changes []StateChange
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Run(ctx, func(c StateChange) {
+			changes = append(changes, c)
+			if len(changes) == want {
+				cancel()
+			}
+		})
+	}()
+	<-done
+	if len(changes) < want {
+		t.Fatalf("got %d state changes before timing out, want %d", len(changes), want)
+	}
+	return changes
+}
+
+func newTestListener(url string) *PushListener {
+	p := NewPushListener(url, nil, "a1")
+	p.MinBackoff = 10 * time.Millisecond
+	p.MaxBackoff = 50 * time.Millisecond
+	return p
+}
+
+func TestPushListenerStateEvents(t *testing.T) {
+	srv, _ := sseServer(t, ""+
+		": keep-alive\n\n"+
+		"event: state\n"+
+		"data: {\"@type\":\"StateChange\",\"changed\":{\"a1\":{\"Email\":\"s1\"}}}\n\n"+
+		": ping\n"+
+		"event: state\n"+
+		"data: {\"@type\":\"StateChange\",\"changed\":{\"a2\":{\"Email\":\"x9\"}}}\n\n"+
+		"data: {\"@type\":\"StateChange\",\"changed\":{\"a1\":{\"Mailbox\":\"m2\"}}}\n\n",
+	)
+
+	changes := collect(t, newTestListener(srv.URL), 2)
+	if got := changes[0].Types["Email"]; got != "s1" {
+		t.Errorf("first change Email state = %q, want s1", got)
+	}
+	// The other account's change is skipped, and an event without a type
+	// counts as a state event
+	if got := changes[1].Types["Mailbox"]; got != "m2" {
+		t.Errorf("second change Mailbox state = %q, want m2", got)
+	}
+}
+
+func TestPushListenerMultiLineData(t *testing.T) {
+	srv, _ := sseServer(t, ""+
+		"event: state\n"+
+		"data: {\"@type\":\"StateChange\",\n"+
+		"data:  \"changed\":{\"a1\":{\n"+
+		"data:   \"Email\":\"s7\",\"Mailbox\":\"m7\"}}}\n\n",
+	)
+
+	changes := collect(t, newTestListener(srv.URL), 1)
+	if changes[0].Types["Email"] != "s7" || changes[0].Types["Mailbox"] != "m7" {
+		t.Errorf("change = %v, want Email s7 and Mailbox m7", changes[0].Types)
+	}
+}
+
+func TestPushListenerReconnects(t *testing.T) {
+	srv, conns := sseServer(t,
+		"data: {\"@type\":\"StateChange\",\"changed\":{\"a1\":{\"Email\":\"s1\"}}}\n\n",
+		"data: {\"@type\":\"StateChange\",\"changed\":{\"a1\":{\"Email\":\"s2\"}}}\n\n",
+	)
+
+	p := newTestListener(srv.URL)
+	var errs int
+	p.OnError = func(error) { errs++ }
+
+	changes := collect(t, p, 2)
+	if changes[0].Types["Email"] != "s1" || changes[1].Types["Email"] != "s2" {
+		t.Errorf("changes = %v, %v; want s1 then s2", changes[0].Types, changes[1].Types)
+	}
+	if n := conns(); n < 2 {
+		t.Errorf("server saw %d connections, want a reconnect", n)
+	}
+	if errs != 0 {
+		t.Errorf("OnError called %d times for clean stream ends", errs)
+	}
+}
+
+// connTimes runs the listener against srv until it has connected n times
+// and returns when each connection came in
+func connTimes(t *testing.T, p *PushListener, handler func(StateChange), n int, at func() []time.Time) []time.Time {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- p.Run(ctx, handler) }()
+	for len(at()) < n && ctx.Err() == nil {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	times := at()
+	if len(times) < n {
+		t.Fatalf("listener connected %d times before timing out, want %d", len(times), n)
+	}
+	return times
+}
+
+// flappingServer accepts the stream and closes it at once, sending body
+// first, and records when each connection came in
+func flappingServer(t *testing.T, body string) (*httptest.Server, func() []time.Time) {
+	t.Helper()
+	var mu sync.Mutex
+	var times []time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time(nil), times...)
+	}
+}
+
+func TestPushListenerBacksOffFromDroppedStreams(t *testing.T) {
+	srv, at := flappingServer(t, "")
+	p := NewPushListener(srv.URL, nil, "a1")
+	p.MinBackoff = 10 * time.Millisecond
+	p.MaxBackoff = time.Second
+
+	times := connTimes(t, p, func(StateChange) {}, 5, at)
+	// 10, 20, 40 then 80ms apart; a reset after each accepted connection
+	// would keep them 10ms apart
+	if gap := times[4].Sub(times[3]); gap < 60*time.Millisecond {
+		t.Errorf("fifth connection came %v after the fourth, want the backoff to grow", gap)
+	}
+}
+
+func TestPushListenerResetsBackoffAfterEvent(t *testing.T) {
+	srv, at := flappingServer(t, "data: {\"changed\":{\"a1\":{\"Email\":\"s1\"}}}\n\n")
+	p := NewPushListener(srv.URL, nil, "a1")
+	p.MinBackoff = 10 * time.Millisecond
+	p.MaxBackoff = time.Second
+
+	times := connTimes(t, p, func(StateChange) {}, 6, at)
+	// Without the reset the last gap would be 160ms
+	if gap := times[5].Sub(times[4]); gap > 100*time.Millisecond {
+		t.Errorf("sixth connection came %v after the fifth, want the backoff reset by each event", gap)
+	}
+}
+
+func TestPushListenerRetriesAfterHTTPError(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		if n == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"changed\":{\"a1\":{\"Email\":\"s3\"}}}\n\n")
+	}))
+	defer srv.Close()
+
+	p := newTestListener(srv.URL)
+	var errs []error
+	p.OnError = func(err error) { errs = append(errs, err) }
+
+	changes := collect(t, p, 1)
+	if changes[0].Types["Email"] != "s3" {
+		t.Errorf("change = %v, want Email s3", changes[0].Types)
+	}
+	if len(errs) == 0 {
+		t.Error("OnError not called for the 503")
+	}
+}
+
+func TestPushListenerEndpoint(t *testing.T) {
+	p := NewPushListener("https://push.example/es?types={types}&closeafter={closeafter}&ping={ping}", nil, "a1")
+	if got, want := p.endpoint(), "https://push.example/es?types=Email%2CMailbox&closeafter=no&ping=60"; got != want {
+		t.Errorf("templated endpoint = %q, want %q", got, want)
+	}
+
+	p.URL = "https://push.example/es"
+	if got, want := p.endpoint(), "https://push.example/es?closeafter=no&ping=60&types=Email%2CMailbox"; got != want {
+		t.Errorf("plain endpoint = %q, want %q", got, want)
+	}
+}
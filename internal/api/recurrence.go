@@ -0,0 +1,446 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"fm-cli/internal/model"
+
+	"github.com/emersion/go-ical"
+	"github.com/emersion/go-webdav/caldav"
+	"github.com/teambition/rrule-go"
+)
+
+// expandCalendarObject returns the events of a calendar object between
+// start and end. A recurring event becomes one event per occurrence, with
+// overrides (RECURRENCE-ID) in place of the occurrences they change and
+// exceptions (EXDATE) left out.
+func expandCalendarObject(obj caldav.CalendarObject, calPath string, start, end time.Time) []model.CalendarEvent {
+	if obj.Data == nil {
+		return nil
+	}
+
+	var master *ical.Component
+	var overrides []*ical.Component
+	for _, comp := range obj.Data.Children {
+		if comp.Name != ical.CompEvent {
+			continue
+		}
+		if comp.Props.Get(ical.PropRecurrenceID) != nil {
+			overrides = append(overrides, comp)
+		} else if master == nil {
+			master = comp
+		}
+	}
+
+	var events []model.CalendarEvent
+	if master == nil {
+		// Invited to single occurrences only
+		for _, comp := range overrides {
+			events = append(events, *parseEvent(comp, obj.Path, calPath))
+		}
+		return events
+	}
+
+	event := parseEvent(master, obj.Path, calPath)
+	set, err := recurrenceSet(master, event.Start)
+	if err != nil || set == nil {
+		return []model.CalendarEvent{*event}
+	}
+	length := event.End.Sub(event.Start)
+
+	overridden := make(map[int64]bool)
+	for _, comp := range overrides {
+		o := parseEvent(comp, obj.Path, calPath)
+		overridden[o.RecurrenceID.Unix()] = true
+		if o.Status == "cancelled" {
+			continue
+		}
+		o.Recurrence = event.Recurrence
+		if o.End.IsZero() {
+			o.End = o.Start.Add(length)
+		}
+		if overlaps(o.Start, o.End, start, end) {
+			events = append(events, *o)
+		}
+	}
+
+	// Start early enough to catch occurrences still going on at start
+	for _, t := range set.Between(start.Add(-length), end, true) {
+		if overridden[t.Unix()] {
+			continue
+		}
+		occurrence := *event
+		occurrence.Start = t
+		occurrence.End = t.Add(length)
+		occurrence.RecurrenceID = t
+		if overlaps(occurrence.Start, occurrence.End, start, end) {
+			events = append(events, occurrence)
+		}
+	}
+	return events
+}
+
+func overlaps(eventStart, eventEnd, start, end time.Time) bool {
+	return eventStart.Before(end) && (eventEnd.After(start) || !eventStart.Before(start))
+}
+
+// recurrenceSet reads a VEVENT's RRULE, RDATE and EXDATE properties, or
+// returns nil if it doesn't recur. go-ical's own RecurrenceSet mixes up
+// RDATE with EXDATE and can't read lists of dates.
+func recurrenceSet(comp *ical.Component, dtstart time.Time) (*rrule.Set, error) {
+	roption, err := comp.Props.RecurrenceRule()
+	if err != nil {
+		return nil, err
+	}
+	rdates := propTimes(comp.Props.Values(ical.PropRecurrenceDates))
+	if roption == nil && len(rdates) == 0 {
+		return nil, nil
+	}
+
+	set := &rrule.Set{}
+	if roption != nil {
+		rule, err := rrule.NewRRule(*roption)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE: %w", err)
+		}
+		set.RRule(rule)
+	} else {
+		// DTSTART is always the first occurrence
+		set.RDate(dtstart)
+	}
+	set.DTStart(dtstart)
+	for _, t := range rdates {
+		set.RDate(t)
+	}
+	for _, t := range propTimes(comp.Props.Values(ical.PropExceptionDates)) {
+		set.ExDate(t)
+	}
+	return set, nil
+}
+
+// propTimes reads date properties that may each hold a comma-separated list
+func propTimes(props []ical.Prop) []time.Time {
+	var times []time.Time
+	for _, prop := range props {
+		for _, v := range strings.Split(prop.Value, ",") {
+			single := ical.Prop{Name: prop.Name, Params: prop.Params, Value: v}
+			if t, err := single.DateTime(time.Local); err == nil {
+				times = append(times, t)
+			}
+		}
+	}
+	return times
+}
+
+// masterComponent returns the VEVENT that isn't an override
+func masterComponent(cal *ical.Calendar) *ical.Component {
+	for _, comp := range cal.Children {
+		if comp.Name == ical.CompEvent && comp.Props.Get(ical.PropRecurrenceID) == nil {
+			return comp
+		}
+	}
+	return nil
+}
+
+// overrideComponent returns the VEVENT overriding the occurrence that
+// originally started at recurrenceID, if there is one
+func overrideComponent(cal *ical.Calendar, recurrenceID time.Time) *ical.Component {
+	for _, comp := range cal.Children {
+		if comp.Name != ical.CompEvent {
+			continue
+		}
+		prop := comp.Props.Get(ical.PropRecurrenceID)
+		if prop == nil {
+			continue
+		}
+		if t, err := prop.DateTime(time.Local); err == nil && t.Equal(recurrenceID) {
+			return comp
+		}
+	}
+	return nil
+}
+
+// setTimeLike sets prop to t, written the way like (the series' DTSTART)
+// is, so that RECURRENCE-ID and EXDATE values name its occurrences
+func setTimeLike(prop *ical.Prop, t time.Time, like *ical.Prop) {
+	switch {
+	case like.ValueType() == ical.ValueDate || like.Params.Get(ical.ParamValue) == "DATE":
+		prop.SetDate(t)
+	case like.Params.Get(ical.ParamTimezoneID) != "":
+		tzid := like.Params.Get(ical.ParamTimezoneID)
+		if loc, err := time.LoadLocation(tzid); err == nil {
+			t = t.In(loc)
+		}
+		prop.SetValueType(ical.ValueDateTime)
+		prop.Params.Set(ical.ParamTimezoneID, tzid)
+		prop.Value = t.Format("20060102T150405")
+	case strings.HasSuffix(like.Value, "Z"):
+		prop.SetDateTime(t.UTC())
+	default:
+		// Floating time
+		prop.SetValueType(ical.ValueDateTime)
+		prop.Value = t.In(time.Local).Format("20060102T150405")
+	}
+}
+
+// UpdateOccurrence changes a single occurrence of a recurring event, the
+// one event.RecurrenceID names, leaving the rest of the series alone. A new
+// override starts as a copy of the series, so it has the same attendees,
+// alarms and other properties fm-cli doesn't edit.
+func (d *DAVClient) UpdateOccurrence(ctx context.Context, event model.CalendarEvent) error {
+	obj, err := d.getEventObject(ctx, event)
+	if err != nil {
+		return err
+	}
+	cal := obj.Data
+	master := masterComponent(cal)
+	if master == nil {
+		return fmt.Errorf("failed to get existing event: no VEVENT in %s", event.ID)
+	}
+
+	override := overrideComponent(cal, event.RecurrenceID)
+	if override == nil {
+		override = newOverride(master, event.RecurrenceID)
+		cal.Children = append(cal.Children, override)
+	}
+
+	// An override carries no rule of its own
+	event.Recurrence = ""
+	patchEvent(override, event)
+
+	_, err = d.CalDAV.PutCalendarObject(withIfMatch(ctx, obj.ETag), event.ID, cal)
+	if err != nil {
+		return davError("failed to update event", err)
+	}
+	return nil
+}
+
+// newOverride starts an override of the occurrence at recurrenceID as a
+// copy of the series, alarms included, without the rule itself
+func newOverride(master *ical.Component, recurrenceID time.Time) *ical.Component {
+	override := copyComponent(master)
+	override.Props.Del(ical.PropRecurrenceRule)
+	override.Props.Del(ical.PropRecurrenceDates)
+	override.Props.Del(ical.PropExceptionDates)
+
+	prop := ical.NewProp(ical.PropRecurrenceID)
+	if dtstart := master.Props.Get(ical.PropDateTimeStart); dtstart != nil {
+		setTimeLike(prop, recurrenceID, dtstart)
+	} else {
+		prop.SetDateTime(recurrenceID)
+	}
+	override.Props.Set(prop)
+	return override
+}
+
+// copyComponent deep-copies a component and its children, so editing the
+// copy leaves the original alone
+func copyComponent(comp *ical.Component) *ical.Component {
+	c := ical.NewComponent(comp.Name)
+	for name, props := range comp.Props {
+		copied := make([]ical.Prop, len(props))
+		for i, prop := range props {
+			copied[i] = prop
+			copied[i].Params = make(ical.Params, len(prop.Params))
+			for k, v := range prop.Params {
+				copied[i].Params[k] = append([]string(nil), v...)
+			}
+		}
+		c.Props[name] = copied
+	}
+	for _, child := range comp.Children {
+		c.Children = append(c.Children, copyComponent(child))
+	}
+	return c
+}
+
+// DeleteOccurrence removes a single occurrence of a recurring event by
+// adding an exception for it
+func (d *DAVClient) DeleteOccurrence(ctx context.Context, event model.CalendarEvent) error {
+	obj, err := d.getEventObject(ctx, event)
+	if err != nil {
+		return err
+	}
+	cal := obj.Data
+	master := masterComponent(cal)
+	if master == nil {
+		return fmt.Errorf("failed to get existing event: no VEVENT in %s", event.ID)
+	}
+
+	exdate := ical.NewProp(ical.PropExceptionDates)
+	if dtstart := master.Props.Get(ical.PropDateTimeStart); dtstart != nil {
+		setTimeLike(exdate, event.RecurrenceID, dtstart)
+	} else {
+		exdate.SetDateTime(event.RecurrenceID)
+	}
+	master.Props.Add(exdate)
+
+	if override := overrideComponent(cal, event.RecurrenceID); override != nil {
+		children := cal.Children[:0]
+		for _, comp := range cal.Children {
+			if comp != override {
+				children = append(children, comp)
+			}
+		}
+		cal.Children = children
+	}
+
+	_, err = d.CalDAV.PutCalendarObject(withIfMatch(ctx, obj.ETag), event.ID, cal)
+	if err != nil {
+		return davError("failed to delete event", err)
+	}
+	return nil
+}
+
+// RecurrenceOption is a repeat rule the event editor offers
+type RecurrenceOption struct {
+	Label string
+	Rule  string // RRULE value, empty for a one-off event
+}
+
+var weekdayCodes = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// RecurrenceOptions lists the common rules for an event starting at start
+func RecurrenceOptions(start time.Time) []RecurrenceOption {
+	day := start.Weekday()
+	code := weekdayCodes[day]
+	nth := (start.Day()-1)/7 + 1
+
+	options := []RecurrenceOption{
+		{Label: "Does not repeat"},
+		{Label: "Every day", Rule: "FREQ=DAILY"},
+		{Label: "Every weekday", Rule: "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR"},
+		{Label: "Weekly on " + day.String(), Rule: "FREQ=WEEKLY;BYDAY=" + code},
+		{Label: fmt.Sprintf("Monthly on day %d", start.Day()), Rule: fmt.Sprintf("FREQ=MONTHLY;BYMONTHDAY=%d", start.Day())},
+	}
+	if nth <= 4 {
+		options = append(options, RecurrenceOption{
+			Label: fmt.Sprintf("Monthly on the %s %s", ordinal(nth), day),
+			Rule:  fmt.Sprintf("FREQ=MONTHLY;BYDAY=%d%s", nth, code),
+		})
+	}
+	if start.AddDate(0, 0, 7).Month() != start.Month() {
+		options = append(options, RecurrenceOption{
+			Label: "Monthly on the last " + day.String(),
+			Rule:  "FREQ=MONTHLY;BYDAY=-1" + code,
+		})
+	}
+	options = append(options, RecurrenceOption{Label: "Every year on " + start.Format("January 2"), Rule: "FREQ=YEARLY"})
+	return options
+}
+
+// SameRecurrence reports whether two RRULE values are the same rule,
+// whatever order their parts are in
+func SameRecurrence(a, b string) bool {
+	pa, pb := ruleParts(a), ruleParts(b)
+	if len(pa) != len(pb) {
+		return false
+	}
+	for k, v := range pa {
+		if pb[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func ruleParts(rule string) map[string]string {
+	parts := make(map[string]string)
+	for _, part := range strings.Split(rule, ";") {
+		if k, v, ok := strings.Cut(part, "="); ok {
+			parts[strings.ToUpper(k)] = strings.ToUpper(v)
+		}
+	}
+	if parts["INTERVAL"] == "1" {
+		delete(parts, "INTERVAL")
+	}
+	return parts
+}
+
+// DescribeRecurrence puts an RRULE value in words, e.g. "Every weekday"
+func DescribeRecurrence(rule string) string {
+	parts := ruleParts(rule)
+	interval, _ := strconv.Atoi(parts["INTERVAL"])
+	every := func(unit string) string {
+		if interval > 1 {
+			return fmt.Sprintf("Every %d %ss", interval, unit)
+		}
+		return "Every " + unit
+	}
+
+	var s string
+	switch parts["FREQ"] {
+	case "DAILY":
+		s = every("day")
+	case "WEEKLY":
+		s = every("week")
+		if parts["BYDAY"] == "MO,TU,WE,TH,FR" && interval <= 1 {
+			s = "Every weekday"
+		} else if parts["BYDAY"] != "" {
+			var days []string
+			for _, code := range strings.Split(parts["BYDAY"], ",") {
+				_, day := splitByDay(code)
+				days = append(days, day)
+			}
+			s += " on " + strings.Join(days, ", ")
+		}
+	case "MONTHLY":
+		s = every("month")
+		if parts["BYMONTHDAY"] != "" {
+			s += " on day " + parts["BYMONTHDAY"]
+		} else if parts["BYDAY"] != "" {
+			n, day := splitByDay(parts["BYDAY"])
+			switch {
+			case n == -1:
+				s += " on the last " + day
+			case n > 0:
+				s += fmt.Sprintf(" on the %s %s", ordinal(n), day)
+			default:
+				s += " on " + day
+			}
+		}
+	case "YEARLY":
+		s = every("year")
+	default:
+		return "Custom: " + rule
+	}
+
+	if count := parts["COUNT"]; count != "" {
+		s += ", " + count + " times"
+	} else if until := parts["UNTIL"]; len(until) >= 8 {
+		if t, err := time.Parse("20060102", until[:8]); err == nil {
+			s += ", until " + t.Format("Jan 2, 2006")
+		}
+	}
+	return s
+}
+
+// splitByDay splits a BYDAY entry such as "2TU" into 2 and "Tuesday"
+func splitByDay(code string) (int, string) {
+	if len(code) < 2 {
+		return 0, code
+	}
+	n, _ := strconv.Atoi(code[:len(code)-2])
+	for i, c := range weekdayCodes {
+		if c == code[len(code)-2:] {
+			return n, time.Weekday(i).String()
+		}
+	}
+	return n, code
+}
+
+func ordinal(n int) string {
+	switch n {
+	case 1:
+		return "1st"
+	case 2:
+		return "2nd"
+	case 3:
+		return "3rd"
+	}
+	return strconv.Itoa(n) + "th"
+}
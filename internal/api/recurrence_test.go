@@ -0,0 +1,290 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"fm-cli/internal/model"
+
+	"github.com/emersion/go-ical"
+	"github.com/emersion/go-webdav/caldav"
+)
+
+func decodeCalendar(t *testing.T, lines ...string) *ical.Calendar {
+	t.Helper()
+	text := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//test//EN\r\n" +
+		strings.Join(lines, "\r\n") + "\r\nEND:VCALENDAR\r\n"
+	cal, err := ical.NewDecoder(strings.NewReader(text)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cal
+}
+
+// starts returns when each event starts, in UTC, in the order given
+func starts(events []model.CalendarEvent) []string {
+	var s []string
+	for _, e := range events {
+		s = append(s, e.Start.UTC().Format("Jan 2 15:04"))
+	}
+	return s
+}
+
+func sameStarts(got []model.CalendarEvent, want ...string) bool {
+	s := starts(got)
+	if len(s) != len(want) {
+		return false
+	}
+	seen := make(map[string]int)
+	for _, v := range s {
+		seen[v]++
+	}
+	for _, v := range want {
+		if seen[v] == 0 {
+			return false
+		}
+		seen[v]--
+	}
+	return true
+}
+
+var march = [2]time.Time{
+	time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC),
+	time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC),
+}
+
+func TestExpandRecurringWithExdate(t *testing.T) {
+	cal := decodeCalendar(t,
+		"BEGIN:VEVENT",
+		"UID:weekly@test",
+		"DTSTAMP:20260101T000000Z",
+		"SUMMARY:Standup",
+		"DTSTART:20260302T090000Z",
+		"DTEND:20260302T093000Z",
+		"RRULE:FREQ=WEEKLY;COUNT=4",
+		"EXDATE:20260309T090000Z",
+		"END:VEVENT",
+	)
+	obj := caldav.CalendarObject{Path: "/cal/weekly.ics", Data: cal}
+
+	events := expandCalendarObject(obj, "/cal/", march[0], march[1])
+	if !sameStarts(events, "Mar 2 09:00", "Mar 16 09:00", "Mar 23 09:00") {
+		t.Fatalf("occurrences start %v, want Mar 2, 16 and 23 at 09:00", starts(events))
+	}
+	for _, e := range events {
+		if e.End.Sub(e.Start) != 30*time.Minute {
+			t.Errorf("occurrence at %v lasts %v, want 30m", e.Start, e.End.Sub(e.Start))
+		}
+		if !e.RecurrenceID.Equal(e.Start) {
+			t.Errorf("RecurrenceID = %v, want the start %v", e.RecurrenceID, e.Start)
+		}
+		if e.Recurrence == "" {
+			t.Errorf("occurrence at %v lost its rule", e.Start)
+		}
+	}
+}
+
+func TestExpandRecurringWithOverrides(t *testing.T) {
+	cal := decodeCalendar(t,
+		"BEGIN:VEVENT",
+		"UID:weekly@test",
+		"DTSTAMP:20260101T000000Z",
+		"SUMMARY:Standup",
+		"DTSTART:20260302T090000Z",
+		"DTEND:20260302T093000Z",
+		"RRULE:FREQ=WEEKLY;COUNT=4",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:weekly@test",
+		"DTSTAMP:20260101T000000Z",
+		"RECURRENCE-ID:20260309T090000Z",
+		"SUMMARY:Standup (moved)",
+		"DTSTART:20260309T140000Z",
+		"DTEND:20260309T143000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:weekly@test",
+		"DTSTAMP:20260101T000000Z",
+		"RECURRENCE-ID:20260316T090000Z",
+		"STATUS:CANCELLED",
+		"DTSTART:20260316T090000Z",
+		"END:VEVENT",
+	)
+	obj := caldav.CalendarObject{Path: "/cal/weekly.ics", Data: cal}
+
+	events := expandCalendarObject(obj, "/cal/", march[0], march[1])
+	if !sameStarts(events, "Mar 2 09:00", "Mar 9 14:00", "Mar 23 09:00") {
+		t.Fatalf("occurrences start %v, want Mar 2 09:00, Mar 9 14:00 and Mar 23 09:00", starts(events))
+	}
+	for _, e := range events {
+		moved := e.Start.UTC().Day() == 9
+		if moved != (e.Title == "Standup (moved)") {
+			t.Errorf("occurrence at %v has title %q", e.Start, e.Title)
+		}
+		if moved && !e.RecurrenceID.Equal(time.Date(2026, time.March, 9, 9, 0, 0, 0, time.UTC)) {
+			t.Errorf("moved occurrence RecurrenceID = %v, want its original start", e.RecurrenceID)
+		}
+	}
+}
+
+func TestExpandAllDaySeries(t *testing.T) {
+	cal := decodeCalendar(t,
+		"BEGIN:VEVENT",
+		"UID:allday@test",
+		"DTSTAMP:20260101T000000Z",
+		"SUMMARY:Conference",
+		"DTSTART;VALUE=DATE:20260310",
+		"DTEND;VALUE=DATE:20260311",
+		"RRULE:FREQ=DAILY;COUNT=3",
+		"END:VEVENT",
+	)
+	obj := caldav.CalendarObject{Path: "/cal/allday.ics", Data: cal}
+
+	start := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.Local)
+	events := expandCalendarObject(obj, "/cal/", start, start.AddDate(0, 1, 0))
+	if len(events) != 3 {
+		t.Fatalf("got %d occurrences, want 3", len(events))
+	}
+	days := make(map[int]bool)
+	for _, e := range events {
+		if !e.IsAllDay {
+			t.Errorf("occurrence at %v isn't all-day", e.Start)
+		}
+		if e.Start.Hour() != 0 || e.End.Sub(e.Start) != 24*time.Hour {
+			t.Errorf("occurrence runs %v to %v, want a whole day", e.Start, e.End)
+		}
+		days[e.Start.Day()] = true
+	}
+	if !days[10] || !days[11] || !days[12] {
+		t.Errorf("occurrences on days %v, want 10, 11 and 12", days)
+	}
+}
+
+func TestPatchEventKeepsUnknownProperties(t *testing.T) {
+	cal := decodeCalendar(t,
+		"BEGIN:VEVENT",
+		"UID:meeting@test",
+		"DTSTAMP:20260101T000000Z",
+		"SUMMARY:Planning",
+		"LOCATION;LANGUAGE=en:Room 1",
+		"DTSTART;TZID=Europe/Berlin:20260302T090000",
+		"DTEND;TZID=Europe/Berlin:20260302T100000",
+		"ORGANIZER:mailto:boss@example.com",
+		"ATTENDEE;CN=Me;PARTSTAT=ACCEPTED:mailto:me@example.com",
+		"X-CUSTOM:kept",
+		"SEQUENCE:3",
+		"END:VEVENT",
+	)
+	comp := masterComponent(cal)
+	event := *parseEvent(comp, "/cal/meeting.ics", "/cal/")
+	event.Title = "Planning, moved"
+	event.Start = event.Start.Add(time.Hour)
+	event.End = event.End.Add(time.Hour)
+	patchEvent(comp, event)
+
+	if got, _ := comp.Props.Get(ical.PropSummary).Text(); got != "Planning, moved" {
+		t.Errorf("SUMMARY = %q", got)
+	}
+	if prop := comp.Props.Get(ical.PropLocation); prop == nil || prop.Params.Get("LANGUAGE") != "en" {
+		t.Errorf("LOCATION lost its parameters: %v", prop)
+	}
+	dtstart := comp.Props.Get(ical.PropDateTimeStart)
+	if dtstart.Params.Get(ical.ParamTimezoneID) != "Europe/Berlin" || dtstart.Value != "20260302T100000" {
+		t.Errorf("DTSTART = %v %q, want 20260302T100000 in Europe/Berlin", dtstart.Params, dtstart.Value)
+	}
+	for _, name := range []string{ical.PropOrganizer, ical.PropAttendee, "X-CUSTOM"} {
+		if comp.Props.Get(name) == nil {
+			t.Errorf("%s was dropped", name)
+		}
+	}
+	if got := comp.Props.Get(ical.PropSequence).Value; got != "4" {
+		t.Errorf("SEQUENCE = %s, want 4", got)
+	}
+}
+
+func TestPatchEventSetsAndClearsRule(t *testing.T) {
+	cal := decodeCalendar(t,
+		"BEGIN:VEVENT",
+		"UID:one@test",
+		"DTSTAMP:20260101T000000Z",
+		"SUMMARY:Gym",
+		"DTSTART:20260302T070000Z",
+		"DTEND:20260302T080000Z",
+		"END:VEVENT",
+	)
+	comp := masterComponent(cal)
+	event := *parseEvent(comp, "/cal/one.ics", "/cal/")
+
+	event.Recurrence = "FREQ=WEEKLY;BYDAY=MO"
+	patchEvent(comp, event)
+	if prop := comp.Props.Get(ical.PropRecurrenceRule); prop == nil || prop.Value != "FREQ=WEEKLY;BYDAY=MO" {
+		t.Fatalf("RRULE = %v, want FREQ=WEEKLY;BYDAY=MO", prop)
+	}
+
+	event.Recurrence = ""
+	patchEvent(comp, event)
+	if prop := comp.Props.Get(ical.PropRecurrenceRule); prop != nil {
+		t.Errorf("RRULE = %q, want it removed", prop.Value)
+	}
+}
+
+func TestNewOverrideKeepsAlarms(t *testing.T) {
+	cal := decodeCalendar(t,
+		"BEGIN:VEVENT",
+		"UID:weekly@test",
+		"DTSTAMP:20260101T000000Z",
+		"SUMMARY:Standup",
+		"DTSTART;TZID=Europe/Berlin:20260302T090000",
+		"DTEND;TZID=Europe/Berlin:20260302T093000",
+		"RRULE:FREQ=WEEKLY;COUNT=4",
+		"EXDATE;TZID=Europe/Berlin:20260316T090000",
+		"ATTENDEE:mailto:me@example.com",
+		"BEGIN:VALARM",
+		"UID:alarm-1",
+		"ACTION:DISPLAY",
+		"DESCRIPTION:Standup",
+		"TRIGGER:-PT10M",
+		"X-APPLE-DEFAULT-ALARM:TRUE",
+		"END:VALARM",
+		"END:VEVENT",
+	)
+	master := masterComponent(cal)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone data")
+	}
+	occurrence := time.Date(2026, time.March, 9, 9, 0, 0, 0, berlin)
+
+	override := newOverride(master, occurrence)
+	cal.Children = append(cal.Children, override)
+	event := *parseEvent(override, "/cal/weekly.ics", "/cal/")
+	event.RecurrenceID = occurrence
+	event.Title = "Standup (moved)"
+	event.Recurrence = ""
+	patchEvent(override, event)
+
+	for _, name := range []string{ical.PropRecurrenceRule, ical.PropExceptionDates} {
+		if override.Props.Get(name) != nil {
+			t.Errorf("override kept the series' %s", name)
+		}
+	}
+	if prop := override.Props.Get(ical.PropRecurrenceID); prop == nil || prop.Value != "20260309T090000" || prop.Params.Get(ical.ParamTimezoneID) != "Europe/Berlin" {
+		t.Errorf("RECURRENCE-ID = %v, want 20260309T090000 in Europe/Berlin", prop)
+	}
+	if override.Props.Get(ical.PropAttendee) == nil {
+		t.Error("override lost the attendees")
+	}
+	if len(override.Children) != 1 || override.Children[0].Props.Get("X-APPLE-DEFAULT-ALARM") == nil {
+		t.Fatalf("override alarms = %d, want the series' alarm kept whole", len(override.Children))
+	}
+
+	// The series itself is untouched
+	if master.Props.Get(ical.PropSummary).Value != "Standup" || len(master.Children) != 1 {
+		t.Error("editing the override changed the series")
+	}
+	override.Children[0].Props.SetText(ical.PropDescription, "changed")
+	if master.Children[0].Props.Get(ical.PropDescription).Value != "Standup" {
+		t.Error("override shares its alarm with the series")
+	}
+}
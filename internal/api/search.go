@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+
+	"fm-cli/internal/model"
+
+	"git.sr.ht/~rockorager/go-jmap"
+	"git.sr.ht/~rockorager/go-jmap/mail/email"
+)
+
+// SearchPageSize is the number of results returned per SearchEmails call
+const SearchPageSize = 20
+
+// SearchEmails runs a search across all mailboxes, newest first, returning
+// one page of results starting at position.
+func (c *Client) SearchEmails(query model.SearchQuery, position int) ([]model.Email, error) {
+	req := &jmap.Request{}
+	req.Invoke(&email.Query{
+		Account:  c.getMailAccountID(),
+		Filter:   searchFilter(query),
+		Sort:     mailboxSort(),
+		Limit:    SearchPageSize,
+		Position: int64(position),
+	})
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Email/query failed: %w", err)
+	}
+
+	var ids []string
+	for _, inv := range resp.Responses {
+		if errArgs, ok := inv.Args.(*jmap.MethodError); ok {
+			return nil, fmt.Errorf("search failed: %s", errArgs.Error())
+		}
+		if res, ok := inv.Args.(*email.QueryResponse); ok {
+			ids = fromJMAPIDs(res.IDs)
+		}
+	}
+
+	if len(ids) == 0 {
+		return []model.Email{}, nil
+	}
+
+	emails, _, err := c.FetchEmailsByID(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// Email/get doesn't preserve order; restore the query's sort
+	return orderByID(ids, emails), nil
+}
+
+// searchFilter maps a parsed query onto JMAP filter conditions. Each
+// criterion gets its own condition so they combine with AND.
+func searchFilter(q model.SearchQuery) email.Filter {
+	var conds []email.Filter
+
+	if q.Text != "" {
+		conds = append(conds, &email.FilterCondition{Text: q.Text})
+	}
+	if q.From != "" {
+		conds = append(conds, &email.FilterCondition{From: q.From})
+	}
+	if q.To != "" {
+		conds = append(conds, &email.FilterCondition{To: q.To})
+	}
+	if q.Subject != "" {
+		conds = append(conds, &email.FilterCondition{Subject: q.Subject})
+	}
+	if q.HasAttachment {
+		conds = append(conds, &email.FilterCondition{HasAttachment: true})
+	}
+	if q.IsUnread {
+		conds = append(conds, &email.FilterCondition{NotKeyword: "$seen"})
+	}
+	if q.IsFlagged {
+		conds = append(conds, &email.FilterCondition{HasKeyword: "$flagged"})
+	}
+	if q.Before != nil {
+		conds = append(conds, &email.FilterCondition{Before: q.Before})
+	}
+	if q.After != nil {
+		conds = append(conds, &email.FilterCondition{After: q.After})
+	}
+
+	switch len(conds) {
+	case 0:
+		return nil
+	case 1:
+		return conds[0]
+	}
+	return &email.FilterOperator{
+		Operator:   jmap.OperatorAND,
+		Conditions: conds,
+	}
+}
@@ -0,0 +1,302 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"fm-cli/internal/model"
+
+	"git.sr.ht/~rockorager/go-jmap"
+	"git.sr.ht/~rockorager/go-jmap/mail"
+	"git.sr.ht/~rockorager/go-jmap/mail/email"
+	"git.sr.ht/~rockorager/go-jmap/mail/mailbox"
+)
+
+// ErrCannotCalculateChanges is returned when the server can no longer compute
+// a delta from the given state and the caller must fall back to a full sync.
+var ErrCannotCalculateChanges = errors.New("server cannot calculate changes from this state")
+
+// Changes describes the objects that changed between two JMAP states.
+type Changes struct {
+	OldState       string
+	NewState       string
+	HasMoreChanges bool
+	Created        []string
+	Updated        []string
+	Destroyed      []string
+}
+
+// QueryChanges describes how the results of an Email/query changed between
+// two query states.
+type QueryChanges struct {
+	OldQueryState string
+	NewQueryState string
+	Removed       []string
+	Added         []string
+}
+
+// AccountID returns the primary mail account ID of the session.
+func (c *Client) AccountID() string {
+	return string(c.getMailAccountID())
+}
+
+// FetchMailboxesByID retrieves the given mailboxes along with the current
+// Mailbox state string. A nil ids slice fetches every mailbox.
+func (c *Client) FetchMailboxesByID(ids []string) ([]model.Mailbox, string, error) {
+	req := &jmap.Request{}
+	req.Invoke(&mailbox.Get{
+		Account: c.getMailAccountID(),
+		IDs:     toJMAPIDs(ids),
+	})
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("Mailbox/get failed: %w", err)
+	}
+
+	var mailboxes []model.Mailbox
+	var state string
+	for _, inv := range resp.Responses {
+		if errArgs, ok := inv.Args.(*jmap.MethodError); ok {
+			return nil, "", fmt.Errorf("JMAP method error: %s (type: %s)", inv.Name, errArgs.Type)
+		}
+		if res, ok := inv.Args.(*mailbox.GetResponse); ok {
+			state = res.State
+			for _, m := range res.List {
+				mailboxes = append(mailboxes, model.Mailbox{
+					ID:          string(m.ID),
+					Name:        m.Name,
+					UnreadCount: int(m.UnreadThreads),
+					Role:        string(m.Role),
+					ParentID:    string(m.ParentID),
+					SortOrder:   int(m.SortOrder),
+				})
+			}
+		}
+	}
+
+	sort.Slice(mailboxes, func(i, j int) bool {
+		return mailboxes[i].SortOrder < mailboxes[j].SortOrder
+	})
+
+	return mailboxes, state, nil
+}
+
+// FetchEmailsByID retrieves list properties for the given emails along with
+// the current Email state string. Without ids it only gets the state.
+func (c *Client) FetchEmailsByID(ids []string) ([]model.Email, string, error) {
+	req := &jmap.Request{}
+	if len(ids) == 0 {
+		// Still asked for, so an empty mailbox has a state to resume from
+		req.Invoke(&emailStateGet{Account: c.getMailAccountID(), IDs: []jmap.ID{}, Properties: []string{"id"}})
+	} else {
+		req.Invoke(&email.Get{
+			Account:    c.getMailAccountID(),
+			IDs:        toJMAPIDs(ids),
+			Properties: emailListProperties,
+		})
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("Email/get failed: %w", err)
+	}
+
+	var emails []model.Email
+	var state string
+	for _, inv := range resp.Responses {
+		if errArgs, ok := inv.Args.(*jmap.MethodError); ok {
+			return nil, "", fmt.Errorf("JMAP method error: %s (type: %s)", inv.Name, errArgs.Type)
+		}
+		if res, ok := inv.Args.(*email.GetResponse); ok {
+			state = res.State
+			for _, e := range res.List {
+				emails = append(emails, toModelEmail(e))
+			}
+		}
+	}
+	return emails, state, nil
+}
+
+// emailStateGet is an Email/get for no emails, which only returns the
+// state. email.Get can't send it: it omits an empty ids, which asks for
+// every email instead.
+type emailStateGet struct {
+	Account    jmap.ID   `json:"accountId"`
+	IDs        []jmap.ID `json:"ids"`
+	Properties []string  `json:"properties"`
+}
+
+func (m *emailStateGet) Name() string { return "Email/get" }
+
+func (m *emailStateGet) Requires() []jmap.URI { return []jmap.URI{mail.URI} }
+
+// QueryEmailIDs returns one page of email IDs for a mailbox, newest first,
+// along with the query state used for Email/queryChanges.
+func (c *Client) QueryEmailIDs(mailboxID string, position, limit int) ([]string, string, error) {
+	req := &jmap.Request{}
+	req.Invoke(&email.Query{
+		Account:  c.getMailAccountID(),
+		Filter:   mailboxFilter(mailboxID),
+		Sort:     mailboxSort(),
+		Limit:    uint64(limit),
+		Position: int64(position),
+	})
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("Email/query failed: %w", err)
+	}
+
+	for _, inv := range resp.Responses {
+		if errArgs, ok := inv.Args.(*jmap.MethodError); ok {
+			return nil, "", fmt.Errorf("JMAP method error: %s (type: %s)", inv.Name, errArgs.Type)
+		}
+		if res, ok := inv.Args.(*email.QueryResponse); ok {
+			return fromJMAPIDs(res.IDs), res.QueryState, nil
+		}
+	}
+	return nil, "", fmt.Errorf("no Email/query response")
+}
+
+// MailboxChanges returns the mailboxes created, updated or destroyed since
+// the given state.
+func (c *Client) MailboxChanges(sinceState string) (*Changes, error) {
+	req := &jmap.Request{}
+	req.Invoke(&mailbox.Changes{
+		Account:    c.getMailAccountID(),
+		SinceState: sinceState,
+	})
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Mailbox/changes failed: %w", err)
+	}
+
+	for _, inv := range resp.Responses {
+		if errArgs, ok := inv.Args.(*jmap.MethodError); ok {
+			return nil, changesError(inv.Name, errArgs)
+		}
+		if res, ok := inv.Args.(*mailbox.ChangesResponse); ok {
+			return &Changes{
+				OldState:       res.OldState,
+				NewState:       res.NewState,
+				HasMoreChanges: res.HasMoreChanges,
+				Created:        fromJMAPIDs(res.Created),
+				Updated:        fromJMAPIDs(res.Updated),
+				Destroyed:      fromJMAPIDs(res.Destroyed),
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("no Mailbox/changes response")
+}
+
+// EmailChanges returns the emails created, updated or destroyed since the
+// given state. At most maxChanges IDs are returned; HasMoreChanges reports
+// whether another call is needed to catch up.
+func (c *Client) EmailChanges(sinceState string, maxChanges int) (*Changes, error) {
+	req := &jmap.Request{}
+	req.Invoke(&email.Changes{
+		Account:    c.getMailAccountID(),
+		SinceState: sinceState,
+		MaxChanges: uint64(maxChanges),
+	})
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Email/changes failed: %w", err)
+	}
+
+	for _, inv := range resp.Responses {
+		if errArgs, ok := inv.Args.(*jmap.MethodError); ok {
+			return nil, changesError(inv.Name, errArgs)
+		}
+		if res, ok := inv.Args.(*email.ChangesResponse); ok {
+			return &Changes{
+				OldState:       res.OldState,
+				NewState:       res.NewState,
+				HasMoreChanges: res.HasMoreChanges,
+				Created:        fromJMAPIDs(res.Created),
+				Updated:        fromJMAPIDs(res.Updated),
+				Destroyed:      fromJMAPIDs(res.Destroyed),
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("no Email/changes response")
+}
+
+// EmailQueryChanges returns how the mailbox listing used by FetchEmails has
+// changed since the given query state.
+func (c *Client) EmailQueryChanges(mailboxID, sinceQueryState string) (*QueryChanges, error) {
+	req := &jmap.Request{}
+	req.Invoke(&email.QueryChanges{
+		Account:         c.getMailAccountID(),
+		Filter:          mailboxFilter(mailboxID),
+		Sort:            mailboxSort(),
+		SinceQueryState: sinceQueryState,
+	})
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Email/queryChanges failed: %w", err)
+	}
+
+	for _, inv := range resp.Responses {
+		if errArgs, ok := inv.Args.(*jmap.MethodError); ok {
+			return nil, changesError(inv.Name, errArgs)
+		}
+		if res, ok := inv.Args.(*email.QueryChangesResponse); ok {
+			qc := &QueryChanges{
+				OldQueryState: res.OldQueryState,
+				NewQueryState: res.NewQueryState,
+				Removed:       fromJMAPIDs(res.Removed),
+			}
+			for _, item := range res.Added {
+				qc.Added = append(qc.Added, string(item.ID))
+			}
+			return qc, nil
+		}
+	}
+	return nil, fmt.Errorf("no Email/queryChanges response")
+}
+
+func mailboxFilter(mailboxID string) *email.FilterCondition {
+	return &email.FilterCondition{
+		InMailbox: jmap.ID(mailboxID),
+	}
+}
+
+func mailboxSort() []*email.SortComparator {
+	return []*email.SortComparator{
+		{Property: "receivedAt", IsAscending: false},
+	}
+}
+
+// changesError maps a */changes method error onto ErrCannotCalculateChanges
+// where appropriate so callers can trigger a full resync.
+func changesError(method string, errArgs *jmap.MethodError) error {
+	if errArgs.Type == "cannotCalculateChanges" {
+		return fmt.Errorf("%s: %w", method, ErrCannotCalculateChanges)
+	}
+	return fmt.Errorf("JMAP method error: %s (type: %s)", method, errArgs.Type)
+}
+
+func toJMAPIDs(ids []string) []jmap.ID {
+	if ids == nil {
+		return nil
+	}
+	out := make([]jmap.ID, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, jmap.ID(id))
+	}
+	return out
+}
+
+func fromJMAPIDs(ids []jmap.ID) []string {
+	var out []string
+	for _, id := range ids {
+		out = append(out, string(id))
+	}
+	return out
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFetchEmailsByIDWithoutIDsReturnsState(t *testing.T) {
+	client := newJMAPServer(t, 50, func(method string, raw json.RawMessage) (string, interface{}) {
+		var args map[string]json.RawMessage
+		json.Unmarshal(raw, &args)
+		// A missing or null ids would ask for every email
+		if method != "Email/get" || string(args["ids"]) != "[]" {
+			t.Errorf("%s called with ids %s, want Email/get with []", method, args["ids"])
+		}
+		return "Email/get", map[string]interface{}{
+			"accountId": "a1",
+			"state":     "s7",
+			"list":      []interface{}{},
+			"notFound":  []string{},
+		}
+	})
+
+	emails, state, err := client.FetchEmailsByID(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 0 || state != "s7" {
+		t.Errorf("got %d emails and state %q, want none and s7", len(emails), state)
+	}
+}
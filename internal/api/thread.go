@@ -0,0 +1,174 @@
+package api
+
+import (
+	"fmt"
+
+	"fm-cli/internal/model"
+
+	"git.sr.ht/~rockorager/go-jmap"
+	"git.sr.ht/~rockorager/go-jmap/mail/email"
+	"git.sr.ht/~rockorager/go-jmap/mail/thread"
+)
+
+// FetchThreadedEmails lists a mailbox one conversation at a time: each entry
+// is the newest message of its thread in the mailbox, newest first.
+func (c *Client) FetchThreadedEmails(mailboxID string, position int) ([]model.Email, error) {
+	req := &jmap.Request{}
+	queryID := req.Invoke(&email.Query{
+		Account:         c.getMailAccountID(),
+		Filter:          mailboxFilter(mailboxID),
+		Sort:            mailboxSort(),
+		CollapseThreads: true,
+		Limit:           20,
+		Position:        int64(position),
+	})
+	req.Invoke(&email.Get{
+		Account: c.getMailAccountID(),
+		ReferenceIDs: &jmap.ResultReference{
+			ResultOf: queryID,
+			Name:     "Email/query",
+			Path:     "/ids",
+		},
+		Properties: emailListProperties,
+	})
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Email/query failed: %w", err)
+	}
+
+	var ids []string
+	var emails []model.Email
+	for _, inv := range resp.Responses {
+		if errArgs, ok := inv.Args.(*jmap.MethodError); ok {
+			return nil, fmt.Errorf("JMAP method error: %s (type: %s)", inv.Name, errArgs.Type)
+		}
+		switch res := inv.Args.(type) {
+		case *email.QueryResponse:
+			ids = fromJMAPIDs(res.IDs)
+		case *email.GetResponse:
+			for _, e := range res.List {
+				emails = append(emails, toModelEmail(e))
+			}
+		}
+	}
+
+	return orderByID(ids, emails), nil
+}
+
+// FetchThreads retrieves the given threads with list properties for every
+// message in them, in the same order as threadIDs.
+func (c *Client) FetchThreads(threadIDs []string) ([]model.Thread, error) {
+	if len(threadIDs) == 0 {
+		return nil, nil
+	}
+
+	req := &jmap.Request{}
+	threadCall := req.Invoke(&thread.Get{
+		Account: c.getMailAccountID(),
+		IDs:     toJMAPIDs(threadIDs),
+	})
+	req.Invoke(&email.Get{
+		Account: c.getMailAccountID(),
+		ReferenceIDs: &jmap.ResultReference{
+			ResultOf: threadCall,
+			Name:     "Thread/get",
+			Path:     "/list/*/emailIds",
+		},
+		Properties: emailListProperties,
+	})
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Thread/get failed: %w", err)
+	}
+
+	// Thread emailIds are already sorted oldest first
+	emailIDs := make(map[string][]string)
+	byID := make(map[string]model.Email)
+	for _, inv := range resp.Responses {
+		if errArgs, ok := inv.Args.(*jmap.MethodError); ok {
+			return nil, fmt.Errorf("JMAP method error: %s (type: %s)", inv.Name, errArgs.Type)
+		}
+		switch res := inv.Args.(type) {
+		case *thread.GetResponse:
+			for _, t := range res.List {
+				emailIDs[string(t.ID)] = fromJMAPIDs(t.EmailIDs)
+			}
+		case *email.GetResponse:
+			for _, e := range res.List {
+				byID[string(e.ID)] = toModelEmail(e)
+			}
+		}
+	}
+
+	threads := make([]model.Thread, 0, len(threadIDs))
+	for _, id := range threadIDs {
+		ids, ok := emailIDs[id]
+		if !ok {
+			continue
+		}
+		t := model.Thread{ID: id}
+		for _, emailID := range ids {
+			if e, ok := byID[emailID]; ok {
+				t.Emails = append(t.Emails, e)
+			}
+		}
+		threads = append(threads, t)
+	}
+	return threads, nil
+}
+
+// orderByID returns the emails in the order of ids, since Email/get doesn't
+// promise to preserve it
+func orderByID(ids []string, emails []model.Email) []model.Email {
+	byID := make(map[string]model.Email, len(emails))
+	for _, e := range emails {
+		byID[e.ID] = e
+	}
+	ordered := make([]model.Email, 0, len(ids))
+	for _, id := range ids {
+		if e, ok := byID[id]; ok {
+			ordered = append(ordered, e)
+		}
+	}
+	return ordered
+}
+
+// FetchReplyContext looks up the Message-ID and References of an email so a
+// reply to it (or a forward) joins its thread.
+func (c *Client) FetchReplyContext(emailID string, forward bool) (*model.ReplyContext, error) {
+	req := &jmap.Request{}
+	req.Invoke(&email.Get{
+		Account:    c.getMailAccountID(),
+		IDs:        []jmap.ID{jmap.ID(emailID)},
+		Properties: []string{"messageId", "references"},
+	})
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Email/get failed: %w", err)
+	}
+
+	for _, inv := range resp.Responses {
+		if errArgs, ok := inv.Args.(*jmap.MethodError); ok {
+			return nil, fmt.Errorf("JMAP method error: %s (type: %s)", inv.Name, errArgs.Type)
+		}
+		if res, ok := inv.Args.(*email.GetResponse); ok {
+			if len(res.List) == 0 {
+				return nil, fmt.Errorf("email not found")
+			}
+			e := res.List[0]
+			reply := &model.ReplyContext{
+				EmailID:    emailID,
+				References: e.References,
+				Forward:    forward,
+			}
+			if len(e.MessageID) > 0 {
+				reply.MessageID = e.MessageID[0]
+			}
+			return reply, nil
+		}
+	}
+	return nil, fmt.Errorf("email not found")
+}
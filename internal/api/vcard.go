@@ -0,0 +1,243 @@
+package api
+
+import (
+	"strings"
+	"time"
+
+	"fm-cli/internal/model"
+
+	"github.com/emersion/go-vcard"
+)
+
+// patchCard writes the fields of contact into an existing card. Fields
+// fm-cli doesn't know, such as PHOTO, URL, CATEGORIES and X- fields, are
+// left alone, as are emails, phones and addresses that didn't change.
+func patchCard(card vcard.Card, contact model.Contact) {
+	fn := contact.FullName
+	if fn == "" {
+		fn = strings.TrimSpace(contact.FirstName + " " + contact.LastName)
+	}
+	setOptionalField(card, vcard.FieldFormattedName, fn)
+
+	// Keeps the middle name, which Contact doesn't have
+	name := card.Name()
+	if name == nil {
+		name = &vcard.Name{}
+	}
+	name.FamilyName = contact.LastName
+	name.GivenName = contact.FirstName
+	name.HonorificPrefix = contact.Prefix
+	name.HonorificSuffix = contact.Suffix
+	card.SetName(name)
+
+	setOptionalField(card, vcard.FieldNickname, contact.Nickname)
+	setOrganization(card, contact.Company)
+	setOptionalField(card, vcard.FieldTitle, contact.JobTitle)
+	setOptionalField(card, vcard.FieldNote, contact.Notes)
+	setOptionalField(card, vcard.FieldBirthday, contact.Birthday)
+	setOptionalField(card, vcard.FieldAnniversary, contact.Anniversary)
+
+	var emails []*vcard.Field
+	for _, email := range contact.Emails {
+		if email.Email == "" {
+			continue
+		}
+		field := findField(card[vcard.FieldEmail], func(f *vcard.Field) bool {
+			return strings.EqualFold(f.Value, email.Email)
+		})
+		if field == nil {
+			field = &vcard.Field{Value: email.Email, Params: make(vcard.Params)}
+		}
+		if email.Type != "" && workHomeType(field) != email.Type {
+			setType(field, workHomeTypes, workHomeTypeParam(email.Type))
+		}
+		emails = append(emails, field)
+	}
+	setFields(card, vcard.FieldEmail, emails)
+
+	var phones []*vcard.Field
+	for _, phone := range contact.Phones {
+		if phone.Number == "" {
+			continue
+		}
+		field := findField(card[vcard.FieldTelephone], func(f *vcard.Field) bool {
+			return f.Value == phone.Number
+		})
+		if field == nil {
+			field = &vcard.Field{Value: phone.Number, Params: make(vcard.Params)}
+		}
+		if phone.Type != "" && phoneType(field) != phone.Type {
+			setType(field, phoneTypes, phoneTypeParam(phone.Type))
+		}
+		phones = append(phones, field)
+	}
+	setFields(card, vcard.FieldTelephone, phones)
+
+	var addresses []*vcard.Field
+	for _, addr := range contact.Addresses {
+		field := findField(card[vcard.FieldAddress], func(f *vcard.Field) bool {
+			a := parseAddress(f)
+			a.Type = addr.Type
+			return a == addr
+		})
+		if field != nil && addr.Type != "" && workHomeType(field) != addr.Type {
+			setType(field, workHomeTypes, workHomeTypeParam(addr.Type))
+		}
+		if field == nil {
+			adr := &vcard.Address{
+				Field:         &vcard.Field{Params: make(vcard.Params)},
+				StreetAddress: addr.Street,
+				Locality:      addr.City,
+				Region:        addr.State,
+				PostalCode:    addr.PostalCode,
+				Country:       addr.Country,
+			}
+			if addr.Type == "home" || addr.Type == "work" {
+				adr.Params.Add(vcard.ParamType, strings.ToUpper(addr.Type))
+			}
+			card.AddAddress(adr)
+			field = card[vcard.FieldAddress][len(card[vcard.FieldAddress])-1]
+		}
+		addresses = append(addresses, field)
+	}
+	setFields(card, vcard.FieldAddress, addresses)
+
+	card.SetRevision(time.Now().UTC())
+}
+
+// setOptionalField sets a single-valued field, or removes it when empty.
+// An unchanged field keeps its parameters.
+func setOptionalField(card vcard.Card, name, value string) {
+	if value == "" {
+		delete(card, name)
+		return
+	}
+	if card.Value(name) == value {
+		return
+	}
+	if field := card.Get(name); field != nil {
+		field.Value = value
+		return
+	}
+	card.SetValue(name, value)
+}
+
+// setOrganization sets the company, the first component of ORG, keeping
+// any departments that follow it
+func setOrganization(card vcard.Card, company string) {
+	field := card.Get(vcard.FieldOrganization)
+	if field == nil {
+		setOptionalField(card, vcard.FieldOrganization, company)
+		return
+	}
+	parts := strings.Split(field.Value, ";")
+	if parts[0] == company {
+		return
+	}
+	parts[0] = company
+	if strings.Join(parts, "") == "" {
+		delete(card, vcard.FieldOrganization)
+		return
+	}
+	field.Value = strings.Join(parts, ";")
+}
+
+// The TYPE values fm-cli maps to a kind. Others, such as INTERNET, VOICE
+// and PREF, are kept when the kind changes.
+var (
+	workHomeTypes = []string{"work", "home"}
+	phoneTypes    = []string{"cell", "mobile", "work", "home", "fax"}
+)
+
+// workHomeType returns the kind of an email or address: work, home or other
+func workHomeType(f *vcard.Field) string {
+	for _, t := range f.Params.Types() {
+		switch strings.ToLower(t) {
+		case "work":
+			return "work"
+		case "home":
+			return "home"
+		}
+	}
+	return "other"
+}
+
+// phoneType returns the kind of a phone number: mobile, work, home, fax or
+// other
+func phoneType(f *vcard.Field) string {
+	types := f.Params.Types()
+	for _, kind := range phoneTypes {
+		for _, t := range types {
+			if strings.Contains(strings.ToLower(t), kind) {
+				if kind == "cell" {
+					return "mobile"
+				}
+				return kind
+			}
+		}
+	}
+	return "other"
+}
+
+func workHomeTypeParam(kind string) string {
+	if kind == "work" || kind == "home" {
+		return strings.ToUpper(kind)
+	}
+	return ""
+}
+
+func phoneTypeParam(kind string) string {
+	switch kind {
+	case "mobile":
+		return "CELL"
+	case "work", "home", "fax":
+		return strings.ToUpper(kind)
+	}
+	return ""
+}
+
+// setType replaces the kinds among a field's TYPE values with value, or
+// just drops them when value is empty
+func setType(f *vcard.Field, kinds []string, value string) {
+	if f.Params == nil {
+		f.Params = make(vcard.Params)
+	}
+	var types []string
+	for _, t := range f.Params[vcard.ParamType] {
+		known := false
+		for _, kind := range kinds {
+			if strings.Contains(strings.ToLower(t), kind) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			types = append(types, t)
+		}
+	}
+	if value != "" {
+		types = append(types, value)
+	}
+	if len(types) == 0 {
+		delete(f.Params, vcard.ParamType)
+		return
+	}
+	f.Params[vcard.ParamType] = types
+}
+
+func findField(fields []*vcard.Field, match func(*vcard.Field) bool) *vcard.Field {
+	for _, f := range fields {
+		if match(f) {
+			return f
+		}
+	}
+	return nil
+}
+
+func setFields(card vcard.Card, name string, fields []*vcard.Field) {
+	if len(fields) == 0 {
+		delete(card, name)
+		return
+	}
+	card[name] = fields
+}
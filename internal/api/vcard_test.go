@@ -0,0 +1,141 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"fm-cli/internal/model"
+
+	"github.com/emersion/go-vcard"
+	"github.com/emersion/go-webdav/carddav"
+)
+
+func decodeCard(t *testing.T, lines ...string) vcard.Card {
+	t.Helper()
+	text := "BEGIN:VCARD\r\nVERSION:3.0\r\n" + strings.Join(lines, "\r\n") + "\r\nEND:VCARD\r\n"
+	card, err := vcard.NewDecoder(strings.NewReader(text)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return card
+}
+
+// roundTrip parses card, lets edit change the contact and patches it back
+// in, then re-encodes and decodes the card the way the server would store it
+func roundTrip(t *testing.T, card vcard.Card, edit func(*model.Contact)) vcard.Card {
+	t.Helper()
+	contact := parseAddressObject(carddav.AddressObject{Path: "/ab/c.vcf", Card: card}, "/ab/")
+	edit(contact)
+	patchCard(card, *contact)
+
+	var b strings.Builder
+	if err := vcard.NewEncoder(&b).Encode(card); err != nil {
+		t.Fatal(err)
+	}
+	out, err := vcard.NewDecoder(strings.NewReader(b.String())).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func types(f *vcard.Field) string {
+	return strings.Join(f.Params[vcard.ParamType], ",")
+}
+
+func TestPatchCardKeepsDepartment(t *testing.T) {
+	card := decodeCard(t,
+		"UID:c1",
+		"FN:Ann Lee",
+		"N:Lee;Ann;;;",
+		"ORG:Acme;Sales",
+	)
+
+	out := roundTrip(t, card, func(c *model.Contact) {
+		if c.Company != "Acme" {
+			t.Errorf("Company = %q, want Acme", c.Company)
+		}
+		c.Company = "Globex"
+	})
+	if got := out.Value(vcard.FieldOrganization); got != "Globex;Sales" {
+		t.Errorf("ORG = %q, want Globex;Sales", got)
+	}
+
+	out = roundTrip(t, out, func(c *model.Contact) { c.Company = "" })
+	if got := out.Value(vcard.FieldOrganization); got != ";Sales" {
+		t.Errorf("ORG = %q, want the department kept", got)
+	}
+}
+
+func TestPatchCardRetypesEmailAndPhone(t *testing.T) {
+	card := decodeCard(t,
+		"UID:c1",
+		"FN:Ann Lee",
+		"N:Lee;Ann;;;",
+		"EMAIL;TYPE=INTERNET,WORK:ann@example.com",
+		"EMAIL;TYPE=INTERNET,HOME;X-LABEL=old:ann@home.example",
+		"TEL;TYPE=VOICE,WORK:+1 555 0100",
+		"ADR;TYPE=HOME:;;1 Main St;Springfield;;12345;USA",
+	)
+
+	out := roundTrip(t, card, func(c *model.Contact) {
+		c.Emails[0].Type = "home"
+		c.Phones[0].Type = "mobile"
+		c.Addresses[0].Type = "work"
+	})
+
+	emails := out[vcard.FieldEmail]
+	if len(emails) != 2 {
+		t.Fatalf("got %d emails, want 2", len(emails))
+	}
+	if got := types(emails[0]); !strings.EqualFold(got, "INTERNET,HOME") {
+		t.Errorf("retyped EMAIL TYPE = %q, want INTERNET,HOME", got)
+	}
+	if emails[1].Params.Get("X-LABEL") != "old" || !strings.EqualFold(types(emails[1]), "INTERNET,HOME") {
+		t.Errorf("unchanged EMAIL lost its parameters: %v", emails[1].Params)
+	}
+	if got := types(out.Get(vcard.FieldTelephone)); !strings.EqualFold(got, "VOICE,CELL") {
+		t.Errorf("TEL TYPE = %q, want VOICE,CELL", got)
+	}
+	adr := out.Get(vcard.FieldAddress)
+	if got := types(adr); !strings.EqualFold(got, "WORK") || !strings.Contains(adr.Value, "1 Main St") {
+		t.Errorf("ADR = %q %q, want the same address typed WORK", got, adr.Value)
+	}
+
+	// And back to other, which has no TYPE of its own
+	out = roundTrip(t, out, func(c *model.Contact) { c.Emails[0].Type = "other" })
+	if got := types(out[vcard.FieldEmail][0]); !strings.EqualFold(got, "INTERNET") {
+		t.Errorf("EMAIL TYPE = %q, want INTERNET", got)
+	}
+}
+
+func TestPatchCardKeepsUnknownFields(t *testing.T) {
+	card := decodeCard(t,
+		"UID:c1",
+		"FN:Ann Lee",
+		"N:Lee;Ann;Marie;;",
+		"PHOTO;VALUE=uri:https://example.com/ann.jpg",
+		"X-SOCIALPROFILE;TYPE=twitter:ann",
+		"EMAIL;TYPE=WORK:ann@example.com",
+		"TEL;TYPE=CELL:+1 555 0100",
+	)
+
+	out := roundTrip(t, card, func(c *model.Contact) {
+		c.JobTitle = "Engineer"
+		c.Phones = append(c.Phones, model.ContactPhone{Type: "home", Number: "+1 555 0199"})
+	})
+
+	if out.Get(vcard.FieldPhoto) == nil || out.Get("X-SOCIALPROFILE") == nil {
+		t.Error("unknown fields were dropped")
+	}
+	if out.Name().AdditionalName != "Marie" {
+		t.Errorf("middle name = %q, want Marie", out.Name().AdditionalName)
+	}
+	if out.Value(vcard.FieldTitle) != "Engineer" {
+		t.Errorf("TITLE = %q, want Engineer", out.Value(vcard.FieldTitle))
+	}
+	phones := out[vcard.FieldTelephone]
+	if len(phones) != 2 || !strings.EqualFold(types(phones[0]), "CELL") || !strings.EqualFold(types(phones[1]), "HOME") {
+		t.Errorf("TEL fields = %v %v, want the CELL number kept and a HOME one added", phones[0], phones[len(phones)-1])
+	}
+}
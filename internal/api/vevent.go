@@ -0,0 +1,90 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"fm-cli/internal/model"
+
+	"github.com/emersion/go-ical"
+)
+
+// patchEvent writes the fields of event into an existing VEVENT. Anything
+// fm-cli doesn't edit, such as ORGANIZER, ATTENDEE, CATEGORIES and X-
+// properties, is left as it is. SEQUENCE goes up by one.
+func patchEvent(comp *ical.Component, event model.CalendarEvent) {
+	setOptionalText(comp, ical.PropSummary, event.Title)
+	setOptionalText(comp, ical.PropDescription, event.Description)
+	setOptionalText(comp, ical.PropLocation, event.Location)
+
+	oldStart := comp.Props.Get(ical.PropDateTimeStart)
+	if oldStart != nil {
+		copied := *oldStart
+		oldStart = &copied
+	}
+	dtstart := ical.NewProp(ical.PropDateTimeStart)
+	setEventTime(dtstart, event.Start, event.IsAllDay, oldStart)
+	comp.Props.Set(dtstart)
+
+	if !event.End.IsZero() {
+		dtend := ical.NewProp(ical.PropDateTimeEnd)
+		setEventTime(dtend, event.End, event.IsAllDay, oldStart)
+		comp.Props.Set(dtend)
+		comp.Props.Del(ical.PropDuration)
+	}
+
+	if event.Recurrence == "" {
+		comp.Props.Del(ical.PropRecurrenceRule)
+	} else if prop := comp.Props.Get(ical.PropRecurrenceRule); prop == nil || !SameRecurrence(prop.Value, event.Recurrence) {
+		rrule := ical.NewProp(ical.PropRecurrenceRule)
+		rrule.SetValueType(ical.ValueRecurrence)
+		rrule.Value = event.Recurrence
+		comp.Props.Set(rrule)
+	}
+
+	patchAlarms(comp, event)
+
+	sequence := 0
+	if prop := comp.Props.Get(ical.PropSequence); prop != nil {
+		sequence, _ = prop.Int()
+	}
+	seq := ical.NewProp(ical.PropSequence)
+	seq.Value = strconv.Itoa(sequence + 1)
+	comp.Props.Set(seq)
+
+	now := time.Now().UTC()
+	comp.Props.SetDateTime(ical.PropDateTimeStamp, now)
+	comp.Props.SetDateTime(ical.PropLastModified, now)
+}
+
+// setOptionalText sets a text property, or removes it when empty
+func setOptionalText(comp *ical.Component, name, value string) {
+	if value == "" {
+		comp.Props.Del(name)
+		return
+	}
+	if old := comp.Props.Get(name); old != nil {
+		if text, err := old.Text(); err == nil && text == value {
+			// Keep its parameters, such as LANGUAGE or ALTREP
+			return
+		}
+	}
+	comp.Props.SetText(name, value)
+}
+
+// setEventTime sets a DTSTART or DTEND to t, written in the time zone of
+// the event's old DTSTART where it had one
+func setEventTime(prop *ical.Prop, t time.Time, allDay bool, oldStart *ical.Prop) {
+	switch {
+	case allDay:
+		prop.SetDate(t)
+	case oldStart == nil || oldStart.ValueType() == ical.ValueDate || oldStart.Params.Get(ical.ParamValue) == "DATE":
+		// time.Local has no zone name to write as TZID
+		if t.Location() == time.Local {
+			t = t.UTC()
+		}
+		prop.SetDateTime(t)
+	default:
+		setTimeLike(prop, t, oldStart)
+	}
+}
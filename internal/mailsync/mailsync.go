@@ -0,0 +1,404 @@
+// Package mailsync keeps the local SQLite cache in step with the JMAP server
+// by applying Mailbox/changes, Email/changes and Email/queryChanges deltas
+// instead of re-downloading mailbox listings.
+package mailsync
+
+import (
+	"errors"
+	"fmt"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/model"
+	"fm-cli/internal/storage"
+)
+
+const (
+	// PageSize is the number of emails fetched when a mailbox is first synced
+	PageSize = 20
+
+	// maxChanges caps the IDs requested per Email/changes call
+	maxChanges = 500
+
+	// getBatch caps the IDs requested per Email/get call
+	getBatch = 100
+
+	// maxRevalidate caps the cached emails checked against the server when
+	// it can't calculate changes. Older ones are dropped and fetched again
+	// when scrolled to.
+	maxRevalidate = 10 * getBatch
+)
+
+// Client is the part of the JMAP API the engine uses. *api.Client
+// implements it.
+type Client interface {
+	AccountID() string
+	FetchMailboxes() ([]model.Mailbox, error)
+	FetchMailboxesByID(ids []string) ([]model.Mailbox, string, error)
+	MailboxChanges(sinceState string) (*api.Changes, error)
+	FetchEmailsByID(ids []string) ([]model.Email, string, error)
+	QueryEmailIDs(mailboxID string, position, limit int) ([]string, string, error)
+	EmailChanges(sinceState string, maxChanges int) (*api.Changes, error)
+	EmailQueryChanges(mailboxID, sinceQueryState string) (*api.QueryChanges, error)
+
+	SaveDraft(existingDraftID string, draft model.Draft) error
+	SendEmail(existingDraftID string, draft model.Draft) error
+	DestroyEmails(emailIDs []string) error
+	MoveEmails(emailIDs []string, fromMailboxID, toMailboxID string) error
+	SetMailboxes(mailboxes map[string][]string) error
+	SetUnreadMany(emailIDs []string, isUnread bool) error
+	SetFlaggedMany(emailIDs []string, isFlagged bool) error
+	SetKeyword(emailIDs []string, keyword string, set bool) error
+}
+
+// Engine syncs one JMAP account into local storage
+type Engine struct {
+	client Client
+	db     *storage.DB
+}
+
+// Result summarises what a sync applied to the cache
+type Result struct {
+	Created   int
+	Updated   int
+	Destroyed int
+}
+
+// New creates a sync engine for the given client and database
+func New(client Client, db *storage.DB) *Engine {
+	return &Engine{client: client, db: db}
+}
+
+func (e *Engine) accountID() string {
+	return e.client.AccountID()
+}
+
+// SyncMailboxes brings the cached mailbox list up to date and returns it
+func (e *Engine) SyncMailboxes() ([]model.Mailbox, error) {
+	state, err := e.db.GetSyncState(e.accountID(), "Mailbox")
+	if err != nil {
+		return nil, err
+	}
+
+	if state == "" {
+		if err := e.fullMailboxSync(); err != nil {
+			return nil, err
+		}
+		return e.db.GetMailboxes()
+	}
+
+	for {
+		changes, err := e.client.MailboxChanges(state)
+		if errors.Is(err, api.ErrCannotCalculateChanges) {
+			if err := e.fullMailboxSync(); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		changed := append(append([]string{}, changes.Created...), changes.Updated...)
+		if len(changed) > 0 {
+			mbs, _, err := e.client.FetchMailboxesByID(changed)
+			if err != nil {
+				return nil, err
+			}
+			if err := e.db.SaveMailboxes(mbs); err != nil {
+				return nil, err
+			}
+		}
+		if len(changes.Destroyed) > 0 {
+			if err := e.db.DeleteMailboxes(changes.Destroyed); err != nil {
+				return nil, err
+			}
+		}
+
+		state = changes.NewState
+		if err := e.db.SetSyncState(e.accountID(), "Mailbox", state); err != nil {
+			return nil, err
+		}
+		if !changes.HasMoreChanges {
+			break
+		}
+	}
+
+	return e.db.GetMailboxes()
+}
+
+func (e *Engine) fullMailboxSync() error {
+	mbs, state, err := e.client.FetchMailboxesByID(nil)
+	if err != nil {
+		return err
+	}
+
+	// Drop mailboxes that no longer exist on the server
+	cached, err := e.db.GetMailboxes()
+	if err != nil {
+		return err
+	}
+	live := make(map[string]bool)
+	for _, mb := range mbs {
+		live[mb.ID] = true
+	}
+	var gone []string
+	for _, mb := range cached {
+		if !live[mb.ID] {
+			gone = append(gone, mb.ID)
+		}
+	}
+	if err := e.db.DeleteMailboxes(gone); err != nil {
+		return err
+	}
+
+	if err := e.db.SaveMailboxes(mbs); err != nil {
+		return err
+	}
+	return e.db.SetSyncState(e.accountID(), "Mailbox", state)
+}
+
+// SyncEmails applies all email changes since the last sync to the cache and
+// reconciles the listing of the given mailbox. The first sync of a mailbox
+// downloads its newest PageSize emails.
+func (e *Engine) SyncEmails(mailboxID string) (Result, error) {
+	var res Result
+
+	state, err := e.db.GetSyncState(e.accountID(), "Email")
+	if err != nil {
+		return res, err
+	}
+
+	if state == "" {
+		if err := e.initialEmailSync(mailboxID, &res); err != nil {
+			return res, err
+		}
+		return res, nil
+	}
+
+	if err := e.applyEmailChanges(state, &res); err != nil {
+		if !errors.Is(err, api.ErrCannotCalculateChanges) {
+			return res, err
+		}
+		if err := e.initialEmailSync(mailboxID, &res); err != nil {
+			return res, err
+		}
+		return res, nil
+	}
+
+	if err := e.syncQuery(mailboxID, &res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+// initialEmailSync seeds the cache with the first page of a mailbox and
+// records the Email and query states to resume from. It also runs when the
+// server can't calculate changes, so the newest emails cached before are
+// checked against the server rather than trusted or thrown away.
+func (e *Engine) initialEmailSync(mailboxID string, res *Result) error {
+	ids, queryState, err := e.client.QueryEmailIDs(mailboxID, 0, PageSize)
+	if err != nil {
+		return err
+	}
+
+	emails, state, err := e.client.FetchEmailsByID(ids)
+	if err != nil {
+		return err
+	}
+	missing, err := e.db.MissingEmailIDs(ids)
+	if err != nil {
+		return err
+	}
+	if err := e.db.SaveEmails(emails); err != nil {
+		return err
+	}
+	res.Created += len(missing)
+	if err := e.revalidateCache(ids, res); err != nil {
+		return err
+	}
+
+	if err := e.db.SetSyncState(e.accountID(), "Email", state); err != nil {
+		return err
+	}
+	return e.db.SetSyncState(e.accountID(), queryKind(mailboxID), queryState)
+}
+
+// revalidateCache refetches the newest maxRevalidate cached emails other
+// than those in skip, and drops the rest. Emails the server no longer
+// returns were destroyed and are dropped too; the others get their current
+// mailboxes and flags and keep their cached bodies.
+func (e *Engine) revalidateCache(skip []string, res *Result) error {
+	cached, err := e.db.EmailIDs()
+	if err != nil {
+		return err
+	}
+	fresh := make(map[string]bool, len(skip))
+	for _, id := range skip {
+		fresh[id] = true
+	}
+	var ids []string
+	for _, id := range cached {
+		if !fresh[id] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > maxRevalidate {
+		if err := e.db.DeleteEmails(ids[maxRevalidate:]); err != nil {
+			return err
+		}
+		ids = ids[:maxRevalidate]
+	}
+
+	for start := 0; start < len(ids); start += getBatch {
+		end := start + getBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := ids[start:end]
+		emails, _, err := e.client.FetchEmailsByID(batch)
+		if err != nil {
+			return fmt.Errorf("failed to check cached emails: %w", err)
+		}
+		if err := e.db.SaveEmails(emails); err != nil {
+			return err
+		}
+
+		found := make(map[string]bool, len(emails))
+		for _, em := range emails {
+			found[em.ID] = true
+		}
+		var gone []string
+		for _, id := range batch {
+			if !found[id] {
+				gone = append(gone, id)
+			}
+		}
+		if err := e.db.DeleteEmails(gone); err != nil {
+			return err
+		}
+		res.Updated += len(emails)
+		res.Destroyed += len(gone)
+	}
+	return nil
+}
+
+// applyEmailChanges walks Email/changes from the given state until the
+// server reports no more changes
+func (e *Engine) applyEmailChanges(state string, res *Result) error {
+	for {
+		changes, err := e.client.EmailChanges(state, maxChanges)
+		if err != nil {
+			return err
+		}
+
+		if err := e.fetchAndSave(changes.Created); err != nil {
+			return err
+		}
+		if err := e.fetchAndSave(changes.Updated); err != nil {
+			return err
+		}
+		if err := e.db.DeleteEmails(changes.Destroyed); err != nil {
+			return err
+		}
+		res.Created += len(changes.Created)
+		res.Updated += len(changes.Updated)
+		res.Destroyed += len(changes.Destroyed)
+
+		state = changes.NewState
+		if err := e.db.SetSyncState(e.accountID(), "Email", state); err != nil {
+			return err
+		}
+		if !changes.HasMoreChanges {
+			return nil
+		}
+	}
+}
+
+// syncQuery reconciles the mailbox listing using Email/queryChanges so that
+// emails which entered the mailbox but were never cached get downloaded
+func (e *Engine) syncQuery(mailboxID string, res *Result) error {
+	kind := queryKind(mailboxID)
+	queryState, err := e.db.GetSyncState(e.accountID(), kind)
+	if err != nil {
+		return err
+	}
+
+	if queryState == "" {
+		return e.reseedQuery(mailboxID, res)
+	}
+
+	qc, err := e.client.EmailQueryChanges(mailboxID, queryState)
+	if errors.Is(err, api.ErrCannotCalculateChanges) {
+		return e.reseedQuery(mailboxID, res)
+	}
+	if err != nil {
+		return err
+	}
+
+	added := make(map[string]bool)
+	for _, id := range qc.Added {
+		added[id] = true
+	}
+	for _, id := range qc.Removed {
+		// queryChanges reports updated emails as removed and re-added
+		if added[id] {
+			continue
+		}
+		if err := e.db.RemoveEmailFromMailbox(id, mailboxID); err != nil {
+			return err
+		}
+	}
+
+	missing, err := e.db.MissingEmailIDs(qc.Added)
+	if err != nil {
+		return err
+	}
+	if err := e.fetchAndSave(missing); err != nil {
+		return err
+	}
+	res.Created += len(missing)
+
+	return e.db.SetSyncState(e.accountID(), kind, qc.NewQueryState)
+}
+
+// reseedQuery fetches the first page of a mailbox that has no usable query
+// state, downloading only the emails not already cached. Email/changes has
+// already brought the mailboxes of cached emails up to date, so nothing
+// else in the mailbox is touched.
+func (e *Engine) reseedQuery(mailboxID string, res *Result) error {
+	ids, queryState, err := e.client.QueryEmailIDs(mailboxID, 0, PageSize)
+	if err != nil {
+		return err
+	}
+	missing, err := e.db.MissingEmailIDs(ids)
+	if err != nil {
+		return err
+	}
+	if err := e.fetchAndSave(missing); err != nil {
+		return err
+	}
+	res.Created += len(missing)
+	return e.db.SetSyncState(e.accountID(), queryKind(mailboxID), queryState)
+}
+
+// fetchAndSave downloads list properties for the IDs in batches and stores them
+func (e *Engine) fetchAndSave(ids []string) error {
+	for start := 0; start < len(ids); start += getBatch {
+		end := start + getBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		emails, _, err := e.client.FetchEmailsByID(ids[start:end])
+		if err != nil {
+			return fmt.Errorf("failed to fetch changed emails: %w", err)
+		}
+		if err := e.db.SaveEmails(emails); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queryKind(mailboxID string) string {
+	return "EmailQuery:" + mailboxID
+}
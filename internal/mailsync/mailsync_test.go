@@ -0,0 +1,245 @@
+package mailsync
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/model"
+	"fm-cli/internal/storage"
+)
+
+// newInboxServer serves an inbox of n emails, e01 the newest, at Email
+// state s1 and query state q1
+func newInboxServer(n int) *fakeClient {
+	client := newFakeClient()
+	client.emailState = "s1"
+	client.queryState = "q1"
+	for i := 1; i <= n; i++ {
+		id := fmt.Sprintf("e%02d", i)
+		client.emails[id] = model.Email{
+			ID:         id,
+			Subject:    "Message " + id,
+			Date:       fmt.Sprintf("2024-02-%02d 10:00", n+1-i),
+			MailboxIDs: []string{"inbox"},
+		}
+		client.queries["inbox"] = append(client.queries["inbox"], id)
+	}
+	return client
+}
+
+// cachedIn returns the cached emails of a mailbox by ID
+func cachedIn(t *testing.T, db *storage.DB, mailboxID string) map[string]bool {
+	t.Helper()
+	emails, err := db.GetEmails(mailboxID, 0, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]bool)
+	for _, e := range emails {
+		ids[e.ID] = true
+	}
+	return ids
+}
+
+// cacheOlderPage stores emails the way scrolling past the first page does
+func cacheOlderPage(t *testing.T, db *storage.DB, client *fakeClient, ids ...string) {
+	t.Helper()
+	var emails []model.Email
+	for _, id := range ids {
+		emails = append(emails, client.emails[id])
+	}
+	if err := db.SaveEmails(emails); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncEmailsInitial(t *testing.T) {
+	db := openTestDB(t)
+	client := newInboxServer(25)
+
+	res, err := New(client, db).SyncEmails("inbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Created != PageSize {
+		t.Errorf("Created = %d, want %d", res.Created, PageSize)
+	}
+	inbox := cachedIn(t, db, "inbox")
+	if len(inbox) != PageSize || !inbox["e01"] || inbox["e21"] {
+		t.Errorf("cached inbox has %d emails, want the newest %d", len(inbox), PageSize)
+	}
+	if state, _ := db.GetSyncState("a1", "Email"); state != "s1" {
+		t.Errorf("Email state = %q, want s1", state)
+	}
+	if state, _ := db.GetSyncState("a1", queryKind("inbox")); state != "q1" {
+		t.Errorf("query state = %q, want q1", state)
+	}
+}
+
+func TestSyncEmailsAppliesChanges(t *testing.T) {
+	db := openTestDB(t)
+	client := newInboxServer(5)
+	if _, err := New(client, db).SyncEmails("inbox"); err != nil {
+		t.Fatal(err)
+	}
+
+	// e06 arrives, e02 is archived and e03 destroyed
+	client.emails["e06"] = model.Email{ID: "e06", MailboxIDs: []string{"inbox"}}
+	e02 := client.emails["e02"]
+	e02.MailboxIDs = []string{"archive"}
+	client.emails["e02"] = e02
+	delete(client.emails, "e03")
+	client.queries["inbox"] = []string{"e06", "e01", "e04", "e05"}
+	client.emailChanges["s1"] = &api.Changes{NewState: "s2", Created: []string{"e06"}, Updated: []string{"e02"}, Destroyed: []string{"e03"}}
+	client.queryChanges["inbox:q1"] = &api.QueryChanges{NewQueryState: "q2", Added: []string{"e06"}, Removed: []string{"e02", "e03"}}
+	client.emailState = "s2"
+	client.queryState = "q2"
+
+	res, err := New(client, db).SyncEmails("inbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Created != 1 || res.Updated != 1 || res.Destroyed != 1 {
+		t.Errorf("result = %+v, want 1 created, updated and destroyed", res)
+	}
+	inbox := cachedIn(t, db, "inbox")
+	if len(inbox) != 4 || !inbox["e06"] || inbox["e02"] || inbox["e03"] {
+		t.Errorf("cached inbox = %v, want e01, e04, e05 and e06", inbox)
+	}
+	if archive := cachedIn(t, db, "archive"); !archive["e02"] {
+		t.Errorf("cached archive = %v, want e02", archive)
+	}
+	if state, _ := db.GetSyncState("a1", "Email"); state != "s2" {
+		t.Errorf("Email state = %q, want s2", state)
+	}
+}
+
+func TestSyncEmailsCannotCalculateKeepsCache(t *testing.T) {
+	db := openTestDB(t)
+	client := newInboxServer(25)
+	if _, err := New(client, db).SyncEmails("inbox"); err != nil {
+		t.Fatal(err)
+	}
+	cacheOlderPage(t, db, client, "e21", "e22", "e23", "e24", "e25")
+	if err := db.SaveEmailBody("e25", "kept for offline reading"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server lost track of s1; e24 was destroyed meanwhile
+	delete(client.emails, "e24")
+	client.queries["inbox"] = append(client.queries["inbox"][:23], "e25")
+	client.emailState = "s9"
+	client.queryState = "q9"
+
+	res, err := New(client, db).SyncEmails("inbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Destroyed != 1 {
+		t.Errorf("Destroyed = %d, want 1", res.Destroyed)
+	}
+	inbox := cachedIn(t, db, "inbox")
+	if len(inbox) != 24 || inbox["e24"] || !inbox["e25"] {
+		t.Errorf("cached inbox has %d emails, want all but the destroyed e24", len(inbox))
+	}
+	if body, _ := db.GetEmailBody("e25"); body != "kept for offline reading" {
+		t.Errorf("body of e25 = %q, want the cached body", body)
+	}
+	if state, _ := db.GetSyncState("a1", "Email"); state != "s9" {
+		t.Errorf("Email state = %q, want s9", state)
+	}
+}
+
+func TestSyncEmailsQueryReseedKeepsOlderPages(t *testing.T) {
+	db := openTestDB(t)
+	client := newInboxServer(25)
+	if _, err := New(client, db).SyncEmails("inbox"); err != nil {
+		t.Fatal(err)
+	}
+	cacheOlderPage(t, db, client, "e21", "e22", "e23", "e24", "e25")
+
+	// Email/changes works, but the query state is too old to follow
+	client.emails["e26"] = model.Email{ID: "e26", MailboxIDs: []string{"inbox"}}
+	client.queries["inbox"] = append([]string{"e26"}, client.queries["inbox"]...)
+	client.emailChanges["s1"] = &api.Changes{NewState: "s2", Created: []string{"e26"}}
+	client.emailState = "s2"
+	client.queryState = "q9"
+
+	if _, err := New(client, db).SyncEmails("inbox"); err != nil {
+		t.Fatal(err)
+	}
+	inbox := cachedIn(t, db, "inbox")
+	if len(inbox) != 26 || !inbox["e26"] || !inbox["e25"] {
+		t.Errorf("cached inbox has %d emails, want all 26", len(inbox))
+	}
+	if state, _ := db.GetSyncState("a1", queryKind("inbox")); state != "q9" {
+		t.Errorf("query state = %q, want q9", state)
+	}
+}
+
+func TestSyncEmailsEmptyMailboxStoresState(t *testing.T) {
+	db := openTestDB(t)
+	client := newInboxServer(0)
+
+	if _, err := New(client, db).SyncEmails("inbox"); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := db.GetSyncState("a1", "Email"); state != "s1" {
+		t.Fatalf("Email state = %q, want s1 so the next sync is incremental", state)
+	}
+
+	// The next sync follows the changes instead of starting over
+	client.emails["e01"] = model.Email{ID: "e01", MailboxIDs: []string{"inbox"}}
+	client.queries["inbox"] = []string{"e01"}
+	client.emailChanges["s1"] = &api.Changes{NewState: "s2", Created: []string{"e01"}}
+	client.queryChanges["inbox:q1"] = &api.QueryChanges{NewQueryState: "q2", Added: []string{"e01"}}
+	client.emailState = "s2"
+	client.queryState = "q2"
+	client.fetched = 0
+
+	res, err := New(client, db).SyncEmails("inbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Created != 1 || client.fetched != 1 {
+		t.Errorf("created %d and fetched %d emails, want just e01", res.Created, client.fetched)
+	}
+}
+
+func TestSyncEmailsCannotCalculateChecksNewestOnly(t *testing.T) {
+	db := openTestDB(t)
+	client := newInboxServer(PageSize)
+	if _, err := New(client, db).SyncEmails("inbox"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Scrolled far back, older than the whole first page
+	var older []string
+	start := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < maxRevalidate+5; i++ {
+		id := fmt.Sprintf("old%04d", i)
+		client.emails[id] = model.Email{
+			ID:         id,
+			Date:       start.Add(-time.Duration(i) * time.Minute).Format("2006-01-02 15:04"),
+			MailboxIDs: []string{"inbox"},
+		}
+		older = append(older, id)
+	}
+	cacheOlderPage(t, db, client, older...)
+
+	client.emailState = "s9"
+	client.fetched = 0
+	if _, err := New(client, db).SyncEmails("inbox"); err != nil {
+		t.Fatal(err)
+	}
+
+	if client.fetched != PageSize+maxRevalidate {
+		t.Errorf("fetched %d emails, want the first page and the newest %d cached", client.fetched, maxRevalidate)
+	}
+	inbox := cachedIn(t, db, "inbox")
+	if !inbox[older[maxRevalidate-1]] || inbox[older[maxRevalidate]] {
+		t.Errorf("cache kept %d emails, want the newest %d cached ones checked and the rest dropped", len(inbox), PageSize+maxRevalidate)
+	}
+}
@@ -0,0 +1,406 @@
+package mailsync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/model"
+	"fm-cli/internal/storage"
+)
+
+// Pending action types
+const (
+	ActionSaveDraft = "save_draft"
+	ActionSendDraft = "send_draft"
+	ActionDelete    = "delete"
+	ActionMove      = "move"
+	ActionSetFlags  = "set_flags"
+	ActionKeyword   = "keyword"
+	ActionMailboxes = "set_mailboxes"
+)
+
+// maxAttempts is how many times an action is retried after network errors
+// before it is marked failed
+const maxAttempts = 5
+
+// ErrConflict is returned when a queued action no longer makes sense on the
+// server, e.g. the target mailbox was deleted while we were offline.
+var ErrConflict = errors.New("conflict")
+
+// draftAction is the data of save_draft and send_draft actions
+type draftAction struct {
+	From        string              `json:"from"`
+	To          string              `json:"to"`
+	Cc          string              `json:"cc,omitempty"`
+	Bcc         string              `json:"bcc,omitempty"`
+	Subject     string              `json:"subject"`
+	Body        string              `json:"body"`
+	HTMLBody    string              `json:"html_body,omitempty"`
+	Attachments []string            `json:"attachments,omitempty"` // Local file paths, in actions queued before Files
+	Files       []model.Attachment  `json:"files,omitempty"`       // Local files and blobs already on the server
+	Reply       *model.ReplyContext `json:"reply,omitempty"`
+}
+
+// moveAction is the data of move actions
+type moveAction struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// mailboxesAction is the data of set_mailboxes actions
+type mailboxesAction struct {
+	Mailboxes []string `json:"mailboxes"`
+}
+
+// flagsAction is the data of set_flags actions; nil fields are left alone
+type flagsAction struct {
+	Unread  *bool `json:"unread,omitempty"`
+	Flagged *bool `json:"flagged,omitempty"`
+}
+
+// keywordAction is the data of keyword actions
+type keywordAction struct {
+	Keyword string `json:"keyword"`
+	Set     bool   `json:"set"`
+}
+
+// QueueDraft queues a local draft to be saved to the server, or sent when
+// actionType is ActionSendDraft
+func QueueDraft(db *storage.DB, actionType, localID string, draft model.Draft) error {
+	data := draftAction{
+		From:     draft.From,
+		To:       draft.To,
+		Cc:       draft.Cc,
+		Bcc:      draft.Bcc,
+		Subject:  draft.Subject,
+		Body:     draft.Body,
+		HTMLBody: draft.HTMLBody,
+		Reply:    draft.Reply,
+	}
+	for _, att := range draft.Attachments {
+		if att.Path != "" || att.BlobID != "" {
+			data.Files = append(data.Files, att)
+		}
+	}
+	return queue(db, actionType, localID, data)
+}
+
+// QueueDelete queues emails to be permanently destroyed on the server
+func QueueDelete(db *storage.DB, emailIDs []string) error {
+	for _, id := range emailIDs {
+		if err := queue(db, ActionDelete, id, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueueMove queues emails to be moved between mailboxes on the server
+func QueueMove(db *storage.DB, emailIDs []string, fromMailboxID, toMailboxID string) error {
+	for _, id := range emailIDs {
+		if err := queue(db, ActionMove, id, moveAction{From: fromMailboxID, To: toMailboxID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueueSetMailboxes queues emails to be put in exactly the mailboxes each
+// maps to, e.g. only the Trash when deleted
+func QueueSetMailboxes(db *storage.DB, mailboxes map[string][]string) error {
+	for id, mbIDs := range mailboxes {
+		if err := queue(db, ActionMailboxes, id, mailboxesAction{Mailboxes: mbIDs}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueueSetFlags queues read/flagged changes; a nil value leaves that
+// keyword unchanged
+func QueueSetFlags(db *storage.DB, emailIDs []string, unread, flagged *bool) error {
+	for _, id := range emailIDs {
+		if err := queue(db, ActionSetFlags, id, flagsAction{Unread: unread, Flagged: flagged}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueueKeyword queues a keyword to be added to, or with set false removed
+// from, emails on the server
+func QueueKeyword(db *storage.DB, emailIDs []string, keyword string, set bool) error {
+	for _, id := range emailIDs {
+		if err := queue(db, ActionKeyword, id, keywordAction{Keyword: keyword, Set: set}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func queue(db *storage.DB, actionType, emailID string, data interface{}) error {
+	encoded, err := encodeAction(data)
+	if err != nil {
+		return err
+	}
+	return db.AddPendingAction(actionType, emailID, encoded)
+}
+
+func encodeAction(data interface{}) (string, error) {
+	if data == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(data)
+	return string(b), err
+}
+
+// The Unqueue functions take back what the matching Queue function queued,
+// for undo. They return the emails whose action had already reached the
+// server, and so has to be undone there.
+
+// UnqueueMove takes back a QueueMove
+func UnqueueMove(db *storage.DB, emailIDs []string, fromMailboxID, toMailboxID string) ([]string, error) {
+	return unqueue(db, ActionMove, emailIDs, func(string) interface{} {
+		return moveAction{From: fromMailboxID, To: toMailboxID}
+	})
+}
+
+// UnqueueSetMailboxes takes back a QueueSetMailboxes
+func UnqueueSetMailboxes(db *storage.DB, mailboxes map[string][]string) ([]string, error) {
+	emailIDs := make([]string, 0, len(mailboxes))
+	for id := range mailboxes {
+		emailIDs = append(emailIDs, id)
+	}
+	return unqueue(db, ActionMailboxes, emailIDs, func(id string) interface{} {
+		return mailboxesAction{Mailboxes: mailboxes[id]}
+	})
+}
+
+// UnqueueSetFlags takes back a QueueSetFlags
+func UnqueueSetFlags(db *storage.DB, emailIDs []string, unread, flagged *bool) ([]string, error) {
+	return unqueue(db, ActionSetFlags, emailIDs, func(string) interface{} {
+		return flagsAction{Unread: unread, Flagged: flagged}
+	})
+}
+
+func unqueue(db *storage.DB, actionType string, emailIDs []string, data func(emailID string) interface{}) ([]string, error) {
+	var sent []string
+	for _, id := range emailIDs {
+		encoded, err := encodeAction(data(id))
+		if err != nil {
+			return nil, err
+		}
+		cancelled, err := db.CancelPendingAction(actionType, id, encoded)
+		if err != nil {
+			return nil, err
+		}
+		if !cancelled {
+			sent = append(sent, id)
+		}
+	}
+	return sent, nil
+}
+
+// ReplayResult summarises a pass over the pending action queue
+type ReplayResult struct {
+	Done        int // Applied and removed from the queue
+	Failed      int // Gave up after an error
+	Conflicts   int // No longer applicable on the server
+	Unconfirmed int // Sends that may or may not have gone out
+	Remaining   int // Still queued, e.g. because the network dropped again
+}
+
+// ReplayPending applies queued actions to the server in the order they were
+// queued. Conflicts and server-side errors park the action for the user to
+// retry or discard and the pass continues; a network error stops the pass
+// and leaves the rest queued for the next one. A send cut off by a network
+// error is parked as unconfirmed rather than requeued, since the server may
+// have accepted it and retrying would send it twice.
+func (e *Engine) ReplayPending() (ReplayResult, error) {
+	var res ReplayResult
+	actions, err := e.db.GetPendingActions()
+	if err != nil {
+		return res, err
+	}
+
+	var queued []storage.PendingAction
+	for _, a := range actions {
+		if a.Status == storage.PendingStatusQueued {
+			queued = append(queued, a)
+		}
+	}
+
+	r := replayer{Engine: e}
+	for i, a := range queued {
+		err := r.apply(a)
+		switch {
+		case err == nil:
+			if err := e.db.RemovePendingAction(a.ID); err != nil {
+				return res, err
+			}
+			res.Done++
+			r.cleanUp(a)
+		case errors.Is(err, ErrConflict) || errors.Is(err, api.ErrNotFound):
+			e.db.RecordPendingAttempt(a.ID, storage.PendingStatusConflict, err.Error())
+			res.Conflicts++
+		case a.Type == ActionSendDraft && api.IsNetworkError(err):
+			e.db.RecordPendingAttempt(a.ID, storage.PendingStatusUnconfirmed,
+				"connection lost while sending, check Sent before retrying: "+err.Error())
+			res.Unconfirmed++
+			res.Remaining = len(queued) - i - 1
+			return res, err
+		case api.IsNetworkError(err) && a.Attempts+1 < maxAttempts:
+			e.db.RecordPendingAttempt(a.ID, storage.PendingStatusQueued, err.Error())
+			res.Remaining = len(queued) - i
+			return res, err
+		default:
+			e.db.RecordPendingAttempt(a.ID, storage.PendingStatusFailed, err.Error())
+			res.Failed++
+		}
+	}
+	return res, nil
+}
+
+// replayer applies single actions, remembering the server's mailboxes for
+// the rest of the pass
+type replayer struct {
+	*Engine
+	mailboxes map[string]bool
+}
+
+func (r *replayer) apply(a storage.PendingAction) error {
+	switch a.Type {
+	case ActionSaveDraft, ActionSendDraft:
+		var data draftAction
+		if err := json.Unmarshal([]byte(a.Data), &data); err != nil {
+			return fmt.Errorf("invalid draft data: %w", err)
+		}
+		draft := model.Draft{
+			From:     data.From,
+			To:       data.To,
+			Cc:       data.Cc,
+			Bcc:      data.Bcc,
+			Subject:  data.Subject,
+			Body:     data.Body,
+			HTMLBody: data.HTMLBody,
+			Reply:    data.Reply,
+		}
+		for _, path := range data.Attachments {
+			data.Files = append(data.Files, model.Attachment{Name: filepath.Base(path), Path: path})
+		}
+		for _, att := range data.Files {
+			// Blobs are already on the server; only local files can go missing
+			if att.BlobID == "" {
+				if _, err := os.Stat(att.Path); err != nil {
+					return fmt.Errorf("%w: attachment %s is gone", ErrConflict, att.Path)
+				}
+			}
+			draft.Attachments = append(draft.Attachments, att)
+		}
+
+		if a.Type == ActionSendDraft {
+			return r.client.SendEmail("", draft)
+		}
+		return r.client.SaveDraft("", draft)
+
+	case ActionDelete:
+		return r.client.DestroyEmails([]string{a.EmailID})
+
+	case ActionMove:
+		var data moveAction
+		if err := json.Unmarshal([]byte(a.Data), &data); err != nil {
+			return fmt.Errorf("invalid move data: %w", err)
+		}
+		exists, err := r.mailboxExists(data.To)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: destination mailbox no longer exists", ErrConflict)
+		}
+		// Leaving a mailbox that is gone is a no-op
+		if ok, _ := r.mailboxExists(data.From); !ok {
+			data.From = ""
+		}
+		return r.client.MoveEmails([]string{a.EmailID}, data.From, data.To)
+
+	case ActionMailboxes:
+		var data mailboxesAction
+		if err := json.Unmarshal([]byte(a.Data), &data); err != nil {
+			return fmt.Errorf("invalid mailboxes data: %w", err)
+		}
+		// Mailboxes deleted in the meantime are dropped, but the email
+		// has to end up somewhere
+		var mbIDs []string
+		for _, id := range data.Mailboxes {
+			exists, err := r.mailboxExists(id)
+			if err != nil {
+				return err
+			}
+			if exists {
+				mbIDs = append(mbIDs, id)
+			}
+		}
+		if len(mbIDs) == 0 {
+			return fmt.Errorf("%w: destination mailbox no longer exists", ErrConflict)
+		}
+		return r.client.SetMailboxes(map[string][]string{a.EmailID: mbIDs})
+
+	case ActionSetFlags:
+		var data flagsAction
+		if err := json.Unmarshal([]byte(a.Data), &data); err != nil {
+			return fmt.Errorf("invalid flags data: %w", err)
+		}
+		if data.Unread != nil {
+			if err := r.client.SetUnreadMany([]string{a.EmailID}, *data.Unread); err != nil {
+				return err
+			}
+		}
+		if data.Flagged != nil {
+			if err := r.client.SetFlaggedMany([]string{a.EmailID}, *data.Flagged); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case ActionKeyword:
+		var data keywordAction
+		if err := json.Unmarshal([]byte(a.Data), &data); err != nil {
+			return fmt.Errorf("invalid keyword data: %w", err)
+		}
+		return r.client.SetKeyword([]string{a.EmailID}, data.Keyword, data.Set)
+	}
+	return fmt.Errorf("unknown action type %q", a.Type)
+}
+
+// cleanUp drops local copies an applied action has made redundant. The
+// action is already off the queue, so a failure here must not bring it
+// back; the next sync tidies up whatever is left.
+func (r *replayer) cleanUp(a storage.PendingAction) {
+	switch a.Type {
+	case ActionSaveDraft, ActionSendDraft:
+		r.db.DeleteLocalDraft(a.EmailID)
+	case ActionDelete:
+		r.db.DeleteEmail(a.EmailID)
+	}
+}
+
+// mailboxExists checks a mailbox against the server's current list, fetched
+// once per pass
+func (r *replayer) mailboxExists(id string) (bool, error) {
+	if r.mailboxes == nil {
+		mbs, err := r.client.FetchMailboxes()
+		if err != nil {
+			return false, err
+		}
+		r.mailboxes = make(map[string]bool, len(mbs))
+		for _, mb := range mbs {
+			r.mailboxes[mb.ID] = true
+		}
+	}
+	return r.mailboxes[id], nil
+}
@@ -0,0 +1,420 @@
+package mailsync
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/model"
+	"fm-cli/internal/storage"
+)
+
+// fakeClient is an in-memory JMAP server. Sync methods serve the emails,
+// queries and scripted change sets; write methods record what they were
+// asked to do. fail makes the named method return an error.
+type fakeClient struct {
+	mailboxes    []model.Mailbox
+	mailboxState string
+
+	emails       map[string]model.Email
+	emailState   string
+	emailChanges map[string]*api.Changes // By since state
+
+	queries      map[string][]string // Mailbox ID to email IDs, newest first
+	queryState   string
+	queryChanges map[string]*api.QueryChanges // By mailbox ID and since state
+
+	fail    map[string]error
+	fetched int // Email IDs asked for by FetchEmailsByID
+
+	sends     int // SendEmail calls, whether they succeed or not
+	sent      []model.Draft
+	saved     []model.Draft
+	destroyed []string
+	keywords  []string
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		mailboxes:    []model.Mailbox{{ID: "inbox", Name: "Inbox", Role: "inbox"}, {ID: "archive", Name: "Archive", Role: "archive"}},
+		mailboxState: "m1",
+		emails:       map[string]model.Email{},
+		emailChanges: map[string]*api.Changes{},
+		queries:      map[string][]string{},
+		queryChanges: map[string]*api.QueryChanges{},
+		fail:         map[string]error{},
+	}
+}
+
+// netErr is what a dropped connection looks like to the api package
+var netErr = &url.Error{Op: "Post", URL: "https://jmap.example/api", Err: errors.New("connection reset by peer")}
+
+func (f *fakeClient) AccountID() string { return "a1" }
+
+func (f *fakeClient) FetchMailboxes() ([]model.Mailbox, error) {
+	if err := f.fail["FetchMailboxes"]; err != nil {
+		return nil, err
+	}
+	return f.mailboxes, nil
+}
+
+func (f *fakeClient) FetchMailboxesByID(ids []string) ([]model.Mailbox, string, error) {
+	if ids == nil {
+		return f.mailboxes, f.mailboxState, nil
+	}
+	want := make(map[string]bool)
+	for _, id := range ids {
+		want[id] = true
+	}
+	var mbs []model.Mailbox
+	for _, mb := range f.mailboxes {
+		if want[mb.ID] {
+			mbs = append(mbs, mb)
+		}
+	}
+	return mbs, f.mailboxState, nil
+}
+
+func (f *fakeClient) MailboxChanges(sinceState string) (*api.Changes, error) {
+	if sinceState == f.mailboxState {
+		return &api.Changes{OldState: sinceState, NewState: sinceState}, nil
+	}
+	return nil, api.ErrCannotCalculateChanges
+}
+
+func (f *fakeClient) FetchEmailsByID(ids []string) ([]model.Email, string, error) {
+	if err := f.fail["FetchEmailsByID"]; err != nil {
+		return nil, "", err
+	}
+	f.fetched += len(ids)
+	var emails []model.Email
+	for _, id := range ids {
+		if e, ok := f.emails[id]; ok {
+			emails = append(emails, e)
+		}
+	}
+	return emails, f.emailState, nil
+}
+
+func (f *fakeClient) QueryEmailIDs(mailboxID string, position, limit int) ([]string, string, error) {
+	ids := f.queries[mailboxID]
+	if position > len(ids) {
+		position = len(ids)
+	}
+	ids = ids[position:]
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, f.queryState, nil
+}
+
+func (f *fakeClient) EmailChanges(sinceState string, maxChanges int) (*api.Changes, error) {
+	if sinceState == f.emailState {
+		return &api.Changes{OldState: sinceState, NewState: sinceState}, nil
+	}
+	if c, ok := f.emailChanges[sinceState]; ok {
+		return c, nil
+	}
+	return nil, api.ErrCannotCalculateChanges
+}
+
+func (f *fakeClient) EmailQueryChanges(mailboxID, sinceQueryState string) (*api.QueryChanges, error) {
+	if sinceQueryState == f.queryState {
+		return &api.QueryChanges{OldQueryState: sinceQueryState, NewQueryState: sinceQueryState}, nil
+	}
+	if qc, ok := f.queryChanges[mailboxID+":"+sinceQueryState]; ok {
+		return qc, nil
+	}
+	return nil, api.ErrCannotCalculateChanges
+}
+
+func (f *fakeClient) SaveDraft(existingDraftID string, draft model.Draft) error {
+	if err := f.fail["SaveDraft"]; err != nil {
+		return err
+	}
+	f.saved = append(f.saved, draft)
+	return nil
+}
+
+func (f *fakeClient) SendEmail(existingDraftID string, draft model.Draft) error {
+	f.sends++
+	if err := f.fail["SendEmail"]; err != nil {
+		return err
+	}
+	f.sent = append(f.sent, draft)
+	return nil
+}
+
+func (f *fakeClient) DestroyEmails(emailIDs []string) error {
+	if err := f.fail["DestroyEmails"]; err != nil {
+		return err
+	}
+	f.destroyed = append(f.destroyed, emailIDs...)
+	return nil
+}
+
+func (f *fakeClient) MoveEmails(emailIDs []string, fromMailboxID, toMailboxID string) error {
+	return f.fail["MoveEmails"]
+}
+
+func (f *fakeClient) SetMailboxes(mailboxes map[string][]string) error {
+	return f.fail["SetMailboxes"]
+}
+
+func (f *fakeClient) SetUnreadMany(emailIDs []string, isUnread bool) error {
+	return f.fail["SetUnreadMany"]
+}
+
+func (f *fakeClient) SetFlaggedMany(emailIDs []string, isFlagged bool) error {
+	return f.fail["SetFlaggedMany"]
+}
+
+func (f *fakeClient) SetKeyword(emailIDs []string, keyword string, set bool) error {
+	if err := f.fail["SetKeyword"]; err != nil {
+		return err
+	}
+	f.keywords = append(f.keywords, emailIDs...)
+	return nil
+}
+
+// openTestDB opens an empty database under a temporary config directory
+func openTestDB(t *testing.T) *storage.DB {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	db, err := storage.OpenAccount("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// pendingStatuses returns the status of each queued action, oldest first
+func pendingStatuses(t *testing.T, db *storage.DB) []string {
+	t.Helper()
+	actions, err := db.GetPendingActions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []string
+	for _, a := range actions {
+		statuses = append(statuses, a.Status)
+	}
+	return statuses
+}
+
+func queueSend(t *testing.T, db *storage.DB, localID string) {
+	t.Helper()
+	draft := model.Draft{From: "me@example.com", To: "you@example.com", Subject: "Hi", Body: "Hello"}
+	if err := db.SaveLocalDraft(localID, draft); err != nil {
+		t.Fatal(err)
+	}
+	if err := QueueDraft(db, ActionSendDraft, localID, draft); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplaySendRemovesActionAndDraft(t *testing.T) {
+	db := openTestDB(t)
+	client := newFakeClient()
+	queueSend(t, db, "local-1")
+
+	res, err := New(client, db).ReplayPending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Done != 1 || len(client.sent) != 1 {
+		t.Fatalf("Done = %d, sent %d, want 1 and 1", res.Done, len(client.sent))
+	}
+	if got := pendingStatuses(t, db); len(got) != 0 {
+		t.Errorf("queue = %v, want empty", got)
+	}
+	if drafts, _ := db.GetLocalDrafts(); len(drafts) != 0 {
+		t.Errorf("%d local drafts left, want 0", len(drafts))
+	}
+
+	// A second pass has nothing left to send
+	if _, err := New(client, db).ReplayPending(); err != nil {
+		t.Fatal(err)
+	}
+	if client.sends != 1 {
+		t.Errorf("SendEmail called %d times, want 1", client.sends)
+	}
+}
+
+func TestReplaySendNetworkErrorNeedsConfirmation(t *testing.T) {
+	db := openTestDB(t)
+	client := newFakeClient()
+	client.fail["SendEmail"] = netErr
+	queueSend(t, db, "local-1")
+	if err := QueueKeyword(db, []string{"e1"}, "$seen", true); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := New(client, db).ReplayPending()
+	if !api.IsNetworkError(err) {
+		t.Fatalf("err = %v, want the network error", err)
+	}
+	if res.Unconfirmed != 1 || res.Remaining != 1 {
+		t.Errorf("result = %+v, want 1 unconfirmed and 1 remaining", res)
+	}
+	want := []string{storage.PendingStatusUnconfirmed, storage.PendingStatusQueued}
+	if got := pendingStatuses(t, db); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("queue = %v, want %v", got, want)
+	}
+
+	// Back online, the rest goes through but the send isn't repeated
+	delete(client.fail, "SendEmail")
+	res, err = New(client, db).ReplayPending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Done != 1 || client.sends != 1 || len(client.keywords) != 1 {
+		t.Errorf("Done = %d, sends = %d, keywords = %v; want 1, 1 and [e1]", res.Done, client.sends, client.keywords)
+	}
+	if drafts, _ := db.GetLocalDrafts(); len(drafts) != 1 {
+		t.Errorf("%d local drafts, want the unconfirmed one kept", len(drafts))
+	}
+}
+
+func TestReplayNetworkErrorStopsPass(t *testing.T) {
+	db := openTestDB(t)
+	client := newFakeClient()
+	client.fail["SetKeyword"] = netErr
+	if err := QueueKeyword(db, []string{"e1", "e2"}, "$seen", true); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := New(client, db).ReplayPending()
+	if !api.IsNetworkError(err) {
+		t.Fatalf("err = %v, want the network error", err)
+	}
+	if res.Remaining != 2 {
+		t.Errorf("Remaining = %d, want 2", res.Remaining)
+	}
+	actions, _ := db.GetPendingActions()
+	if len(actions) != 2 || actions[0].Status != storage.PendingStatusQueued || actions[0].Attempts != 1 || actions[1].Attempts != 0 {
+		t.Errorf("queue = %+v, want both queued and one attempt on the first", actions)
+	}
+}
+
+func TestReplayGivesUpAfterMaxAttempts(t *testing.T) {
+	db := openTestDB(t)
+	client := newFakeClient()
+	client.fail["SetKeyword"] = netErr
+	if err := QueueKeyword(db, []string{"e1"}, "$seen", true); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < maxAttempts; i++ {
+		New(client, db).ReplayPending()
+	}
+	if got := pendingStatuses(t, db); len(got) != 1 || got[0] != storage.PendingStatusFailed {
+		t.Errorf("queue = %v, want one failed action", got)
+	}
+}
+
+func TestReplayConflictContinuesPass(t *testing.T) {
+	db := openTestDB(t)
+	client := newFakeClient()
+	if err := QueueMove(db, []string{"e1"}, "inbox", "deleted-folder"); err != nil {
+		t.Fatal(err)
+	}
+	if err := QueueKeyword(db, []string{"e2"}, "$flagged", true); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := New(client, db).ReplayPending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Conflicts != 1 || res.Done != 1 {
+		t.Errorf("result = %+v, want 1 conflict and 1 done", res)
+	}
+	if got := pendingStatuses(t, db); len(got) != 1 || got[0] != storage.PendingStatusConflict {
+		t.Errorf("queue = %v, want the move parked as a conflict", got)
+	}
+}
+
+func TestReplayDeleteDropsCachedEmail(t *testing.T) {
+	db := openTestDB(t)
+	client := newFakeClient()
+	if err := db.SaveEmails([]model.Email{{ID: "e1", MailboxIDs: []string{"inbox"}}, {ID: "e2", MailboxIDs: []string{"inbox"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := QueueDelete(db, []string{"e1", "e2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(client, db).ReplayPending(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(client.destroyed)
+	if len(client.destroyed) != 2 || client.destroyed[0] != "e1" || client.destroyed[1] != "e2" {
+		t.Errorf("destroyed = %v, want [e1 e2]", client.destroyed)
+	}
+	missing, err := db.MissingEmailIDs([]string{"e1", "e2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 2 {
+		t.Errorf("cache still holds %d deleted emails", 2-len(missing))
+	}
+}
+
+func TestReplaySendKeepsForwardedAttachments(t *testing.T) {
+	db := openTestDB(t)
+	client := newFakeClient()
+	file := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(file, []byte("notes"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	draft := model.Draft{
+		From: "me@example.com", To: "you@example.com", Subject: "Fwd: Report",
+		Attachments: []model.Attachment{
+			{BlobID: "b1", Name: "report.pdf", Type: "application/pdf", Size: 1234},
+			{Name: "notes.txt", Path: file},
+		},
+	}
+	if err := QueueDraft(db, ActionSendDraft, "local-1", draft); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(client, db).ReplayPending(); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.sent) != 1 {
+		t.Fatalf("sent %d emails, want 1", len(client.sent))
+	}
+	if got := client.sent[0].Attachments; !reflect.DeepEqual(got, draft.Attachments) {
+		t.Errorf("sent attachments %+v, want %+v", got, draft.Attachments)
+	}
+}
+
+func TestReplaySendReadsOlderQueuedPaths(t *testing.T) {
+	db := openTestDB(t)
+	client := newFakeClient()
+	file := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(file, []byte("notes"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// As queued before attachments were kept whole
+	data := `{"from":"me@example.com","to":"you@example.com","subject":"Hi","body":"","attachments":["` + file + `"]}`
+	if err := db.AddPendingAction(ActionSendDraft, "local-1", data); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(client, db).ReplayPending(); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.sent) != 1 || len(client.sent[0].Attachments) != 1 || client.sent[0].Attachments[0].Path != file {
+		t.Errorf("sent %+v, want one email with %s attached", client.sent, file)
+	}
+}
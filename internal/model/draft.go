@@ -0,0 +1,52 @@
+package model
+
+// Draft is an outgoing message as entered in compose. Address fields are
+// comma-separated lists accepting "Name <email>" entries.
+type Draft struct {
+	From        string
+	To          string
+	Cc          string
+	Bcc         string
+	Subject     string
+	Body        string
+	HTMLBody    string // Optional HTML alternative of Body
+	Attachments []Attachment
+	Reply       *ReplyContext // Set when replying to or forwarding a message
+}
+
+// ReplyContext ties a reply or forward to the message it answers, so the
+// new message threads correctly and the original gets marked once sent.
+type ReplyContext struct {
+	EmailID    string   // JMAP ID of the original
+	MessageID  string   // Original Message-ID, without angle brackets
+	References []string // Original References header
+	Forward    bool     // Forwarding rather than replying
+}
+
+// InReplyTo returns the In-Reply-To message IDs for a reply; forwards
+// aren't answers and only carry References
+func (r *ReplyContext) InReplyTo() []string {
+	if r == nil || r.MessageID == "" || r.Forward {
+		return nil
+	}
+	return []string{r.MessageID}
+}
+
+// ThreadReferences returns the References header for a reply: the
+// original's references followed by the original itself
+func (r *ReplyContext) ThreadReferences() []string {
+	if r == nil || r.MessageID == "" {
+		return nil
+	}
+	refs := make([]string, 0, len(r.References)+1)
+	refs = append(refs, r.References...)
+	return append(refs, r.MessageID)
+}
+
+// Keyword is the keyword set on the original once the message is sent
+func (r *ReplyContext) Keyword() string {
+	if r.Forward {
+		return "$forwarded"
+	}
+	return "$answered"
+}
@@ -0,0 +1,125 @@
+package model
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SearchQuery is a parsed mail search such as
+// `from:alice subject:"q3 report" has:attachment after:2024-01-01 invoice`.
+type SearchQuery struct {
+	Raw           string
+	Text          string // Free text, matched anywhere in the message
+	From          string
+	To            string
+	Subject       string
+	HasAttachment bool
+	IsUnread      bool
+	IsFlagged     bool
+	Before        *time.Time
+	After         *time.Time
+}
+
+// searchDateLayouts are the accepted formats for before:/after:
+var searchDateLayouts = []string{"2006-01-02", "2006/01/02", "2006-01", "2006"}
+
+// ParseSearchQuery splits a search string into operators and free text.
+// Unknown operators and unparseable dates are kept as free text so nothing
+// the user typed is silently dropped.
+func ParseSearchQuery(raw string) SearchQuery {
+	q := SearchQuery{Raw: raw}
+	var text []string
+
+	for _, tok := range tokenizeSearch(raw) {
+		key, value, found := strings.Cut(tok, ":")
+		if !found || value == "" {
+			text = append(text, tok)
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "from":
+			q.From = value
+		case "to":
+			q.To = value
+		case "subject":
+			q.Subject = value
+		case "has":
+			if strings.EqualFold(value, "attachment") {
+				q.HasAttachment = true
+			} else {
+				text = append(text, tok)
+			}
+		case "is":
+			switch strings.ToLower(value) {
+			case "unread":
+				q.IsUnread = true
+			case "flagged", "starred":
+				q.IsFlagged = true
+			default:
+				text = append(text, tok)
+			}
+		case "before":
+			if t, ok := parseSearchDate(value); ok {
+				q.Before = &t
+			} else {
+				text = append(text, tok)
+			}
+		case "after":
+			if t, ok := parseSearchDate(value); ok {
+				q.After = &t
+			} else {
+				text = append(text, tok)
+			}
+		default:
+			text = append(text, tok)
+		}
+	}
+
+	q.Text = strings.Join(text, " ")
+	return q
+}
+
+// IsEmpty reports whether the query has no criteria at all
+func (q SearchQuery) IsEmpty() bool {
+	return q.Text == "" && q.From == "" && q.To == "" && q.Subject == "" &&
+		!q.HasAttachment && !q.IsUnread && !q.IsFlagged && q.Before == nil && q.After == nil
+}
+
+func parseSearchDate(value string) (time.Time, bool) {
+	for _, layout := range searchDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// tokenizeSearch splits on whitespace while keeping double-quoted phrases
+// (including `key:"quoted value"`) together, with the quotes removed
+func tokenizeSearch(s string) []string {
+	var tokens []string
+	var cur strings.Builder
+	inQuotes := false
+
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case unicode.IsSpace(r) && !inQuotes:
+			flush()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	day := func(y int, mo time.Month, d int) *time.Time {
+		t := time.Date(y, mo, d, 0, 0, 0, 0, time.Local)
+		return &t
+	}
+
+	tests := []struct {
+		in   string
+		want SearchQuery
+	}{
+		{in: "invoice", want: SearchQuery{Text: "invoice"}},
+		{in: "from:alice to:bob", want: SearchQuery{From: "alice", To: "bob"}},
+		{in: `subject:"q3 report" budget`, want: SearchQuery{Subject: "q3 report", Text: "budget"}},
+		{in: `"exact phrase" FROM:Alice`, want: SearchQuery{Text: "exact phrase", From: "Alice"}},
+		{in: "has:attachment is:unread is:starred", want: SearchQuery{HasAttachment: true, IsUnread: true, IsFlagged: true}},
+		{in: "is:flagged", want: SearchQuery{IsFlagged: true}},
+		{in: "after:2024-01-15 before:2024/02/01", want: SearchQuery{After: day(2024, time.January, 15), Before: day(2024, time.February, 1)}},
+		{in: "after:2024-03", want: SearchQuery{After: day(2024, time.March, 1)}},
+		{in: "before:2023", want: SearchQuery{Before: day(2023, time.January, 1)}},
+
+		// Anything not understood stays in the text
+		{in: "label:work has:pdf is:read", want: SearchQuery{Text: "label:work has:pdf is:read"}},
+		{in: "before:soon after:2024-13-01", want: SearchQuery{Text: "before:soon after:2024-13-01"}},
+		{in: "from: alice", want: SearchQuery{Text: "from: alice"}},
+		{in: "http://example.com", want: SearchQuery{Text: "http://example.com"}},
+	}
+
+	for _, tt := range tests {
+		got := ParseSearchQuery(tt.in)
+		tt.want.Raw = tt.in
+		if !sameQuery(got, tt.want) {
+			t.Errorf("ParseSearchQuery(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func sameQuery(a, b SearchQuery) bool {
+	sameTime := func(x, y *time.Time) bool {
+		if x == nil || y == nil {
+			return x == y
+		}
+		return x.Equal(*y)
+	}
+	if !sameTime(a.Before, b.Before) || !sameTime(a.After, b.After) {
+		return false
+	}
+	a.Before, a.After, b.Before, b.After = nil, nil, nil, nil
+	return a == b
+}
+
+func TestSearchQueryIsEmpty(t *testing.T) {
+	for _, in := range []string{"", "   "} {
+		if q := ParseSearchQuery(in); !q.IsEmpty() {
+			t.Errorf("ParseSearchQuery(%q).IsEmpty() = false", in)
+		}
+	}
+	for _, in := range []string{"x", "is:unread", "after:2024"} {
+		if q := ParseSearchQuery(in); q.IsEmpty() {
+			t.Errorf("ParseSearchQuery(%q).IsEmpty() = true", in)
+		}
+	}
+}
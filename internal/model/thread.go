@@ -0,0 +1,70 @@
+package model
+
+import "strings"
+
+// Thread is a conversation: every email sharing a JMAP threadId.
+type Thread struct {
+	ID     string
+	Emails []Email // Oldest first
+}
+
+// Count returns the number of messages in the thread
+func (t Thread) Count() int {
+	return len(t.Emails)
+}
+
+// EmailIDs returns the IDs of every message in the thread
+func (t Thread) EmailIDs() []string {
+	ids := make([]string, 0, len(t.Emails))
+	for _, e := range t.Emails {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+// HasUnread reports whether any message in the thread is unread
+func (t Thread) HasUnread() bool {
+	for _, e := range t.Emails {
+		if e.IsUnread {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFlagged reports whether any message in the thread is flagged
+func (t Thread) IsFlagged() bool {
+	for _, e := range t.Emails {
+		if e.IsFlagged {
+			return true
+		}
+	}
+	return false
+}
+
+// Participants returns the distinct senders in the order they first wrote,
+// using display names where available
+func (t Thread) Participants() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, e := range t.Emails {
+		name := SenderName(e.From)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// SenderName shortens a formatted address list such as
+// "Alice Example <alice@example.com>" to "Alice Example", falling back to the
+// bare address
+func SenderName(from string) string {
+	first, _, _ := strings.Cut(from, ", ")
+	if name, _, found := strings.Cut(first, " <"); found && name != "" {
+		return name
+	}
+	return strings.Trim(first, "<>")
+}
@@ -16,6 +16,8 @@ type Mailbox struct {
 type AppConfig struct {
 	APIToken string
 	JMAPCore string // URL for the JMAP session resource
+	Username string // Basic auth login, used when there is no API token
+	Password string
 }
 
 // Settings holds user preferences
@@ -52,6 +54,20 @@ type Email struct {
 	ThreadID   string
 	MailboxIDs []string
 	Body       string
+
+	HasAttachment bool
+	Attachments   []Attachment // Files attached to a local draft
+	Account       string       // Owning account, set in the unified inbox
+}
+
+// Attachment is a file attached to an email, downloadable by its blob ID.
+type Attachment struct {
+	BlobID   string
+	Name     string
+	Type     string // MIME type, e.g. "application/pdf"
+	Size     int64
+	IsInline bool   // Referenced from the HTML body (e.g. an embedded image)
+	Path     string // Local file still to be uploaded when composing
 }
 
 // Calendar represents a JMAP calendar
@@ -81,10 +97,12 @@ type CalendarEvent struct {
 	Status       string    // confirmed, tentative, cancelled
 	ShowWithoutTime bool
 	Recurrence   string    // RRULE string if recurring
+	RecurrenceID time.Time // Original start of this occurrence of a recurring event
 	Alerts       []EventAlert
 	Participants []EventParticipant
 	Created      time.Time
 	Updated      time.Time
+	ETag         string    // Server version of the calendar object it was read from
 }
 
 // EventAlert represents a reminder for a calendar event
@@ -92,6 +110,8 @@ type EventAlert struct {
 	ID       string
 	Trigger  string // e.g., "-PT15M" (15 minutes before)
 	Action   string // display, email
+	At       time.Time // Absolute trigger, used instead of Trigger when set
+	FromEnd  bool      // Trigger is relative to the end of the event
 }
 
 // EventParticipant represents an attendee of an event
@@ -134,6 +154,7 @@ type Contact struct {
 	Anniversary    string
 	Created        time.Time
 	Updated        time.Time
+	ETag           string // Server version of the card it was read from
 }
 
 // ContactEmail represents an email address for a contact
@@ -0,0 +1,415 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventTime is an event's time as typed, such as "tomorrow 3pm for 90m" or
+// "fri 9:30 to 11".
+type EventTime struct {
+	Start   time.Time
+	End     time.Time // Zero unless an end or length was given
+	HasDate bool      // A day was given, not only a time of day
+	HasTime bool      // A time of day was given
+}
+
+var (
+	clockRe    = regexp.MustCompile(`^(\d{1,2})(?::?(\d{2}))?(am|pm|a|p)?$`)
+	isoDateRe  = regexp.MustCompile(`^(\d{4})-(\d{1,2})-(\d{1,2})$`)
+	ordinalRe  = regexp.MustCompile(`^(\d{1,2})(st|nd|rd|th)?$`)
+	weekdayIdx = map[string]time.Weekday{
+		"sun": time.Sunday, "sunday": time.Sunday,
+		"mon": time.Monday, "monday": time.Monday,
+		"tue": time.Tuesday, "tues": time.Tuesday, "tuesday": time.Tuesday,
+		"wed": time.Wednesday, "wednesday": time.Wednesday,
+		"thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday, "thursday": time.Thursday,
+		"fri": time.Friday, "friday": time.Friday,
+		"sat": time.Saturday, "saturday": time.Saturday,
+	}
+)
+
+// ParseEventTime reads a start and optionally an end, "for" a length or
+// "to"/"until" a time. What isn't given is taken from base: a time alone
+// stays on base's day and a day alone keeps base's time. Words like
+// "tomorrow" and "in 2 hours" count from now. An end without a day that
+// would come before the start is on the next day.
+func ParseEventTime(s string, base, now time.Time) (EventTime, error) {
+	words := strings.Fields(strings.NewReplacer(",", " ", " - ", " to ").Replace(strings.ToLower(s)))
+	if len(words) == 0 {
+		return EventTime{}, fmt.Errorf("no date or time given")
+	}
+
+	startWords, endWords, length := words, []string(nil), ""
+split:
+	for i, w := range words {
+		switch w {
+		case "for":
+			startWords, length = words[:i], strings.Join(words[i+1:], " ")
+			break split
+		case "to", "until", "till":
+			startWords, endWords = words[:i], words[i+1:]
+			break split
+		}
+	}
+
+	et, err := parsePoint(startWords, base, now)
+	if err != nil {
+		return EventTime{}, err
+	}
+
+	switch {
+	case length != "":
+		d, err := ParseLength(length)
+		if err != nil {
+			return EventTime{}, err
+		}
+		et.End = et.Start.Add(d)
+	case endWords != nil:
+		end, err := parsePoint(endWords, et.Start, now)
+		if err != nil {
+			return EventTime{}, err
+		}
+		et.End = end.Start
+		if !end.HasDate && et.End.Before(et.Start) {
+			et.End = et.End.AddDate(0, 0, 1)
+		}
+	}
+	return et, nil
+}
+
+// parsePoint reads a day and/or time of day
+func parsePoint(words []string, base, now time.Time) (EventTime, error) {
+	et := EventTime{Start: base}
+	if len(words) == 0 {
+		return et, nil
+	}
+
+	year, month, day := base.Date()
+	hour, min := base.Hour(), base.Minute()
+	setDay := func(t time.Time) {
+		year, month, day = t.Date()
+		et.HasDate = true
+	}
+	setClock := func(h, m int) {
+		hour, min = h, m
+		et.HasTime = true
+	}
+
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		next := ""
+		if i+1 < len(words) {
+			next = words[i+1]
+		}
+
+		switch {
+		case w == "at" || w == "on" || w == "this":
+		case w == "now":
+			setDay(now)
+			setClock(now.Hour(), now.Minute())
+		case w == "today":
+			setDay(now)
+		case w == "tomorrow" || w == "tmrw" || w == "tmr":
+			setDay(now.AddDate(0, 0, 1))
+		case w == "yesterday":
+			setDay(now.AddDate(0, 0, -1))
+		case w == "noon":
+			setClock(12, 0)
+		case w == "midnight":
+			setClock(0, 0)
+		case w == "next" && isWeekday(next):
+			setDay(nextWeekday(now, weekdayIdx[next], true))
+			i++
+		case w == "next" && next == "week":
+			setDay(now.AddDate(0, 0, 7))
+			i++
+		case isWeekday(w):
+			setDay(nextWeekday(now, weekdayIdx[w], false))
+		case w == "in":
+			// in 3 days, in 2 hours, in 30 min
+			if i+1 >= len(words) {
+				return et, fmt.Errorf("in how long?")
+			}
+			n, unit, used := amount(words[i+1:])
+			if used == 0 {
+				return et, fmt.Errorf("can't understand %q", strings.Join(words[i:], " "))
+			}
+			i += used
+			switch unit {
+			case "day":
+				setDay(now.AddDate(0, 0, n))
+			case "week":
+				setDay(now.AddDate(0, 0, 7*n))
+			case "month":
+				setDay(now.AddDate(0, n, 0))
+			case "hour", "minute":
+				d := time.Duration(n) * time.Hour
+				if unit == "minute" {
+					d = time.Duration(n) * time.Minute
+				}
+				t := now.Add(d)
+				setDay(t)
+				setClock(t.Hour(), t.Minute())
+			default:
+				return et, fmt.Errorf("can't understand %q", strings.Join(words[i-used:i+1], " "))
+			}
+		case isoDateRe.MatchString(w):
+			m := isoDateRe.FindStringSubmatch(w)
+			y, _ := strconv.Atoi(m[1])
+			mo, _ := strconv.Atoi(m[2])
+			d, _ := strconv.Atoi(m[3])
+			t, err := date(y, time.Month(mo), d, base.Location())
+			if err != nil {
+				return et, err
+			}
+			setDay(t)
+		case monthOf(w) != 0:
+			// mar 4, march 4th, mar 4 2026
+			mo := monthOf(w)
+			d, ok := dayOfMonth(next)
+			if !ok {
+				return et, fmt.Errorf("which day of %s?", time.Month(mo))
+			}
+			i++
+			y, explicitYear := now.Year(), false
+			if i+1 < len(words) {
+				if n, ok := yearOf(words[i+1], now); ok {
+					y, explicitYear = n, true
+					i++
+				}
+			}
+			if !explicitYear && passed(now, mo, d) {
+				y++
+			}
+			t, err := date(y, mo, d, base.Location())
+			if err != nil {
+				return et, err
+			}
+			setDay(t)
+		case monthOf(next) != 0 && ordinalRe.MatchString(w):
+			// 4 march, 4th march
+			d, _ := dayOfMonth(w)
+			mo := monthOf(next)
+			i++
+			y := now.Year()
+			if passed(now, mo, d) {
+				y++
+			}
+			t, err := date(y, mo, d, base.Location())
+			if err != nil {
+				return et, err
+			}
+			setDay(t)
+		default:
+			h, m, ok := clock(w, next)
+			if !ok {
+				return et, fmt.Errorf("can't understand %q", w)
+			}
+			if next == "am" || next == "pm" {
+				i++
+			}
+			setClock(h, m)
+		}
+	}
+
+	et.Start = time.Date(year, month, day, hour, min, 0, 0, base.Location())
+	return et, nil
+}
+
+// clock reads a time of day: 3pm, 3:30pm, 3 pm, 15:00, 1530, 9. An hour
+// alone is a daytime hour, so 9 is 9am and 3 is 3pm; 03 stays 3am.
+func clock(w, next string) (int, int, bool) {
+	m := clockRe.FindStringSubmatch(w)
+	if m == nil {
+		return 0, 0, false
+	}
+	h, _ := strconv.Atoi(m[1])
+	min, _ := strconv.Atoi(m[2])
+	suffix := m[3]
+	if suffix == "" && (next == "am" || next == "pm") {
+		suffix = next
+	}
+	if min > 59 {
+		return 0, 0, false
+	}
+	switch suffix {
+	case "am", "a":
+		if h < 1 || h > 12 {
+			return 0, 0, false
+		}
+		if h == 12 {
+			h = 0
+		}
+	case "pm", "p":
+		if h < 1 || h > 12 {
+			return 0, 0, false
+		}
+		if h != 12 {
+			h += 12
+		}
+	default:
+		if h > 23 {
+			return 0, 0, false
+		}
+		if m[2] == "" && m[1][0] != '0' && h >= 1 && h <= 7 {
+			h += 12
+		}
+	}
+	return h, min, true
+}
+
+// yearOf reads the year in "mar 4 2027". Four digits that could also be a
+// time of day, as in "mar 4 1530", are only a year close to now's.
+func yearOf(w string, now time.Time) (int, bool) {
+	if len(w) != 4 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(w)
+	if err != nil {
+		return 0, false
+	}
+	if n >= now.Year()-1 && n <= now.Year()+10 {
+		return n, true
+	}
+	_, _, isClock := clock(w, "")
+	return n, !isClock
+}
+
+// ParseLength reads how long something lasts: 90m, 1h30m, 1.5h, 2 hours,
+// 45 min, an hour, half an hour, 2 days
+func ParseLength(s string) (time.Duration, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "half an hour", "half hour":
+		return 30 * time.Minute, nil
+	case "an hour", "a hour", "hour":
+		return time.Hour, nil
+	case "a day", "day", "all day":
+		return 24 * time.Hour, nil
+	}
+
+	replacer := strings.NewReplacer(
+		"hours", "h", "hour", "h", "hrs", "h", "hr", "h",
+		"minutes", "m", "minute", "m", "mins", "m", "min", "m",
+		"days", "d", "day", "d",
+		" and ", "", " ", "",
+	)
+	compact := replacer.Replace(s)
+	// time.ParseDuration has no days
+	var days float64
+	if before, after, ok := strings.Cut(compact, "d"); ok {
+		n, err := strconv.ParseFloat(before, 64)
+		if err != nil {
+			return 0, fmt.Errorf("can't understand length %q", s)
+		}
+		days, compact = n, after
+	}
+	var d time.Duration
+	if compact != "" {
+		var err error
+		d, err = time.ParseDuration(compact)
+		if err != nil {
+			// A bare number is minutes
+			n, nerr := strconv.Atoi(compact)
+			if nerr != nil {
+				return 0, fmt.Errorf("can't understand length %q", s)
+			}
+			d = time.Duration(n) * time.Minute
+		}
+	}
+	d += time.Duration(days * float64(24*time.Hour))
+	if d <= 0 {
+		return 0, fmt.Errorf("length must be more than zero")
+	}
+	return d, nil
+}
+
+// amount reads "3 days", "2 hours", "30 min", "a week" from the front of
+// words, returning the count, the singular unit and how many words it used
+func amount(words []string) (int, string, int) {
+	if len(words) < 2 {
+		return 0, "", 0
+	}
+	n, err := strconv.Atoi(words[0])
+	if words[0] == "a" || words[0] == "an" {
+		n, err = 1, nil
+	}
+	if err != nil {
+		return 0, "", 0
+	}
+	unit := strings.TrimSuffix(words[1], "s")
+	switch unit {
+	case "min", "minute", "m":
+		unit = "minute"
+	case "hr", "hour", "h":
+		unit = "hour"
+	case "day", "d":
+		unit = "day"
+	case "week", "wk", "w":
+		unit = "week"
+	case "month":
+	default:
+		return 0, "", 0
+	}
+	return n, unit, 2
+}
+
+func isWeekday(w string) bool {
+	_, ok := weekdayIdx[w]
+	return ok
+}
+
+// nextWeekday returns the next day falling on wd, today included unless
+// strictlyAfter
+func nextWeekday(now time.Time, wd time.Weekday, strictlyAfter bool) time.Time {
+	days := (int(wd) - int(now.Weekday()) + 7) % 7
+	if days == 0 && strictlyAfter {
+		days = 7
+	}
+	return now.AddDate(0, 0, days)
+}
+
+func monthOf(w string) time.Month {
+	if len(w) < 3 {
+		return 0
+	}
+	for m := time.January; m <= time.December; m++ {
+		name := strings.ToLower(m.String())
+		if strings.HasPrefix(name, w) {
+			return m
+		}
+	}
+	return 0
+}
+
+func dayOfMonth(w string) (int, bool) {
+	m := ordinalRe.FindStringSubmatch(w)
+	if m == nil {
+		return 0, false
+	}
+	d, _ := strconv.Atoi(m[1])
+	return d, d >= 1 && d <= 31
+}
+
+// date returns the start of a day, refusing one the month doesn't have
+// rather than letting time.Date turn "feb 31" into March 3
+func date(y int, mo time.Month, d int, loc *time.Location) (time.Time, error) {
+	if mo < time.January || mo > time.December {
+		return time.Time{}, fmt.Errorf("there is no month %d", int(mo))
+	}
+	t := time.Date(y, mo, d, 0, 0, 0, 0, loc)
+	if t.Month() != mo || d < 1 {
+		return time.Time{}, fmt.Errorf("%s %d has no day %d", mo, y, d)
+	}
+	return t, nil
+}
+
+// passed reports whether a day of the month is already behind now this
+// year, so a date without a year means next year's
+func passed(now time.Time, mo time.Month, d int) bool {
+	return mo < now.Month() || mo == now.Month() && d < now.Day()
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseEventTime(t *testing.T) {
+	loc := time.UTC
+	// Wednesday
+	now := time.Date(2026, time.March, 4, 10, 17, 0, 0, loc)
+	base := time.Date(2026, time.March, 4, 9, 0, 0, 0, loc)
+	at := func(mo time.Month, d, h, m int) time.Time {
+		return time.Date(2026, mo, d, h, m, 0, 0, loc)
+	}
+
+	tests := []struct {
+		in        string
+		start     time.Time
+		end       time.Time
+		date, clk bool
+	}{
+		{in: "3pm", start: at(time.March, 4, 15, 0), clk: true},
+		{in: "3 pm", start: at(time.March, 4, 15, 0), clk: true},
+		{in: "3:30pm", start: at(time.March, 4, 15, 30), clk: true},
+		{in: "12am", start: at(time.March, 4, 0, 0), clk: true},
+		{in: "12pm", start: at(time.March, 4, 12, 0), clk: true},
+		{in: "15:00", start: at(time.March, 4, 15, 0), clk: true},
+		{in: "1530", start: at(time.March, 4, 15, 30), clk: true},
+		{in: "noon", start: at(time.March, 4, 12, 0), clk: true},
+		{in: "midnight", start: at(time.March, 4, 0, 0), clk: true},
+
+		// A lone hour is a daytime hour
+		{in: "3", start: at(time.March, 4, 15, 0), clk: true},
+		{in: "7", start: at(time.March, 4, 19, 0), clk: true},
+		{in: "9", start: at(time.March, 4, 9, 0), clk: true},
+		{in: "12", start: at(time.March, 4, 12, 0), clk: true},
+		{in: "03", start: at(time.March, 4, 3, 0), clk: true},
+		{in: "3:00", start: at(time.March, 4, 3, 0), clk: true},
+
+		{in: "today", start: at(time.March, 4, 9, 0), date: true},
+		{in: "tomorrow 3pm", start: at(time.March, 5, 15, 0), date: true, clk: true},
+		{in: "fri", start: at(time.March, 6, 9, 0), date: true},
+		{in: "wed", start: at(time.March, 4, 9, 0), date: true},
+		{in: "next wed", start: at(time.March, 11, 9, 0), date: true},
+		{in: "next week", start: at(time.March, 11, 9, 0), date: true},
+		{in: "in 2 hours", start: at(time.March, 4, 12, 17), date: true, clk: true},
+		{in: "in 3 days", start: at(time.March, 7, 9, 0), date: true},
+		{in: "2026-05-01 14:00", start: at(time.May, 1, 14, 0), date: true, clk: true},
+
+		{in: "mar 10", start: at(time.March, 10, 9, 0), date: true},
+		{in: "march 10th 2pm", start: at(time.March, 10, 14, 0), date: true, clk: true},
+		{in: "10 march", start: at(time.March, 10, 9, 0), date: true},
+		{in: "mar 1", start: time.Date(2027, time.March, 1, 9, 0, 0, 0, loc), date: true},
+		{in: "mar 10 2027", start: time.Date(2027, time.March, 10, 9, 0, 0, 0, loc), date: true},
+		{in: "mar 10 1530", start: at(time.March, 10, 15, 30), date: true, clk: true},
+		{in: "mar 10 2027 1530", start: time.Date(2027, time.March, 10, 15, 30, 0, 0, loc), date: true, clk: true},
+		{in: "mar 10 2400", start: time.Date(2400, time.March, 10, 9, 0, 0, 0, loc), date: true},
+
+		{in: "3pm for 90m", start: at(time.March, 4, 15, 0), end: at(time.March, 4, 16, 30), clk: true},
+		{in: "fri 9:30 to 11", start: at(time.March, 6, 9, 30), end: at(time.March, 6, 11, 0), date: true, clk: true},
+		{in: "9 to 5", start: at(time.March, 4, 9, 0), end: at(time.March, 4, 17, 0), clk: true},
+		{in: "11pm to 1am", start: at(time.March, 4, 23, 0), end: at(time.March, 5, 1, 0), clk: true},
+		{in: "2pm - 3pm", start: at(time.March, 4, 14, 0), end: at(time.March, 4, 15, 0), clk: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseEventTime(tt.in, base, now)
+		if err != nil {
+			t.Errorf("ParseEventTime(%q): %v", tt.in, err)
+			continue
+		}
+		if !got.Start.Equal(tt.start) {
+			t.Errorf("ParseEventTime(%q).Start = %v, want %v", tt.in, got.Start, tt.start)
+		}
+		if !got.End.Equal(tt.end) {
+			t.Errorf("ParseEventTime(%q).End = %v, want %v", tt.in, got.End, tt.end)
+		}
+		if got.HasDate != tt.date || got.HasTime != tt.clk {
+			t.Errorf("ParseEventTime(%q) HasDate, HasTime = %v, %v; want %v, %v", tt.in, got.HasDate, got.HasTime, tt.date, tt.clk)
+		}
+	}
+}
+
+func TestParseEventTimeErrors(t *testing.T) {
+	now := time.Date(2026, time.March, 4, 10, 0, 0, 0, time.UTC)
+	for _, in := range []string{"", "feb 30", "2026-13-01", "25:00", "13pm", "3:75", "mar", "in", "in 3 fortnights", "whenever"} {
+		if got, err := ParseEventTime(in, now, now); err == nil {
+			t.Errorf("ParseEventTime(%q) = %v, want an error", in, got.Start)
+		}
+	}
+}
+
+func TestParseLength(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"90m", 90 * time.Minute},
+		{"1h30m", 90 * time.Minute},
+		{"1.5h", 90 * time.Minute},
+		{"2 hours", 2 * time.Hour},
+		{"1 hour and 15 minutes", 75 * time.Minute},
+		{"45 min", 45 * time.Minute},
+		{"45", 45 * time.Minute},
+		{"an hour", time.Hour},
+		{"half an hour", 30 * time.Minute},
+		{"2 days", 48 * time.Hour},
+		{"1d2h", 26 * time.Hour},
+		{"all day", 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		got, err := ParseLength(tt.in)
+		if err != nil {
+			t.Errorf("ParseLength(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLength(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "0m", "soon", "xd"} {
+		if _, err := ParseLength(in); err == nil {
+			t.Errorf("ParseLength(%q) succeeded, want an error", in)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Package remind watches upcoming calendar events and fires their alarms as
+// desktop notifications. fm-cli remind runs it.
+package remind
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/model"
+)
+
+const (
+	checkInterval   = 30 * time.Second
+	refreshInterval = 5 * time.Minute
+	// Events are fetched this far ahead, so alarms up to a week before an
+	// event are seen in time
+	lookahead = 8 * 24 * time.Hour
+)
+
+// Notifier shows a reminder
+type Notifier func(title, body string) error
+
+// DesktopNotifier shows reminders with notify-send, or rings the terminal
+// bell and writes them to out where that isn't available
+func DesktopNotifier(out io.Writer) Notifier {
+	bell := func(title, body string) error {
+		_, err := fmt.Fprintf(out, "\a%s  %s\n", title, body)
+		return err
+	}
+	path, err := exec.LookPath("notify-send")
+	if err != nil {
+		return bell
+	}
+	return func(title, body string) error {
+		if err := exec.Command(path, "-a", "fm-cli", title, body).Run(); err != nil {
+			return bell(title, body)
+		}
+		return nil
+	}
+}
+
+// Reminder fires each alarm of the user's calendars once
+type Reminder struct {
+	dav       *api.DAVClient
+	notify    Notifier
+	log       io.Writer
+	calendars []string
+	events    []model.CalendarEvent
+	fetched   time.Time
+	last      time.Time            // Alarms up to here have been handled
+	fired     map[string]time.Time // Alarms already shown, by when they went off
+}
+
+// New creates a Reminder; problems talking to the server are written to log
+func New(dav *api.DAVClient, notify Notifier, log io.Writer) *Reminder {
+	return &Reminder{
+		dav:    dav,
+		notify: notify,
+		log:    log,
+		// Alarms that went off just before starting still count
+		last:  time.Now().Add(-time.Minute),
+		fired: make(map[string]time.Time),
+	}
+}
+
+// Run checks for due alarms until ctx is done. Events are refetched every
+// few minutes to pick up changes made elsewhere; while the server can't be
+// reached the last known events are used.
+func (r *Reminder) Run(ctx context.Context) error {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		r.check(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reminder) check(ctx context.Context, now time.Time) {
+	if now.Sub(r.fetched) >= refreshInterval {
+		if err := r.refresh(ctx, now); err != nil {
+			fmt.Fprintf(r.log, "remind: %v\n", err)
+		}
+	}
+
+	for _, e := range r.events {
+		if e.Status == "cancelled" {
+			continue
+		}
+		for _, alert := range e.Alerts {
+			// Email alarms are the server's to send
+			if alert.Action == "email" {
+				continue
+			}
+			at, err := api.AlertTime(e, alert)
+			if err != nil || at.After(now) || !at.After(r.last) {
+				continue
+			}
+			key := fmt.Sprintf("%s|%d|%d", e.ID, e.Start.Unix(), at.Unix())
+			if _, ok := r.fired[key]; ok {
+				continue
+			}
+			r.fired[key] = at
+			if err := r.notify(e.Title, describe(e, now)); err != nil {
+				fmt.Fprintf(r.log, "remind: %v\n", err)
+			}
+		}
+	}
+	r.last = now
+
+	for key, at := range r.fired {
+		if now.Sub(at) > 24*time.Hour {
+			delete(r.fired, key)
+		}
+	}
+}
+
+func (r *Reminder) refresh(ctx context.Context, now time.Time) error {
+	if r.calendars == nil {
+		calendars, err := r.dav.FetchCalendars(ctx)
+		if err != nil {
+			return err
+		}
+		for _, cal := range calendars {
+			if cal.IsVisible && cal.MayReadItems {
+				r.calendars = append(r.calendars, cal.ID)
+			}
+		}
+	}
+	// Events that started a little while ago can still have alarms due,
+	// such as ones relative to their end
+	events, err := r.dav.FetchEvents(ctx, r.calendars, now.Add(-24*time.Hour), now.Add(lookahead))
+	if err != nil {
+		return err
+	}
+	r.events = events
+	r.fetched = now
+	return nil
+}
+
+// describe says when and where an event is, e.g. "15:00-15:30 @ Room 1 (in 15 min)"
+func describe(e model.CalendarEvent, now time.Time) string {
+	var s string
+	if e.IsAllDay {
+		s = "All day " + e.Start.Format("Mon Jan 2")
+	} else {
+		s = e.Start.Format("15:04")
+		if !e.End.IsZero() {
+			s += "-" + e.End.Format("15:04")
+		}
+		if e.Start.YearDay() != now.YearDay() || e.Start.Year() != now.Year() {
+			s = e.Start.Format("Mon Jan 2 ") + s
+		}
+	}
+	if e.Location != "" {
+		s += " @ " + e.Location
+	}
+	if until := e.Start.Sub(now).Round(time.Minute); until >= time.Hour {
+		s += fmt.Sprintf(" (in %s)", strings.TrimSuffix(until.String(), "0s"))
+	} else if until > 0 {
+		s += fmt.Sprintf(" (in %d min)", int(until.Minutes()))
+	}
+	return s
+}
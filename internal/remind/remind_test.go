@@ -0,0 +1,188 @@
+package remind
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"fm-cli/internal/model"
+)
+
+var nine = time.Date(2026, time.March, 2, 9, 0, 0, 0, time.UTC)
+
+// newTestReminder starts a Reminder at start with events already fetched,
+// so check doesn't go to the server. The shown reminders are added to shown.
+func newTestReminder(start time.Time, shown *[]string, events ...model.CalendarEvent) *Reminder {
+	return &Reminder{
+		notify: func(title, body string) error {
+			*shown = append(*shown, title+": "+body)
+			return nil
+		},
+		log:     &bytes.Buffer{},
+		events:  events,
+		fetched: start,
+		last:    start.Add(-time.Minute),
+		fired:   make(map[string]time.Time),
+	}
+}
+
+func display(trigger string) model.EventAlert {
+	return model.EventAlert{Trigger: trigger, Action: "display"}
+}
+
+func TestCheckFiresDueAlarmsOnce(t *testing.T) {
+	standup := model.CalendarEvent{
+		ID:       "standup",
+		Title:    "Standup",
+		Location: "Room 1",
+		Start:    nine.Add(15 * time.Minute),
+		End:      nine.Add(45 * time.Minute),
+		// The hour before went off before the reminder started
+		Alerts: []model.EventAlert{display("-PT15M"), display("-PT1H")},
+	}
+	var shown []string
+	r := newTestReminder(nine, &shown, standup)
+
+	r.check(context.Background(), nine.Add(-30*time.Second))
+	if len(shown) != 0 {
+		t.Fatalf("fired before the alarm was due: %q", shown)
+	}
+	r.check(context.Background(), nine.Add(10*time.Second))
+	r.check(context.Background(), nine.Add(40*time.Second))
+	want := []string{"Standup: 09:15-09:45 @ Room 1 (in 15 min)"}
+	if fmt.Sprint(shown) != fmt.Sprint(want) {
+		t.Errorf("shown %q, want %q", shown, want)
+	}
+}
+
+func TestCheckCatchesUpAfterAGap(t *testing.T) {
+	// A suspended laptop misses the check at the alarm itself
+	review := model.CalendarEvent{
+		ID:     "review",
+		Title:  "Review",
+		Start:  nine.Add(time.Hour),
+		End:    nine.Add(2 * time.Hour),
+		Alerts: []model.EventAlert{display("-PT30M")},
+	}
+	var shown []string
+	r := newTestReminder(nine, &shown, review)
+	r.check(context.Background(), nine)
+	r.fetched = nine.Add(40 * time.Minute)
+	r.check(context.Background(), nine.Add(40*time.Minute))
+	if len(shown) != 1 {
+		t.Errorf("shown %q, want the alarm at 9:30 once", shown)
+	}
+}
+
+func TestCheckSkipsCancelledAndEmailAlarms(t *testing.T) {
+	cancelled := model.CalendarEvent{
+		ID:     "cancelled",
+		Title:  "Cancelled",
+		Status: "cancelled",
+		Start:  nine.Add(10 * time.Minute),
+		Alerts: []model.EventAlert{display("-PT10M")},
+	}
+	emailed := model.CalendarEvent{
+		ID:     "emailed",
+		Title:  "Emailed",
+		Start:  nine.Add(10 * time.Minute),
+		Alerts: []model.EventAlert{{Trigger: "-PT10M", Action: "email"}},
+	}
+	var shown []string
+	r := newTestReminder(nine, &shown, cancelled, emailed)
+	r.check(context.Background(), nine.Add(time.Second))
+	if len(shown) != 0 {
+		t.Errorf("shown %q, want nothing", shown)
+	}
+}
+
+func TestCheckFiresEachOccurrence(t *testing.T) {
+	// Occurrences of a recurring event share its ID
+	first := model.CalendarEvent{
+		ID:     "daily",
+		Title:  "Daily",
+		Start:  nine.Add(5 * time.Minute),
+		Alerts: []model.EventAlert{display("-PT5M")},
+	}
+	second := first
+	second.Start = first.Start.Add(24 * time.Hour)
+
+	var shown []string
+	r := newTestReminder(nine, &shown, first, second)
+	r.check(context.Background(), nine.Add(time.Second))
+	next := nine.Add(24 * time.Hour)
+	r.fetched = next
+	r.check(context.Background(), next.Add(time.Second))
+	if len(shown) != 2 {
+		t.Errorf("shown %q, want one reminder per occurrence", shown)
+	}
+}
+
+func TestCheckAbsoluteAndEndAlarms(t *testing.T) {
+	e := model.CalendarEvent{
+		ID:    "talk",
+		Title: "Talk",
+		Start: nine.Add(-time.Hour),
+		End:   nine.Add(5 * time.Minute),
+		Alerts: []model.EventAlert{
+			{Trigger: "-PT5M", Action: "display", FromEnd: true},
+			{At: nine.Add(2 * time.Minute), Action: "display"},
+		},
+	}
+	var shown []string
+	r := newTestReminder(nine, &shown, e)
+	r.check(context.Background(), nine.Add(time.Second))
+	if len(shown) != 1 {
+		t.Fatalf("shown %q, want the alarm before the end", shown)
+	}
+	r.check(context.Background(), nine.Add(2*time.Minute))
+	if len(shown) != 2 {
+		t.Errorf("shown %q, want the absolute alarm too", shown)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		name  string
+		event model.CalendarEvent
+		want  string
+	}{
+		{
+			name:  "soon",
+			event: model.CalendarEvent{Start: nine.Add(15 * time.Minute), End: nine.Add(45 * time.Minute), Location: "Room 1"},
+			want:  "09:15-09:45 @ Room 1 (in 15 min)",
+		},
+		{
+			name:  "hours away",
+			event: model.CalendarEvent{Start: nine.Add(90 * time.Minute), End: nine.Add(2 * time.Hour)},
+			want:  "10:30-11:00 (in 1h30m)",
+		},
+		{
+			name:  "no end",
+			event: model.CalendarEvent{Start: nine.Add(10 * time.Minute)},
+			want:  "09:10 (in 10 min)",
+		},
+		{
+			name:  "another day",
+			event: model.CalendarEvent{Start: nine.Add(24 * time.Hour), End: nine.Add(25 * time.Hour)},
+			want:  "Tue Mar 3 09:00-10:00 (in 24h0m)",
+		},
+		{
+			name:  "all day",
+			event: model.CalendarEvent{Start: nine.Add(15 * time.Hour), IsAllDay: true},
+			want:  "All day Tue Mar 3 (in 15h0m)",
+		},
+		{
+			name:  "already started",
+			event: model.CalendarEvent{Start: nine.Add(-time.Hour), End: nine.Add(5 * time.Minute)},
+			want:  "08:00-09:05",
+		},
+	}
+	for _, tt := range tests {
+		if got := describe(tt.event, nine); got != tt.want {
+			t.Errorf("%s: describe = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package storage
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"fm-cli/internal/model"
@@ -15,7 +17,8 @@ import (
 
 // DB wraps the SQLite database for local email storage
 type DB struct {
-	db *sql.DB
+	db  *sql.DB
+	fts bool // FTS5 search index is available
 }
 
 // PendingAction represents an action to sync when online
@@ -25,22 +28,52 @@ type PendingAction struct {
 	EmailID   string
 	Data      string // JSON encoded action data
 	CreatedAt time.Time
+	Status    string // One of the PendingStatus values
+	Attempts  int
+	LastError string
 }
 
-// Open opens or creates the local database
-func Open() (*DB, error) {
+// Pending action states. Only queued actions are replayed; failed,
+// conflicting and unconfirmed ones wait for the user to retry or discard
+// them. Unconfirmed sends lost the connection mid-request, so the server
+// may have accepted them already.
+const (
+	PendingStatusQueued      = "pending"
+	PendingStatusFailed      = "failed"
+	PendingStatusConflict    = "conflict"
+	PendingStatusUnconfirmed = "unconfirmed"
+)
+
+// ConfigDir returns ~/.config/fm-cli, creating it if needed
+func ConfigDir() (string, error) {
 	// Get user config directory
 	configDir, err := os.UserConfigDir()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get config dir: %w", err)
+		return "", fmt.Errorf("failed to get config dir: %w", err)
 	}
 
-	dbDir := filepath.Join(configDir, "fm-cli")
-	if err := os.MkdirAll(dbDir, 0700); err != nil {
-		return nil, fmt.Errorf("failed to create db dir: %w", err)
+	dir := filepath.Join(configDir, "fm-cli")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create config dir: %w", err)
+	}
+	return dir, nil
+}
+
+// Open opens or creates the local database
+func Open() (*DB, error) {
+	return OpenAccount("")
+}
+
+// OpenAccount opens or creates the local database of a named account, so
+// caches and pending changes of different accounts never mix. The empty
+// name is the original single-account emails.db.
+func OpenAccount(account string) (*DB, error) {
+	dbDir, err := ConfigDir()
+	if err != nil {
+		return nil, err
 	}
 
-	dbPath := filepath.Join(dbDir, "emails.db")
+	dbPath := filepath.Join(dbDir, dbFileName(account))
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -55,6 +88,26 @@ func Open() (*DB, error) {
 	return storage, nil
 }
 
+// dbFileName maps an account name to a safe file name. The name is
+// readable but lossy, so a hash of the exact account name keeps "work.a",
+// "work_a" and, on case-insensitive file systems, "Work" apart.
+func dbFileName(account string) string {
+	if account == "" {
+		return "emails.db"
+	}
+	sum := sha256.Sum256([]byte(account))
+	return fmt.Sprintf("emails-%s-%x.db", safeName(account), sum[:4])
+}
+
+func safeName(account string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, account)
+}
+
 // Close closes the database
 func (d *DB) Close() error {
 	return d.db.Close()
@@ -126,12 +179,84 @@ func (d *DB) migrate() error {
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 
+	CREATE TABLE IF NOT EXISTS attachments (
+		email_id TEXT,
+		blob_id TEXT,
+		name TEXT,
+		type TEXT,
+		size INTEGER,
+		is_inline BOOLEAN DEFAULT 0,
+		position INTEGER,
+		PRIMARY KEY (email_id, blob_id),
+		FOREIGN KEY (email_id) REFERENCES emails(id) ON DELETE CASCADE
+	);
+
+	CREATE TABLE IF NOT EXISTS blobs (
+		blob_id TEXT PRIMARY KEY,
+		data BLOB,
+		size INTEGER,
+		cached_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
 	CREATE INDEX IF NOT EXISTS idx_emails_thread ON emails(thread_id);
 	CREATE INDEX IF NOT EXISTS idx_emails_date ON emails(date);
 	CREATE INDEX IF NOT EXISTS idx_email_mailboxes_mailbox ON email_mailboxes(mailbox_id);
 	`
 
-	_, err := d.db.Exec(schema)
+	if _, err := d.db.Exec(schema); err != nil {
+		return err
+	}
+
+	// Columns added after the initial schema
+	if err := d.addColumn("emails", "has_attachment", "BOOLEAN DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := d.addColumn("local_drafts", "attachments", "TEXT DEFAULT '[]'"); err != nil {
+		return err
+	}
+	if err := d.addColumn("pending_actions", "status", "TEXT DEFAULT 'pending'"); err != nil {
+		return err
+	}
+	if err := d.addColumn("pending_actions", "attempts", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := d.addColumn("pending_actions", "last_error", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := d.addColumn("local_drafts", "cc_addr", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := d.addColumn("local_drafts", "bcc_addr", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+
+	return d.migrateFTS()
+}
+
+// addColumn adds a column to an existing table if it isn't there yet
+func (d *DB) addColumn(table, column, definition string) error {
+	rows, err := d.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	return err
 }
 
@@ -154,6 +279,29 @@ func (d *DB) SetConfig(key, value string) error {
 	return err
 }
 
+// syncStateKey builds the config key holding a JMAP state string
+func syncStateKey(accountID, kind string) string {
+	return "state:" + accountID + ":" + kind
+}
+
+// GetSyncState retrieves the last JMAP state string seen for an account and
+// data type (e.g. "Mailbox", "Email"). Returns "" if never synced.
+func (d *DB) GetSyncState(accountID, kind string) (string, error) {
+	return d.GetConfig(syncStateKey(accountID, kind))
+}
+
+// SetSyncState records the JMAP state string for an account and data type
+func (d *DB) SetSyncState(accountID, kind, state string) error {
+	return d.SetConfig(syncStateKey(accountID, kind), state)
+}
+
+// ClearSyncState forgets the JMAP state for an account and data type,
+// forcing the next sync to start from scratch
+func (d *DB) ClearSyncState(accountID, kind string) error {
+	_, err := d.db.Exec("DELETE FROM config WHERE key = ?", syncStateKey(accountID, kind))
+	return err
+}
+
 // SaveMailboxes saves mailboxes to local storage
 func (d *DB) SaveMailboxes(mailboxes []model.Mailbox) error {
 	tx, err := d.db.Begin()
@@ -218,17 +366,42 @@ func (d *DB) SaveEmails(emails []model.Email) error {
 	}
 	defer tx.Rollback()
 
+	// Upsert rather than REPLACE so list refreshes (which carry no body)
+	// don't wipe bodies already cached for offline reading.
 	stmt, err := tx.Prepare(`
-		INSERT OR REPLACE INTO emails 
+		INSERT INTO emails 
 		(id, thread_id, subject, from_addr, to_addr, cc_addr, bcc_addr, reply_to, 
-		 preview, body_text, date, is_unread, is_flagged, is_draft, mailbox_ids, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		 preview, body_text, date, is_unread, is_flagged, is_draft, mailbox_ids, has_attachment, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(id) DO UPDATE SET
+			thread_id = excluded.thread_id,
+			subject = excluded.subject,
+			from_addr = excluded.from_addr,
+			to_addr = excluded.to_addr,
+			cc_addr = excluded.cc_addr,
+			bcc_addr = excluded.bcc_addr,
+			reply_to = excluded.reply_to,
+			preview = excluded.preview,
+			body_text = CASE WHEN excluded.body_text != '' THEN excluded.body_text ELSE emails.body_text END,
+			date = excluded.date,
+			is_unread = excluded.is_unread,
+			is_flagged = excluded.is_flagged,
+			is_draft = excluded.is_draft,
+			mailbox_ids = excluded.mailbox_ids,
+			has_attachment = excluded.has_attachment,
+			updated_at = CURRENT_TIMESTAMP
 	`)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
+	clearStmt, err := tx.Prepare("DELETE FROM email_mailboxes WHERE email_id = ?")
+	if err != nil {
+		return err
+	}
+	defer clearStmt.Close()
+
 	mbStmt, err := tx.Prepare(`
 		INSERT OR REPLACE INTO email_mailboxes (email_id, mailbox_id)
 		VALUES (?, ?)
@@ -243,12 +416,16 @@ func (d *DB) SaveEmails(emails []model.Email) error {
 		_, err := stmt.Exec(
 			e.ID, e.ThreadID, e.Subject, e.From, e.To, e.Cc, e.Bcc, e.ReplyTo,
 			e.Preview, e.Body, e.Date, e.IsUnread, e.IsFlagged, e.IsDraft, string(mailboxIDs),
+			e.HasAttachment,
 		)
 		if err != nil {
 			return err
 		}
 
-		// Update email_mailboxes junction table
+		// Update email_mailboxes junction table, dropping stale memberships
+		if _, err := clearStmt.Exec(e.ID); err != nil {
+			return err
+		}
 		for _, mbID := range e.MailboxIDs {
 			_, err := mbStmt.Exec(e.ID, mbID)
 			if err != nil {
@@ -260,12 +437,109 @@ func (d *DB) SaveEmails(emails []model.Email) error {
 	return tx.Commit()
 }
 
+// DeleteMailboxes removes mailboxes and their email memberships from local storage
+func (d *DB) DeleteMailboxes(ids []string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		if _, err := tx.Exec("DELETE FROM email_mailboxes WHERE mailbox_id = ?", id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM mailboxes WHERE id = ?", id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteEmailsOnlyIn removes the emails whose only mailbox is mailboxID,
+// as the server does when the mailbox is destroyed with its emails
+func (d *DB) DeleteEmailsOnlyIn(mailboxID string) error {
+	rows, err := d.db.Query(`
+		SELECT email_id FROM email_mailboxes
+		GROUP BY email_id
+		HAVING COUNT(*) = 1 AND MAX(mailbox_id) = ?
+	`, mailboxID)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return d.DeleteEmails(ids)
+}
+
+// EmailIDs returns the IDs of every cached email, newest first
+func (d *DB) EmailIDs() ([]string, error) {
+	rows, err := d.db.Query("SELECT id FROM emails ORDER BY date DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DeleteEmails removes several emails from local storage
+func (d *DB) DeleteEmails(ids []string) error {
+	for _, id := range ids {
+		if err := d.DeleteEmail(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MissingEmailIDs returns the IDs from the list that are not cached locally
+func (d *DB) MissingEmailIDs(ids []string) ([]string, error) {
+	var missing []string
+	for _, id := range ids {
+		var exists int
+		err := d.db.QueryRow("SELECT 1 FROM emails WHERE id = ?", id).Scan(&exists)
+		if err == sql.ErrNoRows {
+			missing = append(missing, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// RemoveEmailFromMailbox drops a single mailbox membership locally
+func (d *DB) RemoveEmailFromMailbox(emailID, mailboxID string) error {
+	_, err := d.db.Exec("DELETE FROM email_mailboxes WHERE email_id = ? AND mailbox_id = ?", emailID, mailboxID)
+	return err
+}
+
 // GetEmails retrieves emails for a mailbox from local storage
 func (d *DB) GetEmails(mailboxID string, offset, limit int) ([]model.Email, error) {
 	rows, err := d.db.Query(`
-		SELECT e.id, e.thread_id, e.subject, e.from_addr, e.to_addr, e.cc_addr, 
-		       e.bcc_addr, e.reply_to, e.preview, e.date, e.is_unread, e.is_flagged, 
-		       e.is_draft, e.mailbox_ids
+		SELECT `+emailColumns+`
 		FROM emails e
 		JOIN email_mailboxes em ON e.id = em.email_id
 		WHERE em.mailbox_id = ?
@@ -277,6 +551,66 @@ func (d *DB) GetEmails(mailboxID string, offset, limit int) ([]model.Email, erro
 	}
 	defer rows.Close()
 
+	return scanEmails(rows)
+}
+
+// GetThreadedEmails retrieves the newest email of each thread in a mailbox
+func (d *DB) GetThreadedEmails(mailboxID string, offset, limit int) ([]model.Email, error) {
+	rows, err := d.db.Query(`
+		SELECT `+emailColumns+`
+		FROM (
+			SELECT e.*, ROW_NUMBER() OVER (
+				PARTITION BY COALESCE(NULLIF(e.thread_id, ''), e.id)
+				ORDER BY e.date DESC
+			) AS thread_rank
+			FROM emails e
+			JOIN email_mailboxes em ON e.id = em.email_id
+			WHERE em.mailbox_id = ?
+		) e
+		WHERE e.thread_rank = 1
+		ORDER BY e.date DESC
+		LIMIT ? OFFSET ?
+	`, mailboxID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanEmails(rows)
+}
+
+// GetThreads retrieves the cached messages of the given threads, oldest first
+func (d *DB) GetThreads(threadIDs []string) ([]model.Thread, error) {
+	var threads []model.Thread
+	for _, id := range threadIDs {
+		rows, err := d.db.Query(`
+			SELECT `+emailColumns+`
+			FROM emails e
+			WHERE e.thread_id = ?
+			ORDER BY e.date ASC
+		`, id)
+		if err != nil {
+			return nil, err
+		}
+		emails, err := scanEmails(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(emails) > 0 {
+			threads = append(threads, model.Thread{ID: id, Emails: emails})
+		}
+	}
+	return threads, nil
+}
+
+// emailColumns are the list columns read by scanEmails, aliased to "e"
+const emailColumns = `e.id, e.thread_id, e.subject, e.from_addr, e.to_addr, e.cc_addr, 
+		       e.bcc_addr, e.reply_to, e.preview, e.date, e.is_unread, e.is_flagged, 
+		       e.is_draft, e.mailbox_ids, e.has_attachment`
+
+// scanEmails reads rows selected with emailColumns
+func scanEmails(rows *sql.Rows) ([]model.Email, error) {
 	var emails []model.Email
 	for rows.Next() {
 		var e model.Email
@@ -284,7 +618,7 @@ func (d *DB) GetEmails(mailboxID string, offset, limit int) ([]model.Email, erro
 		err := rows.Scan(
 			&e.ID, &e.ThreadID, &e.Subject, &e.From, &e.To, &e.Cc,
 			&e.Bcc, &e.ReplyTo, &e.Preview, &e.Date, &e.IsUnread, &e.IsFlagged,
-			&e.IsDraft, &mailboxIDsJSON,
+			&e.IsDraft, &mailboxIDsJSON, &e.HasAttachment,
 		)
 		if err != nil {
 			return nil, err
@@ -296,6 +630,89 @@ func (d *DB) GetEmails(mailboxID string, offset, limit int) ([]model.Email, erro
 	return emails, rows.Err()
 }
 
+// QueryEmails searches the cached emails, newest first. It is the offline
+// counterpart of the server-side search.
+func (d *DB) QueryEmails(q model.SearchQuery, offset, limit int) ([]model.Email, error) {
+	var where []string
+	var args []interface{}
+
+	if d.fts && q.Text != "" {
+		where = append(where, "e.rowid IN (SELECT rowid FROM emails_fts WHERE emails_fts MATCH ?)")
+		args = append(args, ftsMatch(q.Text))
+	} else {
+		for _, word := range strings.Fields(q.Text) {
+			where = append(where, `(e.subject LIKE ? ESCAPE '\' OR e.from_addr LIKE ? ESCAPE '\' OR e.to_addr LIKE ? ESCAPE '\' OR e.preview LIKE ? ESCAPE '\' OR e.body_text LIKE ? ESCAPE '\')`)
+			w := likePattern(word)
+			args = append(args, w, w, w, w, w)
+		}
+	}
+	filters, filterArgs := searchFilters(q)
+	where = append(where, filters...)
+	args = append(args, filterArgs...)
+
+	query := "SELECT " + emailColumns + " FROM emails e"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY e.date DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanEmails(rows)
+}
+
+// searchFilters returns the WHERE clauses for a search's operators, all but
+// its free text
+func searchFilters(q model.SearchQuery) ([]string, []interface{}) {
+	var where []string
+	var args []interface{}
+
+	if q.From != "" {
+		where = append(where, `e.from_addr LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(q.From))
+	}
+	// Only To, as JMAP's to filter does; Cc isn't matched
+	if q.To != "" {
+		where = append(where, `e.to_addr LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(q.To))
+	}
+	if q.Subject != "" {
+		where = append(where, `e.subject LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(q.Subject))
+	}
+	if q.HasAttachment {
+		where = append(where, "e.has_attachment = 1")
+	}
+	if q.IsUnread {
+		where = append(where, "e.is_unread = 1")
+	}
+	if q.IsFlagged {
+		where = append(where, "e.is_flagged = 1")
+	}
+	// Dates are stored in UTC as "2006-01-02 15:04" so they compare as
+	// strings; the bounds are local midnights and need converting
+	if q.Before != nil {
+		where = append(where, "e.date < ?")
+		args = append(args, q.Before.UTC().Format("2006-01-02 15:04"))
+	}
+	if q.After != nil {
+		where = append(where, "e.date >= ?")
+		args = append(args, q.After.UTC().Format("2006-01-02 15:04"))
+	}
+	return where, args
+}
+
+// likePattern matches s anywhere, escaping LIKE's wildcards
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+	return "%" + s + "%"
+}
+
 // GetEmailBody retrieves the body of an email, falling back to preview if body not available
 func (d *DB) GetEmailBody(emailID string) (string, error) {
 	var body sql.NullString
@@ -339,6 +756,71 @@ func (d *DB) GetEmailHTMLBody(emailID string) (string, error) {
 	return htmlBody.String, nil
 }
 
+// SaveAttachments replaces the attachment list of an email
+func (d *DB) SaveAttachments(emailID string, atts []model.Attachment) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM attachments WHERE email_id = ?", emailID); err != nil {
+		return err
+	}
+	for i, a := range atts {
+		_, err := tx.Exec(
+			"INSERT OR REPLACE INTO attachments (email_id, blob_id, name, type, size, is_inline, position) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			emailID, a.BlobID, a.Name, a.Type, a.Size, a.IsInline, i,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetAttachments retrieves the cached attachment list of an email
+func (d *DB) GetAttachments(emailID string) ([]model.Attachment, error) {
+	rows, err := d.db.Query(
+		"SELECT blob_id, name, type, size, is_inline FROM attachments WHERE email_id = ? ORDER BY position",
+		emailID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var atts []model.Attachment
+	for rows.Next() {
+		var a model.Attachment
+		if err := rows.Scan(&a.BlobID, &a.Name, &a.Type, &a.Size, &a.IsInline); err != nil {
+			return nil, err
+		}
+		atts = append(atts, a)
+	}
+	return atts, rows.Err()
+}
+
+// SaveBlob caches downloaded attachment contents for offline use
+func (d *DB) SaveBlob(blobID string, data []byte) error {
+	_, err := d.db.Exec(
+		"INSERT OR REPLACE INTO blobs (blob_id, data, size, cached_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)",
+		blobID, data, len(data),
+	)
+	return err
+}
+
+// GetBlob retrieves cached attachment contents, or nil if not cached
+func (d *DB) GetBlob(blobID string) ([]byte, error) {
+	var data []byte
+	err := d.db.QueryRow("SELECT data FROM blobs WHERE blob_id = ?", blobID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return data, err
+}
+
 // AddPendingAction adds an action to sync later
 func (d *DB) AddPendingAction(actionType, emailID, data string) error {
 	_, err := d.db.Exec(
@@ -348,12 +830,32 @@ func (d *DB) AddPendingAction(actionType, emailID, data string) error {
 	return err
 }
 
-// GetPendingActions retrieves all pending actions
+// CancelPendingAction removes the newest action with this type, email and
+// data that hasn't reached the server yet, and reports whether there was
+// one. Actions stay in the table until they are applied, so any status
+// will do.
+func (d *DB) CancelPendingAction(actionType, emailID, data string) (bool, error) {
+	res, err := d.db.Exec(`
+		DELETE FROM pending_actions WHERE id = (
+			SELECT MAX(id) FROM pending_actions
+			WHERE type = ? AND email_id = ? AND COALESCE(data, '') = ?
+		)
+	`, actionType, emailID, data)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetPendingActions retrieves all pending actions, whatever their status,
+// in the order they were queued
 func (d *DB) GetPendingActions() ([]PendingAction, error) {
 	rows, err := d.db.Query(`
-		SELECT id, type, email_id, data, created_at
+		SELECT id, type, email_id, COALESCE(data, ''), created_at,
+		       COALESCE(status, 'pending'), COALESCE(attempts, 0), COALESCE(last_error, '')
 		FROM pending_actions
-		ORDER BY created_at ASC
+		ORDER BY id ASC
 	`)
 	if err != nil {
 		return nil, err
@@ -364,7 +866,7 @@ func (d *DB) GetPendingActions() ([]PendingAction, error) {
 	for rows.Next() {
 		var a PendingAction
 		var emailID sql.NullString
-		err := rows.Scan(&a.ID, &a.Type, &emailID, &a.Data, &a.CreatedAt)
+		err := rows.Scan(&a.ID, &a.Type, &emailID, &a.Data, &a.CreatedAt, &a.Status, &a.Attempts, &a.LastError)
 		if err != nil {
 			return nil, err
 		}
@@ -383,19 +885,58 @@ func (d *DB) RemovePendingAction(id int64) error {
 	return err
 }
 
-// SaveLocalDraft saves a draft locally (for offline use)
-func (d *DB) SaveLocalDraft(id, from, to, subject, body string) error {
+// RecordPendingAttempt counts a failed replay of an action, keeping the
+// error and moving the action to the given status
+func (d *DB) RecordPendingAttempt(id int64, status, lastError string) error {
+	_, err := d.db.Exec(`
+		UPDATE pending_actions
+		SET attempts = COALESCE(attempts, 0) + 1, status = ?, last_error = ?
+		WHERE id = ?
+	`, status, lastError, id)
+	return err
+}
+
+// RequeuePendingAction puts a failed or conflicting action back in the queue
+func (d *DB) RequeuePendingAction(id int64) error {
+	_, err := d.db.Exec("UPDATE pending_actions SET status = ? WHERE id = ?", PendingStatusQueued, id)
+	return err
+}
+
+// CountPendingActions returns how many actions are queued and how many
+// need attention (failed or conflicting)
+func (d *DB) CountPendingActions() (queued, stuck int, err error) {
+	err = d.db.QueryRow(`
+		SELECT COUNT(CASE WHEN COALESCE(status, 'pending') = 'pending' THEN 1 END),
+		       COUNT(CASE WHEN COALESCE(status, 'pending') != 'pending' THEN 1 END)
+		FROM pending_actions
+	`).Scan(&queued, &stuck)
+	return queued, stuck, err
+}
+
+// SaveLocalDraft saves a draft locally (for offline use). Attachments are
+// kept whole: local files are uploaded when the draft is synced, and those
+// already on the server, such as forwarded ones, are sent by their blob.
+func (d *DB) SaveLocalDraft(id string, draft model.Draft) error {
+	atts := make([]model.Attachment, 0, len(draft.Attachments))
+	for _, att := range draft.Attachments {
+		if att.Path != "" || att.BlobID != "" {
+			atts = append(atts, att)
+		}
+	}
+	attsJSON, _ := json.Marshal(atts)
+
 	_, err := d.db.Exec(`
-		INSERT OR REPLACE INTO local_drafts (id, from_addr, to_addr, subject, body, updated_at)
-		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
-	`, id, from, to, subject, body)
+		INSERT OR REPLACE INTO local_drafts (id, from_addr, to_addr, cc_addr, bcc_addr, subject, body, attachments, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`, id, draft.From, draft.To, draft.Cc, draft.Bcc, draft.Subject, draft.Body, string(attsJSON))
 	return err
 }
 
 // GetLocalDrafts retrieves all local drafts
 func (d *DB) GetLocalDrafts() ([]model.Email, error) {
 	rows, err := d.db.Query(`
-		SELECT id, from_addr, to_addr, subject, body, created_at
+		SELECT id, from_addr, to_addr, COALESCE(cc_addr, ''), COALESCE(bcc_addr, ''), subject, body,
+		       COALESCE(attachments, '[]'), created_at
 		FROM local_drafts
 		ORDER BY updated_at DESC
 	`)
@@ -407,12 +948,28 @@ func (d *DB) GetLocalDrafts() ([]model.Email, error) {
 	var drafts []model.Email
 	for rows.Next() {
 		var e model.Email
-		var createdAt string
-		err := rows.Scan(&e.ID, &e.From, &e.To, &e.Subject, &e.Body, &createdAt)
+		var attsJSON, createdAt string
+		err := rows.Scan(&e.ID, &e.From, &e.To, &e.Cc, &e.Bcc, &e.Subject, &e.Body, &attsJSON, &createdAt)
 		if err != nil {
 			return nil, err
 		}
+		var atts []model.Attachment
+		if json.Unmarshal([]byte(attsJSON), &atts) == nil {
+			e.Attachments = atts
+		} else {
+			// Saved before blobs were kept: a list of file paths
+			var paths []string
+			json.Unmarshal([]byte(attsJSON), &paths)
+			for _, path := range paths {
+				e.Attachments = append(e.Attachments, model.Attachment{
+					Name: filepath.Base(path),
+					Path: path,
+				})
+			}
+		}
+		e.HasAttachment = len(e.Attachments) > 0
 		e.IsDraft = true
+		e.IsLocal = true
 		e.Date = createdAt
 		drafts = append(drafts, e)
 	}
@@ -438,6 +995,15 @@ func (d *DB) DeleteEmail(emailID string) error {
 	if err != nil {
 		return err
 	}
+	_, err = tx.Exec("DELETE FROM attachments WHERE email_id = ?", emailID)
+	if err != nil {
+		return err
+	}
+	// Drop cached blobs no other email refers to
+	_, err = tx.Exec("DELETE FROM blobs WHERE blob_id NOT IN (SELECT blob_id FROM attachments)")
+	if err != nil {
+		return err
+	}
 	_, err = tx.Exec("DELETE FROM emails WHERE id = ?", emailID)
 	if err != nil {
 		return err
@@ -455,6 +1021,59 @@ func (d *DB) UpdateEmailFlags(emailID string, isUnread, isFlagged bool) error {
 	return err
 }
 
+// SetEmailsUnread updates the unread flag of several emails locally
+func (d *DB) SetEmailsUnread(emailIDs []string, isUnread bool) error {
+	for _, id := range emailIDs {
+		if _, err := d.db.Exec("UPDATE emails SET is_unread = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", isUnread, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetEmailsFlagged updates the flagged flag of several emails locally
+func (d *DB) SetEmailsFlagged(emailIDs []string, isFlagged bool) error {
+	for _, id := range emailIDs {
+		if _, err := d.db.Exec("UPDATE emails SET is_flagged = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", isFlagged, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetEmailMailboxes puts an email in exactly the given mailboxes locally,
+// in both the mailbox index and the email's own mailbox_ids
+func (d *DB) SetEmailMailboxes(emailID string, mailboxIDs []string) error {
+	if mailboxIDs == nil {
+		mailboxIDs = []string{}
+	}
+	mailboxIDsJSON, err := json.Marshal(mailboxIDs)
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE emails SET mailbox_ids = ? WHERE id = ?", string(mailboxIDsJSON), emailID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM email_mailboxes WHERE email_id = ?", emailID); err != nil {
+		return err
+	}
+	for _, id := range mailboxIDs {
+		_, err := tx.Exec("INSERT OR REPLACE INTO email_mailboxes (email_id, mailbox_id) VALUES (?, ?)", emailID, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 // MoveEmail updates the mailbox of an email locally
 func (d *DB) MoveEmail(emailID, fromMailboxID, toMailboxID string) error {
 	tx, err := d.db.Begin()
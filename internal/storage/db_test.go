@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"fm-cli/internal/model"
+)
+
+func TestSetEmailMailboxesUpdatesEmail(t *testing.T) {
+	db := openTestDB(t)
+	err := db.SaveEmails([]model.Email{{ID: "e1", Date: "2024-03-01 10:00", MailboxIDs: []string{"archive"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// As undoing an archive does
+	if err := db.SetEmailMailboxes("e1", []string{"inbox", "work"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if archive, _ := db.GetEmails("archive", 0, 10); len(archive) != 0 {
+		t.Errorf("archive still lists %d emails", len(archive))
+	}
+	inbox, err := db.GetEmails("inbox", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inbox) != 1 {
+		t.Fatalf("inbox lists %d emails, want e1", len(inbox))
+	}
+	if want := []string{"inbox", "work"}; !reflect.DeepEqual(inbox[0].MailboxIDs, want) {
+		t.Errorf("MailboxIDs = %v, want %v", inbox[0].MailboxIDs, want)
+	}
+}
+
+func TestLocalDraftKeepsBlobAttachments(t *testing.T) {
+	db := openTestDB(t)
+	atts := []model.Attachment{
+		{BlobID: "b1", Name: "report.pdf", Type: "application/pdf", Size: 1234},
+		{Name: "notes.txt", Path: "/tmp/notes.txt"},
+	}
+	if err := db.SaveLocalDraft("local-1", model.Draft{Subject: "Fwd: Report", Attachments: atts}); err != nil {
+		t.Fatal(err)
+	}
+
+	drafts, err := db.GetLocalDrafts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drafts) != 1 || !reflect.DeepEqual(drafts[0].Attachments, atts) {
+		t.Fatalf("drafts = %+v, want one with %+v", drafts, atts)
+	}
+
+	// Drafts saved before kept only file paths
+	if _, err := db.db.Exec(`UPDATE local_drafts SET attachments = '["/tmp/old.txt"]'`); err != nil {
+		t.Fatal(err)
+	}
+	drafts, err = db.GetLocalDrafts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []model.Attachment{{Name: "old.txt", Path: "/tmp/old.txt"}}; !reflect.DeepEqual(drafts[0].Attachments, want) {
+		t.Errorf("older draft attachments = %+v, want %+v", drafts[0].Attachments, want)
+	}
+}
+
+func TestAccountsWithSimilarNamesKeepSeparateDatabases(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+
+	// Both names map to "work_a" in the readable part of the file name
+	dot, err := OpenAccount("work.a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dot.Close()
+	if err := dot.SaveEmails([]model.Email{{ID: "e1", Date: "2024-03-01 10:00", MailboxIDs: []string{"inbox"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	underscore, err := OpenAccount("work_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer underscore.Close()
+	if emails, _ := underscore.GetEmails("inbox", 0, 10); len(emails) != 0 {
+		t.Errorf("work_a sees %d emails of work.a", len(emails))
+	}
+	if emails, _ := dot.GetEmails("inbox", 0, 10); len(emails) != 1 {
+		t.Errorf("work.a lists %d emails, want e1", len(emails))
+	}
+}
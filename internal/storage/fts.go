@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"fm-cli/internal/model"
+)
+
+// Markers placed around matched terms in SearchResult.Snippet. They are
+// private-use runes so the UI can style them without clashing with mail text.
+const (
+	HighlightStart = "\uE000"
+	HighlightEnd   = "\uE001"
+)
+
+// SearchResult is a cached email matched by full-text search
+type SearchResult struct {
+	Email   model.Email
+	Snippet string  // Matching excerpt with HighlightStart/HighlightEnd around hits
+	Rank    float64 // bm25 score; lower is a better match
+}
+
+// ftsTable indexes the searchable text of the emails table. It is an
+// external-content table kept in step by ftsTriggers, so SaveEmails,
+// SaveEmailBody and DeleteEmail maintain it without extra writes.
+const ftsTable = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS emails_fts USING fts5(
+		subject, from_addr, to_addr, preview, body_text,
+		content='emails', content_rowid='rowid',
+		tokenize='unicode61 remove_diacritics 2'
+	);
+`
+
+const ftsTriggers = `
+	CREATE TRIGGER IF NOT EXISTS emails_fts_insert AFTER INSERT ON emails BEGIN
+		INSERT INTO emails_fts(rowid, subject, from_addr, to_addr, preview, body_text)
+		VALUES (new.rowid, new.subject, new.from_addr, new.to_addr, new.preview, new.body_text);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS emails_fts_delete AFTER DELETE ON emails BEGIN
+		INSERT INTO emails_fts(emails_fts, rowid, subject, from_addr, to_addr, preview, body_text)
+		VALUES ('delete', old.rowid, old.subject, old.from_addr, old.to_addr, old.preview, old.body_text);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS emails_fts_update AFTER UPDATE OF subject, from_addr, to_addr, preview, body_text ON emails BEGIN
+		INSERT INTO emails_fts(emails_fts, rowid, subject, from_addr, to_addr, preview, body_text)
+		VALUES ('delete', old.rowid, old.subject, old.from_addr, old.to_addr, old.preview, old.body_text);
+		INSERT INTO emails_fts(rowid, subject, from_addr, to_addr, preview, body_text)
+		VALUES (new.rowid, new.subject, new.from_addr, new.to_addr, new.preview, new.body_text);
+	END;
+`
+
+// migrateFTS sets up the full-text index. SQLite builds without FTS5 (the
+// binary wasn't built with the sqlite_fts5 tag) drop the triggers so writes
+// keep working and search falls back to LIKE; the index is rebuilt the next
+// time an FTS5 build opens the database.
+func (d *DB) migrateFTS() error {
+	var enabled bool
+	if err := d.db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return err
+	}
+
+	if !enabled {
+		_, err := d.db.Exec(`
+			DROP TRIGGER IF EXISTS emails_fts_insert;
+			DROP TRIGGER IF EXISTS emails_fts_delete;
+			DROP TRIGGER IF EXISTS emails_fts_update;
+		`)
+		return err
+	}
+
+	// Missing triggers mean the index is new or went stale while a
+	// non-FTS5 build was writing, so it needs a rebuild
+	var triggers int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'emails_fts_%'").Scan(&triggers)
+	if err != nil {
+		return err
+	}
+
+	if triggers < 3 {
+		tx, err := d.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, stmt := range []string{ftsTable, ftsTriggers, "INSERT INTO emails_fts(emails_fts) VALUES ('rebuild')"} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("failed to create search index: %w", err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	d.fts = true
+	return nil
+}
+
+// SearchEmails runs a search over the cached emails, best full-text
+// matches first. Each free-text term is prefix-matched over subjects,
+// addresses, previews and bodies, so "invo" finds "invoice"; operators such
+// as from: filter the matches as in QueryEmails. A search without free
+// text, or without FTS5, lists newest first.
+func (d *DB) SearchEmails(q model.SearchQuery, offset, limit int) ([]SearchResult, error) {
+	match := ftsMatch(q.Text)
+	if match == "" || !d.fts {
+		return d.searchEmailsLike(q, offset, limit)
+	}
+
+	// Subject and sender hits outrank matches buried in the body
+	where := []string{"emails_fts MATCH ?"}
+	args := []interface{}{HighlightStart, HighlightEnd, match}
+	filters, filterArgs := searchFilters(q)
+	where = append(where, filters...)
+	args = append(args, filterArgs...)
+	args = append(args, limit, offset)
+
+	rows, err := d.db.Query(`
+		SELECT `+emailColumns+`,
+		       snippet(emails_fts, -1, ?, ?, '…', 12),
+		       bm25(emails_fts, 10.0, 5.0, 3.0, 2.0, 1.0) AS rank
+		FROM emails_fts
+		JOIN emails e ON e.rowid = emails_fts.rowid
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY rank
+		LIMIT ? OFFSET ?
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		var mailboxIDsJSON string
+		err := rows.Scan(
+			&r.Email.ID, &r.Email.ThreadID, &r.Email.Subject, &r.Email.From, &r.Email.To, &r.Email.Cc,
+			&r.Email.Bcc, &r.Email.ReplyTo, &r.Email.Preview, &r.Email.Date, &r.Email.IsUnread, &r.Email.IsFlagged,
+			&r.Email.IsDraft, &mailboxIDsJSON, &r.Email.HasAttachment,
+			&r.Snippet, &r.Rank,
+		)
+		if err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(mailboxIDsJSON), &r.Email.MailboxIDs)
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+// searchEmailsLike is SearchEmails without ranking: newest first, with the
+// preview standing in for a snippet
+func (d *DB) searchEmailsLike(q model.SearchQuery, offset, limit int) ([]SearchResult, error) {
+	emails, err := d.QueryEmails(q, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]SearchResult, 0, len(emails))
+	for _, e := range emails {
+		results = append(results, SearchResult{Email: e, Snippet: e.Preview})
+	}
+	return results, nil
+}
+
+// ftsMatch turns free text into an FTS5 query. Every word is quoted so
+// punctuation and FTS operators in mail text can't cause syntax errors, and
+// made a prefix match; the terms combine with an implicit AND.
+func ftsMatch(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		word = strings.ReplaceAll(word, `"`, `""`)
+		terms = append(terms, `"`+word+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
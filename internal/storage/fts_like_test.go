@@ -0,0 +1,43 @@
+//go:build !sqlite_fts5
+
+package storage
+
+import (
+	"testing"
+
+	"fm-cli/internal/model"
+)
+
+func TestSearchFallsBackToLike(t *testing.T) {
+	db := openTestDB(t)
+	if db.fts {
+		t.Skip("this SQLite has FTS5 built in")
+	}
+	err := db.SaveEmails([]model.Email{
+		{ID: "old", Subject: "Invoice 41", Preview: "paid", Date: "2024-03-01 10:00", MailboxIDs: []string{"inbox"}},
+		{ID: "new", Subject: "Hello", Preview: "the invoice is attached", Date: "2024-03-02 10:00", MailboxIDs: []string{"inbox"}},
+		{ID: "other", Subject: "Lunch", Date: "2024-03-03 10:00", MailboxIDs: []string{"inbox"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveEmailBody("other", "50% off"); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := db.SearchEmails(model.SearchQuery{Text: "invo"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Email.ID != "new" || results[1].Email.ID != "old" {
+		t.Fatalf("invo found %v, want new then old", searchIDs(t, db, model.SearchQuery{Text: "invo"}))
+	}
+	if results[0].Snippet != "the invoice is attached" {
+		t.Errorf("snippet = %q, want the preview", results[0].Snippet)
+	}
+
+	// LIKE wildcards in the query are literal
+	if ids := searchIDs(t, db, model.SearchQuery{Text: "%"}); len(ids) != 1 || ids[0] != "other" {
+		t.Errorf("%% found %v, want only the email containing it", ids)
+	}
+}
@@ -0,0 +1,120 @@
+//go:build sqlite_fts5
+
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"fm-cli/internal/model"
+)
+
+func saveTestEmails(t *testing.T, db *DB, emails ...model.Email) {
+	t.Helper()
+	for i := range emails {
+		emails[i].MailboxIDs = []string{"inbox"}
+	}
+	if err := db.SaveEmails(emails); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSearchIndexFollowsWrites(t *testing.T) {
+	db := openTestDB(t)
+	if !db.fts {
+		t.Fatal("FTS5 not available in a sqlite_fts5 build")
+	}
+	saveTestEmails(t, db, model.Email{ID: "e1", Subject: "Quarterly budget", Date: "2024-03-01 10:00"})
+
+	if ids := searchIDs(t, db, model.SearchQuery{Text: "budget"}); len(ids) != 1 {
+		t.Fatalf("budget found %v after insert, want e1", ids)
+	}
+
+	// The body arrives later, and a refresh renames the subject
+	if err := db.SaveEmailBody("e1", "Please approve the spreadsheet"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := searchIDs(t, db, model.SearchQuery{Text: "spreadsheet"}); len(ids) != 1 {
+		t.Errorf("spreadsheet found %v after the body was saved, want e1", ids)
+	}
+	saveTestEmails(t, db, model.Email{ID: "e1", Subject: "Annual forecast", Date: "2024-03-01 10:00"})
+	if ids := searchIDs(t, db, model.SearchQuery{Text: "budget"}); len(ids) != 0 {
+		t.Errorf("budget found %v after the subject changed", ids)
+	}
+	if ids := searchIDs(t, db, model.SearchQuery{Text: "forecast spreadsheet"}); len(ids) != 1 {
+		t.Errorf("forecast spreadsheet found %v, want e1 with its body kept", ids)
+	}
+
+	if err := db.DeleteEmail("e1"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := searchIDs(t, db, model.SearchQuery{Text: "forecast"}); len(ids) != 0 {
+		t.Errorf("forecast found %v after delete", ids)
+	}
+}
+
+func TestSearchRanksSubjectHitsFirst(t *testing.T) {
+	db := openTestDB(t)
+	saveTestEmails(t, db,
+		model.Email{ID: "body", Subject: "Hello", Preview: "the invoice is attached", Date: "2024-03-02 10:00"},
+		model.Email{ID: "subject", Subject: "Invoice 42", Date: "2024-03-01 10:00"},
+		model.Email{ID: "none", Subject: "Lunch", Date: "2024-03-03 10:00"},
+	)
+
+	results, err := db.SearchEmails(model.SearchQuery{Text: "invoice"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Email.ID != "subject" || results[1].Email.ID != "body" {
+		t.Fatalf("invoice found %v, want the subject hit before the older-dated body hit", searchIDs(t, db, model.SearchQuery{Text: "invoice"}))
+	}
+	if results[0].Rank >= results[1].Rank {
+		t.Errorf("ranks %v, %v; want the better match lower", results[0].Rank, results[1].Rank)
+	}
+}
+
+func TestSearchSnippetHighlightsPrefixMatch(t *testing.T) {
+	db := openTestDB(t)
+	saveTestEmails(t, db, model.Email{ID: "e1", Subject: "Hello", Preview: "Your invoice for March is ready", Date: "2024-03-01 10:00"})
+
+	results, err := db.SearchEmails(model.SearchQuery{Text: "invo"}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("invo found %d emails, want 1", len(results))
+	}
+	if want := HighlightStart + "invoice" + HighlightEnd; !strings.Contains(results[0].Snippet, want) {
+		t.Errorf("snippet = %q, want invoice highlighted", results[0].Snippet)
+	}
+}
+
+func TestSearchOperatorsFilterMatches(t *testing.T) {
+	db := openTestDB(t)
+	saveTestEmails(t, db,
+		model.Email{ID: "alice", From: "alice@example.com", Subject: "Report", Date: "2024-03-01 10:00"},
+		model.Email{ID: "bob", From: "bob@example.com", Subject: "Report", Date: "2024-03-02 10:00"},
+	)
+
+	ids := searchIDs(t, db, model.SearchQuery{Text: "report", From: "alice"})
+	if len(ids) != 1 || ids[0] != "alice" {
+		t.Errorf("report from:alice found %v, want alice", ids)
+	}
+}
+
+func TestSearchIndexRebuiltAfterNonFTSWrites(t *testing.T) {
+	db := openTestDB(t)
+
+	// A build without FTS5 drops the triggers and writes unindexed
+	if _, err := db.db.Exec("DROP TRIGGER emails_fts_insert; DROP TRIGGER emails_fts_delete; DROP TRIGGER emails_fts_update"); err != nil {
+		t.Fatal(err)
+	}
+	saveTestEmails(t, db, model.Email{ID: "e1", Subject: "Offline notes", Date: "2024-03-01 10:00"})
+
+	if err := db.migrateFTS(); err != nil {
+		t.Fatal(err)
+	}
+	if ids := searchIDs(t, db, model.SearchQuery{Text: "notes"}); len(ids) != 1 {
+		t.Errorf("notes found %v after reopening, want e1", ids)
+	}
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"fm-cli/internal/model"
+)
+
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	db, err := OpenAccount("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// searchIDs runs q and returns the IDs found, best first
+func searchIDs(t *testing.T, db *DB, q model.SearchQuery) []string {
+	t.Helper()
+	results, err := db.SearchEmails(q, 0, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, r := range results {
+		ids = append(ids, r.Email.ID)
+	}
+	return ids
+}
+
+func TestSearchToSkipsCc(t *testing.T) {
+	db := openTestDB(t)
+	err := db.SaveEmails([]model.Email{
+		{ID: "to", To: "bob@example.com", Date: "2024-03-01 10:00", MailboxIDs: []string{"inbox"}},
+		{ID: "cc", To: "alice@example.com", Cc: "bob@example.com", Date: "2024-03-01 11:00", MailboxIDs: []string{"inbox"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := searchIDs(t, db, model.SearchQuery{To: "bob"})
+	if len(ids) != 1 || ids[0] != "to" {
+		t.Errorf("to:bob found %v, want only the email addressed to bob", ids)
+	}
+}
+
+func TestSearchDatesCompareInUTC(t *testing.T) {
+	db := openTestDB(t)
+	// Dates are stored in UTC
+	err := db.SaveEmails([]model.Email{
+		{ID: "early", Date: "2024-03-01 13:00", MailboxIDs: []string{"inbox"}},
+		{ID: "late", Date: "2024-03-01 15:00", MailboxIDs: []string{"inbox"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Midnight at UTC+10 is 14:00 UTC the day before
+	midnight := time.Date(2024, time.March, 2, 0, 0, 0, 0, time.FixedZone("UTC+10", 10*60*60))
+	if ids := searchIDs(t, db, model.SearchQuery{Before: &midnight}); len(ids) != 1 || ids[0] != "early" {
+		t.Errorf("before %v found %v, want only early", midnight, ids)
+	}
+	if ids := searchIDs(t, db, model.SearchQuery{After: &midnight}); len(ids) != 1 || ids[0] != "late" {
+		t.Errorf("after %v found %v, want only late", midnight, ids)
+	}
+}
@@ -0,0 +1,350 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/mailsync"
+	"fm-cli/internal/model"
+	"fm-cli/internal/storage"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Account is a configured account handed to the TUI. Client and DAVClient
+// may be nil when starting offline; they are built from Creds later.
+type Account struct {
+	Name      string
+	Client    *api.Client
+	DAVClient *api.DAVClient
+	DB        *storage.DB
+	Creds     *api.Credentials
+}
+
+// accountSession is everything tied to one account. The active account is
+// unpacked into the model's client, davClient, db, creds, pushOnce and
+// identities, so the rest of the TUI doesn't need to know about accounts.
+type accountSession struct {
+	name       string
+	client     *api.Client
+	davClient  *api.DAVClient
+	db         *storage.DB
+	creds      *api.Credentials
+	pushOnce   *sync.Once
+	identities []string
+	mailboxes  []model.Mailbox // Last known, to act on unified inbox entries
+}
+
+type unifiedInboxMsg struct {
+	emails    []model.Email
+	mailboxes map[string][]model.Mailbox // By account name
+	failed    []string                   // Accounts that didn't load, with why
+}
+
+// NewModelWithAccounts starts on the first account
+func NewModelWithAccounts(accounts []Account, offlineMode bool) Model {
+	first := accounts[0]
+	m := NewModelWithStorage(first.Client, first.DAVClient, first.DB, offlineMode)
+	m.creds = first.Creds
+	m.accounts = make([]accountSession, len(accounts))
+	for i, a := range accounts {
+		m.accounts[i] = accountSession{
+			name:      a.Name,
+			client:    a.Client,
+			davClient: a.DAVClient,
+			db:        a.DB,
+			creds:     a.Creds,
+			pushOnce:  &sync.Once{},
+		}
+	}
+	m.accounts[0].pushOnce = m.pushOnce
+	return m
+}
+
+func (m Model) accountName() string {
+	return m.accounts[m.accountIdx].name
+}
+
+// activeSession packs the active account's state back into its session
+func (m Model) activeSession() accountSession {
+	s := m.accounts[m.accountIdx]
+	s.client = m.client
+	s.davClient = m.davClient
+	s.db = m.db
+	s.creds = m.creds
+	s.pushOnce = m.pushOnce
+	s.identities = m.identities
+	s.mailboxes = m.mailboxes
+	return s
+}
+
+// sessions returns all accounts, the active one up to date
+func (m Model) sessions() []accountSession {
+	sessions := make([]accountSession, len(m.accounts))
+	copy(sessions, m.accounts)
+	sessions[m.accountIdx] = m.activeSession()
+	return sessions
+}
+
+// useAccount makes another account active without touching what is on
+// screen, e.g. to act on a unified inbox entry
+func (m *Model) useAccount(i int) tea.Cmd {
+	if i == m.accountIdx {
+		return nil
+	}
+	m.accounts[m.accountIdx] = m.activeSession()
+	m.accountIdx = i
+	s := m.accounts[i]
+	m.client = s.client
+	m.davClient = s.davClient
+	m.db = s.db
+	m.creds = s.creds
+	m.pushOnce = s.pushOnce
+	m.identities = s.identities
+	m.identityIdx = 0
+	m.mailboxes = s.mailboxes
+	m.mbCursor = 0
+	for j, mb := range m.mailboxes {
+		if mb.Role == "inbox" {
+			m.mbCursor = j
+			break
+		}
+	}
+
+	m.offlineMode = m.forceOffline || m.offlineMode || m.client == nil
+	cmds := []tea.Cmd{countPendingCmd(m.db)}
+	if m.offlineMode && !m.forceOffline && m.client == nil {
+		// Build this account's clients if the server is reachable
+		cmds = append(cmds, checkConnectivityCmd(m.client, m.creds, m.accountName(), false))
+	} else if !m.offlineMode {
+		cmds = append(cmds, m.startPush())
+		if len(m.identities) == 0 {
+			cmds = append(cmds, fetchIdentitiesCmd(m.client))
+		}
+	}
+	return tea.Batch(cmds...)
+}
+
+// switchAccount makes another account active and starts over from the main
+// menu, since nothing loaded so far belongs to it
+func (m *Model) switchAccount(i int) tea.Cmd {
+	cmd := m.useAccount(i)
+	m.state = viewMainMenu
+	m.unified = false
+	m.mailboxes = nil
+	m.mbCursor = 0
+	m.emails = nil
+	m.emailCursor = 0
+	m.emailOffset = 0
+	m.searchQuery = nil
+	m.threads = make(map[string]model.Thread)
+	m.thread = nil
+	m.calendars = nil
+	m.events = nil
+	m.addressBooks = nil
+	m.contacts = nil
+	m.pendingActions = nil
+	m.status = "Switched to " + accountLabel(m.accountName())
+	if !m.offlineMode {
+		m.replaying = m.db != nil
+		return tea.Batch(cmd, replayPendingCmd(m.client, m.db))
+	}
+	return cmd
+}
+
+// followUnifiedCursor activates the account of the unified inbox entry
+// under the cursor, so actions on it use that account's client
+func (m *Model) followUnifiedCursor() tea.Cmd {
+	if m.emailCursor >= len(m.emails) {
+		return nil
+	}
+	account := m.emails[m.emailCursor].Account
+	for i, s := range m.accounts {
+		if s.name == account {
+			return m.useAccount(i)
+		}
+	}
+	return nil
+}
+
+// updateAccounts handles the keys of the account switcher. Keys it doesn't
+// use fall through to the global handlers.
+func (m Model) updateAccounts(msg tea.KeyMsg) (Model, tea.Cmd, bool) {
+	rows := len(m.accounts)
+	if rows > 1 {
+		rows++ // All Inboxes
+	}
+
+	switch msg.String() {
+	case "up", "k":
+		if m.accountCursor > 0 {
+			m.accountCursor--
+		}
+		return m, nil, true
+	case "down", "j":
+		if m.accountCursor < rows-1 {
+			m.accountCursor++
+		}
+		return m, nil, true
+	case "enter", "right", "l":
+		if m.accountCursor == len(m.accounts) {
+			m.unified = true
+			m.state = viewEmails
+			m.emails = nil
+			m.emailCursor = 0
+			m.emailOffset = 0
+			m.searchQuery = nil
+			m.thread = nil
+			m.loading = true
+			m.canLoadMore = false
+			return m, unifiedInboxCmd(m.sessions(), m.offlineMode), true
+		}
+		if m.accountCursor == m.accountIdx {
+			m.state = viewMainMenu
+			return m, nil, true
+		}
+		cmd := m.switchAccount(m.accountCursor)
+		return m, cmd, true
+	case "esc", "h", "left":
+		m.state = viewMainMenu
+		return m, nil, true
+	}
+	return m, nil, false
+}
+
+// accountsView lists the accounts, plus the unified inbox when there are
+// several
+func (m Model) accountsView() string {
+	var s strings.Builder
+	s.WriteString("Accounts\n\n")
+
+	for i, a := range m.sessions() {
+		cursor := "  "
+		style := mailboxStyle
+		if i == m.accountCursor {
+			cursor = "> "
+			style = selectedMailboxStyle
+		}
+		active := " "
+		if i == m.accountIdx {
+			active = "*"
+		}
+		label := fmt.Sprintf("%s%s %s", cursor, active, accountLabel(a.name))
+		if a.creds != nil && a.creds.Email != "" {
+			label += "  " + a.creds.Email
+		}
+		if a.client == nil {
+			label += "  (not connected)"
+		}
+		s.WriteString(style.Render(label) + "\n")
+	}
+
+	if len(m.accounts) > 1 {
+		cursor := "  "
+		style := mailboxStyle
+		if m.accountCursor == len(m.accounts) {
+			cursor = "> "
+			style = selectedMailboxStyle
+		}
+		s.WriteString("\n" + style.Render(cursor+"  All Inboxes") + "\n")
+	}
+
+	s.WriteString("\n(j/k: navigate, enter: switch account, esc: back)")
+	return s.String()
+}
+
+// accountLabel names an account for display; the single-account setup has
+// no name
+func accountLabel(name string) string {
+	if name == "" {
+		return "Default account"
+	}
+	return name
+}
+
+// unifiedInboxCmd merges the first page of every account's inbox, newest
+// first. An account that fails to load is reported without holding back
+// the others; only if none load is it an error.
+func unifiedInboxCmd(sessions []accountSession, offline bool) tea.Cmd {
+	return func() tea.Msg {
+		msg := unifiedInboxMsg{mailboxes: make(map[string][]model.Mailbox)}
+		var firstErr error
+		for _, s := range sessions {
+			mailboxes, emails, err := loadInbox(s, offline)
+			if err != nil {
+				err = fmt.Errorf("%s: %w", accountLabel(s.name), err)
+				if firstErr == nil {
+					firstErr = err
+				}
+				msg.failed = append(msg.failed, err.Error())
+				continue
+			}
+			msg.mailboxes[s.name] = mailboxes
+			for i := range emails {
+				emails[i].Account = s.name
+			}
+			msg.emails = append(msg.emails, emails...)
+		}
+		if len(msg.failed) == len(sessions) && firstErr != nil {
+			return errorMsg(firstErr)
+		}
+		// Dates are ISO-style strings, so they sort chronologically
+		sort.SliceStable(msg.emails, func(i, j int) bool {
+			return msg.emails[i].Date > msg.emails[j].Date
+		})
+		return msg
+	}
+}
+
+// loadInbox returns an account's mailboxes and the first page of its inbox,
+// from the server when possible and the cache otherwise
+func loadInbox(s accountSession, offline bool) ([]model.Mailbox, []model.Email, error) {
+	if offline || s.client == nil {
+		if s.db == nil {
+			return nil, nil, nil
+		}
+		mailboxes, err := s.db.GetMailboxes()
+		if err != nil {
+			return nil, nil, err
+		}
+		inbox := inboxID(mailboxes)
+		if inbox == "" {
+			return mailboxes, nil, nil
+		}
+		emails, err := s.db.GetEmails(inbox, 0, mailsync.PageSize)
+		return mailboxes, emails, err
+	}
+
+	var mailboxes []model.Mailbox
+	var err error
+	if s.db != nil {
+		mailboxes, err = mailsync.New(s.client, s.db).SyncMailboxes()
+	} else {
+		mailboxes, err = s.client.FetchMailboxes()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	inbox := inboxID(mailboxes)
+	if inbox == "" {
+		return mailboxes, nil, nil
+	}
+	if s.db != nil {
+		emails, err := syncEmails(s.client, s.db, inbox)
+		return mailboxes, emails, err
+	}
+	emails, err := s.client.FetchEmails(inbox, 0)
+	return mailboxes, emails, err
+}
+
+func inboxID(mailboxes []model.Mailbox) string {
+	for _, mb := range mailboxes {
+		if mb.Role == "inbox" {
+			return mb.ID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package tui
+
+import (
+	"strings"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/model"
+)
+
+// Alarm triggers offered in the event editor
+var alarmTriggers = []string{"PT0S", "-PT5M", "-PT10M", "-PT15M", "-PT30M", "-PT1H", "-P1D", "-P7D"}
+
+type alarmOption struct {
+	label  string
+	alerts []model.EventAlert
+}
+
+// setAlarmOptions offers no alarm or one of the common ones for the event
+// being edited, plus its own alarms if they are something else
+func (m *Model) setAlarmOptions() {
+	m.eventAlarmOptions = []alarmOption{{label: "None"}}
+	for _, trigger := range alarmTriggers {
+		alert := model.EventAlert{Trigger: trigger, Action: "display"}
+		m.eventAlarmOptions = append(m.eventAlarmOptions, alarmOption{
+			label:  api.DescribeAlert(alert),
+			alerts: []model.EventAlert{alert},
+		})
+	}
+
+	m.eventAlarm = 0
+	alerts := m.editingEvent.Alerts
+	if len(alerts) == 0 {
+		return
+	}
+	if len(alerts) == 1 && alerts[0].At.IsZero() && !alerts[0].FromEnd {
+		for i, trigger := range alarmTriggers {
+			if alerts[0].Trigger == trigger {
+				m.eventAlarm = i + 1
+				// Keep the alarm's own ID and action
+				m.eventAlarmOptions[m.eventAlarm].alerts = alerts
+				return
+			}
+		}
+	}
+	m.eventAlarmOptions = append(m.eventAlarmOptions, alarmOption{
+		label:  describeAlerts(alerts),
+		alerts: alerts,
+	})
+	m.eventAlarm = len(m.eventAlarmOptions) - 1
+}
+
+func (m Model) alarmLabel() string {
+	if m.eventAlarm < len(m.eventAlarmOptions) {
+		return m.eventAlarmOptions[m.eventAlarm].label
+	}
+	return ""
+}
+
+func describeAlerts(alerts []model.EventAlert) string {
+	var parts []string
+	for _, a := range alerts {
+		parts = append(parts, api.DescribeAlert(a))
+	}
+	return strings.Join(parts, ", ")
+}
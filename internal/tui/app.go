@@ -2,17 +2,22 @@ package tui
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"mime"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"fm-cli/internal/api"
 	"fm-cli/internal/images"
+	"fm-cli/internal/mailsync"
 	"fm-cli/internal/model"
 	"fm-cli/internal/storage"
 
@@ -31,11 +36,15 @@ const (
 	viewEmails
 	viewBody
 	viewComposeTo
+	viewComposeCc
+	viewComposeBcc
 	viewComposeSubject
 	viewComposeConfirm
 	viewCalendar
 	viewContacts
 	viewSettings
+	viewOutbox
+	viewAccounts
 )
 
 // MainMenuItem represents an option in the main menu
@@ -80,11 +89,20 @@ var (
 	unreadStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color("#04B575")).
 			Bold(true)
+
+	// Search snippet styles: the excerpt, and the terms that matched in it
+	snippetStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("245"))
+
+	snippetMatchStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("229")).
+				Bold(true)
 )
 
 // msg types
 type mailboxesLoadedMsg []model.Mailbox
 type emailsLoadedMsg []model.Email
+type searchResultsMsg []storage.SearchResult // Offline matches, best first
 type emailsRefreshedMsg []model.Email // For refresh without appending
 type emailBodyLoadedMsg struct {
 	body     string
@@ -102,9 +120,25 @@ type contactsLoadedMsg []model.Contact
 type eventCreatedMsg struct{}
 type eventDeletedMsg struct{}
 type contactCreatedMsg struct{}
+type contactConflictMsg struct{ deleting bool } // Contact changed on the server since it was loaded
+type eventConflictMsg struct{ deleting bool }   // Event changed on the server since it was loaded
 type contactDeletedMsg struct{}
 type htmlBodyLoadedMsg string
 type browserOpenedMsg struct{}
+type liveEmailsMsg []model.Email // Email list re-read after a push, keeps cursor
+type threadsLoadedMsg []model.Thread
+type attachmentsLoadedMsg struct {
+	emailID     string
+	attachments []model.Attachment
+}
+type attachmentSavedMsg string // Status line describing where it went
+
+// pushStateMsg is a StateChange pushed by the server of one account
+type pushStateMsg struct {
+	account string
+	api.StateChange
+}
+type replyContextMsg *model.ReplyContext
 type errorMsg error
 
 // Main menu items
@@ -113,6 +147,8 @@ var mainMenuItems = []MainMenuItem{
 	{Name: "Calendar", Shortcut: "c", State: viewCalendar},
 	{Name: "Contacts", Shortcut: "o", State: viewContacts},
 	{Name: "Settings", Shortcut: "s", State: viewSettings},
+	{Name: "Outbox", Shortcut: "p", State: viewOutbox},
+	{Name: "Accounts", Shortcut: "a", State: viewAccounts},
 }
 
 // Model implementation
@@ -122,15 +158,38 @@ type Model struct {
 	db        *storage.DB
 	state     sessionState
 
-	// Offline mode
-	offlineMode bool
+	// Offline mode: chosen in Settings, or because the server can't be
+	// reached. Changes made meanwhile are queued.
+	offlineMode  bool
+	forceOffline bool             // Offline mode chosen in Settings
+	creds        *api.Credentials // Builds the clients once the network is back
+	pendingCount int              // Queued changes, for the status bar
+	stuckCount   int              // Failed or conflicting changes
+
+	// Push notifications from every account (coalesced: at most one
+	// pending event per account)
+	pushEvents chan pushStateMsg
+	pushOnce   *sync.Once // Listener is started once, when first online
+
+	// Accounts; see accountSession for how the active one is kept
+	accounts      []accountSession
+	accountIdx    int
+	accountCursor int
+	unified       bool // Email list merges the inboxes of all accounts
 
 	// Main Menu
 	menuCursor int
 
 	// Mailbox View Data
-	mailboxes []model.Mailbox
-	mbCursor  int
+	mailboxes          []model.Mailbox // In tree order, see mailboxTree
+	mbCursor           int
+	collapsedMailboxes map[string]bool // Folders whose subfolders are hidden
+	folderPrompt       string          // "create" or "rename" while naming a folder
+	folderInput        textinput.Model
+	folderParent       string // Parent of the folder being created
+	movingMailbox      string // Folder being given a new parent
+	mailboxConfirm     string // "delete" or "emails" while confirming a folder delete
+	mailboxConfirmID   string
 
 	// Email View Data
 	emails      []model.Email
@@ -139,23 +198,74 @@ type Model struct {
 	loading     bool
 	canLoadMore bool // If true, hitting bottom loads more
 
+	// Search
+	searching   bool               // Search prompt is open
+	searchInput textinput.Model
+	searchQuery *model.SearchQuery // Active search whose results are listed
+	snippets    map[string]string  // Offline matches' excerpts, by email ID
+
+	destroyConfirm []string    // Emails to delete for good once confirmed
+	undoStack      []undoEntry // Mail actions z can take back, newest last
+
+	// Marked list entries for bulk actions
+	marked       map[string]bool // By email ID
+	visual       bool            // Marking a range with V
+	visualStart  int
+	keywording   bool // Keyword prompt is open
+	keywordInput textinput.Model
+
+	// Folder picker for moving or copying emails
+	picking      string // "move" or "copy" while choosing a folder
+	pickerInput  textinput.Model
+	pickerCursor int
+	pickerIDs    []string // Emails being moved or copied
+	pickerFrom   string   // Mailbox they're moved out of
+	pickerSkip   []string // Mailboxes not offered
+	pickerBulk   map[string]string // Marked emails to the mailbox each leaves
+
+	// Threads
+	threadMode bool                    // List one entry per conversation
+	threads    map[string]model.Thread // Loaded conversations by thread ID
+	thread     *model.Thread           // Conversation open in the reader
+	threadPos  int                     // Message of thread being read
+
 	// Body View Data
 	bodyContent string
 	htmlBody    string // Raw HTML for image rendering
 	showDetails bool   // Toggle expanded headers
+	status      string // One-line notice shown under the body
+
+	// Attachments of the email being read
+	attachments      []model.Attachment
+	attachmentCursor int
+	attachmentFocus  bool // Attachment pane has the keyboard
+	savingAttachment bool // Save directory prompt is open
+	saveDirInput     textinput.Model
+	cacheAttachments bool // Keep downloaded attachments for offline use
 
 	// Composition Data
 	inputTo          textinput.Model
+	inputCc          textinput.Model
+	inputBcc         textinput.Model
 	inputSubject     textinput.Model
 	composeBody      string
 	tempFile         string
 	draftID          string   // If editing a draft
 	identities       []string // Available sending identities (email addresses)
 	identityIdx      int      // Currently selected identity index
+	replyContext     *model.ReplyContext // Message being replied to or forwarded
+	composeMarkdown  bool // Body is Markdown, sent with an HTML alternative
+	composePreview   bool // Confirm screen shows the rendered HTML
+	markdownDefault  bool // New messages start in Markdown mode
 	toSuggestions    []model.Contact // Autocomplete suggestions for To field
 	toSuggestionIdx  int             // Selected suggestion index
 	showSuggestions  bool            // Whether to show suggestions dropdown
 
+	// Files attached to the message being composed
+	composeAttachments []model.Attachment
+	attaching          bool // Attach file prompt is open
+	attachInput        textinput.Model
+
 	// Calendar Data
 	calendars       []model.Calendar
 	calendarCursor  int
@@ -166,6 +276,13 @@ type Model struct {
 	viewEventDetail bool      // Viewing event details
 	editingEvent    *model.CalendarEvent // Event being created/edited
 	eventInput      textinput.Model
+	eventEditField  int                     // Which field of the event form is focused
+	eventRepeatOptions []api.RecurrenceOption // Repeat rules offered in the editor
+	eventRepeat     int                     // Chosen repeat rule
+	eventSeries     string                  // Rule of the event before editing
+	eventScope      string                  // "edit" or "delete" while asking this occurrence or all
+	eventAlarmOptions []alarmOption         // Alarms offered in the editor
+	eventAlarm      int                     // Chosen alarm
 
 	// Contacts Data
 	addressBooks      []model.AddressBook
@@ -180,6 +297,12 @@ type Model struct {
 	// Settings
 	settingsCursor int
 
+	// Outbox: changes made offline waiting to reach the server
+	pendingActions []storage.PendingAction
+	pendingCursor  int
+	discardConfirm bool // Asking before dropping the selected action
+	replaying      bool // Replay pass running
+
 	err    error
 	width  int
 	height int
@@ -194,6 +317,12 @@ func NewModelWithStorage(client *api.Client, davClient *api.DAVClient, db *stora
 	tiTo.Placeholder = "recipient@example.com"
 	tiTo.Focus()
 
+	tiCc := textinput.New()
+	tiCc.Placeholder = "Cc (optional)"
+
+	tiBcc := textinput.New()
+	tiBcc.Placeholder = "Bcc (optional)"
+
 	tiSubj := textinput.New()
 	tiSubj.Placeholder = "Subject"
 
@@ -203,28 +332,76 @@ func NewModelWithStorage(client *api.Client, davClient *api.DAVClient, db *stora
 	tiContact := textinput.New()
 	tiContact.Placeholder = "Contact name"
 
-	return Model{
+	tiSaveDir := textinput.New()
+	tiSaveDir.Placeholder = "~/Downloads"
+
+	tiFolder := textinput.New()
+	tiFolder.Placeholder = "New folder"
+
+	tiKeyword := textinput.New()
+	tiKeyword.Placeholder = "+keyword to add, -keyword to remove"
+
+	tiPicker := textinput.New()
+	tiPicker.Placeholder = "Folder"
+
+	tiAttach := textinput.New()
+	tiAttach.Placeholder = "~/path/to/file"
+
+	tiSearch := textinput.New()
+	tiSearch.Placeholder = "from:alice subject:report has:attachment after:2024-01-01 text"
+
+	m := Model{
 		client:       client,
 		davClient:    davClient,
 		db:           db,
-		offlineMode:  offlineMode,
+		offlineMode:  offlineMode || client == nil,
+		forceOffline: offlineMode,
 		state:        viewMainMenu,
 		inputTo:      tiTo,
+		inputCc:      tiCc,
+		inputBcc:     tiBcc,
 		inputSubject: tiSubj,
 		eventInput:   tiEvent,
 		contactInput: tiContact,
+		searchInput:  tiSearch,
+		saveDirInput: tiSaveDir,
+		folderInput:  tiFolder,
+		pickerInput:  tiPicker,
+		keywordInput: tiKeyword,
+		attachInput:  tiAttach,
 		loading:      false,
 		agendaStart:  time.Now().Truncate(24 * time.Hour),
 		agendaDays:   14,
+		pushEvents:   make(chan pushStateMsg, 1),
+		pushOnce:     &sync.Once{},
+		threads:      make(map[string]model.Thread),
+	}
+	m.accounts = []accountSession{{pushOnce: m.pushOnce}}
+	if db != nil {
+		if v, _ := db.GetConfig("thread_mode"); v == "true" {
+			m.threadMode = true
+		}
+		if v, _ := db.GetConfig("cache_attachments"); v == "true" {
+			m.cacheAttachments = true
+		}
+		if v, _ := db.GetConfig("compose_markdown"); v == "true" {
+			m.markdownDefault = true
+		}
 	}
+	return m
 }
 
 func (m Model) Init() tea.Cmd {
-	// Pre-fetch identities on startup if online
+	// Keep checking the connection unless offline mode was chosen
+	cmds := []tea.Cmd{scheduleConnectivityCmd(), countPendingCmd(m.db)}
+	if !m.forceOffline && m.offlineMode {
+		cmds = append(cmds, checkConnectivityCmd(m.client, m.creds, m.accountName(), false))
+	}
+	// Pre-fetch identities and start listening for pushes on startup if online
 	if !m.offlineMode && m.client != nil {
-		return fetchIdentitiesCmd(m.client)
+		cmds = append(cmds, fetchIdentitiesCmd(m.client), m.startPush(), replayPendingCmd(m.client, m.db))
 	}
-	return nil
+	return tea.Batch(cmds...)
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -247,10 +424,22 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case mailboxesLoadedMsg:
-		m.mailboxes = msg
+		m.setMailboxes(msg, "")
 		m.loading = false
 		return m, nil
 
+	case folderChangedMsg:
+		m.setMailboxes(msg.mailboxes, msg.selectID)
+		m.loading = false
+		m.status = msg.status
+		return m, nil
+
+	case folderHasEmailMsg:
+		m.loading = false
+		m.mailboxConfirm = "emails"
+		m.mailboxConfirmID = msg.id
+		return m, nil
+
 	case emailsLoadedMsg:
 		newEmails := []model.Email(msg)
 		if len(newEmails) < 20 {
@@ -258,8 +447,32 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		} else {
 			m.canLoadMore = true
 		}
+		if len(m.emails) == 0 {
+			m.snippets = nil
+		}
 		m.emails = append(m.emails, newEmails...)
 		m.loading = false
+		if m.threadMode && m.searchQuery == nil {
+			return m, m.threadsCmd(newEmails)
+		}
+		return m, nil
+
+	case searchResultsMsg:
+		if len(m.emails) == 0 || m.snippets == nil {
+			m.snippets = make(map[string]string, len(msg))
+		}
+		for _, r := range msg {
+			m.emails = append(m.emails, r.Email)
+			m.snippets[r.Email.ID] = r.Snippet
+		}
+		m.canLoadMore = len(msg) >= api.SearchPageSize
+		m.loading = false
+		return m, nil
+
+	case threadsLoadedMsg:
+		for _, t := range msg {
+			m.threads[t.ID] = t
+		}
 		return m, nil
 
 	case emailsRefreshedMsg:
@@ -279,12 +492,152 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.bodyContent = msg.body
 		m.htmlBody = msg.htmlBody
 		m.loading = false
+		m.attachments = nil
+		m.attachmentCursor = 0
+		m.attachmentFocus = false
+		if e, ok := m.readingEmail(); ok && m.state == viewBody {
+			if m.offlineMode || m.client == nil {
+				return m, fetchAttachmentsOfflineCmd(m.db, e.ID)
+			}
+			if e.HasAttachment {
+				return m, fetchAttachmentsCmd(m.client, m.db, e.ID, m.cacheAttachments)
+			}
+		}
+		return m, nil
+
+	case attachmentsLoadedMsg:
+		// Ignore lists for a message we've already moved away from
+		if e, ok := m.readingEmail(); ok && e.ID == msg.emailID {
+			m.attachments = msg.attachments
+		}
+		return m, nil
+
+	case attachmentSavedMsg:
+		m.status = string(msg)
+		return m, nil
+
+	case replyContextMsg:
+		// Only if still composing the reply it was looked up for
+		if m.composing() && m.replyContext != nil && msg != nil && m.replyContext.EmailID == msg.EmailID {
+			m.replyContext = msg
+		}
 		return m, nil
 
 	case identitiesLoadedMsg:
 		m.identities = msg
 		return m, nil
 
+	case pushStateMsg:
+		// Re-arm the listener first so no events are missed while we sync
+		cmds := []tea.Cmd{waitForPushCmd(m.pushEvents)}
+		// Another account's change is picked up when it is next opened
+		if m.offlineMode || m.client == nil || msg.account != m.accountName() {
+			return m, tea.Batch(cmds...)
+		}
+		_, emailChanged := msg.Types["Email"]
+		_, mailboxChanged := msg.Types["Mailbox"]
+		if emailChanged || mailboxChanged {
+			cmds = append(cmds, fetchMailboxesCmd(m.client, m.db))
+		}
+		// Without a cache (or in thread mode) only the first page can be
+		// refetched, so leave longer scrolled lists alone
+		canReload := (m.db != nil && !m.threadMode) || len(m.emails) <= mailsync.PageSize
+		if emailChanged && canReload && m.state == viewEmails && m.searchQuery == nil && !m.unified && len(m.mailboxes) > 0 && !m.loading {
+			limit := len(m.emails)
+			if limit < mailsync.PageSize {
+				limit = mailsync.PageSize
+			}
+			cmds = append(cmds, liveEmailsCmd(m.client, m.db, m.mailboxes[m.mbCursor].ID, limit, m.threadMode))
+		}
+		return m, tea.Batch(cmds...)
+
+	case liveEmailsMsg:
+		// Replace the list but keep the cursor on the same message
+		selectedID := ""
+		if m.emailCursor < len(m.emails) {
+			selectedID = m.emails[m.emailCursor].ID
+		}
+		m.emails = []model.Email(msg)
+		m.emailCursor = 0
+		for i, e := range m.emails {
+			if e.ID == selectedID {
+				m.emailCursor = i
+				break
+			}
+		}
+		if m.emailOffset > m.emailCursor {
+			m.emailOffset = m.emailCursor
+		}
+		if m.threadMode && m.searchQuery == nil {
+			return m, m.threadsCmd(m.emails)
+		}
+		return m, nil
+
+	case connectivityTickMsg:
+		if m.forceOffline {
+			return m, scheduleConnectivityCmd()
+		}
+		return m, checkConnectivityCmd(m.client, m.creds, m.accountName(), true)
+
+	case connectivityMsg:
+		return m, m.applyConnectivity(msg)
+
+	case pendingCountMsg:
+		m.pendingCount = msg.queued
+		m.stuckCount = msg.stuck
+		return m, nil
+
+	case unifiedInboxMsg:
+		if !m.unified {
+			return m, nil
+		}
+		m.emails = msg.emails
+		m.loading = false
+		if len(msg.failed) > 0 {
+			m.status = "Couldn't load " + strings.Join(msg.failed, "; ")
+		}
+		for i := range m.accounts {
+			if mbs, ok := msg.mailboxes[m.accounts[i].name]; ok {
+				m.accounts[i].mailboxes = mbs
+			}
+		}
+		if mbs, ok := msg.mailboxes[m.accountName()]; ok {
+			m.setMailboxes(mbs, "")
+			m.mbCursor = 0
+			for i, mb := range m.mailboxes {
+				if mb.Role == "inbox" {
+					m.mbCursor = i
+					break
+				}
+			}
+		}
+		return m, nil
+
+	case pendingActionsLoadedMsg:
+		m.pendingActions = msg
+		if m.pendingCursor >= len(m.pendingActions) {
+			m.pendingCursor = len(m.pendingActions) - 1
+		}
+		if m.pendingCursor < 0 {
+			m.pendingCursor = 0
+		}
+		return m, nil
+
+	case pendingReplayedMsg:
+		m.replaying = false
+		m.status = replaySummary(msg.result)
+		if msg.err != nil && m.status == "" {
+			m.status = "Syncing pending changes failed: " + msg.err.Error()
+		}
+		cmds := []tea.Cmd{countPendingCmd(m.db)}
+		if m.state == viewOutbox {
+			cmds = append(cmds, fetchPendingActionsCmd(m.db))
+		}
+		if msg.result.Done > 0 && m.state == viewMailboxes && m.client != nil && !m.offlineMode {
+			cmds = append(cmds, fetchMailboxesCmd(m.client, m.db))
+		}
+		return m, tea.Batch(cmds...)
+
 	case draftSavedMsg:
 		m.loading = false
 		m.state = viewMailboxes
@@ -299,10 +652,40 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.state = viewMailboxes
 		os.Remove(m.tempFile)
 		if m.offlineMode || m.client == nil {
+			m.status = "Message queued, it will be sent once back online"
 			return m, fetchMailboxesOfflineCmd(m.db)
 		}
 		return m, fetchMailboxesCmd(m.client, m.db)
 
+	case bulkDoneMsg:
+		m.status = msg.status
+		m.clearMarks()
+		return m, m.reloadEmails()
+
+	case undoneMsg:
+		m.status = msg.status
+		if m.state == viewEmails {
+			return m, tea.Batch(countPendingCmd(m.db), m.reloadEmails())
+		}
+		if m.offlineMode || m.client == nil {
+			return m, tea.Batch(countPendingCmd(m.db), fetchMailboxesOfflineCmd(m.db))
+		}
+		return m, tea.Batch(countPendingCmd(m.db), fetchMailboxesCmd(m.client, m.db))
+
+	case allMatchesMsg:
+		m.loading = false
+		if m.state != viewEmails || m.searchQuery == nil {
+			return m, nil
+		}
+		m.emails = []model.Email(msg)
+		m.canLoadMore = false
+		m.marked = make(map[string]bool, len(m.emails))
+		for _, e := range m.emails {
+			m.marked[e.ID] = true
+		}
+		m.status = "Marked all " + messageCount(len(m.emails)) + " matching the search"
+		return m, nil
+
 	case emailDeletedMsg:
 		m.loading = false
 		// Refresh mailbox counts after delete
@@ -392,6 +775,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case contactCreatedMsg:
 		m.editingContact = nil
 		m.loading = false
+		m.status = ""
 		// Refresh contacts
 		if len(m.addressBooks) > 0 && m.client != nil && m.davClient != nil {
 			abID := ""
@@ -402,6 +786,46 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, nil
 
+	case contactConflictMsg:
+		m.loading = false
+		if msg.deleting {
+			m.status = "This contact was changed elsewhere since it was loaded, so it wasn't deleted."
+			if m.davClient != nil && m.addressBookCursor < len(m.addressBooks) {
+				return m, fetchContactsCmd(m.davClient, m.addressBooks[m.addressBookCursor].ID, 100)
+			}
+			return m, nil
+		}
+		if m.editingContact == nil {
+			return m, nil
+		}
+		// Saving again goes ahead without checking the version
+		m.editingContact.ETag = ""
+		m.status = "This contact was changed elsewhere since it was loaded. Enter saves yours over it, esc discards your changes."
+		if m.davClient != nil {
+			return m, fetchContactsCmd(m.davClient, m.editingContact.AddressBookID, 100)
+		}
+		return m, nil
+
+	case eventConflictMsg:
+		m.loading = false
+		if m.editingEvent != nil && !msg.deleting {
+			// Saving again goes ahead without checking the version
+			m.editingEvent.ETag = ""
+			m.status = "This event was changed elsewhere since it was loaded. Enter saves yours over it, esc discards your changes."
+		} else {
+			m.status = "This event was changed elsewhere since it was loaded, so it wasn't deleted."
+		}
+		if len(m.calendars) > 0 && m.davClient != nil {
+			var calIDs []string
+			for _, cal := range m.calendars {
+				if cal.IsVisible && cal.MayReadItems {
+					calIDs = append(calIDs, cal.ID)
+				}
+			}
+			return m, fetchEventsCmd(m.davClient, calIDs, m.agendaStart, m.agendaStart.AddDate(0, 0, m.agendaDays))
+		}
+		return m, nil
+
 	case contactDeletedMsg:
 		m.loading = false
 		m.viewContactDetail = false
@@ -418,6 +842,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case errorMsg:
 		m.err = msg
 		m.loading = false
+		m.connectionLost(msg)
 		return m, nil
 	
 	case tea.WindowSizeMsg:
@@ -426,38 +851,24 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// Don't return, let UI resize if needed (though mostly static)
 	}
 
+	// Handle Folder Naming
+	if m.state == viewMailboxes && m.folderPrompt != "" {
+		return m.updateFolderPrompt(msg)
+	}
+
+	// Handle Keyword Prompt
+	if m.state == viewEmails && m.keywording {
+		return m.updateKeywordPrompt(msg)
+	}
+
+	// Handle Folder Picking
+	if (m.state == viewEmails || m.state == viewBody) && m.picking != "" {
+		return m.updateFolderPicker(msg)
+	}
+
 	// Handle Calendar Event Editing
 	if m.state == viewCalendar && m.editingEvent != nil {
-		m.eventInput, cmd = m.eventInput.Update(msg)
-		
-		switch msg := msg.(type) {
-		case tea.KeyMsg:
-			switch msg.Type {
-			case tea.KeyEnter:
-				// Save the event
-				m.editingEvent.Title = m.eventInput.Value()
-				if m.editingEvent.Title == "" {
-					m.err = fmt.Errorf("event title cannot be empty")
-					return m, nil
-				}
-				if m.editingEvent.Duration == "" {
-					m.editingEvent.Duration = "PT1H"
-				}
-				m.loading = true
-				if m.editingEvent.ID == "" && m.davClient != nil {
-					return m, createEventCmd(m.davClient, *m.editingEvent)
-				} else if m.davClient != nil {
-					return m, updateEventCmd(m.davClient, *m.editingEvent)
-				}
-			case tea.KeyEsc:
-				m.editingEvent = nil
-				m.eventInput.Blur()
-				return m, nil
-			case tea.KeyCtrlC:
-				return m, tea.Quit
-			}
-		}
-		return m, cmd
+		return m.updateEventEditor(msg)
 	}
 
 	// Handle Contact Editing
@@ -561,6 +972,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case tea.KeyEsc:
 				m.editingContact = nil
 				m.contactInput.Blur()
+				m.status = ""
 				return m, nil
 			case tea.KeyCtrlC:
 				return m, tea.Quit
@@ -570,17 +982,19 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 
 	// Handle Composition States
-	if m.state == viewComposeTo {
-		oldValue := m.inputTo.Value()
-		m.inputTo, cmd = m.inputTo.Update(msg)
-		newValue := m.inputTo.Value()
+	if m.state == viewComposeTo || m.state == viewComposeCc || m.state == viewComposeBcc {
+		input := m.recipientInput()
+		oldValue := input.Value()
+		*input, cmd = input.Update(msg)
+		newValue := input.Value()
 		
-		// Update suggestions when input changes
-		if oldValue != newValue && len(newValue) >= 1 {
-			m.toSuggestions = filterContacts(m.contacts, newValue)
+		// Update suggestions for the address being typed after the last comma
+		_, partial := splitLastRecipient(newValue)
+		if oldValue != newValue && len(partial) >= 1 {
+			m.toSuggestions = filterContacts(m.contacts, partial)
 			m.showSuggestions = len(m.toSuggestions) > 0
 			m.toSuggestionIdx = 0
-		} else if newValue == "" {
+		} else if partial == "" {
 			m.toSuggestions = nil
 			m.showSuggestions = false
 		}
@@ -598,50 +1012,41 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.toSuggestionIdx--
 					return m, nil
 				}
-			case tea.KeyEnter:
+			case tea.KeyEnter, tea.KeyTab:
 				if m.showSuggestions && len(m.toSuggestions) > 0 {
-					// Select the suggestion
-					selected := m.toSuggestions[m.toSuggestionIdx]
-					if len(selected.Emails) > 0 {
-						if selected.FullName != "" {
-							m.inputTo.SetValue(fmt.Sprintf("%s <%s>", selected.FullName, selected.Emails[0].Email))
-						} else {
-							m.inputTo.SetValue(selected.Emails[0].Email)
-						}
-					}
+					// Enter and Tab both select the suggestion
+					input.SetValue(completeRecipient(input.Value(), m.toSuggestions[m.toSuggestionIdx]))
+					input.CursorEnd()
 					m.showSuggestions = false
 					m.toSuggestions = nil
 					return m, nil
 				}
-				m.state = viewComposeSubject
-				m.inputTo.Blur()
-				m.inputSubject.Focus()
-				m.showSuggestions = false
-				return m, textinput.Blink
-			case tea.KeyTab:
-				if m.showSuggestions && len(m.toSuggestions) > 0 {
-					// Tab also selects suggestion
-					selected := m.toSuggestions[m.toSuggestionIdx]
-					if len(selected.Emails) > 0 {
-						if selected.FullName != "" {
-							m.inputTo.SetValue(fmt.Sprintf("%s <%s>", selected.FullName, selected.Emails[0].Email))
-						} else {
-							m.inputTo.SetValue(selected.Emails[0].Email)
-						}
+				if msg.Type == tea.KeyTab {
+					if len(m.identities) > 1 {
+						m.identityIdx = (m.identityIdx + 1) % len(m.identities)
 					}
-					m.showSuggestions = false
-					m.toSuggestions = nil
 					return m, nil
 				}
-				if len(m.identities) > 1 {
-					m.identityIdx = (m.identityIdx + 1) % len(m.identities)
+				// To -> Cc -> Bcc -> Subject
+				switch m.state {
+				case viewComposeTo:
+					return m, m.focusComposeField(viewComposeCc)
+				case viewComposeCc:
+					return m, m.focusComposeField(viewComposeBcc)
+				default:
+					return m, m.focusComposeField(viewComposeSubject)
 				}
-				return m, nil
 			case tea.KeyEsc:
 				if m.showSuggestions {
 					m.showSuggestions = false
 					return m, nil
 				}
+				switch m.state {
+				case viewComposeCc:
+					return m, m.focusComposeField(viewComposeTo)
+				case viewComposeBcc:
+					return m, m.focusComposeField(viewComposeCc)
+				}
 				m.state = viewMailboxes
 				m.inputTo.Blur()
 				return m, nil
@@ -692,10 +1097,42 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 				return m, nil
 			case tea.KeyEsc:
-				m.state = viewComposeTo
-				m.inputSubject.Blur()
-				m.inputTo.Focus()
-				return m, textinput.Blink
+				return m, m.focusComposeField(viewComposeBcc)
+			case tea.KeyCtrlC:
+				return m, tea.Quit
+			}
+		}
+		return m, cmd
+	}
+
+	if m.state == viewComposeConfirm && m.attaching {
+		m.attachInput, cmd = m.attachInput.Update(msg)
+
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch msg.Type {
+			case tea.KeyEnter:
+				path := strings.TrimSpace(m.attachInput.Value())
+				if path == "" {
+					m.attaching = false
+					m.attachInput.Blur()
+					return m, nil
+				}
+				att, err := localAttachment(path)
+				if err != nil {
+					m.status = err.Error()
+					return m, nil
+				}
+				m.attaching = false
+				m.attachInput.Blur()
+				m.status = ""
+				m.composeAttachments = append(m.composeAttachments, att)
+				return m, nil
+			case tea.KeyEsc:
+				m.attaching = false
+				m.attachInput.Blur()
+				m.status = ""
+				return m, nil
 			case tea.KeyCtrlC:
 				return m, tea.Quit
 			}
@@ -709,23 +1146,39 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			switch msg.String() {
 			case "y", "Y":
 				m.loading = true
-				fromAddr := ""
-				if len(m.identities) > 0 {
-					fromAddr = m.identities[m.identityIdx]
-				}
-				return m, sendEmailCmd(m.client, m.draftID, fromAddr, m.inputTo.Value(), m.inputSubject.Value(), m.composeBody)
+				return m, m.sendCmd(m.composeDraft())
 			case "s", "S":
 				m.loading = true
-				fromAddr := ""
-				if len(m.identities) > 0 {
-					fromAddr = m.identities[m.identityIdx]
-				}
-				return m, saveDraftCmd(m.client, m.draftID, fromAddr, m.inputTo.Value(), m.inputSubject.Value(), m.composeBody)
+				return m, m.saveCmd(m.composeDraft())
 			case "n", "N":
 				m.state = viewMailboxes
 				m.composeBody = ""
+				m.composeAttachments = nil
 				os.Remove(m.tempFile)
 				return m, nil
+			case "a", "A":
+				m.attaching = true
+				m.status = ""
+				m.attachInput.SetValue("")
+				m.attachInput.Focus()
+				return m, textinput.Blink
+			case "m", "M":
+				m.composeMarkdown = !m.composeMarkdown
+				if !m.composeMarkdown {
+					m.composePreview = false
+				}
+				return m, nil
+			case "p", "P":
+				if m.composeMarkdown {
+					m.composePreview = !m.composePreview
+				}
+				return m, nil
+			case "x", "X":
+				// Drop the most recently attached file
+				if n := len(m.composeAttachments); n > 0 {
+					m.composeAttachments = m.composeAttachments[:n-1]
+				}
+				return m, nil
 			case "e", "E":
 				editor := os.Getenv("EDITOR")
 				if editor == "" {
@@ -747,6 +1200,76 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 	}
 
+	// Handle Attachment Save Prompt
+	if m.state == viewBody && m.savingAttachment {
+		m.saveDirInput, cmd = m.saveDirInput.Update(msg)
+
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch msg.Type {
+			case tea.KeyEnter:
+				m.savingAttachment = false
+				m.saveDirInput.Blur()
+				dir := m.saveDirInput.Value()
+				if dir == "" {
+					dir = m.saveDirInput.Placeholder
+				}
+				if m.db != nil {
+					m.db.SetConfig("download_dir", dir)
+				}
+				att := m.attachments[m.attachmentCursor]
+				m.status = "Saving " + att.Name + "..."
+				client := m.client
+				if m.offlineMode {
+					client = nil
+				}
+				return m, saveAttachmentCmd(client, m.db, att, dir, m.cacheAttachments)
+			case tea.KeyEsc:
+				m.savingAttachment = false
+				m.saveDirInput.Blur()
+				return m, nil
+			case tea.KeyCtrlC:
+				return m, tea.Quit
+			}
+		}
+		return m, cmd
+	}
+
+	// Handle Search Prompt
+	if m.state == viewEmails && m.searching {
+		m.searchInput, cmd = m.searchInput.Update(msg)
+
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch msg.Type {
+			case tea.KeyEnter:
+				m.searching = false
+				m.searchInput.Blur()
+				query := model.ParseSearchQuery(m.searchInput.Value())
+				if query.IsEmpty() {
+					return m, nil
+				}
+				m.searchQuery = &query
+				m.emails = nil
+				m.emailCursor = 0
+				m.emailOffset = 0
+				m.loading = true
+				m.canLoadMore = true
+				if m.offlineMode || m.client == nil {
+					return m, searchEmailsOfflineCmd(m.db, query, 0)
+				}
+				return m, searchEmailsCmd(m.client, query, 0)
+			case tea.KeyEsc:
+				m.searching = false
+				m.searchInput.Blur()
+				return m, nil
+			case tea.KeyCtrlC:
+				return m, tea.Quit
+			}
+		}
+		return m, cmd
+	}
+
 	// Normal Navigation States
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
@@ -755,6 +1278,43 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.err = nil
 			return m, nil
 		}
+		m.status = ""
+
+		if m.state == viewOutbox {
+			if next, cmd, handled := m.updateOutbox(msg); handled {
+				return next, cmd
+			}
+		}
+		if m.state == viewAccounts {
+			if next, cmd, handled := m.updateAccounts(msg); handled {
+				return next, cmd
+			}
+		}
+		if m.state == viewMailboxes {
+			if next, cmd, handled := m.updateMailboxes(msg); handled {
+				return next, cmd
+			}
+		}
+		if m.state == viewEmails && m.destroyConfirm != nil {
+			return m.updateDestroyConfirm(msg)
+		}
+		if m.state == viewEmails {
+			if next, cmd, handled := m.updateMarks(msg); handled {
+				return next, cmd
+			}
+		}
+		if m.state == viewCalendar && m.eventScope != "" {
+			if next, cmd, handled := m.updateEventScope(msg); handled {
+				return next, cmd
+			}
+		}
+		// Unified inbox entries are acted on with their own account
+		if m.unified && m.state == viewEmails && !m.searching {
+			if cmd := m.followUnifiedCursor(); cmd != nil {
+				next, keyCmd := m.Update(msg)
+				return next, tea.Batch(cmd, keyCmd)
+			}
+		}
 
 		switch msg.String() {
 		case "ctrl+c":
@@ -773,8 +1333,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		case "1":
 			// Go to Mail
-			if m.state != viewComposeTo && m.state != viewComposeSubject && m.state != viewComposeConfirm {
+			if !m.composing() {
 				m.state = viewMailboxes
+				m.unified = false
 				m.loading = true
 				if m.offlineMode || m.client == nil {
 					return m, fetchMailboxesOfflineCmd(m.db)
@@ -783,7 +1344,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		case "2":
 			// Go to Calendar
-			if m.state != viewComposeTo && m.state != viewComposeSubject && m.state != viewComposeConfirm {
+			if !m.composing() {
 				m.state = viewCalendar
 				if len(m.calendars) == 0 && m.client != nil && !m.offlineMode && m.davClient != nil {
 					m.loading = true
@@ -793,7 +1354,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		case "3":
 			// Go to Contacts
-			if m.state != viewComposeTo && m.state != viewComposeSubject && m.state != viewComposeConfirm {
+			if !m.composing() {
 				m.state = viewContacts
 				m.contactCursor = 0 // Reset cursor
 				if len(m.addressBooks) == 0 && m.client != nil && !m.offlineMode && m.davClient != nil {
@@ -815,53 +1376,54 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		case "4":
 			// Go to Settings
-			if m.state != viewComposeTo && m.state != viewComposeSubject && m.state != viewComposeConfirm {
+			if !m.composing() {
 				m.state = viewSettings
 				return m, nil
 			}
+		case "5":
+			// Go to Outbox
+			if !m.composing() {
+				m.state = viewOutbox
+				m.pendingCursor = 0
+				return m, fetchPendingActionsCmd(m.db)
+			}
+		case "6":
+			// Go to Accounts
+			if !m.composing() {
+				m.state = viewAccounts
+				m.accountCursor = m.accountIdx
+				return m, nil
+			}
 
 		case "d", "backspace":
 			if m.state == viewEmails && len(m.emails) > 0 {
-				if m.offlineMode {
-					m.err = fmt.Errorf("cannot delete emails in offline mode")
+				// In thread mode the conversation's messages in this mailbox
+				// go; replies in Sent stay where they are, and a copy in the
+				// Inbox isn't destroyed from the Trash
+				ids := m.cursorMailboxEmailIDs(m.sourceMailbox(m.emails[m.emailCursor]))
+				if m.listingTrash() {
+					m.destroyConfirm = ids
 					return m, nil
 				}
-				if m.client == nil {
-					m.err = fmt.Errorf("not connected")
+				trashID := m.roleMailbox("trash")
+				if trashID == "" {
+					m.status = "There is no Trash folder to move it to"
 					return m, nil
 				}
+				m.recordTrash(ids, trashID)
 				m.loading = true
-				selectedEmail := m.emails[m.emailCursor]
 				// Optimistic UI update
-				if m.emailCursor < len(m.emails)-1 {
-					m.emails = append(m.emails[:m.emailCursor], m.emails[m.emailCursor+1:]...)
-				} else {
-					m.emails = m.emails[:m.emailCursor]
-					if m.emailCursor > 0 {
-						m.emailCursor--
-					}
-				}
-				return m, deleteEmailCmd(m.client, selectedEmail.ID)
+				m.dropCursorEmail()
+				return m, m.trashCmd(ids, trashID)
 			} else if m.state == viewCalendar && len(m.events) > 0 && !m.offlineMode && m.davClient != nil {
 				if m.viewEventDetail || m.editingEvent == nil {
-					m.loading = true
-					eventID := m.events[m.eventCursor].ID
-					// Optimistic UI update
-					if m.eventCursor < len(m.events)-1 {
-						m.events = append(m.events[:m.eventCursor], m.events[m.eventCursor+1:]...)
-					} else {
-						m.events = m.events[:m.eventCursor]
-						if m.eventCursor > 0 {
-							m.eventCursor--
-						}
-					}
-					m.viewEventDetail = false
-					return m, deleteEventCmd(m.davClient, eventID)
+					cmd := m.deleteEvent()
+					return m, cmd
 				}
 			} else if m.state == viewContacts && len(m.contacts) > 0 && !m.offlineMode && m.davClient != nil {
 				if m.viewContactDetail || m.editingContact == nil {
 					m.loading = true
-					contactID := m.contacts[m.contactCursor].ID
+					contact := m.contacts[m.contactCursor]
 					// Optimistic UI update
 					if m.contactCursor < len(m.contacts)-1 {
 						m.contacts = append(m.contacts[:m.contactCursor], m.contacts[m.contactCursor+1:]...)
@@ -872,24 +1434,95 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						}
 					}
 					m.viewContactDetail = false
-					return m, deleteContactCmd(m.davClient, contactID)
+					return m, deleteContactCmd(m.davClient, contact)
 				}
 			}
 
 		case "u":
 			if m.state == viewEmails && len(m.emails) > 0 {
 				selectedEmail := m.emails[m.emailCursor]
+				if t, ok := m.cursorThread(); ok {
+					// Mark the whole conversation read, or unread if it all is
+					newState := !t.HasUnread()
+					m.recordRead(t.EmailIDs(), newState)
+					for i := range t.Emails {
+						t.Emails[i].IsUnread = newState
+					}
+					m.emails[m.emailCursor].IsUnread = newState
+					return m, m.setUnreadCmd(t.EmailIDs(), newState)
+				}
 				newState := !selectedEmail.IsUnread
+				m.recordRead([]string{selectedEmail.ID}, newState)
 				m.emails[m.emailCursor].IsUnread = newState
-				return m, toggleUnreadCmd(m.client, selectedEmail.ID, newState)
+				return m, m.setUnreadCmd([]string{selectedEmail.ID}, newState)
 			}
 
 		case "f":
 			if m.state == viewEmails && len(m.emails) > 0 {
 				selectedEmail := m.emails[m.emailCursor]
+				if t, ok := m.cursorThread(); ok && t.IsFlagged() {
+					// Unflag every message so the thread no longer shows as flagged
+					m.recordFlag(t.EmailIDs(), false)
+					for i := range t.Emails {
+						t.Emails[i].IsFlagged = false
+					}
+					m.emails[m.emailCursor].IsFlagged = false
+					return m, m.setFlaggedCmd(t.EmailIDs(), false)
+				} else if ok {
+					// Flag the newest message, as other clients do
+					m.recordFlag([]string{t.Emails[len(t.Emails)-1].ID}, true)
+					t.Emails[len(t.Emails)-1].IsFlagged = true
+					m.emails[m.emailCursor].IsFlagged = true
+					return m, m.setFlaggedCmd([]string{t.Emails[len(t.Emails)-1].ID}, true)
+				}
 				newState := !selectedEmail.IsFlagged
+				m.recordFlag([]string{selectedEmail.ID}, newState)
 				m.emails[m.emailCursor].IsFlagged = newState
-				return m, toggleFlaggedCmd(m.client, selectedEmail.ID, newState)
+				return m, m.setFlaggedCmd([]string{selectedEmail.ID}, newState)
+			}
+
+		case "t":
+			// Toggle conversation view (search results stay flat)
+			if m.state == viewEmails && m.searchQuery == nil && !m.unified && len(m.mailboxes) > 0 {
+				m.threadMode = !m.threadMode
+				if m.db != nil {
+					if m.threadMode {
+						m.db.SetConfig("thread_mode", "true")
+					} else {
+						m.db.SetConfig("thread_mode", "false")
+					}
+				}
+				m.emails = nil
+				m.emailCursor = 0
+				m.emailOffset = 0
+				m.loading = true
+				m.canLoadMore = true
+				return m, m.emailPageCmd(0)
+			}
+
+		case "z":
+			// Undo the last mail action
+			if m.state == viewMailboxes || m.state == viewEmails || m.state == viewBody {
+				return m.undoLast()
+			}
+
+		case "M", "C":
+			// Move or copy to a folder picked from a list
+			if (m.state == viewEmails || m.state == viewBody) && !m.attachmentFocus {
+				action := "move"
+				if msg.String() == "C" {
+					action = "copy"
+				}
+				next, cmd := m.openFolderPicker(action)
+				return next, tea.Batch(cmd, textinput.Blink)
+			}
+
+		case "p":
+			// Previous message in the thread reader
+			if m.state == viewBody && m.thread != nil && m.threadPos > 0 {
+				m.threadPos--
+				m.loading = true
+				return m, m.threadMessageCmd()
 			}
 		
 		case "e":
@@ -905,29 +1538,22 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 				if targetMBID != "" {
 					m.loading = true
-					selectedEmail := m.emails[m.emailCursor]
-					currentMBID := m.mailboxes[m.mbCursor].ID
-					
+					currentMBID := m.sourceMailbox(m.emails[m.emailCursor])
+					ids := m.cursorMailboxEmailIDs(currentMBID)
+					m.recordMove("archiving "+messageCount(len(ids)), fromMailbox(ids, currentMBID), targetMBID)
 					// Optimistic UI update
-					if m.emailCursor < len(m.emails)-1 {
-						m.emails = append(m.emails[:m.emailCursor], m.emails[m.emailCursor+1:]...)
-					} else {
-						m.emails = m.emails[:m.emailCursor]
-						if m.emailCursor > 0 {
-							m.emailCursor--
-						}
-					}
-					
-					return m, moveEmailCmd(m.client, selectedEmail.ID, currentMBID, targetMBID)
+					m.dropCursorEmail()
+					return m, m.moveCmd(ids, currentMBID, targetMBID)
 				}
 			} else if m.state == viewBody {
 				// If viewing a draft, 'e' edits it
-				if len(m.emails) > m.emailCursor {
-					selectedEmail := m.emails[m.emailCursor]
+				if selectedEmail, ok := m.readingEmail(); ok {
 					if selectedEmail.IsDraft {
 						m.state = viewComposeTo
 						m.draftID = selectedEmail.ID
 						m.inputTo.SetValue(selectedEmail.To)
+						m.inputCc.SetValue(selectedEmail.Cc)
+						m.inputBcc.SetValue(selectedEmail.Bcc)
 						m.inputSubject.SetValue(selectedEmail.Subject)
 						
 						// Prepare body
@@ -936,6 +1562,8 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 							body = strings.TrimPrefix(body, "[Converted HTML]\n")
 						}
 						m.composeBody = body
+						// Keep the draft's attachments; their blobs are reused when it's saved again
+						m.composeAttachments = append([]model.Attachment(nil), m.attachments...)
 						
 						// Determine focus
 						if m.inputTo.Value() == "" {
@@ -951,12 +1579,8 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 			} else if m.state == viewCalendar && m.viewEventDetail && len(m.events) > 0 && !m.offlineMode {
 				// Edit event
-				event := m.events[m.eventCursor]
-				m.editingEvent = &event
-				m.viewEventDetail = false
-				m.eventInput.SetValue(event.Title)
-				m.eventInput.Focus()
-				return m, textinput.Blink
+				cmd := m.openEventEditor(m.events[m.eventCursor])
+				return m, cmd
 			} else if m.state == viewContacts && m.viewContactDetail && len(m.contacts) > 0 && !m.offlineMode {
 				// Edit contact
 				contact := m.contacts[m.contactCursor]
@@ -970,12 +1594,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		case "c":
 			m.state = viewComposeTo
-			m.draftID = "" // New email
-			m.inputTo.SetValue("")
-			m.inputSubject.SetValue("")
-			m.composeBody = ""
-			m.showSuggestions = false
-			m.toSuggestions = nil
+			m.resetCompose() // New email
 			m.inputTo.Focus()
 			// Fetch contacts for autocomplete if not already loaded
 			if m.davClient != nil && !m.offlineMode {
@@ -996,10 +1615,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, textinput.Blink
 
 		case "R": // Reply to sender
-			if m.state == viewBody && len(m.emails) > 0 {
-				selectedEmail := m.emails[m.emailCursor]
+			if selectedEmail, ok := m.readingEmail(); ok && m.state == viewBody {
 				m.state = viewComposeTo
-				m.draftID = ""
+				m.resetCompose()
 				// Use ReplyTo if available, otherwise From
 				replyTo := selectedEmail.From
 				if selectedEmail.ReplyTo != "" {
@@ -1016,28 +1634,17 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.composeBody = fmt.Sprintf("\n\n--- Original Message ---\nFrom: %s\nDate: %s\nSubject: %s\n\n%s",
 					selectedEmail.From, selectedEmail.Date, selectedEmail.Subject, m.bodyContent)
 				m.inputTo.Focus()
-				return m, textinput.Blink
+				return m, tea.Batch(textinput.Blink, m.startReply(selectedEmail, false))
 			}
 
 		case "A": // Reply all
-			if m.state == viewBody && len(m.emails) > 0 {
-				selectedEmail := m.emails[m.emailCursor]
+			if selectedEmail, ok := m.readingEmail(); ok && m.state == viewBody {
 				m.state = viewComposeTo
-				m.draftID = ""
-				// Combine From (or ReplyTo), To, and Cc for reply-all
-				var recipients []string
-				replyTo := selectedEmail.From
-				if selectedEmail.ReplyTo != "" {
-					replyTo = selectedEmail.ReplyTo
-				}
-				recipients = append(recipients, replyTo)
-				if selectedEmail.To != "" {
-					recipients = append(recipients, selectedEmail.To)
-				}
-				if selectedEmail.Cc != "" {
-					recipients = append(recipients, selectedEmail.Cc)
-				}
-				m.inputTo.SetValue(strings.Join(recipients, ", "))
+				m.resetCompose()
+				// Sender and original To go in To, original Cc stays in Cc
+				to, cc := replyAllRecipients(selectedEmail, m.identities)
+				m.inputTo.SetValue(to)
+				m.inputCc.SetValue(cc)
 				// Add Re: prefix if not already present
 				subject := selectedEmail.Subject
 				if !strings.HasPrefix(strings.ToLower(subject), "re:") {
@@ -1048,15 +1655,15 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.composeBody = fmt.Sprintf("\n\n--- Original Message ---\nFrom: %s\nDate: %s\nSubject: %s\n\n%s",
 					selectedEmail.From, selectedEmail.Date, selectedEmail.Subject, m.bodyContent)
 				m.inputTo.Focus()
-				return m, textinput.Blink
+				return m, tea.Batch(textinput.Blink, m.startReply(selectedEmail, false))
 			}
 
 		case "F": // Forward
-			if m.state == viewBody && len(m.emails) > 0 {
-				selectedEmail := m.emails[m.emailCursor]
+			if selectedEmail, ok := m.readingEmail(); ok && m.state == viewBody {
 				m.state = viewComposeTo
-				m.draftID = ""
-				m.inputTo.SetValue("") // User needs to enter recipient
+				m.resetCompose() // User needs to enter recipient
+				// Forward the original attachments by their existing blobs
+				m.composeAttachments = append([]model.Attachment(nil), m.attachments...)
 				// Add Fwd: prefix if not already present
 				subject := selectedEmail.Subject
 				if !strings.HasPrefix(strings.ToLower(subject), "fwd:") && !strings.HasPrefix(strings.ToLower(subject), "fw:") {
@@ -1067,6 +1674,51 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.composeBody = fmt.Sprintf("\n\n--- Forwarded Message ---\nFrom: %s\nTo: %s\nDate: %s\nSubject: %s\n\n%s",
 					selectedEmail.From, selectedEmail.To, selectedEmail.Date, selectedEmail.Subject, m.bodyContent)
 				m.inputTo.Focus()
+				return m, tea.Batch(textinput.Blink, m.startReply(selectedEmail, true))
+			}
+
+		case "a":
+			// Move the keyboard into the attachment pane and back
+			if m.state == viewBody && len(m.attachments) > 0 {
+				m.attachmentFocus = !m.attachmentFocus
+				return m, nil
+			}
+
+		case "s":
+			if m.state == viewBody && m.attachmentFocus && m.attachmentCursor < len(m.attachments) {
+				dir := ""
+				if m.db != nil {
+					dir, _ = m.db.GetConfig("download_dir")
+				}
+				if dir == "" {
+					dir = defaultDownloadDir()
+				}
+				m.saveDirInput.Placeholder = dir
+				m.saveDirInput.SetValue(dir)
+				m.saveDirInput.Focus()
+				m.savingAttachment = true
+				return m, textinput.Blink
+			}
+
+		case "o":
+			if m.state == viewBody && m.attachmentFocus && m.attachmentCursor < len(m.attachments) {
+				m.status = "Opening " + m.attachments[m.attachmentCursor].Name + "..."
+				client := m.client
+				if m.offlineMode {
+					client = nil
+				}
+				return m, openAttachmentCmd(client, m.db, m.attachments[m.attachmentCursor], m.cacheAttachments)
+			}
+
+		case "/":
+			if m.state == viewEmails && !m.unified {
+				m.searching = true
+				if m.searchQuery != nil {
+					m.searchInput.SetValue(m.searchQuery.Raw)
+				} else {
+					m.searchInput.SetValue("")
+				}
+				m.searchInput.Focus()
 				return m, textinput.Blink
 			}
 
@@ -1078,6 +1730,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			// 'm' also goes to Mail from main menu
 			if m.state == viewMainMenu {
 				m.state = viewMailboxes
+				m.unified = false
 				m.loading = true
 				if m.offlineMode || m.client == nil {
 					return m, fetchMailboxesOfflineCmd(m.db)
@@ -1103,9 +1756,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.menuCursor--
 				}
 				return m, nil
-			} else if m.state == viewMailboxes {
-				if m.mbCursor > 0 {
-					m.mbCursor--
+			} else if m.state == viewBody && m.attachmentFocus {
+				if m.attachmentCursor > 0 {
+					m.attachmentCursor--
 				}
 				return m, nil
 			} else if m.state == viewEmails {
@@ -1139,9 +1792,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.menuCursor++
 				}
 				return m, nil
-			} else if m.state == viewMailboxes {
-				if m.mbCursor < len(m.mailboxes)-1 {
-					m.mbCursor++
+			} else if m.state == viewBody && m.attachmentFocus {
+				if m.attachmentCursor < len(m.attachments)-1 {
+					m.attachmentCursor++
 				}
 				return m, nil
 			} else if m.state == viewEmails {
@@ -1158,13 +1811,15 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					if m.emailCursor >= m.emailOffset+pageHeight {
 						m.emailOffset++
 					}
-				} else if m.canLoadMore && !m.loading {
+				} else if m.canLoadMore && !m.loading && m.searchQuery != nil {
 					m.loading = true
-					selectedMB := m.mailboxes[m.mbCursor]
 					if m.offlineMode || m.client == nil {
-						return m, fetchEmailsOfflineCmd(m.db, selectedMB.ID, len(m.emails))
+						return m, searchEmailsOfflineCmd(m.db, *m.searchQuery, len(m.emails))
 					}
-					return m, fetchEmailsCmd(m.client, m.db, selectedMB.ID, len(m.emails))
+					return m, searchEmailsCmd(m.client, *m.searchQuery, len(m.emails))
+				} else if m.canLoadMore && !m.loading {
+					m.loading = true
+					return m, m.emailPageCmd(len(m.emails))
 				}
 				return m, nil
 			} else if m.state == viewCalendar && !m.viewEventDetail && m.editingEvent == nil {
@@ -1178,7 +1833,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 				return m, nil
 			} else if m.state == viewSettings {
-				if m.settingsCursor < 1 { // Only 1 setting currently
+				if m.settingsCursor < 2 { // Three settings currently
 					m.settingsCursor++
 				}
 				return m, nil
@@ -1189,7 +1844,14 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				// Navigate to selected menu item
 				selectedItem := mainMenuItems[m.menuCursor]
 				m.state = selectedItem.State
-				if selectedItem.State == viewMailboxes {
+				if selectedItem.State == viewOutbox {
+					m.pendingCursor = 0
+					return m, fetchPendingActionsCmd(m.db)
+				} else if selectedItem.State == viewAccounts {
+					m.accountCursor = m.accountIdx
+					return m, nil
+				} else if selectedItem.State == viewMailboxes {
+					m.unified = false
 					m.loading = true
 					if m.offlineMode || m.client == nil {
 						return m, fetchMailboxesOfflineCmd(m.db)
@@ -1234,20 +1896,26 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			} else if m.state == viewMailboxes && len(m.mailboxes) > 0 {
 				m.state = viewEmails
+				m.unified = false
 				m.emailCursor = 0 // reset cursor
 				m.emailOffset = 0 // reset offset
 				m.emails = nil    // clear previous
+				m.searchQuery = nil
+				m.clearMarks()
 				m.loading = true
 				m.canLoadMore = true
-				selectedMB := m.mailboxes[m.mbCursor]
-				if m.offlineMode || m.client == nil {
-					return m, fetchEmailsOfflineCmd(m.db, selectedMB.ID, 0)
-				}
-				return m, fetchEmailsCmd(m.client, m.db, selectedMB.ID, 0)
+				return m, m.emailPageCmd(0)
 			} else if m.state == viewEmails && len(m.emails) > 0 {
 				// Always go to preview first, even for drafts
 				m.state = viewBody
 				m.loading = true
+				if t, ok := m.cursorThread(); ok {
+					// Open the conversation at its newest message
+					m.thread = &t
+					m.threadPos = len(t.Emails) - 1
+					return m, m.threadMessageCmd()
+				}
+				m.thread = nil
 				selectedEmail := m.emails[m.emailCursor]
 				if m.offlineMode || m.client == nil {
 					return m, fetchEmailBodyOfflineCmd(m.db, selectedEmail.ID)
@@ -1264,14 +1932,40 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			} else if m.state == viewSettings {
 				// Toggle offline mode
 				if m.settingsCursor == 0 {
-					m.offlineMode = !m.offlineMode
+					m.forceOffline = !m.forceOffline
 					if m.db != nil {
-						if m.offlineMode {
+						if m.forceOffline {
 							m.db.SetConfig("offline_mode", "true")
 						} else {
 							m.db.SetConfig("offline_mode", "false")
 						}
 					}
+					if m.forceOffline {
+						m.offlineMode = true
+						return m, nil
+					}
+					// Going online once the server answers, building the
+					// clients first if the app started without them
+					m.status = "Connecting..."
+					return m, checkConnectivityCmd(m.client, m.creds, m.accountName(), false)
+				} else if m.settingsCursor == 1 {
+					m.cacheAttachments = !m.cacheAttachments
+					if m.db != nil {
+						if m.cacheAttachments {
+							m.db.SetConfig("cache_attachments", "true")
+						} else {
+							m.db.SetConfig("cache_attachments", "false")
+						}
+					}
+				} else if m.settingsCursor == 2 {
+					m.markdownDefault = !m.markdownDefault
+					if m.db != nil {
+						if m.markdownDefault {
+							m.db.SetConfig("compose_markdown", "true")
+						} else {
+							m.db.SetConfig("compose_markdown", "false")
+						}
+					}
 				}
 				return m, nil
 			}
@@ -1280,6 +1974,23 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if m.state == viewMailboxes {
 				m.state = viewMainMenu
 				return m, nil
+			} else if m.state == viewBody && m.attachmentFocus {
+				m.attachmentFocus = false
+				return m, nil
+			} else if m.state == viewEmails && m.unified {
+				m.state = viewAccounts
+				m.unified = false
+				m.emails = nil
+				return m, nil
+			} else if m.state == viewEmails && m.searchQuery != nil {
+				// Leave search results and return to the mailbox listing
+				m.searchQuery = nil
+				m.emails = nil
+				m.emailCursor = 0
+				m.emailOffset = 0
+				m.loading = true
+				m.canLoadMore = true
+				return m, m.emailPageCmd(0)
 			} else if m.state == viewEmails {
 				m.state = viewMailboxes
 				m.emails = nil
@@ -1292,6 +2003,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.state = viewEmails
 				m.bodyContent = ""
 				m.htmlBody = ""
+				m.thread = nil
+				m.attachments = nil
+				m.attachmentFocus = false
 			} else if m.state == viewCalendar {
 				if m.viewEventDetail {
 					m.viewEventDetail = false
@@ -1323,6 +2037,30 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return m, fetchMailboxesOfflineCmd(m.db)
 				}
 				return m, fetchMailboxesCmd(m.client, m.db)
+			} else if m.state == viewEmails && m.unified {
+				m.loading = true
+				m.emails = nil
+				m.emailCursor = 0
+				m.emailOffset = 0
+				return m, unifiedInboxCmd(m.sessions(), m.offlineMode)
+			} else if m.state == viewEmails && m.searchQuery != nil {
+				m.loading = true
+				m.emails = nil
+				m.emailCursor = 0
+				m.emailOffset = 0
+				if m.offlineMode || m.client == nil {
+					return m, searchEmailsOfflineCmd(m.db, *m.searchQuery, 0)
+				}
+				return m, searchEmailsCmd(m.client, *m.searchQuery, 0)
+			} else if m.state == viewEmails && m.threadMode && len(m.mailboxes) > 0 {
+				m.loading = true
+				m.emails = nil
+				m.emailCursor = 0
+				m.emailOffset = 0
+				if m.offlineMode || m.client == nil {
+					return m, tea.Batch(fetchMailboxesOfflineCmd(m.db), m.emailPageCmd(0))
+				}
+				return m, tea.Batch(fetchMailboxesCmd(m.client, m.db), m.emailPageCmd(0))
 			} else if m.state == viewEmails && len(m.mailboxes) > 0 {
 				m.loading = true
 				selectedMB := m.mailboxes[m.mbCursor]
@@ -1350,29 +2088,36 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		// Calendar-specific keys
 		case "n":
-			if m.state == viewCalendar && !m.viewEventDetail && m.editingEvent == nil && !m.offlineMode {
+			if m.state == viewBody && m.thread != nil {
+				// Next message in the thread reader
+				if m.threadPos < len(m.thread.Emails)-1 {
+					m.threadPos++
+					m.loading = true
+					return m, m.threadMessageCmd()
+				}
+				return m, nil
+			} else if m.state == viewCalendar && !m.viewEventDetail && m.editingEvent == nil && !m.offlineMode {
 				// Create new event
-				m.editingEvent = &model.CalendarEvent{
+				event := model.CalendarEvent{
 					Start: time.Now().Truncate(time.Hour).Add(time.Hour),
 				}
 				// Set default calendar
 				for _, cal := range m.calendars {
 					if cal.IsDefault && cal.MayAddItems {
-						m.editingEvent.CalendarID = cal.ID
+						event.CalendarID = cal.ID
 						break
 					}
 				}
-				if m.editingEvent.CalendarID == "" && len(m.calendars) > 0 {
+				if event.CalendarID == "" && len(m.calendars) > 0 {
 					for _, cal := range m.calendars {
 						if cal.MayAddItems {
-							m.editingEvent.CalendarID = cal.ID
+							event.CalendarID = cal.ID
 							break
 						}
 					}
 				}
-				m.eventInput.SetValue("")
-				m.eventInput.Focus()
-				return m, textinput.Blink
+				cmd := m.openEventEditor(event)
+				return m, cmd
 			} else if m.state == viewContacts && !m.viewContactDetail && m.editingContact == nil && !m.offlineMode {
 				// Create new contact
 				m.editingContact = &model.Contact{}
@@ -1403,7 +2148,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.height = msg.Height
 
 	case mailboxesLoadedMsg:
-		m.mailboxes = msg
+		m.setMailboxes(msg, "")
 		m.loading = false
 
 	case emailsLoadedMsg:
@@ -1477,6 +2222,260 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// cursorThread returns the conversation under the cursor in thread mode
+func (m Model) cursorThread() (model.Thread, bool) {
+	if !m.threadMode || m.searchQuery != nil || m.unified || m.emailCursor >= len(m.emails) {
+		return model.Thread{}, false
+	}
+	t, ok := m.threads[m.emails[m.emailCursor].ThreadID]
+	if !ok || len(t.Emails) == 0 {
+		return model.Thread{}, false
+	}
+	return t, true
+}
+
+// cursorEmailIDs returns the emails an action at the cursor applies to: the
+// whole conversation in thread mode, otherwise just the selected email
+func (m Model) cursorEmailIDs() []string {
+	if t, ok := m.cursorThread(); ok {
+		return t.EmailIDs()
+	}
+	return []string{m.emails[m.emailCursor].ID}
+}
+
+// readingEmail returns the message shown in viewBody
+func (m Model) readingEmail() (model.Email, bool) {
+	if m.thread != nil && m.threadPos < len(m.thread.Emails) {
+		return m.thread.Emails[m.threadPos], true
+	}
+	if m.emailCursor < len(m.emails) {
+		return m.emails[m.emailCursor], true
+	}
+	return model.Email{}, false
+}
+
+// emailPageCmd loads a page of the open mailbox, one entry per conversation
+// in thread mode
+func (m Model) emailPageCmd(offset int) tea.Cmd {
+	mailboxID := m.mailboxes[m.mbCursor].ID
+	if m.offlineMode || m.client == nil {
+		if m.threadMode {
+			return fetchThreadedEmailsOfflineCmd(m.db, mailboxID, offset)
+		}
+		return fetchEmailsOfflineCmd(m.db, mailboxID, offset)
+	}
+	if m.threadMode {
+		return fetchThreadedEmailsCmd(m.client, m.db, mailboxID, offset)
+	}
+	return fetchEmailsCmd(m.client, m.db, mailboxID, offset)
+}
+
+// threadsCmd loads the conversations the given emails belong to
+func (m Model) threadsCmd(emails []model.Email) tea.Cmd {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, e := range emails {
+		if e.ThreadID != "" && !seen[e.ThreadID] {
+			seen[e.ThreadID] = true
+			ids = append(ids, e.ThreadID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if m.offlineMode || m.client == nil {
+		return fetchThreadsOfflineCmd(m.db, ids)
+	}
+	return fetchThreadsCmd(m.client, m.db, ids)
+}
+
+// threadMessageCmd loads the body of the thread message being read
+func (m Model) threadMessageCmd() tea.Cmd {
+	e := m.thread.Emails[m.threadPos]
+	if m.offlineMode || m.client == nil {
+		return fetchEmailBodyOfflineCmd(m.db, e.ID)
+	}
+	return fetchEmailBodyCmd(m.client, m.db, e.ID)
+}
+
+// composing reports whether one of the compose screens is open
+func (m Model) composing() bool {
+	switch m.state {
+	case viewComposeTo, viewComposeCc, viewComposeBcc, viewComposeSubject, viewComposeConfirm:
+		return true
+	}
+	return false
+}
+
+// recipientInput returns the address field being edited
+func (m *Model) recipientInput() *textinput.Model {
+	switch m.state {
+	case viewComposeCc:
+		return &m.inputCc
+	case viewComposeBcc:
+		return &m.inputBcc
+	}
+	return &m.inputTo
+}
+
+// focusComposeField moves to another compose header field
+func (m *Model) focusComposeField(state sessionState) tea.Cmd {
+	m.inputTo.Blur()
+	m.inputCc.Blur()
+	m.inputBcc.Blur()
+	m.inputSubject.Blur()
+	m.showSuggestions = false
+	m.toSuggestions = nil
+
+	m.state = state
+	switch state {
+	case viewComposeSubject:
+		m.inputSubject.Focus()
+	default:
+		m.recipientInput().Focus()
+	}
+	return textinput.Blink
+}
+
+// resetCompose clears the compose fields for a new message
+func (m *Model) resetCompose() {
+	m.draftID = ""
+	m.inputTo.SetValue("")
+	m.inputCc.SetValue("")
+	m.inputBcc.SetValue("")
+	m.inputSubject.SetValue("")
+	m.composeBody = ""
+	m.composeAttachments = nil
+	m.replyContext = nil
+	m.composeMarkdown = m.markdownDefault
+	m.composePreview = false
+	m.showSuggestions = false
+	m.toSuggestions = nil
+}
+
+// composeDraft collects the compose fields into an outgoing message
+func (m Model) composeDraft() model.Draft {
+	from := ""
+	if len(m.identities) > 0 {
+		from = m.identities[m.identityIdx]
+	}
+	draft := model.Draft{
+		From:        from,
+		To:          m.inputTo.Value(),
+		Cc:          m.inputCc.Value(),
+		Bcc:         m.inputBcc.Value(),
+		Subject:     m.inputSubject.Value(),
+		Body:        m.composeBody,
+		Attachments: m.composeAttachments,
+		Reply:       m.replyContext,
+	}
+	if m.composeMarkdown {
+		// Rendering into memory can't fail in practice; if it did the
+		// message simply goes out as plain text
+		draft.HTMLBody, _ = markdownToHTML(m.composeBody)
+	}
+	return draft
+}
+
+// startReply links the message being composed to the one being read and
+// looks up its threading headers
+func (m *Model) startReply(e model.Email, forward bool) tea.Cmd {
+	m.replyContext = &model.ReplyContext{EmailID: e.ID, Forward: forward}
+	if m.offlineMode || m.client == nil || e.IsLocal {
+		return nil
+	}
+	return fetchReplyContextCmd(m.client, e.ID, forward)
+}
+
+// defaultDownloadDir is ~/Downloads when it exists, otherwise the home directory
+func defaultDownloadDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "."
+	}
+	if info, err := os.Stat(filepath.Join(home, "Downloads")); err == nil && info.IsDir() {
+		return filepath.Join(home, "Downloads")
+	}
+	return home
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, strings.TrimPrefix(path, "~"))
+		}
+	}
+	return path
+}
+
+// safeFileName strips directory parts so an attachment name can't write
+// outside the chosen directory
+func safeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	// Control characters could garble the terminal or the name, and a
+	// leading dot would hide the file, or with ".." climb out of the folder
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+	if name == "" || name == "/" {
+		return "attachment"
+	}
+	return name
+}
+
+// uniquePath appends " (n)" before the extension until the path is unused
+func uniquePath(path string) string {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return path
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// formatSize renders a byte count for display
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.0f KB", float64(size)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", size)
+}
+
+// localAttachment describes a file picked in the compose attach prompt; it
+// is uploaded when the message is sent or saved
+func localAttachment(path string) (model.Attachment, error) {
+	path, err := filepath.Abs(expandHome(path))
+	if err != nil {
+		return model.Attachment{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return model.Attachment{}, fmt.Errorf("cannot attach: %w", err)
+	}
+	if info.IsDir() {
+		return model.Attachment{}, fmt.Errorf("cannot attach %s: is a directory", path)
+	}
+	return model.Attachment{
+		Name: info.Name(),
+		Type: mime.TypeByExtension(filepath.Ext(path)),
+		Size: info.Size(),
+		Path: path,
+	}, nil
+}
+
 // filterContacts returns contacts matching the search query
 func filterContacts(contacts []model.Contact, query string) []model.Contact {
 	if query == "" {
@@ -1503,6 +2502,67 @@ func filterContacts(contacts []model.Contact, query string) []model.Contact {
 	return matches
 }
 
+// splitLastRecipient splits a recipient field into the addresses already
+// entered (with their trailing separator) and the one being typed
+func splitLastRecipient(value string) (string, string) {
+	i := strings.LastIndex(value, ",")
+	if i < 0 {
+		return "", strings.TrimSpace(value)
+	}
+	return value[:i+1] + " ", strings.TrimSpace(value[i+1:])
+}
+
+// completeRecipient replaces the address being typed with a contact
+func completeRecipient(value string, c model.Contact) string {
+	prefix, partial := splitLastRecipient(value)
+	if len(c.Emails) == 0 {
+		return prefix + partial
+	}
+	if c.FullName != "" {
+		return prefix + fmt.Sprintf("%s <%s>", c.FullName, c.Emails[0].Email)
+	}
+	return prefix + c.Emails[0].Email
+}
+
+// replyAllRecipients addresses a reply to everyone on an email: the sender
+// (or Reply-To) and the original To recipients go in To, the original Cc
+// stays in Cc. Our own identities and duplicates are left out, unless that
+// would leave nobody to reply to.
+func replyAllRecipients(e model.Email, identities []string) (string, string) {
+	skip := make(map[string]bool)
+	for _, ident := range identities {
+		skip[strings.ToLower(ident)] = true
+	}
+
+	replyTo := e.From
+	if e.ReplyTo != "" {
+		replyTo = e.ReplyTo
+	}
+	to := keepRecipients(replyTo, skip)
+	to = append(to, keepRecipients(e.To, skip)...)
+	cc := keepRecipients(e.Cc, skip)
+
+	if len(to) == 0 && len(cc) == 0 {
+		return replyTo, ""
+	}
+	return strings.Join(to, ", "), strings.Join(cc, ", ")
+}
+
+// keepRecipients returns the addresses of list not in skip, adding each kept
+// address to skip so it isn't repeated in a later field
+func keepRecipients(list string, skip map[string]bool) []string {
+	var kept []string
+	for _, addr := range api.ParseRecipients(list) {
+		key := strings.ToLower(addr.Address)
+		if skip[key] {
+			continue
+		}
+		skip[key] = true
+		kept = append(kept, api.FormatRecipient(addr.Name, addr.Address))
+	}
+	return kept
+}
+
 // Helper to make links clickable (OSC 8)
 func linkify(text string) string {
 	// 1. Convert Markdown links: [Title](URL) -> OSC 8 link
@@ -1679,16 +2739,15 @@ func (m Model) View() string {
 	s.WriteString(titleStyle.Render("FM-CLI"))
 	s.WriteString(" ")
 
-	// Show offline indicator
-	if m.offlineMode {
-		s.WriteString("[OFFLINE] ")
-	}
-
 	// Breadcrumbs based on state
 	switch m.state {
-	case viewMailboxes, viewEmails, viewBody, viewComposeTo, viewComposeSubject, viewComposeConfirm:
+	case viewMailboxes, viewEmails, viewBody, viewComposeTo, viewComposeCc, viewComposeBcc, viewComposeSubject, viewComposeConfirm:
 		s.WriteString("> Mail")
-		if (m.state == viewEmails || m.state == viewBody) && len(m.mailboxes) > 0 {
+		if (m.state == viewEmails || m.state == viewBody) && m.unified {
+			s.WriteString(" > All Inboxes")
+		} else if (m.state == viewEmails || m.state == viewBody) && m.searchQuery != nil {
+			s.WriteString(fmt.Sprintf(" > Search: %s", m.searchQuery.Raw))
+		} else if (m.state == viewEmails || m.state == viewBody) && len(m.mailboxes) > 0 {
 			mb := m.mailboxes[m.mbCursor]
 			s.WriteString(fmt.Sprintf(" > %s", mb.Name))
 		}
@@ -1698,12 +2757,16 @@ func (m Model) View() string {
 		s.WriteString("> Contacts")
 	case viewSettings:
 		s.WriteString("> Settings")
+	case viewOutbox:
+		s.WriteString("> Outbox")
+	case viewAccounts:
+		s.WriteString("> Accounts")
 	}
 	s.WriteString("\n\n")
 
 	// Global shortcuts hint
-	if m.state != viewMainMenu && m.state != viewComposeTo && m.state != viewComposeSubject && m.state != viewComposeConfirm {
-		s.WriteString("(1: Mail  2: Calendar  3: Contacts  4: Settings  0: Menu)\n\n")
+	if m.state != viewMainMenu && !m.composing() {
+		s.WriteString("(1: Mail  2: Calendar  3: Contacts  4: Settings  5: Outbox  6: Accounts  0: Menu)\n\n")
 	}
 
 	if m.state == viewMainMenu {
@@ -1721,26 +2784,15 @@ func (m Model) View() string {
 		s.WriteString("\n(j/k navigate, enter to select, q to quit)")
 
 	} else if m.state == viewMailboxes {
-		if m.loading {
-			s.WriteString("Loading mailboxes...")
-		} else if len(m.mailboxes) == 0 {
-			s.WriteString("No mailboxes found.")
-		}
-		for i, mb := range m.mailboxes {
-			cursor := " "
-			style := mailboxStyle
+		s.WriteString(m.mailboxesView())
 
-			if i == m.mbCursor {
-				cursor = ">"
-				style = selectedMailboxStyle
-			}
-
-			label := fmt.Sprintf("%s %s (%d)", cursor, mb.Name, mb.UnreadCount)
-			s.WriteString(style.Render(label) + "\n")
-		}
-		s.WriteString("\n(j/k navigate, enter/l open, r: refresh, c: compose)")
+	} else if (m.state == viewEmails || m.state == viewBody) && m.picking != "" {
+		s.WriteString(m.folderPickerView())
 
 	} else if m.state == viewEmails {
+		if m.searching {
+			s.WriteString("Search: " + m.searchInput.View() + "\n\n")
+		}
 		if m.loading {
 			s.WriteString("Loading emails using JMAP...\n")
 		} else if len(m.emails) == 0 {
@@ -1779,22 +2831,76 @@ func (m Model) View() string {
 
 				// Format: * ! [Date] From: Subject
 				line := fmt.Sprintf("%s%s [%s] %-20s %s", unreadMarker, flagMarker, e.Date, e.From, e.Subject)
+				if m.unified {
+					line = fmt.Sprintf("%s%s [%s] %-10s %-20s %s", unreadMarker, flagMarker, e.Date, accountLabel(e.Account), e.From, e.Subject)
+				}
+
+				// Conversations show who took part and how many messages
+				if t, ok := m.threads[e.ThreadID]; ok && m.threadMode && m.searchQuery == nil && !m.unified {
+					if t.HasUnread() {
+						unreadMarker = "*"
+						e.IsUnread = true
+					}
+					if t.IsFlagged() {
+						flagMarker = "!"
+					}
+					participants := strings.Join(t.Participants(), ", ")
+					if r := []rune(participants); len(r) > 30 {
+						participants = string(r[:29]) + "…"
+					}
+					count := ""
+					if t.Count() > 1 {
+						count = fmt.Sprintf("(%d) ", t.Count())
+					}
+					line = fmt.Sprintf("%s%s [%s] %-30s %s%s", unreadMarker, flagMarker, e.Date, participants, count, e.Subject)
+				}
+
+				if m.marking() {
+					if m.isMarked(i) {
+						line = "+ " + line
+					} else {
+						line = "  " + line
+					}
+				}
 
 				if e.IsUnread {
 					line = unreadStyle.Render(line)
 				}
 
-				s.WriteString(style.Render(line) + "\n")
+				line = style.Render(line)
+				if snippet := m.snippets[e.ID]; snippet != "" && m.searchQuery != nil {
+					line += "  " + snippetView(snippet)
+				}
+				s.WriteString(line + "\n")
 			}
 		}
-		s.WriteString("\n(h/esc back, j/k navigate, r: refresh, u: read/unread, f: flag, e: archive, d: delete, c: compose)")
+		if m.searching {
+			s.WriteString("\n(enter: search, esc: cancel)")
+		} else if m.destroyConfirm != nil {
+			s.WriteString(fmt.Sprintf("\nPermanently delete %s? This can't be undone. (y/n)", messageCount(len(m.destroyConfirm))))
+		} else if m.keywording {
+			s.WriteString("\nKeyword: " + m.keywordInput.View())
+			s.WriteString("\n(enter: apply, esc: cancel)")
+		} else if m.marking() {
+			marked := len(m.markedEmails())
+			if m.visual {
+				s.WriteString(fmt.Sprintf("\n-- VISUAL -- %s marked (V: end range)", messageCount(marked)))
+			} else {
+				s.WriteString(fmt.Sprintf("\n%s marked", messageCount(marked)))
+			}
+			s.WriteString("\n(space: mark, V: range, *: all, u: read/unread, f: flag, e: archive, M: move, C: copy, K: keyword, d: delete, esc: clear marks)")
+		} else {
+			s.WriteString("\n(h/esc back, j/k navigate, /: search, t: threads, r: refresh, u: read/unread, f: flag, e: archive, M: move, C: copy, d: delete, c: compose, space/V/*: mark)")
+		}
 	
 	} else if m.state == viewBody {
 		if m.loading {
 			s.WriteString("Loading content...\n")
 		} else {
-			if len(m.emails) > m.emailCursor {
-				e := m.emails[m.emailCursor]
+			if e, ok := m.readingEmail(); ok {
+				if m.thread != nil && len(m.thread.Emails) > 1 {
+					s.WriteString(fmt.Sprintf("Message %d of %d in conversation\n", m.threadPos+1, len(m.thread.Emails)))
+				}
 				s.WriteString(fmt.Sprintf("Subject: %s\nFrom:    %s\nDate:    %s\n", e.Subject, e.From, e.Date))
 
 				if m.showDetails {
@@ -1810,8 +2916,25 @@ func (m Model) View() string {
 					if e.ReplyTo != "" {
 						s.WriteString(fmt.Sprintf("ReplyTo: %s\n", e.ReplyTo))
 					}
-					s.WriteString(fmt.Sprintf("ID:      %s\n", e.ID))
-					s.WriteString(fmt.Sprintf("Mailboxes: %v\n", e.MailboxIDs))
+					s.WriteString(fmt.Sprintf("ID:      %s\n", e.ID))
+					s.WriteString(fmt.Sprintf("Mailboxes: %v\n", e.MailboxIDs))
+				}
+
+				if len(m.attachments) > 0 {
+					s.WriteString(fmt.Sprintf("Attachments (%d):\n", len(m.attachments)))
+					for i, a := range m.attachments {
+						cursor := "  "
+						style := mailboxStyle
+						if m.attachmentFocus && i == m.attachmentCursor {
+							cursor = "> "
+							style = selectedMailboxStyle
+						}
+						label := fmt.Sprintf("%s%s (%s, %s)", cursor, a.Name, a.Type, formatSize(a.Size))
+						if a.IsInline {
+							label += " [inline]"
+						}
+						s.WriteString(style.Render(label) + "\n")
+					}
 				}
 
 				s.WriteString("--------------------------------------------------\n\n")
@@ -1822,25 +2945,53 @@ func (m Model) View() string {
 			}
 		}
 		
-		help := "\n\n(h/esc: back, R: reply, A: reply all, F: forward, m: toggle details, b: browser"
+		help := "\n\n(h/esc: back, R: reply, A: reply all, F: forward, M: move, C: copy, m: toggle details, b: browser"
 		if images.HasGraphicsSupport() {
 			help += ", i: images)"
 		} else {
 			help += ")"
 		}
-		if len(m.emails) > m.emailCursor && m.emails[m.emailCursor].IsDraft {
+		if e, ok := m.readingEmail(); ok && e.IsDraft {
 			help = "\n\n(h/esc: back, e: edit draft, m: toggle details, b: browser)"
 		}
+		if m.thread != nil && len(m.thread.Emails) > 1 {
+			help += "\n(n: next message, p: previous message)"
+		}
+		if m.savingAttachment {
+			help = "\n\nSave to: " + m.saveDirInput.View() + "\n(enter: save, esc: cancel)"
+		} else if m.attachmentFocus {
+			help = "\n\n(j/k: select attachment, s: save, o: open, a/esc: back to message)"
+		} else if len(m.attachments) > 0 {
+			help += "\n(a: attachments)"
+		}
 		s.WriteString(help)
+		if m.status != "" {
+			s.WriteString("\n" + m.status)
+		}
 
-	} else if m.state == viewComposeTo {
+	} else if m.state == viewComposeTo || m.state == viewComposeCc || m.state == viewComposeBcc {
 		s.WriteString("Compose New Email\n\n")
 		fromAddr := "(loading...)"
 		if len(m.identities) > 0 {
 			fromAddr = m.identities[m.identityIdx]
 		}
 		s.WriteString("From: " + fromAddr + "  [Tab to change]\n")
-		s.WriteString("To: " + m.inputTo.View() + "\n")
+		fields := []struct {
+			label string
+			state sessionState
+			input textinput.Model
+		}{
+			{"To", viewComposeTo, m.inputTo},
+			{"Cc", viewComposeCc, m.inputCc},
+			{"Bcc", viewComposeBcc, m.inputBcc},
+		}
+		for _, f := range fields {
+			if f.state == m.state {
+				s.WriteString(f.label + ": " + f.input.View() + "\n")
+			} else if f.state < m.state || f.input.Value() != "" {
+				s.WriteString(f.label + ": " + f.input.Value() + "\n")
+			}
+		}
 		
 		// Show autocomplete suggestions
 		if m.showSuggestions && len(m.toSuggestions) > 0 {
@@ -1862,7 +3013,7 @@ func (m Model) View() string {
 			}
 			s.WriteString("\n(↑/↓ select, Tab/Enter to use, Esc to dismiss)")
 		} else {
-			s.WriteString("\n(Enter to continue, Tab to cycle From, Esc to cancel)")
+			s.WriteString("\n(Enter to continue, Tab to cycle From, Esc to go back)")
 		}
 
 	} else if m.state == viewComposeSubject {
@@ -1873,6 +3024,12 @@ func (m Model) View() string {
 		}
 		s.WriteString("From: " + fromAddr + "\n")
 		s.WriteString("To: " + m.inputTo.Value() + "\n")
+		if m.inputCc.Value() != "" {
+			s.WriteString("Cc: " + m.inputCc.Value() + "\n")
+		}
+		if m.inputBcc.Value() != "" {
+			s.WriteString("Bcc: " + m.inputBcc.Value() + "\n")
+		}
 		s.WriteString("Subject: " + m.inputSubject.View() + "\n")
 		s.WriteString("\n(Enter to write body in $EDITOR, Tab to cycle From, Esc to back)")
 
@@ -1884,19 +3041,59 @@ func (m Model) View() string {
 		}
 		s.WriteString("From: " + fromAddr + "\n")
 		s.WriteString("To: " + m.inputTo.Value() + "\n")
+		if m.inputCc.Value() != "" {
+			s.WriteString("Cc: " + m.inputCc.Value() + "\n")
+		}
+		if m.inputBcc.Value() != "" {
+			s.WriteString("Bcc: " + m.inputBcc.Value() + "\n")
+		}
 		s.WriteString("Subject: " + m.inputSubject.Value() + "\n")
-		s.WriteString("Body Preview:\n")
-		
-		preview := m.composeBody
-		if len(preview) > 100 {
-			preview = preview[:100] + "..."
+		if m.composeMarkdown {
+			s.WriteString("Format: Markdown (sent as text + HTML)\n")
+		} else {
+			s.WriteString("Format: Plain text\n")
+		}
+
+		if m.composePreview {
+			// Show the HTML part the way a received message would look
+			s.WriteString("HTML Preview:\n")
+			html, err := markdownToHTML(m.composeBody)
+			if err != nil {
+				s.WriteString(err.Error() + "\n")
+			} else {
+				s.WriteString(renderEmailBody("", html, 80) + "\n")
+			}
+		} else {
+			s.WriteString("Body Preview:\n")
+
+			preview := m.composeBody
+			if len(preview) > 100 {
+				preview = preview[:100] + "..."
+			}
+			s.WriteString(preview + "\n")
+		}
+
+		if len(m.composeAttachments) > 0 {
+			s.WriteString(fmt.Sprintf("\nAttachments (%d):\n", len(m.composeAttachments)))
+			for _, att := range m.composeAttachments {
+				s.WriteString(fmt.Sprintf("  %s (%s)\n", att.Name, formatSize(att.Size)))
+			}
+		}
+		if m.status != "" {
+			s.WriteString("\n" + m.status + "\n")
 		}
-		s.WriteString(preview + "\n")
 		
 		if m.loading {
 			s.WriteString("\nSENDING...\n")
+		} else if m.attaching {
+			s.WriteString("\nAttach: " + m.attachInput.View() + "\n(enter: attach, esc: cancel)")
 		} else {
-			s.WriteString("\n(y) Send  (s) Save Draft  (n) Cancel  (e) Edit Body  (Tab) Change From")
+			s.WriteString("\n(y) Send  (s) Save Draft  (n) Cancel  (e) Edit Body  (a) Attach  (x) Remove Last  (Tab) Change From")
+			if m.composeMarkdown {
+				s.WriteString("\n(m) Plain Text  (p) Toggle HTML Preview")
+			} else {
+				s.WriteString("\n(m) Markdown")
+			}
 		}
 
 	} else if m.state == viewCalendar {
@@ -1905,20 +3102,7 @@ func (m Model) View() string {
 		if m.loading {
 			s.WriteString("Loading calendar...")
 		} else if m.editingEvent != nil {
-			// Editing/Creating event
-			if m.editingEvent.ID == "" {
-				s.WriteString("Create New Event\n\n")
-			} else {
-				s.WriteString("Edit Event\n\n")
-			}
-			s.WriteString(fmt.Sprintf("Title: %s\n", m.eventInput.View()))
-			s.WriteString(fmt.Sprintf("Date: %s\n", m.editingEvent.Start.Format("2006-01-02")))
-			s.WriteString(fmt.Sprintf("Time: %s\n", m.editingEvent.Start.Format("15:04")))
-			if m.editingEvent.Duration != "" {
-				s.WriteString(fmt.Sprintf("Duration: %s\n", m.editingEvent.Duration))
-			}
-			s.WriteString(fmt.Sprintf("Location: %s\n", m.editingEvent.Location))
-			s.WriteString("\n(enter: save, esc: cancel)")
+			s.WriteString(m.eventEditorView())
 		} else if m.viewEventDetail && m.eventCursor < len(m.events) {
 			// Viewing event details
 			e := m.events[m.eventCursor]
@@ -1932,6 +3116,12 @@ func (m Model) View() string {
 			if e.Location != "" {
 				s.WriteString(fmt.Sprintf("Location: %s\n", e.Location))
 			}
+			if e.Recurrence != "" {
+				s.WriteString(fmt.Sprintf("Repeats: %s\n", api.DescribeRecurrence(e.Recurrence)))
+			}
+			if len(e.Alerts) > 0 {
+				s.WriteString(fmt.Sprintf("Alarm: %s\n", describeAlerts(e.Alerts)))
+			}
 			if e.Description != "" {
 				s.WriteString(fmt.Sprintf("\nDescription:\n%s\n", e.Description))
 			}
@@ -1945,7 +3135,11 @@ func (m Model) View() string {
 					s.WriteString(fmt.Sprintf("  - %s <%s>%s\n", p.Name, p.Email, status))
 				}
 			}
-			s.WriteString("\n(e: edit, d: delete, esc: back)")
+			if m.eventScope != "" {
+				s.WriteString(m.eventScopeView())
+			} else {
+				s.WriteString("\n(e: edit, d: delete, esc: back)")
+			}
 		} else if len(m.events) == 0 && len(m.calendars) > 0 {
 			s.WriteString("No events in the next " + fmt.Sprintf("%d", m.agendaDays) + " days.\n")
 			s.WriteString("\n(n: new event, r: refresh, esc: back)")
@@ -1994,12 +3188,19 @@ func (m Model) View() string {
 				}
 				
 				line := fmt.Sprintf("%s %s  %s", cursor, timeStr, e.Title)
+				if e.Recurrence != "" {
+					line += " " + recurringMark
+				}
 				if e.Location != "" {
 					line += fmt.Sprintf(" @ %s", e.Location)
 				}
 				s.WriteString(style.Render(line) + "\n")
 			}
-			s.WriteString("\n(j/k navigate, enter: view, n: new, d: delete, r: refresh)")
+			if m.eventScope != "" {
+				s.WriteString(m.eventScopeView())
+			} else {
+				s.WriteString("\n(j/k navigate, enter: view, n: new, d: delete, r: refresh)")
+			}
 		}
 
 	} else if m.state == viewContacts {
@@ -2148,12 +3349,24 @@ func (m Model) View() string {
 		s.WriteString("Settings\n\n")
 		
 		offlineStatus := "OFF"
-		if m.offlineMode {
+		if m.forceOffline {
 			offlineStatus = "ON"
 		}
 		
+		cacheStatus := "OFF"
+		if m.cacheAttachments {
+			cacheStatus = "ON"
+		}
+
+		markdownStatus := "OFF"
+		if m.markdownDefault {
+			markdownStatus = "ON"
+		}
+
 		settings := []string{
 			fmt.Sprintf("  Offline Mode: %s", offlineStatus),
+			fmt.Sprintf("  Cache Attachments Offline: %s", cacheStatus),
+			fmt.Sprintf("  Compose in Markdown: %s", markdownStatus),
 		}
 		
 		for i, setting := range settings {
@@ -2165,15 +3378,27 @@ func (m Model) View() string {
 		}
 		
 		s.WriteString("\n(enter to toggle, 0: back to menu)")
+
+	} else if m.state == viewOutbox {
+		s.WriteString(m.outboxView())
+	} else if m.state == viewAccounts {
+		s.WriteString(m.accountsView())
+	}
+
+	// The body and confirm screens place the status line themselves
+	if m.status != "" && m.state != viewBody && m.state != viewComposeConfirm {
+		s.WriteString("\n\n" + m.status)
 	}
 
+	s.WriteString("\n\n" + m.statusBar())
+
 	return appStyle.Render(s.String())
 }
 
 // Commands
-func saveDraftCmd(client *api.Client, draftID, from, to, subject, body string) tea.Cmd {
+func saveDraftCmd(client *api.Client, draftID string, draft model.Draft) tea.Cmd {
 	return func() tea.Msg {
-		err := client.SaveDraft(draftID, from, to, subject, body)
+		err := client.SaveDraft(draftID, draft)
 		if err != nil {
 			return errorMsg(err)
 		}
@@ -2181,9 +3406,9 @@ func saveDraftCmd(client *api.Client, draftID, from, to, subject, body string) t
 	}
 }
 
-func sendEmailCmd(client *api.Client, draftID, from, to, subject, body string) tea.Cmd {
+func sendEmailCmd(client *api.Client, draftID string, draft model.Draft) tea.Cmd {
 	return func() tea.Msg {
-		err := client.SendEmail(draftID, from, to, subject, body)
+		err := client.SendEmail(draftID, draft)
 		if err != nil {
 			return errorMsg(err)
 		}
@@ -2191,9 +3416,22 @@ func sendEmailCmd(client *api.Client, draftID, from, to, subject, body string) t
 	}
 }
 
-func moveEmailCmd(client *api.Client, emailID, fromMBID, toMBID string) tea.Cmd {
+// fetchReplyContextCmd loads the threading headers of a message being
+// replied to. Failures are dropped: the reply still works, it just won't
+// thread.
+func fetchReplyContextCmd(client *api.Client, emailID string, forward bool) tea.Cmd {
 	return func() tea.Msg {
-		err := client.MoveEmail(emailID, fromMBID, toMBID)
+		reply, err := client.FetchReplyContext(emailID, forward)
+		if err != nil {
+			return nil
+		}
+		return replyContextMsg(reply)
+	}
+}
+
+func moveEmailsCmd(client *api.Client, emailIDs []string, fromMBID, toMBID string) tea.Cmd {
+	return func() tea.Msg {
+		err := client.MoveEmails(emailIDs, fromMBID, toMBID)
 		if err != nil {
 			return errorMsg(err)
 		}
@@ -2201,9 +3439,19 @@ func moveEmailCmd(client *api.Client, emailID, fromMBID, toMBID string) tea.Cmd
 	}
 }
 
-func deleteEmailCmd(client *api.Client, emailID string) tea.Cmd {
+func trashEmailsCmd(client *api.Client, emailIDs []string, trashID string) tea.Cmd {
+	return func() tea.Msg {
+		err := client.TrashEmails(emailIDs, trashID)
+		if err != nil {
+			return errorMsg(err)
+		}
+		return emailDeletedMsg{}
+	}
+}
+
+func destroyEmailsCmd(client *api.Client, emailIDs []string) tea.Cmd {
 	return func() tea.Msg {
-		err := client.DeleteEmail(emailID)
+		err := client.DestroyEmails(emailIDs)
 		if err != nil {
 			return errorMsg(err)
 		}
@@ -2211,9 +3459,9 @@ func deleteEmailCmd(client *api.Client, emailID string) tea.Cmd {
 	}
 }
 
-func toggleUnreadCmd(client *api.Client, emailID string, isUnread bool) tea.Cmd {
+func toggleUnreadCmd(client *api.Client, emailIDs []string, isUnread bool) tea.Cmd {
 	return func() tea.Msg {
-		err := client.SetUnread(emailID, isUnread)
+		err := client.SetUnreadMany(emailIDs, isUnread)
 		if err != nil {
 			return errorMsg(err)
 		}
@@ -2221,9 +3469,9 @@ func toggleUnreadCmd(client *api.Client, emailID string, isUnread bool) tea.Cmd
 	}
 }
 
-func toggleFlaggedCmd(client *api.Client, emailID string, isFlagged bool) tea.Cmd {
+func toggleFlaggedCmd(client *api.Client, emailIDs []string, isFlagged bool) tea.Cmd {
 	return func() tea.Msg {
-		err := client.SetFlagged(emailID, isFlagged)
+		err := client.SetFlaggedMany(emailIDs, isFlagged)
 		if err != nil {
 			return errorMsg(err)
 		}
@@ -2233,14 +3481,10 @@ func toggleFlaggedCmd(client *api.Client, emailID string, isFlagged bool) tea.Cm
 
 func fetchMailboxesCmd(client *api.Client, db *storage.DB) tea.Cmd {
 	return func() tea.Msg {
-		mbs, err := client.FetchMailboxes()
+		mbs, err := loadMailboxes(client, db)
 		if err != nil {
 			return errorMsg(err)
 		}
-		// Save to local storage if available
-		if db != nil {
-			db.SaveMailboxes(mbs)
-		}
 		return mailboxesLoadedMsg(mbs)
 	}
 }
@@ -2272,8 +3516,45 @@ func fetchIdentitiesCmd(client *api.Client) tea.Cmd {
 	}
 }
 
+// prefetchBodies caches email bodies in the background for offline access
+func prefetchBodies(client *api.Client, db *storage.DB, emails []model.Email) {
+	for _, email := range emails {
+		// Check if body already cached
+		existingBody, _ := db.GetEmailBody(email.ID)
+		if existingBody == "" || strings.HasPrefix(existingBody, "[Full email body not cached") || strings.HasPrefix(existingBody, "[Email body not available") {
+			body, err := client.FetchEmailBody(email.ID)
+			if err == nil && body != "" {
+				db.SaveEmailBody(email.ID, body)
+			}
+		}
+	}
+}
+
+// syncEmails applies server-side changes to the cache and returns the first
+// page of the mailbox from local storage
+func syncEmails(client *api.Client, db *storage.DB, mailboxID string) ([]model.Email, error) {
+	if _, err := mailsync.New(client, db).SyncEmails(mailboxID); err != nil {
+		return nil, err
+	}
+	emails, err := db.GetEmails(mailboxID, 0, mailsync.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	go prefetchBodies(client, db, emails)
+	return emails, nil
+}
+
 func fetchEmailsCmd(client *api.Client, db *storage.DB, mailboxID string, offset int) tea.Cmd {
 	return func() tea.Msg {
+		// The first page comes from the incrementally synced cache; older
+		// pages are still paged in from the server
+		if db != nil && offset == 0 {
+			emails, err := syncEmails(client, db, mailboxID)
+			if err != nil {
+				return errorMsg(err)
+			}
+			return emailsLoadedMsg(emails)
+		}
 		emails, err := client.FetchEmails(mailboxID, offset)
 		if err != nil {
 			return errorMsg(err)
@@ -2281,24 +3562,55 @@ func fetchEmailsCmd(client *api.Client, db *storage.DB, mailboxID string, offset
 		// Save to local storage if available
 		if db != nil {
 			db.SaveEmails(emails)
-			// Pre-fetch and cache email bodies in background for offline access
-			go func() {
-				for _, email := range emails {
-					// Check if body already cached
-					existingBody, _ := db.GetEmailBody(email.ID)
-					if existingBody == "" || strings.HasPrefix(existingBody, "[Full email body not cached") || strings.HasPrefix(existingBody, "[Email body not available") {
-						body, err := client.FetchEmailBody(email.ID)
-						if err == nil && body != "" {
-							db.SaveEmailBody(email.ID, body)
-						}
-					}
-				}
-			}()
+			go prefetchBodies(client, db, emails)
+		}
+		return emailsLoadedMsg(emails)
+	}
+}
+
+func searchEmailsCmd(client *api.Client, query model.SearchQuery, offset int) tea.Cmd {
+	return func() tea.Msg {
+		emails, err := client.SearchEmails(query, offset)
+		if err != nil {
+			return errorMsg(err)
 		}
 		return emailsLoadedMsg(emails)
 	}
 }
 
+func searchEmailsOfflineCmd(db *storage.DB, query model.SearchQuery, offset int) tea.Cmd {
+	return func() tea.Msg {
+		if db == nil {
+			return errorMsg(fmt.Errorf("no local storage available"))
+		}
+		results, err := db.SearchEmails(query, offset, api.SearchPageSize)
+		if err != nil {
+			return errorMsg(err)
+		}
+		return searchResultsMsg(results)
+	}
+}
+
+// snippetView renders a search excerpt on one line, its matched terms
+// highlighted
+func snippetView(snippet string) string {
+	snippet = strings.Join(strings.Fields(snippet), " ")
+	var s strings.Builder
+	for snippet != "" {
+		before, rest, found := strings.Cut(snippet, storage.HighlightStart)
+		if before != "" {
+			s.WriteString(snippetStyle.Render(before))
+		}
+		if !found {
+			break
+		}
+		match, after, _ := strings.Cut(rest, storage.HighlightEnd)
+		s.WriteString(snippetMatchStyle.Render(match))
+		snippet = after
+	}
+	return s.String()
+}
+
 func fetchEmailsOfflineCmd(db *storage.DB, mailboxID string, offset int) tea.Cmd {
 	return func() tea.Msg {
 		if db == nil {
@@ -2312,27 +3624,183 @@ func fetchEmailsOfflineCmd(db *storage.DB, mailboxID string, offset int) tea.Cmd
 	}
 }
 
-func refreshEmailsCmd(client *api.Client, db *storage.DB, mailboxID string) tea.Cmd {
+func fetchThreadedEmailsCmd(client *api.Client, db *storage.DB, mailboxID string, offset int) tea.Cmd {
 	return func() tea.Msg {
-		emails, err := client.FetchEmails(mailboxID, 0)
+		emails, err := client.FetchThreadedEmails(mailboxID, offset)
 		if err != nil {
 			return errorMsg(err)
 		}
 		if db != nil {
 			db.SaveEmails(emails)
-			// Pre-fetch and cache email bodies in background for offline access
-			go func() {
-				for _, email := range emails {
-					// Check if body already cached
-					existingBody, _ := db.GetEmailBody(email.ID)
-					if existingBody == "" || strings.HasPrefix(existingBody, "[Full email body not cached") || strings.HasPrefix(existingBody, "[Email body not available") {
-						body, err := client.FetchEmailBody(email.ID)
-						if err == nil && body != "" {
-							db.SaveEmailBody(email.ID, body)
-						}
-					}
-				}
-			}()
+			go prefetchBodies(client, db, emails)
+		}
+		return emailsLoadedMsg(emails)
+	}
+}
+
+func fetchThreadedEmailsOfflineCmd(db *storage.DB, mailboxID string, offset int) tea.Cmd {
+	return func() tea.Msg {
+		if db == nil {
+			return errorMsg(fmt.Errorf("no local storage available"))
+		}
+		emails, err := db.GetThreadedEmails(mailboxID, offset, 20)
+		if err != nil {
+			return errorMsg(err)
+		}
+		return emailsLoadedMsg(emails)
+	}
+}
+
+func fetchThreadsCmd(client *api.Client, db *storage.DB, threadIDs []string) tea.Cmd {
+	return func() tea.Msg {
+		threads, err := client.FetchThreads(threadIDs)
+		if err != nil {
+			return errorMsg(err)
+		}
+		// Cache the whole conversation so it can be read offline
+		if db != nil {
+			for _, t := range threads {
+				db.SaveEmails(t.Emails)
+			}
+		}
+		return threadsLoadedMsg(threads)
+	}
+}
+
+func fetchThreadsOfflineCmd(db *storage.DB, threadIDs []string) tea.Cmd {
+	return func() tea.Msg {
+		if db == nil {
+			return errorMsg(fmt.Errorf("no local storage available"))
+		}
+		threads, err := db.GetThreads(threadIDs)
+		if err != nil {
+			return errorMsg(err)
+		}
+		return threadsLoadedMsg(threads)
+	}
+}
+
+func fetchAttachmentsCmd(client *api.Client, db *storage.DB, emailID string, cache bool) tea.Cmd {
+	return func() tea.Msg {
+		atts, err := client.FetchAttachments(emailID)
+		if err != nil {
+			return errorMsg(err)
+		}
+		if db != nil {
+			db.SaveAttachments(emailID, atts)
+			if cache {
+				go prefetchAttachments(client, db, atts)
+			}
+		}
+		return attachmentsLoadedMsg{emailID: emailID, attachments: atts}
+	}
+}
+
+func fetchAttachmentsOfflineCmd(db *storage.DB, emailID string) tea.Cmd {
+	return func() tea.Msg {
+		if db == nil {
+			return nil
+		}
+		atts, err := db.GetAttachments(emailID)
+		if err != nil {
+			return errorMsg(err)
+		}
+		return attachmentsLoadedMsg{emailID: emailID, attachments: atts}
+	}
+}
+
+// maxCachedAttachment keeps very large files out of the offline cache
+const maxCachedAttachment = 25 << 20
+
+// prefetchAttachments caches attachment contents in the background for
+// offline access
+func prefetchAttachments(client *api.Client, db *storage.DB, atts []model.Attachment) {
+	for _, a := range atts {
+		if a.IsInline || a.Size > maxCachedAttachment {
+			continue
+		}
+		if cached, _ := db.GetBlob(a.BlobID); cached != nil {
+			continue
+		}
+		if data, err := client.DownloadAttachment(a); err == nil {
+			db.SaveBlob(a.BlobID, data)
+		}
+	}
+}
+
+// attachmentData returns an attachment's contents from the offline cache or,
+// with a client, from the server. A nil client means offline.
+func attachmentData(client *api.Client, db *storage.DB, att model.Attachment, cache bool) ([]byte, error) {
+	if db != nil {
+		if data, err := db.GetBlob(att.BlobID); err == nil && data != nil {
+			return data, nil
+		}
+	}
+	if client == nil {
+		return nil, fmt.Errorf("%s is not cached for offline use", att.Name)
+	}
+	data, err := client.DownloadAttachment(att)
+	if err != nil {
+		return nil, err
+	}
+	if db != nil && cache && len(data) <= maxCachedAttachment {
+		db.SaveBlob(att.BlobID, data)
+	}
+	return data, nil
+}
+
+func saveAttachmentCmd(client *api.Client, db *storage.DB, att model.Attachment, dir string, cache bool) tea.Cmd {
+	return func() tea.Msg {
+		data, err := attachmentData(client, db, att, cache)
+		if err != nil {
+			return errorMsg(err)
+		}
+		dir = expandHome(dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return errorMsg(fmt.Errorf("failed to create %s: %w", dir, err))
+		}
+		path := uniquePath(filepath.Join(dir, safeFileName(att.Name)))
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return errorMsg(fmt.Errorf("failed to save attachment: %w", err))
+		}
+		return attachmentSavedMsg("Saved to " + path)
+	}
+}
+
+func openAttachmentCmd(client *api.Client, db *storage.DB, att model.Attachment, cache bool) tea.Cmd {
+	return func() tea.Msg {
+		data, err := attachmentData(client, db, att, cache)
+		if err != nil {
+			return errorMsg(err)
+		}
+		dir, err := os.MkdirTemp("", "fm-cli-attachment-*")
+		if err != nil {
+			return errorMsg(err)
+		}
+		path := filepath.Join(dir, safeFileName(att.Name))
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return errorMsg(err)
+		}
+		// xdg-open (or open on macOS)
+		if err := images.OpenInBrowser(path); err != nil {
+			return errorMsg(fmt.Errorf("failed to open attachment: %w", err))
+		}
+		return attachmentSavedMsg("Opened " + att.Name)
+	}
+}
+
+func refreshEmailsCmd(client *api.Client, db *storage.DB, mailboxID string) tea.Cmd {
+	return func() tea.Msg {
+		if db != nil {
+			emails, err := syncEmails(client, db, mailboxID)
+			if err != nil {
+				return errorMsg(err)
+			}
+			return emailsRefreshedMsg(emails)
+		}
+		emails, err := client.FetchEmails(mailboxID, 0)
+		if err != nil {
+			return errorMsg(err)
 		}
 		return emailsRefreshedMsg(emails)
 	}
@@ -2412,23 +3880,97 @@ func renderImagesCmd(htmlBody string) tea.Cmd {
 	}
 }
 
-func saveDraftOfflineCmd(db *storage.DB, from, to, subject, body string) tea.Cmd {
+func saveDraftOfflineCmd(db *storage.DB, draft model.Draft) tea.Cmd {
+	return queueDraftCmd(db, mailsync.ActionSaveDraft, draft, draftSavedMsg{})
+}
+
+// sendEmailOfflineCmd keeps the message as a local draft until the queue
+// sends it
+func sendEmailOfflineCmd(db *storage.DB, draft model.Draft) tea.Cmd {
+	return queueDraftCmd(db, mailsync.ActionSendDraft, draft, emailSentMsg{})
+}
+
+func queueDraftCmd(db *storage.DB, actionType string, draft model.Draft, done tea.Msg) tea.Cmd {
 	return func() tea.Msg {
 		if db == nil {
 			return errorMsg(fmt.Errorf("no local storage available"))
 		}
 		// Generate a local ID
 		localID := fmt.Sprintf("local-%d", time.Now().UnixNano())
-		err := db.SaveLocalDraft(localID, from, to, subject, body)
+		err := db.SaveLocalDraft(localID, draft)
 		if err != nil {
 			return errorMsg(err)
 		}
-		// Queue for sync
-		data, _ := json.Marshal(map[string]string{
-			"from": from, "to": to, "subject": subject, "body": body,
-		})
-		db.AddPendingAction("save_draft", localID, string(data))
-		return draftSavedMsg{}
+		// Queue for sync; attachments go by path and are uploaded then
+		if err := mailsync.QueueDraft(db, actionType, localID, draft); err != nil {
+			return errorMsg(err)
+		}
+		return done
+	}
+}
+
+// startPushCmd runs an account's EventSource listener in the background
+// for the lifetime of the program, delivering its events on the shared
+// channel tagged with the account. Events are coalesced per account, so a
+// busy account can't crowd out another's.
+func startPushCmd(client *api.Client, account string, events chan pushStateMsg) tea.Cmd {
+	listener := client.PushListener()
+	if listener == nil {
+		return nil
+	}
+	pending := make(chan api.StateChange, 1)
+	go listener.Run(context.Background(), func(change api.StateChange) {
+		select {
+		case pending <- change:
+		default:
+			// An event is already pending; the delta sync it triggers
+			// will pick this change up too
+		}
+	})
+	go func() {
+		for change := range pending {
+			events <- pushStateMsg{account: account, StateChange: change}
+		}
+	}()
+	return waitForPushCmd(events)
+}
+
+func waitForPushCmd(events chan pushStateMsg) tea.Cmd {
+	return func() tea.Msg {
+		return <-events
+	}
+}
+
+// liveEmailsCmd re-reads the open mailbox after a push without resetting
+// the list position
+func liveEmailsCmd(client *api.Client, db *storage.DB, mailboxID string, limit int, threaded bool) tea.Cmd {
+	return func() tea.Msg {
+		if threaded {
+			emails, err := client.FetchThreadedEmails(mailboxID, 0)
+			if err != nil {
+				return errorMsg(err)
+			}
+			if db != nil {
+				db.SaveEmails(emails)
+			}
+			return liveEmailsMsg(emails)
+		}
+		if db == nil {
+			emails, err := client.FetchEmails(mailboxID, 0)
+			if err != nil {
+				return errorMsg(err)
+			}
+			return liveEmailsMsg(emails)
+		}
+		if _, err := mailsync.New(client, db).SyncEmails(mailboxID); err != nil {
+			return errorMsg(err)
+		}
+		emails, err := db.GetEmails(mailboxID, 0, limit)
+		if err != nil {
+			return errorMsg(err)
+		}
+		go prefetchBodies(client, db, emails)
+		return liveEmailsMsg(emails)
 	}
 }
 
@@ -2477,7 +4019,13 @@ func updateEventCmd(davClient *api.DAVClient, event model.CalendarEvent) tea.Cmd
 		if davClient == nil {
 			return errorMsg(fmt.Errorf("CalDAV not configured"))
 		}
-		err := davClient.UpdateEvent(context.Background(), event)
+		err := moveEventIfNeeded(context.Background(), davClient, &event)
+		if err == nil {
+			err = davClient.UpdateEvent(context.Background(), event)
+		}
+		if errors.Is(err, api.ErrConflict) {
+			return eventConflictMsg{}
+		}
 		if err != nil {
 			return errorMsg(err)
 		}
@@ -2485,12 +4033,15 @@ func updateEventCmd(davClient *api.DAVClient, event model.CalendarEvent) tea.Cmd
 	}
 }
 
-func deleteEventCmd(davClient *api.DAVClient, eventPath string) tea.Cmd {
+func deleteEventCmd(davClient *api.DAVClient, event model.CalendarEvent) tea.Cmd {
 	return func() tea.Msg {
 		if davClient == nil {
 			return errorMsg(fmt.Errorf("CalDAV not configured"))
 		}
-		err := davClient.DeleteEvent(context.Background(), eventPath)
+		err := davClient.DeleteEvent(context.Background(), event)
+		if errors.Is(err, api.ErrConflict) {
+			return eventConflictMsg{deleting: true}
+		}
 		if err != nil {
 			return errorMsg(err)
 		}
@@ -2544,6 +4095,9 @@ func updateContactCmd(davClient *api.DAVClient, contact model.Contact) tea.Cmd {
 			return errorMsg(fmt.Errorf("CardDAV not configured"))
 		}
 		err := davClient.UpdateContact(context.Background(), contact)
+		if errors.Is(err, api.ErrConflict) {
+			return contactConflictMsg{}
+		}
 		if err != nil {
 			return errorMsg(err)
 		}
@@ -2551,12 +4105,15 @@ func updateContactCmd(davClient *api.DAVClient, contact model.Contact) tea.Cmd {
 	}
 }
 
-func deleteContactCmd(davClient *api.DAVClient, contactPath string) tea.Cmd {
+func deleteContactCmd(davClient *api.DAVClient, contact model.Contact) tea.Cmd {
 	return func() tea.Msg {
 		if davClient == nil {
 			return errorMsg(fmt.Errorf("CardDAV not configured"))
 		}
-		err := davClient.DeleteContact(context.Background(), contactPath)
+		err := davClient.DeleteContact(context.Background(), contact)
+		if errors.Is(err, api.ErrConflict) {
+			return contactConflictMsg{deleting: true}
+		}
 		if err != nil {
 			return errorMsg(err)
 		}
@@ -0,0 +1,437 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/model"
+	"fm-cli/internal/storage"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// bulkDoneMsg reports how a change to the marked emails went
+type bulkDoneMsg struct {
+	status string
+}
+
+// allMatchesMsg carries every email matching the active search
+type allMatchesMsg []model.Email
+
+// marking reports whether any list entries are marked
+func (m Model) marking() bool {
+	return m.visual || len(m.marked) > 0
+}
+
+// isMarked reports whether list entry i is marked, or in the range being
+// marked with V
+func (m Model) isMarked(i int) bool {
+	if m.visual {
+		lo, hi := m.visualStart, m.emailCursor
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if i >= lo && i <= hi {
+			return true
+		}
+	}
+	return m.marked[m.emails[i].ID]
+}
+
+// commitVisual adds the range being marked with V to the marks
+func (m *Model) commitVisual() {
+	if !m.visual {
+		return
+	}
+	if m.marked == nil {
+		m.marked = make(map[string]bool)
+	}
+	for i := range m.emails {
+		if m.isMarked(i) {
+			m.marked[m.emails[i].ID] = true
+		}
+	}
+	m.visual = false
+}
+
+func (m *Model) clearMarks() {
+	m.marked = nil
+	m.visual = false
+}
+
+// markedTargets returns the emails a bulk action applies to, each mapped
+// to the mailbox it's listed in. Conversations count as all their
+// messages, or with inMailbox only those in that mailbox, so replies in
+// Sent stay where they are.
+func (m Model) markedTargets(inMailbox bool) map[string]string {
+	targets := make(map[string]string)
+	for i, e := range m.emails {
+		if !m.isMarked(i) {
+			continue
+		}
+		from := m.sourceMailbox(e)
+		added := false
+		if t, ok := m.threads[e.ThreadID]; ok && m.threadMode && m.searchQuery == nil {
+			for _, te := range t.Emails {
+				if !inMailbox || hasMailbox(te, from) {
+					targets[te.ID] = from
+					added = true
+				}
+			}
+		}
+		if !added {
+			targets[e.ID] = from
+		}
+	}
+	return targets
+}
+
+// markedEmails returns the marked list entries, conversations expanded
+func (m Model) markedEmails() []model.Email {
+	var emails []model.Email
+	for i, e := range m.emails {
+		if !m.isMarked(i) {
+			continue
+		}
+		if t, ok := m.threads[e.ThreadID]; ok && m.threadMode && m.searchQuery == nil {
+			emails = append(emails, t.Emails...)
+			continue
+		}
+		emails = append(emails, e)
+	}
+	return emails
+}
+
+// updateMarks handles marking list entries and the actions that then apply
+// to all of them. Keys it doesn't use fall through to the global handlers.
+func (m Model) updateMarks(msg tea.KeyMsg) (Model, tea.Cmd, bool) {
+	if m.unified || len(m.emails) == 0 {
+		return m, nil, false
+	}
+
+	switch msg.String() {
+	case " ":
+		m.commitVisual()
+		if m.marked == nil {
+			m.marked = make(map[string]bool)
+		}
+		id := m.emails[m.emailCursor].ID
+		if m.marked[id] {
+			delete(m.marked, id)
+		} else {
+			m.marked[id] = true
+		}
+		if m.emailCursor < len(m.emails)-1 {
+			m.emailCursor++
+		}
+		return m, nil, true
+
+	case "V":
+		if m.visual {
+			m.commitVisual()
+		} else {
+			m.visual = true
+			m.visualStart = m.emailCursor
+		}
+		return m, nil, true
+
+	case "*":
+		m.visual = false
+		if m.searchQuery != nil && m.canLoadMore {
+			// Only a page is loaded, so fetch the rest of the matches
+			m.loading = true
+			return m, allMatchesCmd(m.client, m.db, m.offlineMode, *m.searchQuery), true
+		}
+		if len(m.marked) == len(m.emails) {
+			m.clearMarks()
+			return m, nil, true
+		}
+		m.marked = make(map[string]bool, len(m.emails))
+		for _, e := range m.emails {
+			m.marked[e.ID] = true
+		}
+		return m, nil, true
+
+	case "K":
+		m.commitVisual()
+		m.keywording = true
+		m.keywordInput.SetValue("")
+		m.keywordInput.Focus()
+		return m, nil, true
+	}
+
+	if !m.marking() {
+		return m, nil, false
+	}
+
+	switch msg.String() {
+	case "esc":
+		m.clearMarks()
+		return m, nil, true
+	case "u", "f", "e", "d", "backspace":
+		m.commitVisual()
+		return m.bulkAction(msg.String())
+	}
+	return m, nil, false
+}
+
+// bulkAction applies u, f, e or d to every marked email
+func (m Model) bulkAction(key string) (Model, tea.Cmd, bool) {
+	emails := m.markedEmails()
+	ids := make([]string, 0, len(emails))
+	for _, e := range emails {
+		ids = append(ids, e.ID)
+	}
+
+	switch key {
+	case "u":
+		// Mark them all read, or unread if they all are
+		unread := true
+		for _, e := range emails {
+			if e.IsUnread {
+				unread = false
+				break
+			}
+		}
+		verb := "Marked read"
+		if unread {
+			verb = "Marked unread"
+		}
+		m.recordRead(ids, unread)
+		return m, m.bulkCmd(did(verb), len(ids), func(c *api.Client) error {
+			return c.SetUnreadMany(ids, unread)
+		}, queueFlagsCmd(m.db, ids, &unread, nil)), true
+
+	case "f":
+		// Flag them all, or unflag them if they all are flagged
+		flagged := false
+		for _, e := range emails {
+			if !e.IsFlagged {
+				flagged = true
+				break
+			}
+		}
+		verb := "Unflagged"
+		if flagged {
+			verb = "Flagged"
+		}
+		m.recordFlag(ids, flagged)
+		return m, m.bulkCmd(did(verb), len(ids), func(c *api.Client) error {
+			return c.SetFlaggedMany(ids, flagged)
+		}, queueFlagsCmd(m.db, ids, nil, &flagged)), true
+
+	case "e":
+		archiveID := ""
+		for _, mb := range m.mailboxes {
+			if mb.Role == "archive" {
+				archiveID = mb.ID
+				break
+			}
+		}
+		if archiveID == "" {
+			return m, nil, true
+		}
+		from := m.markedTargets(true)
+		m.recordMove("archiving "+messageCount(len(from)), from, archiveID)
+		return m, m.bulkMoveCmd(did("Archived"), from, archiveID), true
+
+	case "d", "backspace":
+		// Only messages in the listed mailbox: replies in Sent stay put,
+		// and copies elsewhere aren't destroyed from the Trash
+		targets := m.markedTargets(true)
+		ids = make([]string, 0, len(targets))
+		for id := range targets {
+			ids = append(ids, id)
+		}
+		if m.listingTrash() {
+			m.destroyConfirm = ids
+			return m, nil, true
+		}
+		trashID := m.roleMailbox("trash")
+		if trashID == "" {
+			m.status = "There is no Trash folder to move them to"
+			return m, nil, true
+		}
+		moved := func(count string) string { return "Moved " + count + " to Trash" }
+		m.recordTrash(ids, trashID)
+		return m, m.bulkCmd(moved, len(ids), func(c *api.Client) error {
+			return c.TrashEmails(ids, trashID)
+		}, queueMailboxesCmd(m.db, trashMailboxes(ids, trashID))), true
+	}
+	return m, nil, false
+}
+
+// bulkMoveCmd moves emails out of the mailbox each is mapped to, or copies
+// them where that is empty
+func (m Model) bulkMoveCmd(describe func(string) string, from map[string]string, toMBID string) tea.Cmd {
+	return m.bulkCmd(describe, len(from), func(c *api.Client) error {
+		return c.MoveEmailsFrom(from, toMBID)
+	}, queueMoveCmd(m.db, from, toMBID))
+}
+
+// bulkCmd makes a change to n emails in one Email/set call when online, or
+// through queued, the offline command. Emails the server wouldn't change
+// are counted in the report rather than failing the lot; describe words
+// the report around the count, e.g. "Archived 3 of 4 messages".
+func (m Model) bulkCmd(describe func(count string) string, n int, online func(*api.Client) error, queued tea.Cmd) tea.Cmd {
+	if m.offlineMode || m.client == nil {
+		status := describe(messageCount(n)) + ", to be synced once back online"
+		done := func() tea.Msg { return bulkDoneMsg{status: status} }
+		return tea.Sequence(queued, countPendingCmd(m.db), done)
+	}
+	client := m.client
+	return func() tea.Msg {
+		err := online(client)
+		var setErr *api.EmailSetError
+		if errors.As(err, &setErr) {
+			count := fmt.Sprintf("%d of %s", n-len(setErr.Failed), messageCount(n))
+			return bulkDoneMsg{status: fmt.Sprintf("%s; %d failed: %s",
+				describe(count), len(setErr.Failed), strings.Join(setErr.Reasons(), ", "))}
+		}
+		if err != nil {
+			return errorMsg(err)
+		}
+		return bulkDoneMsg{status: describe(messageCount(n))}
+	}
+}
+
+// did describes a bulk change as the verb followed by the count
+func did(verb string) func(string) string {
+	return func(count string) string { return verb + " " + count }
+}
+
+func messageCount(n int) string {
+	if n == 1 {
+		return "1 message"
+	}
+	return fmt.Sprintf("%d messages", n)
+}
+
+// reloadEmails loads the list afresh after a bulk change, along with the
+// mailbox counts
+func (m *Model) reloadEmails() tea.Cmd {
+	m.emails = nil
+	m.emailCursor = 0
+	m.emailOffset = 0
+	m.loading = true
+	m.canLoadMore = true
+
+	offline := m.offlineMode || m.client == nil
+	mailboxes := fetchMailboxesCmd(m.client, m.db)
+	if offline {
+		mailboxes = fetchMailboxesOfflineCmd(m.db)
+	}
+	if m.searchQuery != nil {
+		if offline {
+			return tea.Batch(mailboxes, searchEmailsOfflineCmd(m.db, *m.searchQuery, 0))
+		}
+		return tea.Batch(mailboxes, searchEmailsCmd(m.client, *m.searchQuery, 0))
+	}
+	if len(m.mailboxes) == 0 {
+		return mailboxes
+	}
+	return tea.Batch(mailboxes, m.emailPageCmd(0))
+}
+
+// updateKeywordPrompt handles typing the keyword to add or remove
+func (m Model) updateKeywordPrompt(msg tea.Msg) (Model, tea.Cmd) {
+	key, ok := msg.(tea.KeyMsg)
+	if !ok {
+		var cmd tea.Cmd
+		m.keywordInput, cmd = m.keywordInput.Update(msg)
+		return m, cmd
+	}
+
+	switch key.Type {
+	case tea.KeyEsc:
+		m.keywording = false
+		m.keywordInput.Blur()
+		return m, nil
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEnter:
+		keyword, set, err := parseKeyword(m.keywordInput.Value())
+		if err != nil {
+			m.status = err.Error()
+			return m, nil
+		}
+		m.keywording = false
+		m.keywordInput.Blur()
+
+		var ids []string
+		if m.marking() {
+			for _, e := range m.markedEmails() {
+				ids = append(ids, e.ID)
+			}
+		} else {
+			ids = m.cursorEmailIDs()
+		}
+		verb := "Added " + keyword + " to"
+		if !set {
+			verb = "Removed " + keyword + " from"
+		}
+		// Other keywords aren't loaded with the emails, so what they were
+		// before is unknown and this can't go on the undo stack
+		return m, m.bulkCmd(did(verb), len(ids), func(c *api.Client) error {
+			return c.SetKeyword(ids, keyword, set)
+		}, queueKeywordCmd(m.db, ids, keyword, set))
+	}
+
+	var cmd tea.Cmd
+	m.keywordInput, cmd = m.keywordInput.Update(msg)
+	return m, cmd
+}
+
+// parseKeyword reads "+name" or "name" as adding keyword name and "-name"
+// as removing it. Keywords are case-insensitive, so they're lowercased.
+func parseKeyword(s string) (string, bool, error) {
+	s = strings.TrimSpace(s)
+	set := true
+	if strings.HasPrefix(s, "-") {
+		set = false
+		s = s[1:]
+	} else {
+		s = strings.TrimPrefix(s, "+")
+	}
+	if s == "" {
+		return "", false, fmt.Errorf("Type a keyword, +name to add it or -name to remove it")
+	}
+	if len(s) > 255 {
+		return "", false, fmt.Errorf("Keywords can be at most 255 characters")
+	}
+	for _, r := range s {
+		// RFC 8621 allows printable ASCII other than these
+		if r <= ' ' || r > '~' || strings.ContainsRune(`(){]%*"\`, r) {
+			return "", false, fmt.Errorf("Keywords can't contain %q", r)
+		}
+	}
+	return strings.ToLower(s), set, nil
+}
+
+// allMatchesCmd loads every email matching the search, a page at a time
+func allMatchesCmd(client *api.Client, db *storage.DB, offline bool, query model.SearchQuery) tea.Cmd {
+	return func() tea.Msg {
+		var all []model.Email
+		for {
+			var page []model.Email
+			var err error
+			if offline || client == nil {
+				if db == nil {
+					return errorMsg(fmt.Errorf("no local storage available"))
+				}
+				page, err = db.QueryEmails(query, len(all), api.SearchPageSize)
+			} else {
+				page, err = client.SearchEmails(query, len(all))
+			}
+			if err != nil {
+				return errorMsg(err)
+			}
+			all = append(all, page...)
+			if len(page) < api.SearchPageSize {
+				return allMatchesMsg(all)
+			}
+		}
+	}
+}
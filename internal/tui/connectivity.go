@@ -0,0 +1,344 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/mailsync"
+	"fm-cli/internal/model"
+	"fm-cli/internal/storage"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// connectivityInterval is how often the connection is probed
+const connectivityInterval = 30 * time.Second
+
+var (
+	onlineStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	offlineStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+)
+
+type connectivityTickMsg struct{}
+type connectivityMsg struct {
+	online    bool
+	account   string      // Account the check was made for
+	client    *api.Client // Built by this check, if there was none yet
+	davClient *api.DAVClient
+	err       error // Why connecting failed although the server answered
+	periodic  bool  // Part of the tick chain, schedules the next check
+}
+type pendingCountMsg struct {
+	queued int
+	stuck  int
+}
+
+// WithCredentials lets the model build its clients itself once the network
+// is reachable, for when it was started offline
+func (m Model) WithCredentials(creds api.Credentials) Model {
+	m.creds = &creds
+	return m
+}
+
+func scheduleConnectivityCmd() tea.Cmd {
+	return tea.Tick(connectivityInterval, func(time.Time) tea.Msg {
+		return connectivityTickMsg{}
+	})
+}
+
+// checkConnectivityCmd probes the server and builds the clients from the
+// credentials if it is reachable and there are none yet
+func checkConnectivityCmd(client *api.Client, creds *api.Credentials, account string, periodic bool) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		msg := connectivityMsg{account: account, periodic: periodic}
+		if !api.Reachable(ctx, sessionEndpoint(client, creds)) {
+			return msg
+		}
+		if client != nil || creds == nil {
+			msg.online = true
+			return msg
+		}
+		msg.client, msg.davClient, msg.err = api.Connect(*creds)
+		msg.online = msg.err == nil
+		return msg
+	}
+}
+
+// sessionEndpoint is the JMAP session resource the probe checks
+func sessionEndpoint(client *api.Client, creds *api.Credentials) string {
+	if client != nil {
+		return client.Client.SessionEndpoint
+	}
+	if creds != nil {
+		return api.SessionURL(creds.AppConfig())
+	}
+	return api.FastmailSessionURL
+}
+
+// applyConnectivity switches between online and offline as the probe
+// found, picking up freshly built clients
+func (m *Model) applyConnectivity(msg connectivityMsg) tea.Cmd {
+	var cmds []tea.Cmd
+	if msg.periodic {
+		cmds = append(cmds, scheduleConnectivityCmd())
+	}
+	if msg.client != nil {
+		if msg.account == m.accountName() && m.client == nil {
+			m.client = msg.client
+			m.davClient = msg.davClient
+		} else {
+			// Switched accounts while connecting; keep it for later
+			for i := range m.accounts {
+				if m.accounts[i].name == msg.account && m.accounts[i].client == nil {
+					m.accounts[i].client = msg.client
+					m.accounts[i].davClient = msg.davClient
+				}
+			}
+		}
+	}
+	// Offline was chosen in Settings while the probe ran
+	if m.forceOffline {
+		return tea.Batch(cmds...)
+	}
+
+	wasOffline := m.offlineMode
+	m.offlineMode = !msg.online || m.client == nil
+	switch {
+	case wasOffline && !m.offlineMode:
+		m.status = "Back online"
+		cmds = append(cmds, m.goOnline())
+	case !wasOffline && m.offlineMode:
+		m.status = "Connection lost, working offline: changes will be queued"
+	case m.offlineMode && msg.err != nil:
+		m.status = "Server reachable but connecting failed: " + msg.err.Error()
+	case m.offlineMode && !msg.online && !msg.periodic:
+		m.status = "Can't reach the server, staying offline and retrying"
+	}
+	cmds = append(cmds, countPendingCmd(m.db))
+	return tea.Batch(cmds...)
+}
+
+// goOnline starts what only runs while connected: push, identities and
+// replaying the changes queued meanwhile
+func (m *Model) goOnline() tea.Cmd {
+	cmds := []tea.Cmd{m.startPush(), replayPendingCmd(m.client, m.db)}
+	m.replaying = m.db != nil
+	if len(m.identities) == 0 {
+		cmds = append(cmds, fetchIdentitiesCmd(m.client))
+	}
+	if m.state == viewMailboxes || m.state == viewEmails {
+		cmds = append(cmds, fetchMailboxesCmd(m.client, m.db))
+	}
+	return tea.Batch(cmds...)
+}
+
+// startPush starts the push listener, once for the program's lifetime; it
+// reconnects by itself after the network drops
+func (m Model) startPush() tea.Cmd {
+	var cmd tea.Cmd
+	m.pushOnce.Do(func() {
+		cmd = startPushCmd(m.client, m.accountName(), m.pushEvents)
+	})
+	return cmd
+}
+
+// connectionLost switches to offline mode after a request failed to reach
+// the server, so following actions are queued until the next probe
+// succeeds
+func (m *Model) connectionLost(err error) bool {
+	if m.offlineMode || !api.IsNetworkError(err) {
+		return false
+	}
+	m.offlineMode = true
+	m.status = "Connection lost, working offline: changes will be queued"
+	return true
+}
+
+// statusBar shows whether we're online and how many changes wait for the
+// server
+func (m Model) statusBar() string {
+	var state string
+	if len(m.accounts) > 1 {
+		state = accountLabel(m.accountName()) + "  "
+	}
+	switch {
+	case !m.offlineMode:
+		state += onlineStyle.Render("● Online")
+	case m.forceOffline:
+		state += offlineStyle.Render("○ Offline (Settings)")
+	default:
+		state += offlineStyle.Render("○ Offline, reconnecting")
+	}
+	if m.pendingCount > 0 {
+		state += fmt.Sprintf("  %d queued", m.pendingCount)
+	}
+	if m.stuckCount > 0 {
+		state += fmt.Sprintf("  %d need attention (5: Outbox)", m.stuckCount)
+	}
+	return state
+}
+
+func countPendingCmd(db *storage.DB) tea.Cmd {
+	if db == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		queued, stuck, err := db.CountPendingActions()
+		if err != nil {
+			return nil
+		}
+		return pendingCountMsg{queued: queued, stuck: stuck}
+	}
+}
+
+// The helpers below send a change to the server when online, or apply it
+// to the cache and queue it for replay when offline.
+
+func (m Model) setUnreadCmd(emailIDs []string, isUnread bool) tea.Cmd {
+	if m.offlineMode || m.client == nil {
+		return tea.Sequence(queueFlagsCmd(m.db, emailIDs, &isUnread, nil), countPendingCmd(m.db))
+	}
+	return toggleUnreadCmd(m.client, emailIDs, isUnread)
+}
+
+func (m Model) setFlaggedCmd(emailIDs []string, isFlagged bool) tea.Cmd {
+	if m.offlineMode || m.client == nil {
+		return tea.Sequence(queueFlagsCmd(m.db, emailIDs, nil, &isFlagged), countPendingCmd(m.db))
+	}
+	return toggleFlaggedCmd(m.client, emailIDs, isFlagged)
+}
+
+func (m Model) destroyCmd(emailIDs []string) tea.Cmd {
+	if m.offlineMode || m.client == nil {
+		return tea.Sequence(queueDeleteCmd(m.db, emailIDs), countPendingCmd(m.db))
+	}
+	return destroyEmailsCmd(m.client, emailIDs)
+}
+
+func (m Model) trashCmd(emailIDs []string, trashID string) tea.Cmd {
+	if m.offlineMode || m.client == nil {
+		return tea.Sequence(queueMailboxesCmd(m.db, trashMailboxes(emailIDs, trashID)), countPendingCmd(m.db))
+	}
+	return trashEmailsCmd(m.client, emailIDs, trashID)
+}
+
+func (m Model) moveCmd(emailIDs []string, fromMBID, toMBID string) tea.Cmd {
+	if m.offlineMode || m.client == nil {
+		return tea.Sequence(queueMoveCmd(m.db, fromMailbox(emailIDs, fromMBID), toMBID), countPendingCmd(m.db))
+	}
+	return moveEmailsCmd(m.client, emailIDs, fromMBID, toMBID)
+}
+
+func (m Model) sendCmd(draft model.Draft) tea.Cmd {
+	if m.offlineMode || m.client == nil {
+		return tea.Sequence(sendEmailOfflineCmd(m.db, draft), countPendingCmd(m.db))
+	}
+	return sendEmailCmd(m.client, m.draftID, draft)
+}
+
+func (m Model) saveCmd(draft model.Draft) tea.Cmd {
+	if m.offlineMode || m.client == nil {
+		return tea.Sequence(saveDraftOfflineCmd(m.db, draft), countPendingCmd(m.db))
+	}
+	return saveDraftCmd(m.client, m.draftID, draft)
+}
+
+func queueFlagsCmd(db *storage.DB, emailIDs []string, unread, flagged *bool) tea.Cmd {
+	return func() tea.Msg {
+		if db == nil {
+			return errorMsg(fmt.Errorf("no local storage available"))
+		}
+		if unread != nil {
+			if err := db.SetEmailsUnread(emailIDs, *unread); err != nil {
+				return errorMsg(err)
+			}
+		}
+		if flagged != nil {
+			if err := db.SetEmailsFlagged(emailIDs, *flagged); err != nil {
+				return errorMsg(err)
+			}
+		}
+		if err := mailsync.QueueSetFlags(db, emailIDs, unread, flagged); err != nil {
+			return errorMsg(err)
+		}
+		return nil
+	}
+}
+
+// queueMailboxesCmd queues putting each email in exactly the mailboxes it
+// maps to
+func queueMailboxesCmd(db *storage.DB, mailboxes map[string][]string) tea.Cmd {
+	return func() tea.Msg {
+		if db == nil {
+			return errorMsg(fmt.Errorf("no local storage available"))
+		}
+		if err := mailsync.QueueSetMailboxes(db, mailboxes); err != nil {
+			return errorMsg(err)
+		}
+		for id, mbIDs := range mailboxes {
+			if err := db.SetEmailMailboxes(id, mbIDs); err != nil {
+				return errorMsg(err)
+			}
+		}
+		return emailDeletedMsg{}
+	}
+}
+
+func queueKeywordCmd(db *storage.DB, emailIDs []string, keyword string, set bool) tea.Cmd {
+	return func() tea.Msg {
+		if db == nil {
+			return errorMsg(fmt.Errorf("no local storage available"))
+		}
+		if err := mailsync.QueueKeyword(db, emailIDs, keyword, set); err != nil {
+			return errorMsg(err)
+		}
+		return nil
+	}
+}
+
+func queueDeleteCmd(db *storage.DB, emailIDs []string) tea.Cmd {
+	return func() tea.Msg {
+		if db == nil {
+			return errorMsg(fmt.Errorf("no local storage available"))
+		}
+		if err := mailsync.QueueDelete(db, emailIDs); err != nil {
+			return errorMsg(err)
+		}
+		if err := db.DeleteEmails(emailIDs); err != nil {
+			return errorMsg(err)
+		}
+		return emailDeletedMsg{}
+	}
+}
+
+// fromMailbox maps each email to the same mailbox it leaves
+func fromMailbox(emailIDs []string, mbID string) map[string]string {
+	from := make(map[string]string, len(emailIDs))
+	for _, id := range emailIDs {
+		from[id] = mbID
+	}
+	return from
+}
+
+// queueMoveCmd queues moves out of the mailbox each email is mapped to
+func queueMoveCmd(db *storage.DB, from map[string]string, toMBID string) tea.Cmd {
+	return func() tea.Msg {
+		if db == nil {
+			return errorMsg(fmt.Errorf("no local storage available"))
+		}
+		for id, fromMBID := range from {
+			if err := mailsync.QueueMove(db, []string{id}, fromMBID, toMBID); err != nil {
+				return errorMsg(err)
+			}
+			if err := db.MoveEmail(id, fromMBID, toMBID); err != nil {
+				return errorMsg(err)
+			}
+		}
+		return emailDeletedMsg{} // Reuse deleted msg to clear loading state
+	}
+}
@@ -0,0 +1,379 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/model"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Fields of the event editor
+const (
+	eventFieldTitle = iota
+	eventFieldStart
+	eventFieldEnd
+	eventFieldAllDay
+	eventFieldLocation
+	eventFieldDescription
+	eventFieldCalendar
+	eventFieldRepeat
+	eventFieldAlarm
+	eventFieldCount
+)
+
+var eventFieldLabels = [eventFieldCount]string{
+	"Title", "Start", "End", "All day", "Location", "Description", "Calendar", "Repeats", "Alarm",
+}
+
+const (
+	eventDateLayout     = "2006-01-02"
+	eventDateTimeLayout = "2006-01-02 15:04"
+)
+
+// isEventTextField reports whether a field is typed in rather than chosen
+func isEventTextField(field int) bool {
+	switch field {
+	case eventFieldTitle, eventFieldStart, eventFieldEnd, eventFieldLocation, eventFieldDescription:
+		return true
+	}
+	return false
+}
+
+// openEventEditor starts editing a copy of event on its title
+func (m *Model) openEventEditor(event model.CalendarEvent) tea.Cmd {
+	if event.End.IsZero() || !event.End.After(event.Start) {
+		event.End = event.Start.Add(time.Hour)
+		if event.IsAllDay {
+			event.End = event.Start.AddDate(0, 0, 1)
+		}
+	}
+	m.editingEvent = &event
+	m.viewEventDetail = false
+	m.setRepeatOptions()
+	m.setAlarmOptions()
+	m.eventEditField = eventFieldTitle
+	m.loadEventField()
+	return textinput.Blink
+}
+
+// loadEventField puts the current field's value in the input
+func (m *Model) loadEventField() {
+	if !isEventTextField(m.eventEditField) {
+		m.eventInput.Blur()
+		return
+	}
+	m.eventInput.SetValue(m.eventFieldValue(m.eventEditField))
+	m.eventInput.CursorEnd()
+	switch m.eventEditField {
+	case eventFieldTitle:
+		m.eventInput.Placeholder = "Event title"
+	case eventFieldStart:
+		m.eventInput.Placeholder = "e.g. tomorrow 3pm for 90m"
+	case eventFieldEnd:
+		m.eventInput.Placeholder = "e.g. 5pm, for 2h"
+	default:
+		m.eventInput.Placeholder = ""
+	}
+	m.eventInput.Focus()
+}
+
+// eventFieldValue is how a field of the event being edited reads
+func (m Model) eventFieldValue(field int) string {
+	e := m.editingEvent
+	switch field {
+	case eventFieldTitle:
+		return e.Title
+	case eventFieldStart:
+		if e.IsAllDay {
+			return e.Start.Format(eventDateLayout)
+		}
+		return e.Start.Format(eventDateTimeLayout)
+	case eventFieldEnd:
+		if e.IsAllDay {
+			// DTEND of an all-day event is the day after it ends
+			return e.End.AddDate(0, 0, -1).Format(eventDateLayout)
+		}
+		return e.End.Format(eventDateTimeLayout)
+	case eventFieldAllDay:
+		if e.IsAllDay {
+			return "Yes"
+		}
+		return "No"
+	case eventFieldLocation:
+		return e.Location
+	case eventFieldDescription:
+		return e.Description
+	case eventFieldCalendar:
+		for _, cal := range m.calendars {
+			if cal.ID == e.CalendarID {
+				return cal.Name
+			}
+		}
+		return e.CalendarID
+	case eventFieldRepeat:
+		return m.repeatLabel()
+	case eventFieldAlarm:
+		return m.alarmLabel()
+	}
+	return ""
+}
+
+// commitEventField stores what was typed in the current field
+func (m *Model) commitEventField() error {
+	if !isEventTextField(m.eventEditField) {
+		return nil
+	}
+	e := m.editingEvent
+	value := strings.TrimSpace(m.eventInput.Value())
+	unchanged := value == m.eventFieldValue(m.eventEditField)
+
+	switch m.eventEditField {
+	case eventFieldTitle:
+		e.Title = value
+	case eventFieldLocation:
+		e.Location = value
+	case eventFieldDescription:
+		e.Description = value
+	case eventFieldStart:
+		if unchanged {
+			return nil
+		}
+		et, err := model.ParseEventTime(value, e.Start, time.Now())
+		if err != nil {
+			return fmt.Errorf("start: %w", err)
+		}
+		length := e.End.Sub(e.Start)
+		e.Start = et.Start
+		e.End = e.Start.Add(length)
+		if !et.End.IsZero() {
+			e.End = et.End
+		}
+		if e.IsAllDay {
+			e.Start = startOfDay(e.Start)
+			e.End = startOfDay(e.End)
+			if !e.End.After(e.Start) {
+				e.End = e.Start.AddDate(0, 0, 1)
+			}
+		}
+	case eventFieldEnd:
+		if unchanged {
+			return nil
+		}
+		end, err := m.parseEventEnd(value)
+		if err != nil {
+			return fmt.Errorf("end: %w", err)
+		}
+		e.End = end
+	}
+	return nil
+}
+
+// parseEventEnd reads the end field: a time, a day, or "for" a length
+func (m Model) parseEventEnd(value string) (time.Time, error) {
+	e := m.editingEvent
+	if e.IsAllDay {
+		// The last day of the event, kept as the day after
+		et, err := model.ParseEventTime(value, e.Start, time.Now())
+		if err != nil {
+			return time.Time{}, err
+		}
+		return startOfDay(et.Start).AddDate(0, 0, 1), nil
+	}
+
+	lower := strings.ToLower(value)
+	if !strings.HasPrefix(lower, "for ") && !strings.HasPrefix(lower, "to ") && !strings.HasPrefix(lower, "until ") {
+		// A bare number is a time of day, as after "to" in the start field,
+		// so only lengths with a unit count: "11" is 11:00, "90m" a length
+		_, bare := strconv.Atoi(strings.TrimSpace(value))
+		if d, err := model.ParseLength(value); err == nil && bare != nil && !strings.Contains(value, ":") {
+			return e.Start.Add(d), nil
+		}
+		value = "to " + value
+	}
+	et, err := model.ParseEventTime(value, e.Start, time.Now())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return et.End, nil
+}
+
+// cycleEventChoice changes a chosen field by delta
+func (m *Model) cycleEventChoice(delta int) {
+	e := m.editingEvent
+	switch m.eventEditField {
+	case eventFieldAllDay:
+		e.IsAllDay = !e.IsAllDay
+		if e.IsAllDay {
+			days := int(startOfDay(e.End.Add(-time.Nanosecond)).Sub(startOfDay(e.Start)).Hours() / 24)
+			e.Start = startOfDay(e.Start)
+			e.End = e.Start.AddDate(0, 0, days+1)
+		} else {
+			e.Start = e.Start.Add(9 * time.Hour)
+			e.End = e.Start.Add(time.Hour)
+		}
+	case eventFieldCalendar:
+		var writable []model.Calendar
+		current := 0
+		for _, cal := range m.calendars {
+			if !cal.MayAddItems {
+				continue
+			}
+			if cal.ID == e.CalendarID {
+				current = len(writable)
+			}
+			writable = append(writable, cal)
+		}
+		if len(writable) > 0 {
+			e.CalendarID = writable[(current+delta+len(writable))%len(writable)].ID
+		}
+	case eventFieldRepeat:
+		if n := len(m.eventRepeatOptions); n > 0 {
+			m.eventRepeat = (m.eventRepeat + delta + n) % n
+		}
+	case eventFieldAlarm:
+		if n := len(m.eventAlarmOptions); n > 0 {
+			m.eventAlarm = (m.eventAlarm + delta + n) % n
+		}
+	}
+}
+
+// validateEvent checks the event being edited can be saved
+func (m Model) validateEvent() error {
+	e := m.editingEvent
+	if e.Title == "" {
+		return fmt.Errorf("event title cannot be empty")
+	}
+	if !e.End.After(e.Start) {
+		return fmt.Errorf("event must end after it starts")
+	}
+	if e.CalendarID == "" {
+		return fmt.Errorf("no calendar to save the event in")
+	}
+	return nil
+}
+
+// updateEventEditor handles input while an event is being edited
+func (m Model) updateEventEditor(msg tea.Msg) (Model, tea.Cmd) {
+	key, ok := msg.(tea.KeyMsg)
+	if !ok {
+		var cmd tea.Cmd
+		m.eventInput, cmd = m.eventInput.Update(msg)
+		return m, cmd
+	}
+	if m.eventScope != "" {
+		next, cmd, _ := m.updateEventScope(key)
+		return next, cmd
+	}
+	m.status = ""
+
+	switch key.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc":
+		m.editingEvent = nil
+		m.eventInput.Blur()
+		return m, nil
+	case "tab", "down", "shift+tab", "up":
+		if err := m.commitEventField(); err != nil {
+			m.status = err.Error()
+			return m, nil
+		}
+		step := 1
+		if key.String() == "shift+tab" || key.String() == "up" {
+			step = eventFieldCount - 1
+		}
+		m.eventEditField = (m.eventEditField + step) % eventFieldCount
+		m.loadEventField()
+		return m, nil
+	case "enter":
+		if err := m.commitEventField(); err != nil {
+			m.status = err.Error()
+			return m, nil
+		}
+		if err := m.validateEvent(); err != nil {
+			m.status = err.Error()
+			return m, nil
+		}
+		if m.davClient == nil {
+			return m, nil
+		}
+		cmd := m.saveEvent()
+		return m, cmd
+	}
+
+	if !isEventTextField(m.eventEditField) {
+		switch key.String() {
+		case " ", "right", "l":
+			m.cycleEventChoice(1)
+		case "left", "h":
+			m.cycleEventChoice(-1)
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.eventInput, cmd = m.eventInput.Update(msg)
+	return m, cmd
+}
+
+// eventEditorView renders the event form
+func (m Model) eventEditorView() string {
+	var s strings.Builder
+	if m.editingEvent.ID == "" {
+		s.WriteString("Create New Event\n\n")
+	} else {
+		s.WriteString("Edit Event\n\n")
+	}
+
+	for i := 0; i < eventFieldCount; i++ {
+		marker := " "
+		value := m.eventFieldValue(i)
+		if i == m.eventEditField {
+			marker = ">"
+			if isEventTextField(i) {
+				value = m.eventInput.View()
+			} else {
+				value = "< " + value + " >"
+			}
+		}
+		s.WriteString(fmt.Sprintf("%s %-12s %s\n", marker, eventFieldLabels[i]+":", value))
+	}
+
+	if m.eventScope != "" {
+		s.WriteString(m.eventScopeView())
+	} else if isEventTextField(m.eventEditField) {
+		s.WriteString("\n(tab/shift+tab: next/previous field, enter: save, esc: cancel)")
+	} else {
+		s.WriteString("\n(space/left/right: change, tab/shift+tab: next/previous field, enter: save, esc: cancel)")
+	}
+	return s.String()
+}
+
+// moveEventIfNeeded moves an event whose calendar was changed in the
+// editor before it is updated at its new path
+func moveEventIfNeeded(ctx context.Context, davClient *api.DAVClient, event *model.CalendarEvent) error {
+	if event.ID == "" || path.Dir(event.ID)+"/" == event.CalendarID {
+		return nil
+	}
+	newPath, err := davClient.MoveEvent(ctx, *event)
+	if err != nil {
+		return err
+	}
+	// The ETag was checked by the move and may not survive it
+	event.ID = newPath
+	event.ETag = ""
+	return nil
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, mo, d := t.Date()
+	return time.Date(y, mo, d, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,482 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/mailsync"
+	"fm-cli/internal/model"
+	"fm-cli/internal/storage"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// folderChangedMsg reports a folder change along with the reloaded list
+type folderChangedMsg struct {
+	status    string
+	mailboxes []model.Mailbox
+	selectID  string // Mailbox to put the cursor on
+}
+
+// folderHasEmailMsg asks whether to delete a folder's emails with it
+type folderHasEmailMsg struct {
+	id string
+}
+
+// mailboxTree orders mailboxes depth first, each folder followed by its
+// subfolders, siblings by sort order and then name. Folders whose parent
+// isn't in the list are shown at the top level.
+func mailboxTree(mbs []model.Mailbox) []model.Mailbox {
+	known := make(map[string]bool, len(mbs))
+	for _, mb := range mbs {
+		known[mb.ID] = true
+	}
+	children := make(map[string][]model.Mailbox)
+	for _, mb := range mbs {
+		parent := mb.ParentID
+		if !known[parent] {
+			parent = ""
+		}
+		children[parent] = append(children[parent], mb)
+	}
+
+	tree := make([]model.Mailbox, 0, len(mbs))
+	var walk func(parent string)
+	walk = func(parent string) {
+		kids := children[parent]
+		sort.SliceStable(kids, func(i, j int) bool {
+			if kids[i].SortOrder != kids[j].SortOrder {
+				return kids[i].SortOrder < kids[j].SortOrder
+			}
+			return strings.ToLower(kids[i].Name) < strings.ToLower(kids[j].Name)
+		})
+		for _, mb := range kids {
+			tree = append(tree, mb)
+			walk(mb.ID)
+		}
+	}
+	walk("")
+	return tree
+}
+
+// setMailboxes shows a new mailbox list as a tree, keeping the cursor on
+// selectID if given, or else on the mailbox it was on
+func (m *Model) setMailboxes(mbs []model.Mailbox, selectID string) {
+	if selectID == "" && m.mbCursor < len(m.mailboxes) {
+		selectID = m.mailboxes[m.mbCursor].ID
+	}
+	m.mailboxes = mailboxTree(mbs)
+	found := false
+	for i, mb := range m.mailboxes {
+		if mb.ID == selectID {
+			m.mbCursor = i
+			found = true
+			break
+		}
+	}
+	// The mailbox is gone, so stay about where it was
+	if !found && m.mbCursor >= len(m.mailboxes) {
+		m.mbCursor = len(m.mailboxes) - 1
+	}
+	if m.mbCursor < 0 {
+		m.mbCursor = 0
+	}
+	// Open the folders above the cursor so it can be seen
+	for _, id := range m.mailboxAncestors(m.mbCursor) {
+		delete(m.collapsedMailboxes, id)
+	}
+}
+
+func (m Model) mailboxByID(id string) (model.Mailbox, bool) {
+	for _, mb := range m.mailboxes {
+		if mb.ID == id {
+			return mb, true
+		}
+	}
+	return model.Mailbox{}, false
+}
+
+// mailboxAncestors returns the IDs of the folders containing mailbox i
+func (m Model) mailboxAncestors(i int) []string {
+	if i >= len(m.mailboxes) {
+		return nil
+	}
+	var ids []string
+	seen := map[string]bool{}
+	parent := m.mailboxes[i].ParentID
+	for parent != "" && !seen[parent] {
+		mb, ok := m.mailboxByID(parent)
+		if !ok {
+			break
+		}
+		seen[parent] = true
+		ids = append(ids, parent)
+		parent = mb.ParentID
+	}
+	return ids
+}
+
+// mailboxHidden reports whether mailbox i is inside a collapsed folder
+func (m Model) mailboxHidden(i int) bool {
+	for _, id := range m.mailboxAncestors(i) {
+		if m.collapsedMailboxes[id] {
+			return true
+		}
+	}
+	return false
+}
+
+func (m Model) hasSubfolders(id string) bool {
+	for _, mb := range m.mailboxes {
+		if mb.ParentID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// moveMailboxCursor moves to the next visible mailbox in direction step
+func (m *Model) moveMailboxCursor(step int) {
+	for i := m.mbCursor + step; i >= 0 && i < len(m.mailboxes); i += step {
+		if !m.mailboxHidden(i) {
+			m.mbCursor = i
+			return
+		}
+	}
+}
+
+// updateMailboxes handles the folder keys of the mailbox list. Keys it
+// doesn't use fall through to the global handlers.
+func (m Model) updateMailboxes(msg tea.KeyMsg) (Model, tea.Cmd, bool) {
+	if m.mailboxConfirm != "" {
+		confirm, id := m.mailboxConfirm, m.mailboxConfirmID
+		m.mailboxConfirm, m.mailboxConfirmID = "", ""
+		if msg.String() != "y" {
+			return m, nil, true
+		}
+		m.loading = true
+		if confirm == "empty" {
+			mb, _ := m.mailboxByID(id)
+			return m, emptyFolderCmd(m.client, m.db, mb), true
+		}
+		return m, deleteFolderCmd(m.client, m.db, id, confirm == "emails"), true
+	}
+
+	if m.movingMailbox != "" {
+		switch msg.String() {
+		case "esc":
+			m.movingMailbox = ""
+			return m, nil, true
+		case "enter", "t":
+			id := m.movingMailbox
+			parentID := ""
+			if msg.String() == "enter" && m.mbCursor < len(m.mailboxes) {
+				parentID = m.mailboxes[m.mbCursor].ID
+			}
+			if parentID == id || m.isInside(parentID, id) {
+				m.status = "A folder can't go inside itself"
+				return m, nil, true
+			}
+			m.movingMailbox = ""
+			m.loading = true
+			return m, moveFolderCmd(m.client, m.db, id, parentID), true
+		}
+	}
+
+	switch msg.String() {
+	case "up", "k":
+		m.moveMailboxCursor(-1)
+		return m, nil, true
+	case "down", "j":
+		m.moveMailboxCursor(1)
+		return m, nil, true
+	case " ":
+		if m.mbCursor < len(m.mailboxes) {
+			id := m.mailboxes[m.mbCursor].ID
+			if m.hasSubfolders(id) {
+				if m.collapsedMailboxes == nil {
+					m.collapsedMailboxes = make(map[string]bool)
+				}
+				m.collapsedMailboxes[id] = !m.collapsedMailboxes[id]
+			}
+		}
+		return m, nil, true
+	case "a", "A", "R", "M", "D", "E":
+	default:
+		return m, nil, false
+	}
+
+	// Folder changes need the server
+	if m.offlineMode || m.client == nil {
+		m.status = "Folders can only be changed while online"
+		return m, nil, true
+	}
+	if m.loading {
+		return m, nil, true
+	}
+	var selected model.Mailbox
+	if m.mbCursor < len(m.mailboxes) {
+		selected = m.mailboxes[m.mbCursor]
+	}
+
+	switch msg.String() {
+	case "a", "A":
+		m.folderPrompt = "create"
+		m.folderParent = ""
+		m.folderInput.Placeholder = "New folder"
+		if msg.String() == "a" && selected.ID != "" {
+			m.folderParent = selected.ID
+			m.folderInput.Placeholder = "New folder in " + selected.Name
+		}
+		m.folderInput.SetValue("")
+		m.folderInput.Focus()
+		return m, textinput.Blink, true
+	case "R":
+		if selected.ID == "" {
+			return m, nil, true
+		}
+		m.folderPrompt = "rename"
+		m.folderInput.Placeholder = selected.Name
+		m.folderInput.SetValue(selected.Name)
+		m.folderInput.CursorEnd()
+		m.folderInput.Focus()
+		return m, textinput.Blink, true
+	case "M":
+		if selected.ID != "" {
+			m.movingMailbox = selected.ID
+		}
+		return m, nil, true
+	case "D":
+		switch {
+		case selected.ID == "":
+		case selected.Role != "":
+			m.status = fmt.Sprintf("%s is a special folder and can't be deleted", selected.Name)
+		case m.hasSubfolders(selected.ID):
+			m.status = api.ErrMailboxHasChild.Error()
+		default:
+			m.mailboxConfirm = "delete"
+			m.mailboxConfirmID = selected.ID
+		}
+		return m, nil, true
+	case "E":
+		if selected.Role == "trash" || selected.Role == "junk" {
+			m.mailboxConfirm = "empty"
+			m.mailboxConfirmID = selected.ID
+		} else if selected.ID != "" {
+			m.status = "Only the Trash and Junk folders can be emptied"
+		}
+		return m, nil, true
+	}
+	return m, nil, true
+}
+
+// isInside reports whether mailbox id is somewhere inside folder ancestor
+func (m Model) isInside(id, ancestor string) bool {
+	for i, mb := range m.mailboxes {
+		if mb.ID != id {
+			continue
+		}
+		for _, a := range m.mailboxAncestors(i) {
+			if a == ancestor {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// updateFolderPrompt handles typing a folder name
+func (m Model) updateFolderPrompt(msg tea.Msg) (Model, tea.Cmd) {
+	key, ok := msg.(tea.KeyMsg)
+	if !ok {
+		var cmd tea.Cmd
+		m.folderInput, cmd = m.folderInput.Update(msg)
+		return m, cmd
+	}
+
+	switch key.Type {
+	case tea.KeyEsc:
+		m.folderPrompt = ""
+		m.folderInput.Blur()
+		return m, nil
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEnter:
+		name := strings.TrimSpace(m.folderInput.Value())
+		if name == "" {
+			return m, nil
+		}
+		prompt := m.folderPrompt
+		m.folderPrompt = ""
+		m.folderInput.Blur()
+		m.loading = true
+		if prompt == "rename" && m.mbCursor < len(m.mailboxes) {
+			return m, renameFolderCmd(m.client, m.db, m.mailboxes[m.mbCursor], name)
+		}
+		return m, createFolderCmd(m.client, m.db, name, m.folderParent)
+	}
+
+	var cmd tea.Cmd
+	m.folderInput, cmd = m.folderInput.Update(msg)
+	return m, cmd
+}
+
+// mailboxesView renders the mailbox tree
+func (m Model) mailboxesView() string {
+	var s strings.Builder
+	if m.loading {
+		s.WriteString("Loading mailboxes...")
+	} else if len(m.mailboxes) == 0 {
+		s.WriteString("No mailboxes found.")
+	}
+	for i, mb := range m.mailboxes {
+		if m.mailboxHidden(i) {
+			continue
+		}
+		cursor := " "
+		style := mailboxStyle
+		if i == m.mbCursor {
+			cursor = ">"
+			style = selectedMailboxStyle
+		}
+
+		indent := strings.Repeat("  ", len(m.mailboxAncestors(i)))
+		marker := "  "
+		if m.hasSubfolders(mb.ID) {
+			marker = "▾ "
+			if m.collapsedMailboxes[mb.ID] {
+				marker = "▸ "
+			}
+		}
+		label := fmt.Sprintf("%s %s%s%s (%d)", cursor, indent, marker, mb.Name, mb.UnreadCount)
+		if mb.ID == m.movingMailbox {
+			label += "  [moving]"
+		}
+		s.WriteString(style.Render(label) + "\n")
+	}
+
+	switch {
+	case m.folderPrompt == "create":
+		s.WriteString("\nNew folder: " + m.folderInput.View())
+		s.WriteString("\n(enter: create, esc: cancel)")
+	case m.folderPrompt == "rename":
+		s.WriteString("\nRename to: " + m.folderInput.View())
+		s.WriteString("\n(enter: rename, esc: cancel)")
+	case m.mailboxConfirm == "delete":
+		mb, _ := m.mailboxByID(m.mailboxConfirmID)
+		s.WriteString(fmt.Sprintf("\nDelete folder %q? (y/n)", mb.Name))
+	case m.mailboxConfirm == "empty":
+		mb, _ := m.mailboxByID(m.mailboxConfirmID)
+		s.WriteString(fmt.Sprintf("\nPermanently delete every email in %q? This can't be undone. (y/n)", mb.Name))
+	case m.mailboxConfirm == "emails":
+		mb, _ := m.mailboxByID(m.mailboxConfirmID)
+		s.WriteString(fmt.Sprintf("\n%q still has emails. Delete them with it? Emails also in other folders are kept. (y/n)", mb.Name))
+	case m.movingMailbox != "":
+		mb, _ := m.mailboxByID(m.movingMailbox)
+		s.WriteString(fmt.Sprintf("\nMoving %q: pick the folder to put it in and press enter, t: top level, esc: cancel", mb.Name))
+	default:
+		s.WriteString("\n(j/k navigate, enter/l open, space: fold, a/A: new subfolder/folder, R: rename, M: move, D: delete, E: empty Trash/Junk, r: refresh, c: compose)")
+	}
+	return s.String()
+}
+
+// loadMailboxes reads the mailbox list, through the local cache if there
+// is one
+func loadMailboxes(client *api.Client, db *storage.DB) ([]model.Mailbox, error) {
+	if db != nil {
+		// Apply Mailbox/changes deltas instead of refetching
+		return mailsync.New(client, db).SyncMailboxes()
+	}
+	return client.FetchMailboxes()
+}
+
+// folderDone reloads the mailboxes after a folder change
+func folderDone(client *api.Client, db *storage.DB, status, selectID string) tea.Msg {
+	mbs, err := loadMailboxes(client, db)
+	if err != nil {
+		return errorMsg(err)
+	}
+	return folderChangedMsg{status: status, mailboxes: mbs, selectID: selectID}
+}
+
+func createFolderCmd(client *api.Client, db *storage.DB, name, parentID string) tea.Cmd {
+	return func() tea.Msg {
+		mb, err := client.CreateMailbox(name, parentID)
+		if err != nil {
+			return errorMsg(err)
+		}
+		if db != nil {
+			db.SaveMailboxes([]model.Mailbox{mb})
+		}
+		return folderDone(client, db, "Created folder "+name, mb.ID)
+	}
+}
+
+func renameFolderCmd(client *api.Client, db *storage.DB, mb model.Mailbox, name string) tea.Cmd {
+	return func() tea.Msg {
+		if err := client.RenameMailbox(mb.ID, name); err != nil {
+			return errorMsg(err)
+		}
+		if db != nil {
+			mb.Name = name
+			db.SaveMailboxes([]model.Mailbox{mb})
+		}
+		return folderDone(client, db, "Renamed folder to "+name, mb.ID)
+	}
+}
+
+func moveFolderCmd(client *api.Client, db *storage.DB, id, parentID string) tea.Cmd {
+	return func() tea.Msg {
+		if err := client.MoveMailbox(id, parentID); err != nil {
+			return errorMsg(err)
+		}
+		if db != nil {
+			if mbs, err := db.GetMailboxes(); err == nil {
+				for _, mb := range mbs {
+					if mb.ID == id {
+						mb.ParentID = parentID
+						db.SaveMailboxes([]model.Mailbox{mb})
+					}
+				}
+			}
+		}
+		return folderDone(client, db, "Moved folder", id)
+	}
+}
+
+func deleteFolderCmd(client *api.Client, db *storage.DB, id string, removeEmails bool) tea.Cmd {
+	return func() tea.Msg {
+		err := client.DeleteMailbox(id, removeEmails)
+		if errors.Is(err, api.ErrMailboxHasEmail) && !removeEmails {
+			return folderHasEmailMsg{id: id}
+		}
+		if err != nil {
+			return errorMsg(err)
+		}
+		if db != nil {
+			if removeEmails {
+				db.DeleteEmailsOnlyIn(id)
+			}
+			db.DeleteMailboxes([]string{id})
+		}
+		return folderDone(client, db, "Deleted folder", "")
+	}
+}
+
+// emptyFolderCmd permanently deletes everything in the Trash or Junk
+func emptyFolderCmd(client *api.Client, db *storage.DB, mb model.Mailbox) tea.Cmd {
+	return func() tea.Msg {
+		ids, err := client.EmptyMailbox(mb.ID)
+		if db != nil {
+			db.DeleteEmails(ids)
+		}
+		if err != nil {
+			return errorMsg(err)
+		}
+		return folderDone(client, db, fmt.Sprintf("Emptied %s, %s deleted for good", mb.Name, messageCount(len(ids))), mb.ID)
+	}
+}
@@ -0,0 +1,30 @@
+package tui
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// markdown renders compose bodies. Line breaks are kept as typed, since
+// mail written in an editor rarely means two lines to be one paragraph, and
+// raw HTML in the source is left out rather than passed through; the plain
+// text part still carries it as typed.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithRendererOptions(gmhtml.WithHardWraps()),
+)
+
+// markdownToHTML renders a Markdown body as a complete HTML document for
+// the text/html alternative of an outgoing message
+func markdownToHTML(body string) (string, error) {
+	var buf bytes.Buffer
+	buf.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"></head><body>\n")
+	if err := markdown.Convert([]byte(body), &buf); err != nil {
+		return "", err
+	}
+	buf.WriteString("</body></html>\n")
+	return buf.String(), nil
+}
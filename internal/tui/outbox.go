@@ -0,0 +1,253 @@
+package tui
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/mailsync"
+	"fm-cli/internal/storage"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type pendingActionsLoadedMsg []storage.PendingAction
+type pendingReplayedMsg struct {
+	result mailsync.ReplayResult
+	err    error
+}
+
+// updateOutbox handles the keys of the Outbox view. Keys it doesn't use
+// fall through to the global handlers.
+func (m Model) updateOutbox(msg tea.KeyMsg) (Model, tea.Cmd, bool) {
+	if m.discardConfirm {
+		m.discardConfirm = false
+		if msg.String() == "y" && m.pendingCursor < len(m.pendingActions) {
+			return m, discardPendingActionCmd(m.db, m.pendingActions[m.pendingCursor]), true
+		}
+		return m, nil, true
+	}
+
+	switch msg.String() {
+	case "up", "k":
+		if m.pendingCursor > 0 {
+			m.pendingCursor--
+		}
+		return m, nil, true
+	case "down", "j":
+		if m.pendingCursor < len(m.pendingActions)-1 {
+			m.pendingCursor++
+		}
+		return m, nil, true
+	case "r":
+		// Retry the selected action
+		if m.pendingCursor < len(m.pendingActions) {
+			m.replaying = true
+			return m, retryPendingActionsCmd(m.onlineClient(), m.db, []int64{m.pendingActions[m.pendingCursor].ID}), true
+		}
+		return m, nil, true
+	case "R":
+		// Retry everything that failed or conflicted. Unconfirmed sends
+		// are left for r, as they may already have gone out.
+		var ids []int64
+		for _, a := range m.pendingActions {
+			if a.Status != storage.PendingStatusQueued && a.Status != storage.PendingStatusUnconfirmed {
+				ids = append(ids, a.ID)
+			}
+		}
+		m.replaying = true
+		return m, retryPendingActionsCmd(m.onlineClient(), m.db, ids), true
+	case "d", "x":
+		if m.pendingCursor < len(m.pendingActions) {
+			m.discardConfirm = true
+		}
+		return m, nil, true
+	case "esc", "h", "left":
+		m.state = viewMainMenu
+		return m, nil, true
+	}
+	return m, nil, false
+}
+
+// onlineClient is the JMAP client, or nil while offline
+func (m Model) onlineClient() *api.Client {
+	if m.offlineMode {
+		return nil
+	}
+	return m.client
+}
+
+// outboxView lists queued, failed, conflicting and unconfirmed actions
+func (m Model) outboxView() string {
+	var s strings.Builder
+	s.WriteString("Outbox / Pending Changes\n\n")
+
+	if len(m.pendingActions) == 0 {
+		s.WriteString("Nothing waiting to be synced.\n")
+		s.WriteString("\n(esc: back)")
+		return s.String()
+	}
+
+	for i, a := range m.pendingActions {
+		cursor := "  "
+		style := mailboxStyle
+		if i == m.pendingCursor {
+			cursor = "> "
+			style = selectedMailboxStyle
+		}
+		status := "queued"
+		switch a.Status {
+		case storage.PendingStatusFailed:
+			status = "FAILED"
+		case storage.PendingStatusConflict:
+			status = "CONFLICT"
+		case storage.PendingStatusUnconfirmed:
+			status = "UNCONFIRMED"
+		}
+		label := fmt.Sprintf("%s[%s] %s  (%s", cursor, status, describePendingAction(a), a.CreatedAt.Local().Format("Jan 2 15:04"))
+		if a.Attempts > 0 {
+			label += fmt.Sprintf(", %d attempts", a.Attempts)
+		}
+		label += ")"
+		s.WriteString(style.Render(label) + "\n")
+		if a.LastError != "" && (i == m.pendingCursor || a.Status != storage.PendingStatusQueued) {
+			s.WriteString("      " + a.LastError + "\n")
+		}
+	}
+
+	if m.discardConfirm {
+		s.WriteString("\nDiscard this change? It will never reach the server. (y/n)")
+	} else if m.replaying {
+		s.WriteString("\nSyncing...")
+	} else {
+		s.WriteString("\n(j/k: navigate, r: retry, R: retry all failed, d: discard, esc: back)")
+	}
+	return s.String()
+}
+
+// describePendingAction summarises an action for the Outbox list
+func describePendingAction(a storage.PendingAction) string {
+	switch a.Type {
+	case mailsync.ActionSaveDraft, mailsync.ActionSendDraft:
+		var data struct {
+			To      string `json:"to"`
+			Subject string `json:"subject"`
+		}
+		json.Unmarshal([]byte(a.Data), &data)
+		verb := "Save draft"
+		if a.Type == mailsync.ActionSendDraft {
+			verb = "Send"
+		}
+		if data.Subject == "" {
+			data.Subject = "(no subject)"
+		}
+		if data.To == "" {
+			return fmt.Sprintf("%s %q", verb, data.Subject)
+		}
+		return fmt.Sprintf("%s %q to %s", verb, data.Subject, data.To)
+	case mailsync.ActionDelete:
+		return "Delete email " + a.EmailID
+	case mailsync.ActionMove, mailsync.ActionMailboxes:
+		return "Move email " + a.EmailID
+	case mailsync.ActionSetFlags:
+		return "Update flags on email " + a.EmailID
+	case mailsync.ActionKeyword:
+		return "Update keywords on email " + a.EmailID
+	}
+	return a.Type + " " + a.EmailID
+}
+
+func fetchPendingActionsCmd(db *storage.DB) tea.Cmd {
+	return func() tea.Msg {
+		if db == nil {
+			return pendingActionsLoadedMsg(nil)
+		}
+		actions, err := db.GetPendingActions()
+		if err != nil {
+			return errorMsg(err)
+		}
+		return pendingActionsLoadedMsg(actions)
+	}
+}
+
+// replayPendingCmd drains the pending action queue in the background
+func replayPendingCmd(client *api.Client, db *storage.DB) tea.Cmd {
+	if client == nil || db == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		result, err := mailsync.New(client, db).ReplayPending()
+		return pendingReplayedMsg{result: result, err: err}
+	}
+}
+
+// retryPendingActionsCmd puts actions back in the queue and, when online,
+// replays it straight away
+func retryPendingActionsCmd(client *api.Client, db *storage.DB, ids []int64) tea.Cmd {
+	return func() tea.Msg {
+		if db == nil {
+			return pendingReplayedMsg{}
+		}
+		for _, id := range ids {
+			if err := db.RequeuePendingAction(id); err != nil {
+				return pendingReplayedMsg{err: err}
+			}
+		}
+		if client == nil {
+			queued, _, _ := db.CountPendingActions()
+			return pendingReplayedMsg{result: mailsync.ReplayResult{Remaining: queued}}
+		}
+		result, err := mailsync.New(client, db).ReplayPending()
+		return pendingReplayedMsg{result: result, err: err}
+	}
+}
+
+// discardPendingActionCmd drops an action, along with the local draft it
+// would have uploaded
+func discardPendingActionCmd(db *storage.DB, a storage.PendingAction) tea.Cmd {
+	return func() tea.Msg {
+		if err := db.RemovePendingAction(a.ID); err != nil {
+			return errorMsg(err)
+		}
+		if a.Type == mailsync.ActionSaveDraft || a.Type == mailsync.ActionSendDraft {
+			if err := db.DeleteLocalDraft(a.EmailID); err != nil {
+				return errorMsg(err)
+			}
+		}
+		actions, err := db.GetPendingActions()
+		if err != nil {
+			return errorMsg(err)
+		}
+		return pendingActionsLoadedMsg(actions)
+	}
+}
+
+// replaySummary describes a replay pass for the status line, or "" if it
+// did nothing worth mentioning
+func replaySummary(r mailsync.ReplayResult) string {
+	var parts []string
+	if r.Done > 0 {
+		parts = append(parts, fmt.Sprintf("%d synced", r.Done))
+	}
+	if r.Failed > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed", r.Failed))
+	}
+	if r.Conflicts > 0 {
+		parts = append(parts, fmt.Sprintf("%d in conflict", r.Conflicts))
+	}
+	if r.Unconfirmed > 0 {
+		parts = append(parts, fmt.Sprintf("%d sends unconfirmed", r.Unconfirmed))
+	}
+	if r.Remaining > 0 {
+		parts = append(parts, fmt.Sprintf("%d still queued", r.Remaining))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	summary := "Pending changes: " + strings.Join(parts, ", ")
+	if r.Failed > 0 || r.Conflicts > 0 || r.Unconfirmed > 0 {
+		summary += " (see Outbox)"
+	}
+	return summary
+}
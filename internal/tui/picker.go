@@ -0,0 +1,354 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"fm-cli/internal/model"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// folderChoice is a mailbox offered by the folder picker
+type folderChoice struct {
+	mailbox model.Mailbox
+	path    string // Names from the top level down, e.g. "Work/Clients"
+	score   int
+}
+
+// mailboxPath returns the full name of mailbox i, its folders joined by "/"
+func (m Model) mailboxPath(i int) string {
+	names := []string{m.mailboxes[i].Name}
+	for _, id := range m.mailboxAncestors(i) {
+		if mb, ok := m.mailboxByID(id); ok {
+			names = append([]string{mb.Name}, names...)
+		}
+	}
+	return strings.Join(names, "/")
+}
+
+// fuzzyScore matches query against s as a subsequence, ignoring case.
+// Letters that follow each other or start a word score higher, so "wc"
+// ranks "Work/Clients" above "Newsletters/Archive".
+func fuzzyScore(query, s string) (int, bool) {
+	q := []rune(strings.ToLower(query))
+	if len(q) == 0 {
+		return 0, true
+	}
+	r := []rune(s)
+	score, qi, last := 0, 0, -1
+	for i := 0; i < len(r) && qi < len(q); i++ {
+		if unicode.ToLower(r[i]) != q[qi] {
+			continue
+		}
+		score++
+		if last == i-1 {
+			score += 3
+		}
+		if i == 0 || !unicode.IsLetter(r[i-1]) && !unicode.IsDigit(r[i-1]) {
+			score += 2
+		}
+		last = i
+		qi++
+	}
+	if qi < len(q) {
+		return 0, false
+	}
+	// Prefer the shorter of otherwise equal matches
+	return score*100 - len(r), true
+}
+
+// pickerChoices returns the folders matching the picker's filter, best
+// first and otherwise in tree order
+func (m Model) pickerChoices() []folderChoice {
+	skip := make(map[string]bool, len(m.pickerSkip))
+	for _, id := range m.pickerSkip {
+		skip[id] = true
+	}
+	query := strings.TrimSpace(m.pickerInput.Value())
+	var choices []folderChoice
+	for i, mb := range m.mailboxes {
+		if skip[mb.ID] {
+			continue
+		}
+		path := m.mailboxPath(i)
+		score, ok := fuzzyScore(query, path)
+		if !ok {
+			continue
+		}
+		choices = append(choices, folderChoice{mailbox: mb, path: path, score: score})
+	}
+	if query != "" {
+		sort.SliceStable(choices, func(i, j int) bool {
+			return choices[i].score > choices[j].score
+		})
+	}
+	return choices
+}
+
+// sourceMailbox returns the mailbox e is moved out of: the open one, or
+// for search results the one it was found in
+func (m Model) sourceMailbox(e model.Email) string {
+	if m.searchQuery != nil && len(e.MailboxIDs) > 0 {
+		return m.firstMailbox(e)
+	}
+	return m.mailboxes[m.mbCursor].ID
+}
+
+// firstMailbox returns the first of e's mailboxes in folder list order, so
+// an email filed in several is always taken out of the same one
+func (m Model) firstMailbox(e model.Email) string {
+	for _, mb := range m.mailboxes {
+		if hasMailbox(e, mb.ID) {
+			return mb.ID
+		}
+	}
+	if len(e.MailboxIDs) > 0 {
+		return e.MailboxIDs[0]
+	}
+	return ""
+}
+
+// cursorMailboxEmailIDs returns the emails in mailbox mbID an action at the
+// cursor applies to. In thread mode that's the conversation's messages in
+// it; replies in Sent stay where they are.
+func (m Model) cursorMailboxEmailIDs(mbID string) []string {
+	selected := m.emails[m.emailCursor]
+	t, ok := m.cursorThread()
+	if !ok {
+		return []string{selected.ID}
+	}
+	var ids []string
+	for _, e := range t.Emails {
+		if hasMailbox(e, mbID) {
+			ids = append(ids, e.ID)
+		}
+	}
+	if len(ids) == 0 {
+		ids = []string{selected.ID}
+	}
+	return ids
+}
+
+func hasMailbox(e model.Email, mbID string) bool {
+	for _, id := range e.MailboxIDs {
+		if id == mbID {
+			return true
+		}
+	}
+	return false
+}
+
+// dropCursorEmail takes the entry under the cursor out of the list
+func (m *Model) dropCursorEmail() {
+	if m.emailCursor >= len(m.emails) {
+		return
+	}
+	m.emails = append(m.emails[:m.emailCursor], m.emails[m.emailCursor+1:]...)
+	if m.emailCursor >= len(m.emails) && m.emailCursor > 0 {
+		m.emailCursor--
+	}
+}
+
+// openFolderPicker starts choosing a folder to "move" or "copy" the list
+// entry under the cursor, or the message being read, to
+func (m Model) openFolderPicker(action string) (Model, tea.Cmd) {
+	if m.unified || len(m.mailboxes) == 0 {
+		return m, nil
+	}
+	m.pickerBulk = nil
+	if m.state == viewEmails && m.marking() {
+		m.commitVisual()
+		m.pickerBulk = m.markedTargets(action == "move")
+		m.pickerIDs = nil
+		m.pickerFrom = ""
+		m.pickerSkip = nil
+		for id, from := range m.pickerBulk {
+			m.pickerIDs = append(m.pickerIDs, id)
+			m.pickerFrom = from
+		}
+		// Offer every folder unless they all leave the same one
+		for _, from := range m.pickerBulk {
+			if from != m.pickerFrom {
+				m.pickerFrom = ""
+				break
+			}
+		}
+		if m.pickerFrom != "" {
+			m.pickerSkip = []string{m.pickerFrom}
+		}
+		m.picking = action
+		m.pickerCursor = 0
+		m.pickerInput.SetValue("")
+		m.pickerInput.Focus()
+		return m, nil
+	}
+
+	var e model.Email
+	var ids []string
+	if m.state == viewBody {
+		var ok bool
+		if e, ok = m.readingEmail(); !ok {
+			return m, nil
+		}
+		ids = []string{e.ID}
+	} else {
+		if len(m.emails) == 0 {
+			return m, nil
+		}
+		e = m.emails[m.emailCursor]
+	}
+	from := m.sourceMailbox(e)
+	if m.thread != nil && !hasMailbox(e, from) && len(e.MailboxIDs) > 0 {
+		// A reply in Sent, say, read as part of the conversation
+		from = m.firstMailbox(e)
+	}
+	if ids == nil {
+		ids = m.cursorMailboxEmailIDs(from)
+	}
+
+	m.picking = action
+	m.pickerIDs = ids
+	m.pickerFrom = from
+	m.pickerSkip = []string{from}
+	if action == "copy" {
+		// Already there, so nothing to copy to
+		m.pickerSkip = append(m.pickerSkip, e.MailboxIDs...)
+	}
+	m.pickerCursor = 0
+	m.pickerInput.SetValue("")
+	m.pickerInput.Focus()
+	return m, nil
+}
+
+// updateFolderPicker handles filtering and choosing in the folder picker
+func (m Model) updateFolderPicker(msg tea.Msg) (Model, tea.Cmd) {
+	key, ok := msg.(tea.KeyMsg)
+	if !ok {
+		var cmd tea.Cmd
+		m.pickerInput, cmd = m.pickerInput.Update(msg)
+		return m, cmd
+	}
+
+	switch key.String() {
+	case "esc":
+		m.picking = ""
+		m.pickerInput.Blur()
+		return m, nil
+	case "ctrl+c":
+		return m, tea.Quit
+	case "up", "ctrl+p", "shift+tab":
+		if m.pickerCursor > 0 {
+			m.pickerCursor--
+		}
+		return m, nil
+	case "down", "ctrl+n", "tab":
+		if m.pickerCursor < len(m.pickerChoices())-1 {
+			m.pickerCursor++
+		}
+		return m, nil
+	case "enter":
+		choices := m.pickerChoices()
+		if m.pickerCursor >= len(choices) {
+			return m, nil
+		}
+		return m.pickFolder(choices[m.pickerCursor])
+	}
+
+	before := m.pickerInput.Value()
+	var cmd tea.Cmd
+	m.pickerInput, cmd = m.pickerInput.Update(msg)
+	if m.pickerInput.Value() != before {
+		m.pickerCursor = 0
+	}
+	return m, cmd
+}
+
+// pickFolder moves or copies the picked emails to the chosen folder.
+// Copying adds the folder alongside the ones they're already in.
+func (m Model) pickFolder(choice folderChoice) (Model, tea.Cmd) {
+	action, ids, from := m.picking, m.pickerIDs, m.pickerFrom
+	m.picking = ""
+	m.pickerInput.Blur()
+
+	if m.pickerBulk != nil {
+		bulk := m.pickerBulk
+		m.pickerBulk = nil
+		if action == "copy" {
+			for id := range bulk {
+				bulk[id] = ""
+			}
+			m.recordMove("copying "+messageCount(len(bulk))+" to "+choice.path, bulk, choice.mailbox.ID)
+			return m, m.bulkMoveCmd(func(count string) string {
+				return "Copied " + count + " to " + choice.path
+			}, bulk, choice.mailbox.ID)
+		}
+		m.recordMove("moving "+messageCount(len(bulk))+" to "+choice.path, bulk, choice.mailbox.ID)
+		return m, m.bulkMoveCmd(func(count string) string {
+			return "Moved " + count + " to " + choice.path
+		}, bulk, choice.mailbox.ID)
+	}
+
+	what := messageCount(len(ids))
+	if action == "copy" {
+		m.status = fmt.Sprintf("Copied %s to %s", what, choice.path)
+		m.recordMove("copying "+what+" to "+choice.path, fromMailbox(ids, ""), choice.mailbox.ID)
+		return m, m.moveCmd(ids, "", choice.mailbox.ID)
+	}
+
+	m.status = fmt.Sprintf("Moved %s to %s", what, choice.path)
+	m.recordMove("moving "+what+" to "+choice.path, fromMailbox(ids, from), choice.mailbox.ID)
+	if m.state == viewEmails {
+		m.loading = true
+		m.dropCursorEmail()
+	} else if m.thread == nil {
+		// The message is gone from this mailbox, so back to the list
+		m.state = viewEmails
+		m.bodyContent = ""
+		m.htmlBody = ""
+		m.attachments = nil
+		m.attachmentFocus = false
+		m.dropCursorEmail()
+	}
+	return m, m.moveCmd(ids, from, choice.mailbox.ID)
+}
+
+// folderPickerView renders the picker in place of the list or message
+func (m Model) folderPickerView() string {
+	var s strings.Builder
+	verb := "Move"
+	if m.picking == "copy" {
+		verb = "Copy"
+	}
+	what := "message"
+	if len(m.pickerIDs) > 1 {
+		what = fmt.Sprintf("%d messages", len(m.pickerIDs))
+	}
+	s.WriteString(fmt.Sprintf("%s %s to: %s\n\n", verb, what, m.pickerInput.View()))
+
+	choices := m.pickerChoices()
+	if len(choices) == 0 {
+		s.WriteString("No matching folders.\n")
+	}
+	pageHeight := m.height - 12
+	if pageHeight < 5 {
+		pageHeight = 5
+	}
+	start := 0
+	if m.pickerCursor >= pageHeight {
+		start = m.pickerCursor - pageHeight + 1
+	}
+	for i := start; i < len(choices) && i < start+pageHeight; i++ {
+		cursor := " "
+		style := mailboxStyle
+		if i == m.pickerCursor {
+			cursor = ">"
+			style = selectedMailboxStyle
+		}
+		s.WriteString(style.Render(cursor+" "+choices[i].path) + "\n")
+	}
+	s.WriteString(fmt.Sprintf("\n(type to filter, up/down: select, enter: %s, esc: cancel)", strings.ToLower(verb)))
+	return s.String()
+}
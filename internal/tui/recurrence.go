@@ -0,0 +1,182 @@
+package tui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/model"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const recurringMark = "↻"
+
+// setRepeatOptions offers the common repeat rules for the event being
+// edited, plus its own rule if it is none of them
+func (m *Model) setRepeatOptions() {
+	e := m.editingEvent
+	m.eventSeries = e.Recurrence
+	m.eventRepeatOptions = api.RecurrenceOptions(e.Start)
+	m.eventRepeat = 0
+	if e.Recurrence == "" {
+		return
+	}
+	for i, o := range m.eventRepeatOptions {
+		if api.SameRecurrence(o.Rule, e.Recurrence) {
+			m.eventRepeat = i
+			return
+		}
+	}
+	m.eventRepeatOptions = append(m.eventRepeatOptions, api.RecurrenceOption{
+		Label: api.DescribeRecurrence(e.Recurrence),
+		Rule:  e.Recurrence,
+	})
+	m.eventRepeat = len(m.eventRepeatOptions) - 1
+}
+
+func (m Model) repeatLabel() string {
+	if m.eventRepeat < len(m.eventRepeatOptions) {
+		return m.eventRepeatOptions[m.eventRepeat].Label
+	}
+	return ""
+}
+
+// saveEvent saves the event being edited. Changing one occurrence of a
+// recurring event asks first whether the change is for the whole series;
+// changing its rule always is.
+func (m *Model) saveEvent() tea.Cmd {
+	e := m.editingEvent
+	if m.eventRepeat < len(m.eventRepeatOptions) {
+		e.Recurrence = m.eventRepeatOptions[m.eventRepeat].Rule
+	}
+	if m.eventAlarm < len(m.eventAlarmOptions) {
+		e.Alerts = m.eventAlarmOptions[m.eventAlarm].alerts
+	}
+	if e.ID == "" {
+		m.loading = true
+		return createEventCmd(m.davClient, *e)
+	}
+	if !e.RecurrenceID.IsZero() && api.SameRecurrence(e.Recurrence, m.eventSeries) {
+		m.eventScope = "edit"
+		return nil
+	}
+	m.loading = true
+	return updateEventCmd(m.davClient, *e)
+}
+
+// deleteEvent deletes the event under the cursor, asking first for a
+// recurring one whether to delete the occurrence or the series
+func (m *Model) deleteEvent() tea.Cmd {
+	event := m.events[m.eventCursor]
+	if !event.RecurrenceID.IsZero() {
+		m.eventScope = "delete"
+		return nil
+	}
+	m.loading = true
+	m.viewEventDetail = false
+	m.dropEvents(event.ID, time.Time{})
+	return deleteEventCmd(m.davClient, event)
+}
+
+// dropEvents removes an event from the agenda ahead of the server: one
+// occurrence, or with a zero recurrenceID all of them
+func (m *Model) dropEvents(id string, recurrenceID time.Time) {
+	kept := m.events[:0]
+	for _, e := range m.events {
+		if e.ID == id && (recurrenceID.IsZero() || e.RecurrenceID.Equal(recurrenceID)) {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	m.events = kept
+	if m.eventCursor >= len(m.events) && m.eventCursor > 0 {
+		m.eventCursor = len(m.events) - 1
+	}
+}
+
+// updateEventScope handles the answer to "this occurrence or all?". Every
+// key is taken while the question is open.
+func (m Model) updateEventScope(msg tea.KeyMsg) (Model, tea.Cmd, bool) {
+	switch msg.String() {
+	case "t", "a":
+		scope := m.eventScope
+		m.eventScope = ""
+		all := msg.String() == "a"
+
+		if scope == "delete" {
+			if m.eventCursor >= len(m.events) {
+				return m, nil, true
+			}
+			event := m.events[m.eventCursor]
+			m.loading = true
+			m.viewEventDetail = false
+			if all {
+				m.dropEvents(event.ID, time.Time{})
+				return m, deleteEventCmd(m.davClient, event), true
+			}
+			m.dropEvents(event.ID, event.RecurrenceID)
+			return m, deleteOccurrenceCmd(m.davClient, event), true
+		}
+
+		if m.editingEvent == nil {
+			return m, nil, true
+		}
+		m.loading = true
+		if all {
+			return m, updateEventCmd(m.davClient, *m.editingEvent), true
+		}
+		return m, updateOccurrenceCmd(m.davClient, *m.editingEvent), true
+	case "esc", "n":
+		m.eventScope = ""
+		return m, nil, true
+	case "ctrl+c":
+		return m, tea.Quit, true
+	}
+	return m, nil, true
+}
+
+func (m Model) eventScopeView() string {
+	verb := "Change"
+	if m.eventScope == "delete" {
+		verb = "Delete"
+	}
+	return fmt.Sprintf("\nThis is a recurring event. %s (t)his occurrence or (a)ll occurrences? (esc: cancel)", verb)
+}
+
+func updateOccurrenceCmd(davClient *api.DAVClient, event model.CalendarEvent) tea.Cmd {
+	return func() tea.Msg {
+		if davClient == nil {
+			return errorMsg(fmt.Errorf("CalDAV not configured"))
+		}
+		err := moveEventIfNeeded(context.Background(), davClient, &event)
+		if err == nil {
+			err = davClient.UpdateOccurrence(context.Background(), event)
+		}
+		if errors.Is(err, api.ErrConflict) {
+			return eventConflictMsg{}
+		}
+		if err != nil {
+			return errorMsg(err)
+		}
+		return eventCreatedMsg{}
+	}
+}
+
+func deleteOccurrenceCmd(davClient *api.DAVClient, event model.CalendarEvent) tea.Cmd {
+	return func() tea.Msg {
+		if davClient == nil {
+			return errorMsg(fmt.Errorf("CalDAV not configured"))
+		}
+		err := davClient.DeleteOccurrence(context.Background(), event)
+		if errors.Is(err, api.ErrConflict) {
+			return eventConflictMsg{deleting: true}
+		}
+		if err != nil {
+			return errorMsg(err)
+		}
+		return eventDeletedMsg{}
+	}
+}
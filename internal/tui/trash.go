@@ -0,0 +1,55 @@
+package tui
+
+import (
+	"fm-cli/internal/api"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// roleMailbox returns the ID of the mailbox with a role such as "trash",
+// or "" if there is none
+func (m Model) roleMailbox(role string) string {
+	for _, mb := range m.mailboxes {
+		if mb.Role == role {
+			return mb.ID
+		}
+	}
+	return ""
+}
+
+// listingTrash reports whether the list is the Trash or Junk, the only
+// places emails are deleted for good
+func (m Model) listingTrash() bool {
+	if m.searchQuery != nil || m.unified || m.mbCursor >= len(m.mailboxes) {
+		return false
+	}
+	role := m.mailboxes[m.mbCursor].Role
+	return role == "trash" || role == "junk"
+}
+
+// trashMailboxes maps each email to the Trash alone
+func trashMailboxes(emailIDs []string, trashID string) map[string][]string {
+	mailboxes := make(map[string][]string, len(emailIDs))
+	for _, id := range emailIDs {
+		mailboxes[id] = []string{trashID}
+	}
+	return mailboxes
+}
+
+// updateDestroyConfirm asks before deleting emails for good
+func (m Model) updateDestroyConfirm(msg tea.KeyMsg) (Model, tea.Cmd) {
+	ids := m.destroyConfirm
+	m.destroyConfirm = nil
+	if msg.String() != "y" {
+		return m, nil
+	}
+	if m.marking() {
+		return m, m.bulkCmd(did("Permanently deleted"), len(ids), func(c *api.Client) error {
+			return c.DestroyEmails(ids)
+		}, queueDeleteCmd(m.db, ids))
+	}
+	m.loading = true
+	// Optimistic UI update
+	m.dropCursorEmail()
+	return m, m.destroyCmd(ids)
+}
@@ -0,0 +1,260 @@
+package tui
+
+import (
+	"fmt"
+
+	"fm-cli/internal/api"
+	"fm-cli/internal/mailsync"
+	"fm-cli/internal/model"
+	"fm-cli/internal/storage"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxUndo is how many actions z can take back
+const maxUndo = 20
+
+// undoEntry is a mail action z can take back: how the emails were before
+// it, and how to take it back out of the pending queue if it was queued
+type undoEntry struct {
+	label   string // What was done, e.g. "archive 3 messages"
+	account string // Whose emails they are
+	before  map[string]api.EmailState
+	queued  bool // Made offline, so it went through the queue
+	// unqueue cancels the queued action, returning the emails it had
+	// already reached the server for
+	unqueue func(db *storage.DB) ([]string, error)
+}
+
+// undoneMsg reports an undo
+type undoneMsg struct {
+	status string
+}
+
+// knownEmail finds an email in the list, the loaded conversations or the
+// reader
+func (m Model) knownEmail(id string) (model.Email, bool) {
+	for _, e := range m.emails {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	if m.thread != nil {
+		for _, e := range m.thread.Emails {
+			if e.ID == id {
+				return e, true
+			}
+		}
+	}
+	for _, t := range m.threads {
+		for _, e := range t.Emails {
+			if e.ID == id {
+				return e, true
+			}
+		}
+	}
+	return model.Email{}, false
+}
+
+// mailboxesBefore records the mailboxes emails are in before a move
+func (m Model) mailboxesBefore(emailIDs []string) map[string]api.EmailState {
+	before := make(map[string]api.EmailState, len(emailIDs))
+	for _, id := range emailIDs {
+		if e, ok := m.knownEmail(id); ok && len(e.MailboxIDs) > 0 {
+			before[id] = api.EmailState{Mailboxes: append([]string(nil), e.MailboxIDs...)}
+		}
+	}
+	return before
+}
+
+// flagsBefore records whether emails are read, for "$seen", or flagged,
+// for "$flagged", before that changes
+func (m Model) flagsBefore(emailIDs []string, keyword string) map[string]api.EmailState {
+	before := make(map[string]api.EmailState, len(emailIDs))
+	for _, id := range emailIDs {
+		e, ok := m.knownEmail(id)
+		if !ok {
+			continue
+		}
+		had := e.IsFlagged
+		if keyword == "$seen" {
+			had = !e.IsUnread
+		}
+		before[id] = api.EmailState{Keywords: map[string]bool{keyword: had}}
+	}
+	return before
+}
+
+// pushUndo records an action so z can take it back
+func (m *Model) pushUndo(label string, before map[string]api.EmailState, unqueue func(*storage.DB) ([]string, error)) {
+	if len(before) == 0 {
+		return
+	}
+	m.undoStack = append(m.undoStack, undoEntry{
+		label:   label,
+		account: m.accountName(),
+		before:  before,
+		queued:  m.offlineMode || m.client == nil,
+		unqueue: unqueue,
+	})
+	if len(m.undoStack) > maxUndo {
+		m.undoStack = m.undoStack[len(m.undoStack)-maxUndo:]
+	}
+}
+
+// recordMove records moving emails out of the mailbox each maps to, or
+// copying them where that is empty. Call it before the list changes.
+func (m *Model) recordMove(label string, from map[string]string, toMBID string) {
+	ids := make([]string, 0, len(from))
+	for id := range from {
+		ids = append(ids, id)
+	}
+	m.pushUndo(label, m.mailboxesBefore(ids), func(db *storage.DB) ([]string, error) {
+		var sent []string
+		for id, fromMBID := range from {
+			s, err := mailsync.UnqueueMove(db, []string{id}, fromMBID, toMBID)
+			if err != nil {
+				return nil, err
+			}
+			sent = append(sent, s...)
+		}
+		return sent, nil
+	})
+}
+
+// recordTrash records moving emails to the Trash
+func (m *Model) recordTrash(emailIDs []string, trashID string) {
+	label := "moving " + messageCount(len(emailIDs)) + " to Trash"
+	m.pushUndo(label, m.mailboxesBefore(emailIDs), func(db *storage.DB) ([]string, error) {
+		return mailsync.UnqueueSetMailboxes(db, trashMailboxes(emailIDs, trashID))
+	})
+}
+
+// recordRead records marking emails read or unread
+func (m *Model) recordRead(emailIDs []string, unread bool) {
+	label := "marking " + messageCount(len(emailIDs)) + " read"
+	if unread {
+		label = "marking " + messageCount(len(emailIDs)) + " unread"
+	}
+	m.pushUndo(label, m.flagsBefore(emailIDs, "$seen"), func(db *storage.DB) ([]string, error) {
+		return mailsync.UnqueueSetFlags(db, emailIDs, &unread, nil)
+	})
+}
+
+// recordFlag records flagging or unflagging emails
+func (m *Model) recordFlag(emailIDs []string, flagged bool) {
+	label := "flagging " + messageCount(len(emailIDs))
+	if !flagged {
+		label = "unflagging " + messageCount(len(emailIDs))
+	}
+	m.pushUndo(label, m.flagsBefore(emailIDs, "$flagged"), func(db *storage.DB) ([]string, error) {
+		return mailsync.UnqueueSetFlags(db, emailIDs, nil, &flagged)
+	})
+}
+
+// undoLast takes back the most recent action on the undo stack
+func (m Model) undoLast() (Model, tea.Cmd) {
+	if len(m.undoStack) == 0 {
+		m.status = "Nothing to undo"
+		return m, nil
+	}
+	entry := m.undoStack[len(m.undoStack)-1]
+	m.undoStack = m.undoStack[:len(m.undoStack)-1]
+	// The unified inbox acts through whichever account an entry is from, so
+	// the action may not belong to the active one
+	for _, s := range m.sessions() {
+		if s.name == entry.account {
+			return m, undoCmd(s.client, s.db, m.offlineMode || s.client == nil, entry)
+		}
+	}
+	m.status = "Can't undo " + entry.label + ": its account is gone"
+	return m, nil
+}
+
+// undoCmd takes an action back. Queued actions that haven't been sent are
+// just cancelled; the rest are reversed on the server, or through the queue
+// when offline. The cache is put back either way.
+func undoCmd(client *api.Client, db *storage.DB, offline bool, entry undoEntry) tea.Cmd {
+	return func() tea.Msg {
+		restore := entry.before
+		if entry.queued && db != nil {
+			sent, err := entry.unqueue(db)
+			if err != nil {
+				return errorMsg(err)
+			}
+			restore = make(map[string]api.EmailState, len(sent))
+			for _, id := range sent {
+				if state, ok := entry.before[id]; ok {
+					restore[id] = state
+				}
+			}
+		}
+		if db != nil {
+			if err := restoreCache(db, entry.before); err != nil {
+				return errorMsg(err)
+			}
+		}
+		if len(restore) > 0 {
+			var err error
+			if offline {
+				err = queueRestore(db, restore)
+			} else {
+				err = client.RestoreEmails(restore)
+			}
+			if err != nil {
+				return errorMsg(err)
+			}
+		}
+		return undoneMsg{status: "Undid " + entry.label}
+	}
+}
+
+// restoreCache puts emails back the way they were in local storage
+func restoreCache(db *storage.DB, states map[string]api.EmailState) error {
+	for id, state := range states {
+		if state.Mailboxes != nil {
+			if err := db.SetEmailMailboxes(id, state.Mailboxes); err != nil {
+				return err
+			}
+		}
+		if had, ok := state.Keywords["$seen"]; ok {
+			if err := db.SetEmailsUnread([]string{id}, !had); err != nil {
+				return err
+			}
+		}
+		if had, ok := state.Keywords["$flagged"]; ok {
+			if err := db.SetEmailsFlagged([]string{id}, had); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// queueRestore queues putting emails back the way they were
+func queueRestore(db *storage.DB, states map[string]api.EmailState) error {
+	if db == nil {
+		return fmt.Errorf("no local storage available")
+	}
+	for id, state := range states {
+		if state.Mailboxes != nil {
+			if err := mailsync.QueueSetMailboxes(db, map[string][]string{id: state.Mailboxes}); err != nil {
+				return err
+			}
+		}
+		for keyword, had := range state.Keywords {
+			var err error
+			switch keyword {
+			case "$seen":
+				unread := !had
+				err = mailsync.QueueSetFlags(db, []string{id}, &unread, nil)
+			case "$flagged":
+				err = mailsync.QueueSetFlags(db, []string{id}, nil, &had)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
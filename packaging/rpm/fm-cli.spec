@@ -22,7 +22,7 @@ contacts, offline mode, and secure credential storage.
 
 %build
 export CGO_ENABLED=1
-go build -ldflags "-s -w -X main.version=%{version}" -o %{name} ./cmd/fm-cli
+go build -tags sqlite_fts5 -ldflags "-s -w -X main.version=%{version}" -o %{name} ./cmd/fm-cli
 
 %install
 install -Dm755 %{name} %{buildroot}%{_bindir}/%{name}
@@ -8,7 +8,7 @@ PKG_NAME="fm-cli_${VERSION}_${ARCH}"
 echo "Building fm-cli v${VERSION} for ${ARCH}..."
 
 # Build binary
-CGO_ENABLED=1 go build -ldflags "-s -w" -o fm-cli ./cmd/fm-cli
+CGO_ENABLED=1 go build -tags sqlite_fts5 -ldflags "-s -w" -o fm-cli ./cmd/fm-cli
 
 # Create package structure
 mkdir -p "${PKG_NAME}/DEBIAN"